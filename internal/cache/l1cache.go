@@ -1,59 +1,441 @@
 ﻿package cache
 
-import "sync"
+import (
+    "errors"
+    "sort"
+    "sync"
+    "sync/atomic"
+)
+
+// ErrTooManyPins is returned by Pin once as many series are pinned as the
+// cache holds.
+var ErrTooManyPins = errors.New("cannot pin more series than the cache size")
+
+// numStripes bounds how many independently locked stripes the cache is split
+// into. Writes for r values that land in different stripes never contend.
+const numStripes = 16
+
+// SeriesInfo summarizes one cached r without its values. Params is nil for
+// a series nobody called SetParams for.
+type SeriesInfo struct {
+    RHash   uint64
+    Entries int
+    MaxN    int64
+    Params  *SeriesParams
+}
+
+// SeriesParams are the values a series was computed from. Most keys are a
+// hash they cannot be read back from, so they are kept next to the series
+// for reporting.
+type SeriesParams struct {
+    R, C, X0 float64
+}
 
 type L1Cache struct {
-    entries map[uint64]map[int]float64
-    keys    []uint64
+    // OnEvict, if set, is called with each series evicted to make room for
+    // a new one. It runs on the goroutine calling Set after the cache lock is
+    // released, so it may call back into the cache, and it owns the series
+    // passed to it. Set it before the cache is shared.
+    OnEvict func(rHash uint64, series map[int64]float64)
+
+    stripes []*stripe
     size    int
-    head    int
-    mu      sync.RWMutex
+
+    // disabled caches nothing; see NewDisabledL1Cache.
+    disabled bool
+
+    pinMu sync.Mutex
+    pins  int
+
+    // generation stamps each series created; see SetGeneration.
+    generation atomic.Int64
+}
+
+// tail is the converged end of a series: every n from on holds val.
+type tail struct {
+    from int64
+    val  float64
+}
+
+// stripe is one lock domain of the cache with its own ring buffer, so
+// eviction order is tracked per stripe. Every unpinned key in entries sits in
+// exactly one occupied ring slot, so occupancy never exceeds size. Pinned
+// keys are kept outside the ring and are never evicted. A key in tails is
+// always in entries too, with its entry at from.
+type stripe struct {
+    entries  map[uint64]map[int64]float64
+    gens     map[uint64]int64
+    tails    map[uint64]tail
+    params   map[uint64]SeriesParams
+    keys     []uint64
+    occupied []bool
+    pinned   map[uint64]bool
+    size     int
+    head     int
+    mu       sync.RWMutex
 }
 
 func NewL1Cache(size int) *L1Cache {
-    return &L1Cache{
-        entries: make(map[uint64]map[int]float64),
-        keys:    make([]uint64, size),
+    count := numStripes
+    if size < count {
+        count = size
+    }
+    if count < 1 {
+        count = 1
+    }
+
+    c := &L1Cache{
+        stripes: make([]*stripe, count),
         size:    size,
     }
+    for i := range c.stripes {
+        // Spread the capacity so the stripes together hold exactly size series.
+        stripeSize := size / count
+        if i < size%count {
+            stripeSize++
+        }
+        c.stripes[i] = &stripe{
+            entries:  make(map[uint64]map[int64]float64),
+            gens:     make(map[uint64]int64),
+            tails:    make(map[uint64]tail),
+            params:   make(map[uint64]SeriesParams),
+            keys:     make([]uint64, stripeSize),
+            occupied: make([]bool, stripeSize),
+            pinned:   make(map[uint64]bool),
+            size:     stripeSize,
+        }
+    }
+    return c
+}
+
+// NewDisabledL1Cache returns a cache that holds nothing: every lookup
+// misses and every write is dropped. It isolates the cost of the other
+// layers in benchmarks.
+func NewDisabledL1Cache() *L1Cache {
+    return &L1Cache{disabled: true}
 }
 
-func (c *L1Cache) Get(rHash uint64, n int) (float64, bool) {
-    c.mu.RLock()
-    defer c.mu.RUnlock()
-    if series, ok := c.entries[rHash]; ok {
+// stripeFor picks the stripe owning rHash. The hash is the raw float64 bits,
+// whose low mantissa bits are often zero for "round" r values, so mix before
+// reducing.
+func (c *L1Cache) stripeFor(rHash uint64) *stripe {
+    mixed := rHash * 0x9E3779B97F4A7C15
+    return c.stripes[(mixed>>32)%uint64(len(c.stripes))]
+}
+
+func (c *L1Cache) Get(rHash uint64, n int64) (float64, bool) {
+    if c.disabled {
+        return 0, false
+    }
+    s := c.stripeFor(rHash)
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    if series, ok := s.entries[rHash]; ok {
         if val, exists := series[n]; exists {
             return val, true
         }
     }
+    if t, ok := s.tails[rHash]; ok && n >= t.from {
+        return t.val, true
+    }
     return 0, false
 }
 
-func (c *L1Cache) Set(rHash uint64, n int, val float64) {
-    c.mu.Lock()
-    defer c.mu.Unlock()
-    
-    if _, ok := c.entries[rHash]; !ok {
-        if len(c.entries) >= c.size {
-            oldKey := c.keys[c.head]
-            delete(c.entries, oldKey)
+// Contains reports whether any entry of rHash is cached.
+func (c *L1Cache) Contains(rHash uint64) bool {
+    if c.disabled {
+        return false
+    }
+    s := c.stripeFor(rHash)
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    _, ok := s.entries[rHash]
+    return ok
+}
+
+// Floor returns the cached entry for rHash with the largest n' such that
+// after < n' < n, so a compute can resume from it rather than from further
+// back. Entries above n are never considered.
+func (c *L1Cache) Floor(rHash uint64, n, after int64) (int64, float64, bool) {
+    if c.disabled {
+        return 0, 0, false
+    }
+    s := c.stripeFor(rHash)
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    series, ok := s.entries[rHash]
+    if !ok || len(series) == 0 {
+        return 0, 0, false
+    }
+    // Every n in a converged tail is cached, n-1 included.
+    if t, ok := s.tails[rHash]; ok && t.from < n && n-1 > after {
+        return n - 1, t.val, true
+    }
+
+    // Probe downwards when the gap is short, otherwise scan the series, so
+    // the cost is bounded by whichever is smaller.
+    if n-after <= int64(len(series)) {
+        for k := n - 1; k > after; k-- {
+            if val, exists := series[k]; exists {
+                return k, val, true
+            }
+        }
+        return 0, 0, false
+    }
+
+    best, found := after, false
+    for k := range series {
+        if k > best && k < n {
+            best, found = k, true
         }
-        c.entries[rHash] = make(map[int]float64)
-        c.keys[c.head] = rHash
-        c.head = (c.head + 1) % c.size
     }
-    c.entries[rHash][n] = val
+    if !found {
+        return 0, 0, false
+    }
+    return best, series[best], true
+}
+
+func (c *L1Cache) Set(rHash uint64, n int64, val float64) {
+    c.set(rHash, n, val, false)
 }
 
-func (c *L1Cache) GetAllEntries() map[uint64]map[int]float64 {
-    c.mu.RLock()
-    defer c.mu.RUnlock()
-    snapshot := make(map[uint64]map[int]float64)
-    for k, v := range c.entries {
-        snapshot[k] = make(map[int]float64)
-        for n, val := range v {
-            snapshot[k][n] = val
+// SetConverged records that the series of rHash has converged at n: x_n and
+// every later value equal val. One entry then stands for the whole tail, so
+// Get answers any n from there on and entries past n are dropped rather
+// than kept one per n. A Set in the tail of a different value ends it.
+func (c *L1Cache) SetConverged(rHash uint64, n int64, val float64) {
+    c.set(rHash, n, val, true)
+}
+
+func (c *L1Cache) set(rHash uint64, n int64, val float64, converged bool) {
+    if c.disabled {
+        return
+    }
+    s := c.stripeFor(rHash)
+    s.mu.Lock()
+    if t, ok := s.tails[rHash]; ok && !converged && n >= t.from {
+        if val == t.val {
+            s.mu.Unlock()
+            return
+        }
+        delete(s.tails, rHash)
+    }
+
+    var evictedKey uint64
+    var evicted map[int64]float64
+    if _, ok := s.entries[rHash]; !ok && s.pinned[rHash] {
+        s.entries[rHash] = make(map[int64]float64)
+        s.gens[rHash] = c.generation.Load()
+    } else if !ok {
+        // The slot at head holds the oldest key once the ring has wrapped.
+        // Evicting by slot occupancy rather than map size keeps the ring and
+        // entries in lockstep: an occupied slot's key is always present.
+        if s.occupied[s.head] {
+            evictedKey = s.keys[s.head]
+            evicted = s.entries[evictedKey]
+            delete(s.entries, evictedKey)
+            delete(s.gens, evictedKey)
+            delete(s.tails, evictedKey)
+            delete(s.params, evictedKey)
         }
+        s.entries[rHash] = make(map[int64]float64)
+        s.gens[rHash] = c.generation.Load()
+        s.keys[s.head] = rHash
+        s.occupied[s.head] = true
+        s.head = (s.head + 1) % s.size
+    }
+    s.entries[rHash][n] = val
+    if converged {
+        for k := range s.entries[rHash] {
+            if k > n {
+                delete(s.entries[rHash], k)
+            }
+        }
+        s.tails[rHash] = tail{from: n, val: val}
+    }
+    s.mu.Unlock()
+
+    if evicted != nil && c.OnEvict != nil {
+        c.OnEvict(evictedKey, evicted)
+    }
+}
+
+// SetParams records what the series of rHash was computed from, if it is
+// cached. The record goes when the series is evicted.
+func (c *L1Cache) SetParams(rHash uint64, params SeriesParams) {
+    if c.disabled {
+        return
+    }
+    s := c.stripeFor(rHash)
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if _, ok := s.entries[rHash]; ok {
+        s.params[rHash] = params
+    }
+}
+
+// SetGeneration sets the generation stamped on series from now on. Series
+// created under an earlier generation are stale until replaced.
+func (c *L1Cache) SetGeneration(generation int64) {
+    c.generation.Store(generation)
+}
+
+// Stale reports whether rHash is cached from before the current generation.
+func (c *L1Cache) Stale(rHash uint64) bool {
+    if c.disabled {
+        return false
+    }
+    s := c.stripeFor(rHash)
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    gen, ok := s.gens[rHash]
+    return ok && gen < c.generation.Load()
+}
+
+// Replace swaps all cached entries of rHash for series, stamped with the
+// current generation. The cache owns series afterwards.
+func (c *L1Cache) Replace(rHash uint64, series map[int64]float64) {
+    if c.disabled || len(series) == 0 {
+        return
+    }
+    s := c.stripeFor(rHash)
+    s.mu.Lock()
+    if _, ok := s.entries[rHash]; ok {
+        s.entries[rHash] = series
+        s.gens[rHash] = c.generation.Load()
+        delete(s.tails, rHash)
+        s.mu.Unlock()
+        return
+    }
+    s.mu.Unlock()
+
+    for n, val := range series {
+        c.Set(rHash, n, val)
+    }
+}
+
+// Pin marks rHash as never evictable. A pinned series is held in addition to
+// the size series of the ring, and at most size series may be pinned, so
+// the cache holds up to twice size series when every pin is used. If rHash
+// is already cached it keeps its entries and gives up its ring slot.
+func (c *L1Cache) Pin(rHash uint64) error {
+    if c.disabled {
+        return nil
+    }
+    c.pinMu.Lock()
+    defer c.pinMu.Unlock()
+
+    s := c.stripeFor(rHash)
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if s.pinned[rHash] {
+        return nil
+    }
+    if c.pins >= c.size {
+        return ErrTooManyPins
+    }
+
+    if _, ok := s.entries[rHash]; ok {
+        for i, key := range s.keys {
+            if s.occupied[i] && key == rHash {
+                s.occupied[i] = false
+                break
+            }
+        }
+    }
+    s.pinned[rHash] = true
+    c.pins++
+    return nil
+}
+
+// ForEach calls fn for every cached entry without copying the cache. Each
+// stripe's read lock is held while its entries are visited, so fn must not
+// call back into the cache and should be quick. All entries of one rHash are
+// visited consecutively, in no particular n order.
+func (c *L1Cache) ForEach(fn func(rHash uint64, n int64, val float64)) {
+    for _, s := range c.stripes {
+        s.mu.RLock()
+        for rHash, series := range s.entries {
+            for n, val := range series {
+                fn(rHash, n, val)
+            }
+        }
+        s.mu.RUnlock()
+    }
+}
+
+// ForEachCurrent is ForEach restricted to series cached under the current
+// generation, skipping stale ones.
+func (c *L1Cache) ForEachCurrent(fn func(rHash uint64, n int64, val float64)) {
+    for i := range c.stripes {
+        c.ForEachCurrentIn(i, fn)
+    }
+}
+
+// Stripes returns how many stripes the cache is split into, for
+// ForEachCurrentIn.
+func (c *L1Cache) Stripes() int {
+    return len(c.stripes)
+}
+
+// ForEachCurrentIn is ForEachCurrent over stripe i alone. Every series lives
+// in exactly one stripe, so a caller walking the stripes in turn sees each
+// series whole and can act between stripes without holding any lock.
+func (c *L1Cache) ForEachCurrentIn(i int, fn func(rHash uint64, n int64, val float64)) {
+    s := c.stripes[i]
+    generation := c.generation.Load()
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    for rHash, series := range s.entries {
+        if s.gens[rHash] < generation {
+            continue
+        }
+        for n, val := range series {
+            fn(rHash, n, val)
+        }
+    }
+}
+
+// GetAllEntries returns a deep copy of the whole cache.
+//
+// Deprecated: the copy can briefly double cache memory. Use ForEach.
+func (c *L1Cache) GetAllEntries() map[uint64]map[int64]float64 {
+    snapshot := make(map[uint64]map[int64]float64)
+    for _, s := range c.stripes {
+        s.mu.RLock()
+        for k, v := range s.entries {
+            snapshot[k] = make(map[int64]float64)
+            for n, val := range v {
+                snapshot[k][n] = val
+            }
+        }
+        s.mu.RUnlock()
     }
     return snapshot
+}
+
+// Keys lists every cached series ordered by rHash, so offsets into the list
+// are stable between calls while the cache is unchanged.
+func (c *L1Cache) Keys() []SeriesInfo {
+    var infos []SeriesInfo
+    for _, s := range c.stripes {
+        s.mu.RLock()
+        for rHash, series := range s.entries {
+            info := SeriesInfo{RHash: rHash, Entries: len(series)}
+            if params, ok := s.params[rHash]; ok {
+                info.Params = &params
+            }
+            for n := range series {
+                if n > info.MaxN {
+                    info.MaxN = n
+                }
+            }
+            infos = append(infos, info)
+        }
+        s.mu.RUnlock()
+    }
+    sort.Slice(infos, func(i, j int) bool { return infos[i].RHash < infos[j].RHash })
+    return infos
 }
\ No newline at end of file
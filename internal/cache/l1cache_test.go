@@ -0,0 +1,264 @@
+package cache
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// BenchmarkL1CacheConcurrentSet measures Set throughput with 64 goroutines,
+// each writing a series for its own r value.
+func BenchmarkL1CacheConcurrentSet(b *testing.B) {
+	const workers = 64
+	c := NewL1Cache(75)
+
+	b.ResetTimer()
+	var wg sync.WaitGroup
+	perWorker := b.N/workers + 1
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(rHash uint64) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				c.Set(rHash, int64(i%1000), float64(i))
+				c.Get(rHash, int64(i%1000))
+			}
+		}(uint64(w) * 0x9E3779B97F4A7C15)
+	}
+	wg.Wait()
+}
+
+// checkRing asserts that every stripe's ring and entries map agree, with
+// pinned keys held outside the ring.
+func checkRing(t *testing.T, c *L1Cache) {
+	t.Helper()
+	total := 0
+	for i, s := range c.stripes {
+		pinned := 0
+		slots := make(map[uint64]int)
+		for j, occupied := range s.occupied {
+			if occupied {
+				slots[s.keys[j]]++
+			}
+		}
+		for rHash := range s.entries {
+			if s.pinned[rHash] {
+				if slots[rHash] != 0 {
+					t.Fatalf("stripe %d: pinned key %d holds a ring slot", i, rHash)
+				}
+				pinned++
+				continue
+			}
+			if slots[rHash] != 1 {
+				t.Fatalf("stripe %d: key %d in %d ring slots, want 1", i, rHash, slots[rHash])
+			}
+		}
+		if len(slots) != len(s.entries)-pinned {
+			t.Fatalf("stripe %d: %d ring keys but %d unpinned entries", i, len(slots), len(s.entries)-pinned)
+		}
+		total += len(s.entries) - pinned
+	}
+	if total > c.size {
+		t.Fatalf("cache holds %d series, capacity %d", total, c.size)
+	}
+}
+
+func TestL1CacheRingStaysConsistent(t *testing.T) {
+	c := NewL1Cache(20)
+	rng := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 20000; i++ {
+		// A small key space forces constant eviction and re-insertion of
+		// previously evicted keys, including rHash 0.
+		rHash := uint64(rng.Intn(60))
+		c.Set(rHash, int64(i%7), float64(i))
+		checkRing(t, c)
+	}
+}
+
+func TestL1CachePinnedSurvivesEviction(t *testing.T) {
+	c := NewL1Cache(20)
+
+	// One key pinned before it is cached, one after.
+	const early, late = uint64(1000), uint64(2000)
+	if err := c.Pin(early); err != nil {
+		t.Fatal(err)
+	}
+	c.Set(early, 1, 0.25)
+	c.Set(late, 1, 0.75)
+	if err := c.Pin(late); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10000; i++ {
+		c.Set(uint64(i%500), 1, float64(i))
+		checkRing(t, c)
+	}
+
+	if val, ok := c.Get(early, 1); !ok || val != 0.25 {
+		t.Errorf("pinned-before-set entry = %v, %v; want 0.25, true", val, ok)
+	}
+	if val, ok := c.Get(late, 1); !ok || val != 0.75 {
+		t.Errorf("pinned-after-set entry = %v, %v; want 0.75, true", val, ok)
+	}
+}
+
+func TestL1CachePinLimit(t *testing.T) {
+	c := NewL1Cache(3)
+	for i := uint64(0); i < 3; i++ {
+		if err := c.Pin(i); err != nil {
+			t.Fatalf("Pin(%d): %v", i, err)
+		}
+	}
+	if err := c.Pin(0); err != nil {
+		t.Errorf("re-pinning: %v", err)
+	}
+	if err := c.Pin(3); err != ErrTooManyPins {
+		t.Errorf("Pin beyond the cache size: err = %v, want ErrTooManyPins", err)
+	}
+
+	// Pins are held beside a full ring, so the cache holds twice its size.
+	for i := uint64(0); i < 100; i++ {
+		c.Set(i, 1, 0.5)
+	}
+	if got := len(c.Keys()); got != 6 {
+		t.Errorf("%d series cached, want 3 pinned plus 3 in the ring", got)
+	}
+}
+
+func TestL1CacheOnEvict(t *testing.T) {
+	c := NewL1Cache(1)
+	var gotKey uint64
+	var gotSeries map[int64]float64
+	calls := 0
+	c.OnEvict = func(rHash uint64, series map[int64]float64) {
+		// Calling back into the cache must not deadlock.
+		c.Get(rHash, 1)
+		gotKey, gotSeries = rHash, series
+		calls++
+	}
+
+	c.Set(7, 1, 0.25)
+	c.Set(7, 2, 0.5)
+	if calls != 0 {
+		t.Fatalf("OnEvict called %d times before any eviction", calls)
+	}
+	c.Set(8, 1, 0.75)
+	if calls != 1 || gotKey != 7 {
+		t.Fatalf("OnEvict called %d times with key %d, want once with 7", calls, gotKey)
+	}
+	if len(gotSeries) != 2 || gotSeries[1] != 0.25 || gotSeries[2] != 0.5 {
+		t.Errorf("evicted series = %v, want {1: 0.25, 2: 0.5}", gotSeries)
+	}
+	if _, ok := c.Get(7, 1); ok {
+		t.Error("evicted series still cached")
+	}
+}
+
+// fillCache builds a cache of 75 series with 10000 entries each.
+func fillCache() *L1Cache {
+	c := NewL1Cache(75)
+	for r := 0; r < 75; r++ {
+		rHash := uint64(r) * 0x9E3779B97F4A7C15
+		for n := int64(1); n <= 10000; n++ {
+			c.Set(rHash, n, float64(n))
+		}
+	}
+	return c
+}
+
+// BenchmarkL1CacheGetAllEntries and BenchmarkL1CacheForEach compare the
+// memory a full pass over the cache costs; see B/op.
+func BenchmarkL1CacheGetAllEntries(b *testing.B) {
+	c := fillCache()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sum := 0.0
+		for _, series := range c.GetAllEntries() {
+			for _, val := range series {
+				sum += val
+			}
+		}
+	}
+}
+
+func BenchmarkL1CacheForEach(b *testing.B) {
+	c := fillCache()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sum := 0.0
+		c.ForEach(func(rHash uint64, n int64, val float64) {
+			sum += val
+		})
+	}
+}
+
+func TestL1CacheGenerations(t *testing.T) {
+	c := NewL1Cache(4)
+	c.Set(1, 10, 0.25)
+	c.Set(1, 20, 0.5)
+	if c.Stale(1) || c.Stale(2) {
+		t.Fatal("series stale before the generation changed")
+	}
+
+	c.SetGeneration(1)
+	c.Set(2, 10, 0.75)
+	if !c.Stale(1) || c.Stale(2) {
+		t.Errorf("Stale(1) = %v, Stale(2) = %v; want only the older series stale", c.Stale(1), c.Stale(2))
+	}
+	if val, ok := c.Get(1, 20); !ok || val != 0.5 {
+		t.Errorf("stale entry = %v, %v; want it still readable", val, ok)
+	}
+
+	visited := make(map[uint64]int)
+	c.ForEachCurrent(func(rHash uint64, n int64, val float64) { visited[rHash]++ })
+	if len(visited) != 1 || visited[2] != 1 {
+		t.Errorf("ForEachCurrent visited %v, want only series 2", visited)
+	}
+
+	c.Replace(1, map[int64]float64{10: 0.3})
+	if c.Stale(1) {
+		t.Error("series stale after Replace")
+	}
+	if _, ok := c.Get(1, 20); ok {
+		t.Error("Replace kept an entry of the stale series")
+	}
+	if val, ok := c.Get(1, 10); !ok || val != 0.3 {
+		t.Errorf("replaced entry = %v, %v; want 0.3, true", val, ok)
+	}
+}
+
+func TestL1CacheConvergedTail(t *testing.T) {
+	c := NewL1Cache(4)
+	c.Set(1, 10, 0.25)
+	c.Set(1, 60, 0.6)
+	c.SetConverged(1, 50, 0.6)
+
+	if keys := c.Keys(); len(keys) != 1 || keys[0].Entries != 2 || keys[0].MaxN != 50 {
+		t.Errorf("Keys = %+v, want entries 10 and 50 only", keys)
+	}
+	for _, n := range []int64{50, 51, 1 << 40} {
+		if val, ok := c.Get(1, n); !ok || val != 0.6 {
+			t.Errorf("Get(%d) = %v, %v; want the converged 0.6", n, val, ok)
+		}
+	}
+	if _, ok := c.Get(1, 49); ok {
+		t.Error("Get(49) hit, want a miss before the tail")
+	}
+	if n, val, ok := c.Floor(1, 1000, 10); !ok || n != 999 || val != 0.6 {
+		t.Errorf("Floor(1000) = %d, %v, %v; want 999 in the tail", n, val, ok)
+	}
+
+	// Setting the converged value again stores nothing; another value ends
+	// the tail.
+	c.Set(1, 70, 0.6)
+	if keys := c.Keys(); keys[0].Entries != 2 {
+		t.Errorf("%d entries after a Set in the tail, want 2", keys[0].Entries)
+	}
+	c.Set(1, 70, 0.7)
+	if _, ok := c.Get(1, 80); ok {
+		t.Error("tail still answers after a different value was set in it")
+	}
+}
@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/big"
+)
+
+// Adaptive precision starts at minAdaptivePrecision bits and doubles up to
+// MaxAdaptivePrecision.
+const (
+	minAdaptivePrecision = 64
+	MaxAdaptivePrecision = 1 << 15
+)
+
+// ErrNoConvergence is returned by ComputeAdaptive when consecutive
+// precisions still disagree at MaxAdaptivePrecision.
+var ErrNoConvergence = errors.New("result did not converge within the maximum precision")
+
+// ComputeAdaptive computes x_n with big.Float arithmetic at 64 bits of
+// mantissa, then 128, 256 and so on, until two consecutive precisions agree
+// to within relTol relative error. It returns the value at the higher of the
+// two and the precision that produced it. L1 is never used, since cached
+// values are float64; with big checkpoints enabled each precision resumes
+// from and writes checkpoints of its own, see computeBig.
+//
+// In the chaotic regime each step loses about log2 of the Lyapunov number in
+// bits, one bit per step at r=4, so the precision needed grows linearly with
+// n and each step costs more as it does. Deep chaotic n therefore ends in
+// ErrNoConvergence after computing every precision up to the maximum.
+func (e *ComputeEngine) ComputeAdaptive(ctx context.Context, r float64, n int, relTol float64) (*big.Float, uint, error) {
+	var prev *big.Float
+	for prec := uint(minAdaptivePrecision); prec <= MaxAdaptivePrecision; prec *= 2 {
+		x, err := e.computeBig(ctx, r, n, prec)
+		if err != nil {
+			return nil, 0, err
+		}
+		if prev != nil && agree(x, prev, relTol) {
+			return x, prec, nil
+		}
+		prev = x
+	}
+	return nil, 0, ErrNoConvergence
+}
+
+func (e *ComputeEngine) iterateBig(ctx context.Context, r, x0 float64, n int, prec uint) (*big.Float, error) {
+	x := new(big.Float).SetPrec(prec).SetFloat64(x0)
+	if err := e.stepBig(ctx, r, x, 0, n, nil); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// stepBig advances x from x_from to x_n in place, at x's precision. visit, if
+// set, is called with every new x_i and must not keep x.
+func (e *ComputeEngine) stepBig(ctx context.Context, r float64, x *big.Float, from, n int, visit func(i int, x *big.Float)) error {
+	prec := x.Prec()
+	rb := new(big.Float).SetPrec(prec).SetFloat64(r)
+	one := new(big.Float).SetPrec(prec).SetInt64(1)
+	t := new(big.Float).SetPrec(prec)
+
+	stride := int(e.cancelCheckStride.Load())
+	for i := from; i < n; i++ {
+		if (i+1)%stride == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		t.Sub(one, x)
+		x.Mul(x, t)
+		x.Mul(x, rb)
+		if visit != nil {
+			visit(i+1, x)
+		}
+	}
+	return nil
+}
+
+// agree reports whether |a-b| <= relTol*|a|.
+func agree(a, b *big.Float, relTol float64) bool {
+	diff := new(big.Float).Sub(a, b)
+	diff.Abs(diff)
+	bound := new(big.Float).Abs(a)
+	bound.Mul(bound, big.NewFloat(relTol))
+	return diff.Cmp(bound) <= 0
+}
+
+// SignificantDigits is how many decimal digits relTol guarantees.
+func SignificantDigits(relTol float64) int {
+	return int(math.Ceil(-math.Log10(relTol)))
+}
@@ -0,0 +1,123 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+
+	"resilientrecursion/pkg/config"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestComputeAdaptive(t *testing.T) {
+	e, _ := newTestEngine(t)
+	ctx := context.Background()
+
+	// A stable 2-cycle agrees with float64 at the lowest precisions.
+	x, prec, err := e.ComputeAdaptive(ctx, 3.2, 1000, 1e-12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := x.Float64()
+	if math.Abs(got-directIterate(3.2, 1000)) > 1e-12 || prec != 2*minAdaptivePrecision {
+		t.Errorf("ComputeAdaptive(3.2, 1000) = %v at %d bits, want %v at %d bits",
+			got, prec, directIterate(3.2, 1000), 2*minAdaptivePrecision)
+	}
+
+	// At r=3.9 float64 has lost every digit by n=1000, so more bits are needed.
+	_, prec, err = e.ComputeAdaptive(ctx, 3.9, 1000, 1e-12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prec < 1024 {
+		t.Errorf("ComputeAdaptive(3.9, 1000) stable at %d bits, want at least 1024", prec)
+	}
+}
+
+func TestBigCheckpointRoundTrip(t *testing.T) {
+	e, _ := newTestEngine(t)
+	ctx := context.Background()
+	for _, prec := range []uint{64, 256, 4096} {
+		x, err := e.iterateBig(ctx, 3.9, 0.5, 500, prec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := decodeBigCheckpoint(encodeBigCheckpoint(x), prec)
+		if err != nil || got.Cmp(x) != 0 || got.Prec() != prec {
+			t.Errorf("%d bits: round trip = %v, %v; want %v", prec, got, err, x)
+		}
+	}
+
+	x, _ := e.iterateBig(ctx, 3.9, 0.5, 500, 128)
+	for _, member := range []string{
+		encodeBigCheckpoint(x), // another precision
+		encodeCheckpoint(0.25, config.CheckpointEncodingText),
+		encodeCheckpoint(0.25, config.CheckpointEncodingBinary),
+		"b256:garbage",
+	} {
+		if _, err := decodeBigCheckpoint(member, 256); !errors.Is(err, errCorruptCheckpoint) {
+			t.Errorf("decodeBigCheckpoint(%.20q, 256) = %v, want errCorruptCheckpoint", member, err)
+		}
+	}
+}
+
+func TestBigCheckpointsAreKeyedApart(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	cfg.BigCheckpoints = true
+	e := NewComputeEngine(cfg)
+	t.Cleanup(e.Close)
+	ctx := context.Background()
+	rHash := HashFloat64(3.9)
+
+	want, err := e.iterateBig(ctx, 3.9, 0.5, 2500, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := e.computeBig(ctx, 3.9, 2500, 256)
+	if err != nil || got.Cmp(want) != 0 {
+		t.Fatalf("computeBig(3.9, 2500, 256) = %v, %v; want %v", got, err, want)
+	}
+	bigKey := bigCheckpointKey(config.DefaultTenant, rHash, 256)
+	if members, _ := mr.ZMembers(bigKey); len(members) != 2 {
+		t.Errorf("%s holds %d checkpoints, want those at 1000 and 2000", bigKey, len(members))
+	}
+	if mr.Exists(checkpointKey(config.DefaultTenant, rHash)) {
+		t.Error("an adaptive compute wrote float64 checkpoints")
+	}
+
+	// A resumed compute gives the same bits as one from x0, and does resume:
+	// a planted checkpoint changes the result.
+	if got, err := e.computeBig(ctx, 3.9, 2500, 256); err != nil || got.Cmp(want) != 0 {
+		t.Errorf("resumed computeBig = %v, %v; want %v", got, err, want)
+	}
+	mr.Del(bigKey)
+	mr.ZAdd(bigKey, 2000, "b256:0.25")
+	if got, err := e.computeBig(ctx, 3.9, 2500, 256); err != nil || got.Cmp(want) == 0 {
+		t.Errorf("computeBig ignored the checkpoint at 2000: %v, %v", got, err)
+	}
+	mr.Del(bigKey)
+
+	// Neither kind resumes from the other: a float64 checkpoint is not
+	// picked up at any precision, and the float64 compute ignores the
+	// big.Float ones.
+	mr.ZAdd(checkpointKey(config.DefaultTenant, rHash), 2400, encodeCheckpoint(0.125, config.CheckpointEncodingText))
+	if got, err := e.computeBig(ctx, 3.9, 2500, 256); err != nil || got.Cmp(want) != 0 {
+		t.Errorf("computeBig next to a float64 checkpoint = %v, %v; want %v", got, err, want)
+	}
+	mr.Del(checkpointKey(config.DefaultTenant, rHash))
+	mr.ZAdd(bigCheckpointKey(config.DefaultTenant, rHash, 64), 2400, fmt.Sprintf("b64:%v", 0.125))
+	if x, err := e.Compute(ctx, 3.9, 2500); err != nil || x != directIterate(3.9, 2500) {
+		t.Errorf("Compute next to big.Float checkpoints = %v, %v; want %v", x, err, directIterate(3.9, 2500))
+	}
+	if got, err := e.computeBig(ctx, 3.9, 2500, 128); err != nil {
+		t.Fatal(err)
+	} else if want, _ := e.iterateBig(ctx, 3.9, 0.5, 2500, 128); got.Cmp(want) != 0 {
+		t.Errorf("computeBig at 128 bits resumed from another precision: %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"context"
+	"sync"
+
+	"resilientrecursion/internal/models"
+	"resilientrecursion/pkg/logistic"
+)
+
+// BasinGrid finds the attractor of each cell of a grid of steps+1 r values
+// over [rMin, rMax] by steps+1 x0 values over [x0Min, x0Max]. Each cell
+// iterates its x0 for transient steps, then looks for the smallest period
+// up to n/2 in the next n iterates, by the test detectPeriod uses with the
+// engine's epsilon.
+//
+// Rows of one r are computed concurrently on the worker pool at the
+// priority carried by ctx, falling back to the calling goroutine when the
+// queue is full, and passed to emit in order as the rows finish, so a large
+// grid can be streamed. The cache is never touched.
+func (e *ComputeEngine) BasinGrid(ctx context.Context, rMin, rMax, x0Min, x0Max float64, steps, n, transient int, emit func(models.BasinCell)) error {
+	if steps <= 0 || !(rMax > rMin) || !(x0Max > x0Min) {
+		return ErrInvalidRange
+	}
+
+	rows := make([][]models.BasinCell, steps+1)
+	done := make([]chan struct{}, steps+1)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for i := range rows {
+		i := i
+		done[i] = make(chan struct{})
+		r := rMin + (rMax-rMin)*float64(i)/float64(steps)
+		task := func() {
+			defer wg.Done()
+			defer close(done[i])
+			row := make([]models.BasinCell, steps+1)
+			for j := range row {
+				if ctx.Err() != nil {
+					return
+				}
+				x0 := x0Min + (x0Max-x0Min)*float64(j)/float64(steps)
+				row[j] = e.basinCell(ctx, r, x0, n, transient)
+			}
+			rows[i] = row
+		}
+
+		wg.Add(1)
+		if err := e.pool.Submit(priorityFrom(ctx), task); err != nil {
+			task()
+		}
+	}
+
+	for i := range rows {
+		select {
+		case <-done[i]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for _, cell := range rows[i] {
+			emit(cell)
+		}
+		rows[i] = nil
+	}
+	return nil
+}
+
+// basinCell settles the orbit of x0 under r. A cancelled ctx leaves the
+// cell incomplete; BasinGrid never emits it.
+func (e *ComputeEngine) basinCell(ctx context.Context, r, x0 float64, n, transient int) models.BasinCell {
+	cell := models.BasinCell{R: r, X0: x0}
+	x := x0
+	stride := int(e.cancelCheckStride.Load())
+	for i := 0; i < transient; i++ {
+		if (i+1)%stride == 0 && ctx.Err() != nil {
+			return cell
+		}
+		x = logistic.Step(r, x)
+	}
+
+	orbit := make([]float64, n+1)
+	orbit[0] = x
+	for i := 1; i < len(orbit); i++ {
+		orbit[i] = logistic.Step(r, orbit[i-1])
+	}
+	for _, x := range orbit {
+		if !(x >= 0 && x <= 1) {
+			cell.Escaped = true
+			return cell
+		}
+	}
+
+	cell.Period = periodAt(orbit, n/2, e.Epsilon())
+	cell.Value = orbit[0]
+	for _, x := range orbit[:cell.Period] {
+		cell.Value = min(cell.Value, x)
+	}
+	return cell
+}
@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"resilientrecursion/internal/models"
+)
+
+func TestBasinGrid(t *testing.T) {
+	e, _ := newTestEngine(t)
+	var cells []models.BasinCell
+	emit := func(cell models.BasinCell) { cells = append(cells, cell) }
+
+	if err := e.BasinGrid(context.Background(), 3.2, 2.8, 0, 1, 2, 128, 1000, emit); !errors.Is(err, ErrInvalidRange) {
+		t.Errorf("decreasing r range: err = %v, want ErrInvalidRange", err)
+	}
+
+	// x0 = 0 and 1 are absorbed at 0 in at most one step, whatever r.
+	if err := e.BasinGrid(context.Background(), 2.8, 3.2, 0, 1, 1, 128, 1000, emit); err != nil {
+		t.Fatal(err)
+	}
+	if len(cells) != 4 {
+		t.Fatalf("got %d cells, want 4", len(cells))
+	}
+	lowPoint := (4.2 - math.Sqrt(0.2*4.2)) / 6.4
+	want := []models.BasinCell{
+		{R: 2.8, X0: 0, Value: 0, Period: 1},
+		{R: 2.8, X0: 1, Value: 0, Period: 1},
+		{R: 3.2, X0: 0, Value: 0, Period: 1},
+		{R: 3.2, X0: 1, Value: 0, Period: 1},
+	}
+	for i, cell := range cells {
+		if cell != want[i] {
+			t.Errorf("cell %d = %+v, want %+v", i, cell, want[i])
+		}
+	}
+
+	// Inside (0, 1) the orbits settle onto the fixed point 1-1/r at 2.8 and
+	// onto the 2-cycle at 3.2, reported by its low point.
+	cells = nil
+	if err := e.BasinGrid(context.Background(), 2.8, 3.2, 0.1, 0.6, 1, 128, 1000, emit); err != nil || len(cells) != 4 {
+		t.Fatalf("got %d cells, err %v; want 4", len(cells), err)
+	}
+	for i, cell := range cells {
+		wantR, wantPeriod, wantValue := 2.8, 1, 1-1/2.8
+		if i >= 2 {
+			wantR, wantPeriod, wantValue = 3.2, 2, lowPoint
+		}
+		if cell.R != wantR || cell.Period != wantPeriod || math.Abs(cell.Value-wantValue) > 1e-9 || cell.Escaped {
+			t.Errorf("cell %d = %+v, want r=%v period %d value %v", i, cell, wantR, wantPeriod, wantValue)
+		}
+	}
+
+	cells = nil
+	if err := e.BasinGrid(context.Background(), 4.2, 4.5, 0.1, 0.6, 1, 128, 1000, emit); err != nil || len(cells) != 4 {
+		t.Fatalf("got %d cells, err %v; want 4", len(cells), err)
+	}
+	for i, cell := range cells {
+		if !cell.Escaped || cell.Value != 0 || cell.Period != 0 {
+			t.Errorf("cell %d at r > 4 = %+v, want escaped", i, cell)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := e.BasinGrid(ctx, 2.8, 3.2, 0, 1, 10, 128, 1000, emit); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled grid: err = %v", err)
+	}
+}
@@ -0,0 +1,226 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"resilientrecursion/internal/logging"
+	"resilientrecursion/internal/models"
+)
+
+// seriesID identifies one cached series: an r, its perturbation c and
+// whether it is clamped.
+type seriesID struct {
+	r, c  float64
+	clamp bool
+}
+
+// ComputeBatch computes every request, grouping by series and walking each
+// group in ascending n so later points resume from the cache filled by
+// earlier ones. Repeats of the same n within a group keep their request
+// order, and with config.BatchDuplicatesDedupe only the first is computed and
+// returned. With a per-r budget, each group's points share that much compute
+// time, counted from its first point; once it runs out, the points still
+// to compute come back partial and the batch moves on. A point whose orbit
+// diverges or turns numerically degenerate, or that another pod owns under
+// strict sharding, is returned with Error set; requests that fail for other
+// reasons are logged and left out.
+func (e *ComputeEngine) ComputeBatch(ctx context.Context, requests []models.Request) []models.Response {
+	return e.computeBatch(ctx, requests, nil)
+}
+
+// ComputeBatchMeta is ComputeBatch that also reports how the batch was
+// answered.
+func (e *ComputeEngine) ComputeBatchMeta(ctx context.Context, requests []models.Request) ([]models.Response, models.BatchMeta) {
+	start := time.Now()
+	meta := models.BatchMeta{PodID: e.podID}
+	responses := e.computeBatch(ctx, requests, &meta)
+	meta.ElapsedMs = time.Since(start).Milliseconds()
+	return responses, meta
+}
+
+func (e *ComputeEngine) computeBatch(ctx context.Context, requests []models.Request, stats *models.BatchMeta) []models.Response {
+	ctx = withBulk(ctx)
+	grouped := make(map[seriesID][]models.Request)
+	for _, req := range requests {
+		id := seriesID{req.R, req.C, req.Clamp}
+		grouped[id] = append(grouped[id], req)
+	}
+
+	for id := range grouped {
+		group := grouped[id]
+		sort.SliceStable(group, func(i, j int) bool { return group[i].N < group[j].N })
+		if e.dedupeBatches {
+			grouped[id] = dedupeN(group)
+		}
+	}
+
+	responses := make([]models.Response, 0, len(requests))
+
+	for _, group := range grouped {
+		var rDeadline time.Time
+		if e.perRBudget > 0 {
+			rDeadline = time.Now().Add(e.perRBudget)
+		}
+		for _, req := range group {
+			if resp, ok := e.batchItem(ctx, req, stats, rDeadline); ok {
+				responses = append(responses, resp)
+			}
+		}
+	}
+
+	return responses
+}
+
+// ComputeBatchEach computes requests in request order and passes each
+// response to emit, with its index, as soon as it is computed. The per-r
+// budget and Error are applied as in ComputeBatch; a request that fails for
+// another reason is logged and emitted with ok false. It returns early once
+// ctx is done.
+func (e *ComputeEngine) ComputeBatchEach(ctx context.Context, requests []models.Request, emit func(i int, resp models.Response, ok bool)) {
+	ctx = withBulk(ctx)
+	rDeadlines := make(map[seriesID]time.Time)
+	for i, req := range requests {
+		if ctx.Err() != nil {
+			return
+		}
+		var rDeadline time.Time
+		if e.perRBudget > 0 {
+			id := seriesID{req.R, req.C, req.Clamp}
+			var started bool
+			if rDeadline, started = rDeadlines[id]; !started {
+				rDeadline = time.Now().Add(e.perRBudget)
+				rDeadlines[id] = rDeadline
+			}
+		}
+		resp, ok := e.batchItem(ctx, req, nil, rDeadline)
+		emit(i, resp, ok)
+	}
+}
+
+// batchItem computes one request of a batch and signs it. An orbit that
+// diverges or turns degenerate, or an r another pod owns, comes back with
+// Error set; any other failure is logged and reported as not ok.
+func (e *ComputeEngine) batchItem(ctx context.Context, req models.Request, stats *models.BatchMeta, rDeadline time.Time) (models.Response, bool) {
+	resp, err := e.computeRequest(ctx, req, stats, rDeadline)
+	var notOwner *NotOwnerError
+	if errors.Is(err, ErrDiverged) || errors.Is(err, ErrDegenerate) || errors.As(err, &notOwner) {
+		setError(&resp, err)
+		return resp, true
+	}
+	if err != nil {
+		logging.Errorf("Compute error: %v", err)
+		return resp, false
+	}
+	e.Sign(&resp)
+	return resp, true
+}
+
+// dedupeN drops every request of a group sorted by n whose n repeats the
+// one before it, keeping the first occurrence.
+func dedupeN(group []models.Request) []models.Request {
+	kept := group[:0]
+	for _, req := range group {
+		if len(kept) > 0 && req.N == kept[len(kept)-1].N {
+			continue
+		}
+		kept = append(kept, req)
+	}
+	return kept
+}
+
+// setError records err on an item of an aligned response, including the
+// owning pod for strict sharding refusals.
+func setError(resp *models.Response, err error) {
+	resp.Error = err.Error()
+	var notOwner *NotOwnerError
+	if errors.As(err, &notOwner) {
+		resp.OwnerPod = &notOwner.Owner
+	}
+}
+
+// ComputeRequest computes a single request as an item of a batch would be,
+// without signing it.
+func (e *ComputeEngine) ComputeRequest(ctx context.Context, req models.Request) (models.Response, error) {
+	return e.computeRequest(ctx, req, nil, time.Time{})
+}
+
+// computeRequest computes a single request, honoring its wall-clock budget
+// and rDeadline, the end of its series' per-r budget, whichever comes first.
+// A zero rDeadline means none.
+func (e *ComputeEngine) computeRequest(ctx context.Context, req models.Request, stats *models.BatchMeta, rDeadline time.Time) (models.Response, error) {
+	resp := models.Response{R: req.R, N: req.N, C: req.C, Clamped: req.Clamp || e.clampComputes}
+
+	opts := computeOpts{c: req.C, stats: stats, clamp: req.Clamp}
+	if req.BudgetMs > 0 {
+		opts.deadline = time.Now().Add(time.Duration(req.BudgetMs) * time.Millisecond)
+	}
+	byR := !rDeadline.IsZero() && (opts.deadline.IsZero() || rDeadline.Before(opts.deadline))
+	if byR {
+		opts.deadline = rDeadline
+	}
+	if req.IncludeCheckpoints {
+		opts.checkpoint = collectCheckpoints(&resp.Checkpoints)
+	}
+	if req.Debug {
+		resp.ResumedFrom = new(int64)
+		opts.resumed = resp.ResumedFrom
+	}
+	if req.Provenance {
+		resp.Provenance = e.Provenance(req.R, req.C, req.Clamp)
+	}
+	if req.RUsed {
+		resp.RUsed = models.ExactDecimal(req.R)
+	}
+
+	result, reached, err := e.compute(ctx, req.R, req.N, opts)
+	if err != nil {
+		return resp, err
+	}
+	resp.Result = result
+	if reached < req.N {
+		resp.Partial = true
+		resp.ReachedN = reached
+		if byR {
+			resp.RBudgetMs = e.perRBudget.Milliseconds()
+		}
+	}
+	return resp, nil
+}
+
+// ComputeMulti computes x_n for every r in rs concurrently on the worker
+// pool and returns one response per r in input order. When the pool queue is
+// full the remaining r values are computed on the calling goroutine instead,
+// so a burst degrades to sequential work rather than failing. Tasks are
+// queued at the priority carried by ctx.
+func (e *ComputeEngine) ComputeMulti(ctx context.Context, rs []float64, n int64) []models.Response {
+	ctx = withBulk(ctx)
+	responses := make([]models.Response, len(rs))
+	var wg sync.WaitGroup
+
+	for i, r := range rs {
+		i, r := i, r
+		task := func() {
+			defer wg.Done()
+			responses[i] = models.Response{R: r, N: n}
+			result, err := e.Compute(ctx, r, n)
+			if err != nil {
+				setError(&responses[i], err)
+				return
+			}
+			responses[i].Result = result
+			e.Sign(&responses[i])
+		}
+
+		wg.Add(1)
+		if err := e.pool.Submit(priorityFrom(ctx), task); err != nil {
+			task()
+		}
+	}
+
+	wg.Wait()
+	return responses
+}
@@ -0,0 +1,89 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"resilientrecursion/internal/logging"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// encodeBigCheckpoint renders x as a sorted-set member: "b<prec>:" followed
+// by x.Text('g', -1), the fewest decimal digits that parse back to exactly x
+// at that precision.
+func encodeBigCheckpoint(x *big.Float) string {
+	return fmt.Sprintf("b%d:%s", x.Prec(), x.Text('g', -1))
+}
+
+// decodeBigCheckpoint reads a member written by encodeBigCheckpoint at prec
+// bits. A member of any other precision, or a float64 member, is reported as
+// corrupt rather than read at the wrong precision.
+func decodeBigCheckpoint(member string, prec uint) (*big.Float, error) {
+	head, text, ok := strings.Cut(member, ":")
+	if !ok || head != "b"+strconv.FormatUint(uint64(prec), 10) {
+		return nil, fmt.Errorf("%w: not a %d-bit checkpoint", errCorruptCheckpoint, prec)
+	}
+	x, _, err := new(big.Float).SetPrec(prec).Parse(text, 10)
+	if err != nil || x.IsInf() {
+		return nil, fmt.Errorf("%w: %d-bit value does not parse", errCorruptCheckpoint, prec)
+	}
+	return x, nil
+}
+
+// computeBig is iterateBig from x0 of the series of r, resuming from and
+// writing big.Float checkpoints at prec when they are enabled. These live
+// under their own keys, apart from the float64 checkpoints, so a float64
+// series never resumes from a big.Float value or the other way round.
+func (e *ComputeEngine) computeBig(ctx context.Context, r float64, n int, prec uint) (*big.Float, error) {
+	if !e.bigCheckpoints || int64(n) < e.minRedisN.Load() {
+		return e.iterateBig(ctx, r, e.x0, n, prec)
+	}
+
+	key := bigCheckpointKey(TenantFrom(ctx), e.seriesHash(r, 0), prec)
+	x, from := e.findNearestBigCheckpoint(ctx, key, n, prec)
+	if x == nil {
+		x, from = new(big.Float).SetPrec(prec).SetFloat64(e.x0), 0
+	}
+
+	checkpointMod := int(e.checkpointMod.Load())
+	pipe := e.redisClient.Pipeline()
+	err := e.stepBig(ctx, r, x, from, n, func(i int, x *big.Float) {
+		if i%checkpointMod == 0 {
+			pipe.ZAdd(ctx, key, redis.Z{Score: float64(i), Member: encodeBigCheckpoint(x)})
+		}
+	})
+	if pipe.Len() > 0 {
+		pipe.Expire(ctx, key, time.Duration(e.checkpointTTL.Load()))
+		pipe.Exec(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+func (e *ComputeEngine) findNearestBigCheckpoint(ctx context.Context, key string, n int, prec uint) (*big.Float, int) {
+	result, err := e.readClient.ZRevRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{
+		Min:   "0",
+		Max:   strconv.Itoa(n),
+		Count: checkpointCandidates,
+	}).Result()
+	if err != nil {
+		return nil, 0
+	}
+
+	for _, z := range result {
+		x, err := decodeBigCheckpoint(z.Member.(string), prec)
+		if err != nil {
+			logging.Warnf("Ignoring checkpoint %s at n=%d: %v", key, int(z.Score), err)
+			continue
+		}
+		return x, int(z.Score)
+	}
+	return nil, 0
+}
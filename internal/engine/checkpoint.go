@@ -0,0 +1,150 @@
+package engine
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"resilientrecursion/pkg/config"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// maxExactScore is the largest n a sorted-set score, a float64, holds
+// exactly. Further out neighbouring n round to the same score.
+const maxExactScore = 1 << 53
+
+// encodeCheckpoint renders x as a sorted-set member in the configured
+// encoding: "%.15e" text, or the raw 8 IEEE-754 bytes in binary mode, which
+// is smaller and round-trips exactly.
+func encodeCheckpoint(x float64, encoding string) string {
+	if encoding == config.CheckpointEncodingBinary {
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(x))
+		return string(b[:])
+	}
+	return fmt.Sprintf("%.15e", x)
+}
+
+// errCorruptCheckpoint is wrapped by decodeCheckpoint for members that are
+// not a checkpoint value in either encoding.
+var errCorruptCheckpoint = errors.New("corrupt checkpoint")
+
+// decodeCheckpoint reads a member written in either encoding, so a pod can
+// switch encodings without invalidating existing checkpoints. Text members
+// are never exactly 8 bytes long. The whole member must parse, and NaN is
+// rejected, so garbage is reported instead of read as some x.
+func decodeCheckpoint(member string) (float64, error) {
+	var x float64
+	if len(member) == 8 {
+		x = math.Float64frombits(binary.LittleEndian.Uint64([]byte(member)))
+	} else {
+		var err error
+		if x, err = strconv.ParseFloat(member, 64); err != nil {
+			return 0, fmt.Errorf("%w: %q", errCorruptCheckpoint, member)
+		}
+	}
+	if math.IsNaN(x) {
+		return 0, fmt.Errorf("%w: NaN", errCorruptCheckpoint)
+	}
+	return x, nil
+}
+
+// checkpointZ is the sorted-set entry of the checkpoint x_n, scored by n.
+// Above maxExactScore the score is only approximate, so the member is
+// prefixed with the exact n and a colon. Neither encoding contains a colon
+// on its own, and binary members are never longer than 8 bytes.
+func checkpointZ(n int64, x float64, encoding string) redis.Z {
+	member := encodeCheckpoint(x, encoding)
+	if n > maxExactScore {
+		member = strconv.FormatInt(n, 10) + ":" + member
+	}
+	return redis.Z{Score: float64(n), Member: member}
+}
+
+// replaceCheckpointScript adds the member ARGV[2] at score ARGV[1] to the
+// set KEYS[1] after removing the members already there for the same n, so
+// the latest write wins. For an exact score, ARGV[3] is empty and those are
+// the members without an n prefix. Above maxExactScore, ARGV[3] is the
+// prefix of this n and only members carrying it go, leaving those of
+// neighbouring n that share the score.
+var replaceCheckpointScript = redis.NewScript(`
+local prefix = ARGV[3]
+for _, m in ipairs(redis.call('ZRANGEBYSCORE', KEYS[1], ARGV[1], ARGV[1])) do
+	local unprefixed = #m == 8 or not string.find(m, ':', 1, true)
+	if (prefix == '' and unprefixed) or (prefix ~= '' and not unprefixed and string.sub(m, 1, #prefix) == prefix) then
+		redis.call('ZREM', KEYS[1], m)
+	end
+end
+return redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+`)
+
+// addCheckpoint queues the write of x_n to the set key on pipe. With replace
+// any other value stored for n is removed in the same step, so n keeps
+// exactly one checkpoint; otherwise a different value, say from another
+// precision or encoding, is added beside it.
+func (e *ComputeEngine) addCheckpoint(ctx context.Context, pipe redis.Pipeliner, key string, n int64, x float64, replace bool) {
+	z := checkpointZ(n, x, e.checkpointEncoding)
+	if !replace {
+		pipe.ZAdd(ctx, key, z)
+		return
+	}
+	var prefix string
+	if n > maxExactScore {
+		prefix = strconv.FormatInt(n, 10) + ":"
+	}
+	replaceCheckpointScript.Eval(ctx, pipe, []string{key}, strconv.FormatInt(n, 10), z.Member, prefix)
+}
+
+// parseCheckpointZ reads back the n and x of an entry written by
+// checkpointZ.
+func parseCheckpointZ(z redis.Z) (int64, float64, error) {
+	member := z.Member.(string)
+	n := int64(z.Score)
+	if i := strings.IndexByte(member, ':'); i >= 0 && len(member) != 8 {
+		var err error
+		if n, err = strconv.ParseInt(member[:i], 10, 64); err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("%w: %q", errCorruptCheckpoint, member)
+		}
+		member = member[i+1:]
+	}
+	x, err := decodeCheckpoint(member)
+	return n, x, err
+}
+
+// maxSkippedCheckpoints bounds a run of skipped checkpoint writes, so a
+// compute resuming behind them iterates at most
+// (maxSkippedCheckpoints+1)*CHECKPOINT_MOD steps more than it would have.
+const maxSkippedCheckpoints = 63
+
+// checkpointDedupe decides, over one compute, which checkpoint writes
+// repeat the last checkpoint closely enough to leave out. A skipped write
+// costs nothing in correctness: a later compute resumes from the earlier
+// checkpoint and iterates from there.
+type checkpointDedupe struct {
+	tolerance float64
+	last      float64
+	have      bool
+	run       int
+}
+
+// skip reports whether a checkpoint of x can be left out, and otherwise
+// takes x as the last one written.
+func (d *checkpointDedupe) skip(x float64) bool {
+	if d.tolerance > 0 && d.have && d.run < maxSkippedCheckpoints && math.Abs(x-d.last) <= d.tolerance {
+		d.run++
+		return true
+	}
+	d.seen(x)
+	return false
+}
+
+// seen takes x as the last checkpoint stored, as for the one a compute
+// resumed from.
+func (d *checkpointDedupe) seen(x float64) {
+	d.last, d.have, d.run = x, true, 0
+}
@@ -0,0 +1,215 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"resilientrecursion/internal/models"
+	"resilientrecursion/pkg/config"
+)
+
+func TestCheckpointEncodingRoundTrip(t *testing.T) {
+	x := 0.1234567890123456789
+
+	got, err := decodeCheckpoint(encodeCheckpoint(x, config.CheckpointEncodingBinary))
+	if err != nil || got != x {
+		t.Errorf("binary round trip = %v, %v; want %v", got, err, x)
+	}
+
+	got, err = decodeCheckpoint(encodeCheckpoint(x, config.CheckpointEncodingText))
+	if err != nil || got != 1.234567890123457e-01 {
+		t.Errorf("text round trip = %v, %v", got, err)
+	}
+}
+
+func TestDecodeCheckpointRejectsGarbage(t *testing.T) {
+	for _, member := range []string{"garbage", "", "0.5abc", "NaN", encodeCheckpoint(math.NaN(), config.CheckpointEncodingBinary)} {
+		if x, err := decodeCheckpoint(member); !errors.Is(err, errCorruptCheckpoint) {
+			t.Errorf("decodeCheckpoint(%q) = %v, %v; want errCorruptCheckpoint", member, x, err)
+		}
+	}
+}
+
+func TestCheckpointZKeepsExactN(t *testing.T) {
+	for _, encoding := range []string{config.CheckpointEncodingText, config.CheckpointEncodingBinary} {
+		for _, n := range []int64{1<<31 - 1, 1 << 31, 1<<31 + 1, 1<<53 - 1, 1 << 53, 1<<53 + 1, 1<<53 + 3, math.MaxInt64} {
+			z := checkpointZ(n, 0.25, encoding)
+			at, x, err := parseCheckpointZ(z)
+			if err != nil || at != n || x != 0.25 {
+				t.Errorf("%s: parseCheckpointZ(checkpointZ(%d)) = %d, %v, %v", encoding, n, at, x, err)
+			}
+		}
+	}
+
+	// Past 2^53 neighbouring n share a score, so only the member tells them
+	// apart.
+	if a, b := checkpointZ(1<<53, 0, config.CheckpointEncodingText), checkpointZ(1<<53+1, 0, config.CheckpointEncodingText); a.Score != b.Score {
+		t.Fatalf("scores %v and %v differ; the test no longer covers shared scores", a.Score, b.Score)
+	}
+}
+
+func TestCheckpointLookupAboveExactScores(t *testing.T) {
+	e, _ := newTestEngine(t)
+	ctx := context.Background()
+	rHash := HashFloat64(3.7)
+	key := checkpointKey(TenantFrom(ctx), rHash)
+
+	e.storeCheckpoint(ctx, key, rHash, 1<<53, 0.25)
+	e.storeCheckpoint(ctx, key, rHash, 1<<53+1, 0.75)
+
+	for _, tc := range []struct {
+		n, wantN int64
+		wantX    float64
+	}{
+		{1 << 53, 1 << 53, 0.25},
+		{1<<53 + 1, 1<<53 + 1, 0.75},
+		{1<<53 + 2, 1<<53 + 1, 0.75},
+	} {
+		x, at := e.findNearestCheckpoint(ctx, key, tc.n)
+		if x == nil || *x != tc.wantX || at != tc.wantN {
+			t.Errorf("findNearestCheckpoint(%d) = %v, %d; want %v at %d", tc.n, x, at, tc.wantX, tc.wantN)
+		}
+	}
+
+	if x, ok, err := e.checkpointAt(ctx, key, 1<<53+1); err != nil || !ok || x != 0.75 {
+		t.Errorf("checkpointAt(2^53+1) = %v, %v, %v; want 0.75", x, ok, err)
+	}
+	if _, ok, err := e.checkpointAt(ctx, key, 1<<53+2); err != nil || ok {
+		t.Errorf("checkpointAt(2^53+2) found a checkpoint, err %v", err)
+	}
+}
+
+func TestCheckpointCollisions(t *testing.T) {
+	ctx := context.Background()
+	rHash := HashFloat64(3.7)
+	key := checkpointKey(TenantFrom(ctx), rHash)
+
+	for _, tc := range []struct {
+		replace bool
+		want    int64
+	}{
+		{true, 1},
+		{false, 2},
+	} {
+		e, mr := newTestEngine(t)
+		e.replaceCheckpoints = tc.replace
+		e.storeCheckpoint(ctx, key, rHash, 1000, 0.25)
+		e.storeCheckpoint(ctx, key, rHash, 1000, 0.75)
+
+		if got, err := e.redisClient.ZCard(ctx, key).Result(); err != nil || got != tc.want {
+			t.Errorf("replace=%v: %d members after two writes at n=1000, err %v; want %d", tc.replace, got, err, tc.want)
+		}
+		if tc.replace {
+			if x, ok, err := e.checkpointAt(ctx, key, 1000); err != nil || !ok || x != 0.75 {
+				t.Errorf("checkpointAt(1000) = %v, %v, %v; want the latest write, 0.75", x, ok, err)
+			}
+		}
+		mr.FlushAll()
+	}
+}
+
+func TestCheckpointReplaceKeepsNeighboursAboveExactScores(t *testing.T) {
+	e, _ := newTestEngine(t)
+	ctx := context.Background()
+	rHash := HashFloat64(3.7)
+	key := checkpointKey(TenantFrom(ctx), rHash)
+	e.replaceCheckpoints = true
+
+	e.storeCheckpoint(ctx, key, rHash, 1<<53+1, 0.75)
+	e.storeCheckpoint(ctx, key, rHash, 1<<53, 0.5)
+	e.storeCheckpoint(ctx, key, rHash, 1<<53, 0.25)
+
+	if got, _ := e.redisClient.ZCard(ctx, key).Result(); got != 2 {
+		t.Errorf("%d members; want one for each of 2^53 and 2^53+1", got)
+	}
+	for n, want := range map[int64]float64{1 << 53: 0.25, 1<<53 + 1: 0.75} {
+		if x, ok, err := e.checkpointAt(ctx, key, n); err != nil || !ok || x != want {
+			t.Errorf("checkpointAt(%d) = %v, %v, %v; want %v", n, x, ok, err, want)
+		}
+	}
+}
+
+func BenchmarkCheckpointText(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		decodeCheckpoint(encodeCheckpoint(0.1234567890123456789, config.CheckpointEncodingText))
+	}
+}
+
+func BenchmarkCheckpointBinary(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		decodeCheckpoint(encodeCheckpoint(0.1234567890123456789, config.CheckpointEncodingBinary))
+	}
+}
+
+// checkpointWrites counts the pipelines that write a checkpoint, each
+// refreshing its key's TTL.
+type checkpointWrites struct {
+	n atomic.Int64
+}
+
+func (h *checkpointWrites) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h *checkpointWrites) ProcessHook(next redis.ProcessHook) redis.ProcessHook { return next }
+
+func (h *checkpointWrites) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			if cmd.Name() == "expire" {
+				h.n.Add(1)
+				break
+			}
+		}
+		return next(ctx, cmds)
+	}
+}
+
+func TestCheckpointDedupeSkipsConvergedWrites(t *testing.T) {
+	// r=3.2 settles on a 2-cycle, so every checkpoint, 1000 steps apart,
+	// repeats the one before it.
+	const r, n = 3.2, 200000
+	written := func(tolerance float64) (int, models.Stats) {
+		mr := miniredis.RunT(t)
+		cfg := config.Default()
+		cfg.RedisAddr = mr.Addr()
+		cfg.TotalPods = 1
+		cfg.CheckpointDedupeTolerance = tolerance
+		e := NewComputeEngine(cfg)
+		defer e.Close()
+		// Every checkpoint of the cycle has the same member, so Redis holds
+		// one however many are written; count the writes instead.
+		hook := &checkpointWrites{}
+		e.redisClient.AddHook(hook)
+
+		got, err := e.Compute(context.Background(), r, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := directIterate(r, n); got != want {
+			t.Fatalf("tolerance %v: Compute = %v, want %v", tolerance, got, want)
+		}
+		return int(hook.n.Load()), e.Stats()
+	}
+
+	plain, stats := written(0)
+	if plain != n/1000 || stats.CheckpointsSkipped != 0 {
+		t.Fatalf("without dedupe wrote %d checkpoints and skipped %d, want %d and 0", plain, stats.CheckpointsSkipped, n/1000)
+	}
+
+	deduped, stats := written(1e-12)
+	if deduped*20 > plain {
+		t.Errorf("with dedupe wrote %d of %d checkpoints, want far fewer", deduped, plain)
+	}
+	if int(stats.CheckpointsSkipped) != plain-deduped {
+		t.Errorf("skipped %d writes, want %d", stats.CheckpointsSkipped, plain-deduped)
+	}
+	// A run of skips is bounded, so resumes stay close behind any n.
+	if min := plain / (maxSkippedCheckpoints + 1); deduped < min {
+		t.Errorf("with dedupe wrote %d checkpoints, want at least %d", deduped, min)
+	}
+}
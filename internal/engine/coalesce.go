@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// flightKey identifies a compute by everything its result depends on. x_0
+// is the same for every compute of an engine, so it is left out.
+type flightKey struct {
+	tenant string
+	kind   string
+	r, c   float64
+	clamp  bool
+	n      int64
+}
+
+// flight is a compute in progress. done is closed once x, reached and err
+// are set.
+type flight struct {
+	done    chan struct{}
+	x       float64
+	reached int64
+	err     error
+}
+
+// flights tracks the computes in progress, so that identical ones started
+// while the first is running wait for its result instead of repeating it.
+type flights struct {
+	mu       sync.Mutex
+	inFlight map[flightKey]*flight
+}
+
+// coalescable reports whether a compute with opts can share its result:
+// per-call deadlines, callbacks and where the compute resumed would not
+// apply to the callers that wait, and a fresh compute must not be answered
+// from a cached one. stats, when set, only counts the work of the caller
+// that computes.
+func (opts computeOpts) coalescable() bool {
+	return opts.deadline.IsZero() && opts.progress == nil && opts.checkpoint == nil && opts.resumed == nil && !opts.fresh
+}
+
+// coalesce runs fn unless an identical compute is in flight, in which case
+// it waits for that one's result. If the compute it waited for was
+// cancelled while ctx is still live, it computes on its own instead, so one
+// caller giving up never fails the others.
+func (e *ComputeEngine) coalesce(ctx context.Context, key flightKey, fn func() (float64, int64, error)) (float64, int64, error) {
+	e.flights.mu.Lock()
+	if f, ok := e.flights.inFlight[key]; ok {
+		e.flights.mu.Unlock()
+		e.recorder.CoalescedCompute()
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return 0, 0, ctx.Err()
+		}
+		if isContextErr(f.err) && ctx.Err() == nil {
+			return fn()
+		}
+		return f.x, f.reached, f.err
+	}
+	f := &flight{done: make(chan struct{})}
+	if e.flights.inFlight == nil {
+		e.flights.inFlight = make(map[flightKey]*flight)
+	}
+	e.flights.inFlight[key] = f
+	e.flights.mu.Unlock()
+
+	defer func() {
+		e.flights.mu.Lock()
+		delete(e.flights.inFlight, key)
+		e.flights.mu.Unlock()
+		close(f.done)
+	}()
+	f.x, f.reached, f.err = fn()
+	return f.x, f.reached, f.err
+}
+
+func isContextErr(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// waitCoalesced waits until want computes are waiting on another's result.
+func waitCoalesced(t *testing.T, e *ComputeEngine, want float64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(e.metrics.CoalescedComputes) < want {
+		if time.Now().After(deadline) {
+			t.Fatalf("%v computes coalesced, want %v", testutil.ToFloat64(e.metrics.CoalescedComputes), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConcurrentIdenticalComputesCoalesce(t *testing.T) {
+	e, mr := newTestEngine(t)
+	const callers = 10
+	r, n := 3.7, int64(5000)
+
+	// Holding Redis keeps the first compute at its checkpoint lookup until
+	// every other caller is waiting on it.
+	mr.Lock()
+	results := make([]float64, callers)
+	errs := make([]error, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = e.Compute(context.Background(), r, n)
+		}(i)
+	}
+	waitCoalesced(t, e, callers-1)
+	mr.Unlock()
+	wg.Wait()
+
+	want := directIterate(r, n)
+	for i := range results {
+		if errs[i] != nil || results[i] != want {
+			t.Errorf("caller %d: %v, %v, want %v", i, results[i], errs[i], want)
+		}
+	}
+	if got := testutil.ToFloat64(e.metrics.CoalescedComputes); got != callers-1 {
+		t.Errorf("%v computes coalesced, want %d: only one should iterate", got, callers-1)
+	}
+}
+
+func TestCoalescedComputeOutlivesCancelledLeader(t *testing.T) {
+	e, mr := newTestEngine(t)
+	r, n := 3.7, int64(5000)
+
+	mr.Lock()
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := e.Compute(leaderCtx, r, n)
+		leaderErr <- err
+	}()
+	for {
+		e.flights.mu.Lock()
+		started := len(e.flights.inFlight) > 0
+		e.flights.mu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	type result struct {
+		x   float64
+		err error
+	}
+	follower := make(chan result, 1)
+	go func() {
+		x, err := e.Compute(context.Background(), r, n)
+		follower <- result{x, err}
+	}()
+	waitCoalesced(t, e, 1)
+	cancel()
+	mr.Unlock()
+
+	if err := <-leaderErr; err != context.Canceled {
+		t.Errorf("leader: err = %v, want context.Canceled", err)
+	}
+	if got := <-follower; got.err != nil || got.x != directIterate(r, n) {
+		t.Errorf("follower: %v, %v, want %v", got.x, got.err, directIterate(r, n))
+	}
+}
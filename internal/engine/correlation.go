@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+)
+
+// ErrTooFewPairs is returned when fewer than two radii have any pair of points
+// within them, leaving no slope to fit.
+var ErrTooFewPairs = errors.New("too few radii with close pairs to fit a slope")
+
+// CorrelationDimension estimates the correlation dimension of the attractor
+// at r with the Grassberger–Procaccia algorithm. It collects
+// x_{transient+1}..x_n as Density does and computes, at each of count radii
+// spaced logarithmically over [epsMin, epsMax], the correlation integral
+// C(eps): the fraction of pairs of points closer than eps. The estimate is
+// the least-squares slope of log C against log eps, fitted over the radii
+// where C > 0.
+//
+// The points are one-dimensional, so sorting them lets each C(eps) be
+// counted in linear time instead of over all pairs.
+func (e *ComputeEngine) CorrelationDimension(ctx context.Context, r float64, n, transient, count int, epsMin, epsMax float64) (radii, integrals []float64, slope float64, err error) {
+	if count < 2 || !(epsMin > 0) || !(epsMax > epsMin) {
+		return nil, nil, 0, ErrInvalidRange
+	}
+
+	points := make([]float64, 0, n-transient)
+	err = e.walk(ctx, r, int64(n), false, func(i int64, x float64) {
+		if i > int64(transient) {
+			points = append(points, x)
+		}
+	})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	sort.Float64s(points)
+
+	radii = make([]float64, count)
+	integrals = make([]float64, count)
+	pairs := float64(len(points)) * float64(len(points)-1) / 2
+	step := math.Log(epsMax/epsMin) / float64(count-1)
+	for k := range radii {
+		radii[k] = epsMin * math.Exp(step*float64(k))
+		if pairs > 0 {
+			integrals[k] = float64(closePairs(points, radii[k])) / pairs
+		}
+	}
+
+	slope, ok := logLogSlope(radii, integrals)
+	if !ok {
+		return radii, integrals, 0, ErrTooFewPairs
+	}
+	return radii, integrals, slope, nil
+}
+
+// closePairs counts the pairs of sorted points less than eps apart.
+func closePairs(sorted []float64, eps float64) int {
+	var pairs, j int
+	for i := range sorted {
+		if j < i {
+			j = i
+		}
+		for j+1 < len(sorted) && sorted[j+1]-sorted[i] < eps {
+			j++
+		}
+		pairs += j - i
+	}
+	return pairs
+}
+
+// logLogSlope fits log y = a + b log x by least squares over the points with
+// y > 0 and returns b. It reports false when fewer than two points qualify.
+func logLogSlope(xs, ys []float64) (float64, bool) {
+	var n, sx, sy, sxx, sxy float64
+	for i := range xs {
+		if ys[i] <= 0 {
+			continue
+		}
+		lx, ly := math.Log(xs[i]), math.Log(ys[i])
+		n++
+		sx += lx
+		sy += ly
+		sxx += lx * lx
+		sxy += lx * ly
+	}
+	if n < 2 {
+		return 0, false
+	}
+	return (n*sxy - sx*sy) / (n*sxx - sx*sx), true
+}
@@ -0,0 +1,61 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCorrelationDimension(t *testing.T) {
+	e, _ := newTestEngine(t)
+	ctx := context.Background()
+
+	// A chaotic orbit near r=4 fills an interval, so the dimension is close to 1.
+	radii, integrals, slope, err := e.CorrelationDimension(ctx, 3.99, 20000, 1000, 10, 1e-3, 1e-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(radii) != 10 || len(integrals) != 10 || radii[0] != 1e-3 {
+		t.Fatalf("radii %v, integrals %v", radii, integrals)
+	}
+	for k := 1; k < len(integrals); k++ {
+		if integrals[k] < integrals[k-1] {
+			t.Errorf("C(%v)=%v below C(%v)=%v", radii[k], integrals[k], radii[k-1], integrals[k-1])
+		}
+	}
+	if slope < 0.8 || slope > 1.1 {
+		t.Errorf("chaotic slope = %v, want about 1", slope)
+	}
+
+	// A 4-cycle is a set of points: below the gaps between them C is flat
+	// and the dimension 0.
+	_, _, slope, err = e.CorrelationDimension(ctx, 3.5, 5000, 1000, 10, 1e-4, 1e-2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slope < -0.01 || slope > 0.01 {
+		t.Errorf("periodic slope = %v, want 0", slope)
+	}
+
+	if _, _, _, err := e.CorrelationDimension(ctx, 3.5, 10, 9, 10, 1e-3, 1e-1); !errors.Is(err, ErrTooFewPairs) {
+		t.Errorf("single point: err = %v, want ErrTooFewPairs", err)
+	}
+}
+
+func TestClosePairs(t *testing.T) {
+	points := []float64{0, 0, 0.1, 0.25, 0.3}
+	for _, tc := range []struct {
+		eps  float64
+		want int
+	}{
+		{0.01, 1}, // the two zeros
+		{0.06, 2}, // and 0.25-0.3
+		{0.11, 4}, // and 0-0.1 twice
+		{0.19, 5}, // and 0.1-0.25
+		{1, 10},   // every pair
+	} {
+		if got := closePairs(points, tc.eps); got != tc.want {
+			t.Errorf("closePairs(eps=%v) = %d, want %d", tc.eps, got, tc.want)
+		}
+	}
+}
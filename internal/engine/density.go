@@ -0,0 +1,41 @@
+package engine
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrInvalidBins is returned when a histogram has no bins.
+var ErrInvalidBins = errors.New("bins must be positive")
+
+// Density histograms x_{transient+1}..x_n for r into bins equal-width buckets
+// over [0,1], estimating the invariant density of the attractor. Values
+// outside [0,1] (divergent r) are counted separately. Cached steps are reused
+// but new ones are not stored, since n is typically far larger than a series
+// worth keeping.
+func (e *ComputeEngine) Density(ctx context.Context, r float64, n, transient, bins int) (counts []int, outside int, err error) {
+	if bins <= 0 {
+		return nil, 0, ErrInvalidBins
+	}
+
+	counts = make([]int, bins)
+	err = e.walk(ctx, r, int64(n), false, func(i int64, x float64) {
+		if i <= int64(transient) {
+			return
+		}
+		if x < 0 || x > 1 {
+			outside++
+			return
+		}
+		bin := int(x * float64(bins))
+		if bin == bins {
+			bin--
+		}
+		counts[bin]++
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return counts, outside, nil
+}
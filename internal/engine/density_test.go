@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestDensityMatchesReference(t *testing.T) {
+	e, _ := newTestEngine(t)
+	ctx := context.Background()
+	const r, n, transient, bins = 3.9, 50000, 1000, 20
+
+	want := make([]int, bins)
+	x := e.X0()
+	for i := 1; i <= n; i++ {
+		x = r * x * (1 - x)
+		if i > transient {
+			want[int(x*bins)]++
+		}
+	}
+
+	// Part of the orbit is cached and read back, the rest computed.
+	if _, err := e.Compute(ctx, r, 2*transient); err != nil {
+		t.Fatal(err)
+	}
+	counts, outside, err := e.Density(ctx, r, n, transient, bins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outside != 0 {
+		t.Errorf("%d values outside [0, 1] for r=%v", outside, r)
+	}
+	for i := range want {
+		if counts[i] != want[i] {
+			t.Errorf("bin %d = %d, want %d", i, counts[i], want[i])
+		}
+	}
+
+	// The long walk is not kept in L1.
+	if _, _, ok := e.l1Cache.Floor(e.seriesHash(r, 0), n, 2*transient); ok {
+		t.Error("Density stored steps past the cached ones")
+	}
+}
+
+func TestDensityOfACycle(t *testing.T) {
+	e, _ := newTestEngine(t)
+
+	// r = 3.2 settles on a 2-cycle, so after the transient every sample
+	// falls in one of two bins.
+	counts, outside, err := e.Density(context.Background(), 3.2, 10000, 1000, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filled, total := 0, 0
+	for _, c := range counts {
+		if c > 0 {
+			filled++
+		}
+		total += c
+	}
+	if filled != 2 || total != 9000 || outside != 0 {
+		t.Errorf("counts %v, %d outside; want 9000 samples in 2 bins", counts, outside)
+	}
+}
+
+func TestDensityCountsDivergentValues(t *testing.T) {
+	e, _ := newTestEngine(t)
+
+	// r = 4.2 leaves [0, 1] at once.
+	counts, outside, err := e.Density(context.Background(), 4.2, 5, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inside := 0
+	for _, c := range counts {
+		inside += c
+	}
+	if outside == 0 || inside+outside != 5 {
+		t.Errorf("counts %v, %d outside; want the escaped values counted apart", counts, outside)
+	}
+
+	if _, _, err := e.Density(context.Background(), 3.9, 100, 0, 0); !errors.Is(err, ErrInvalidBins) {
+		t.Errorf("zero bins: err = %v, want ErrInvalidBins", err)
+	}
+}
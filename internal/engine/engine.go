@@ -1,167 +1,1038 @@
-﻿package engine
+package engine
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"math"
+	"math/rand"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"resilientrecursion/internal/cache"
+	"resilientrecursion/internal/logging"
+	"resilientrecursion/internal/metrics"
+	"resilientrecursion/internal/models"
+	"resilientrecursion/internal/worker"
+	"resilientrecursion/pkg/config"
+	"resilientrecursion/pkg/logistic"
+	"resilientrecursion/pkg/signature"
 
 	"github.com/redis/go-redis/v9"
 )
 
+// ErrDiverged is returned when a perturbed map leaves every bounded region,
+// so no finite x_n exists.
+var ErrDiverged = errors.New("iteration diverged")
+
+// ErrDegenerate is returned when rounding drives an r=4 orbit onto x=1,
+// after which float64 sticks at 0 while the true orbit keeps moving.
+var ErrDegenerate = errors.New("numerically degenerate: rounding reached x=1")
+
+// checkpointCandidates is how many checkpoints at or below n are fetched at
+// once, so a corrupt one can be skipped for the next lower one.
+const checkpointCandidates = 4
+
+// divergenceBound is the |x| beyond which a perturbed orbit is taken to have
+// escaped; past it each step roughly squares x.
+const divergenceBound = 1e3
+
 type ComputeEngine struct {
-	l1Cache       *cache.L1Cache
-	redisClient   *redis.Client
-	checkpointMod int
-	podID         string
-	totalPods     int
+	// l1Cache belongs to the default tenant. caches holds one cache per
+	// configured tenant, l1Cache included, and tenants lists their names in
+	// a fixed order; neither changes after construction.
+	l1Cache     *cache.L1Cache
+	caches      map[string]*cache.L1Cache
+	tenants     []string
+	redisClient *redis.Client
+	// readClient serves checkpoint and series reads: a replica when one is
+	// configured, otherwise redisClient. Writes always go to redisClient.
+	readClient *redis.Client
+	// checkpointMod, checkpointTTL (nanoseconds), minRedisN,
+	// cancelCheckStride and the generation of the caches can change at
+	// runtime through ApplyReload.
+	checkpointMod     atomic.Int64
+	checkpointTTL     atomic.Int64
+	minRedisN         atomic.Int64
+	cancelCheckStride atomic.Int64
+	podID             string
+	totalPods         int
+	podWeights        []float64
+
+	strictSharding bool
+
+	dedupeBatches bool
+	perRBudget    time.Duration
+
+	ownedCheckpointsOnly bool
+
+	coalesceComputes bool
+	flights          flights
+
+	// checkpointResumes and iterationsSaved back Stats.
+	checkpointResumes atomic.Int64
+	iterationsSaved   atomic.Int64
+	// checkpointsSkipped counts the writes checkpoint dedupe left out.
+	checkpointsSkipped atomic.Int64
+
+	// clampComputes clamps every compute; see computeOpts.clamp.
+	clampComputes bool
+
+	staleWhileRevalidate bool
+	refreshes            refreshes
+
+	flushFullSeries   bool
+	convergedTails    bool
+	seriesCompression string
+	flushScope        string
+	flushJitter       time.Duration
+
+	checkpointEncoding string
+	// replaceCheckpoints removes what a checkpoint set holds at n before
+	// writing x_n there; see addCheckpoint.
+	replaceCheckpoints bool
+	bigCheckpoints     bool
+	// checkpointDedupe is the tolerance of checkpointDedupe, 0 for off.
+	checkpointDedupe float64
+
+	preheatLimit int
+
+	// metrics is the Prometheus recorder, served on /metrics. recorder is
+	// where measurements go: metrics alone, or metrics and statsd.
+	metrics        *metrics.Metrics
+	recorder       metrics.Recorder
+	statsd         *metrics.StatsD
+	sampleInterval time.Duration
+	sampleKeys     int
+	sampleCursor   uint64
+
+	nonLocalLogEvery int
+	nonLocalCount    atomic.Uint64
+
+	pool      *worker.Pool
+	jobTTL    time.Duration
+	jobCtx    context.Context
+	cancelJob context.CancelCauseFunc
+	jobStream string
+
+	// jobsRunning counts the async jobs in progress and jobsSaved those that
+	// stored partial progress on shutdown; see StopJobs.
+	jobsRunning atomic.Int64
+	jobsSaved   atomic.Int64
+	jobCancels  jobCancels
+
+	checkpointChannel string
+
+	signingKey     []byte
+	compareEpsilon float64
+
+	// x0 is x_0 of every series.
+	x0 float64
 }
 
-func NewComputeEngine(redisAddr, podID string, totalPods int) *ComputeEngine {
-	rdb := redis.NewClient(&redis.Options{
-		Addr:         redisAddr,
+func NewComputeEngine(cfg *config.Config) *ComputeEngine {
+	rdb := newRedisClient(cfg.RedisAddr)
+	readRdb := rdb
+	if cfg.RedisReplicaAddr != "" {
+		readRdb = newRedisClient(cfg.RedisReplicaAddr)
+	}
+
+	// Prometheus always records, for /metrics; StatsD records alongside it
+	// when configured.
+	m := metrics.New(cfg.PodID, cfg.ComputeDurationBuckets, cfg.ComputeIterationBuckets)
+	var recorder metrics.Recorder = m
+	var statsd *metrics.StatsD
+	if cfg.StatsdAddr != "" {
+		var err error
+		if statsd, err = metrics.NewStatsD(cfg.StatsdAddr, cfg.PodID, cfg.StatsdTags); err != nil {
+			logging.Errorf("Not sending metrics to StatsD: %v", err)
+		} else {
+			recorder = metrics.Multi{m, statsd}
+		}
+	}
+	rdb.AddHook(metrics.NewRedisHook(recorder))
+	if readRdb != rdb {
+		readRdb.AddHook(metrics.NewRedisHook(recorder))
+	}
+	if limit := newRedisLimit(cfg.RedisMaxConcurrent); limit != nil {
+		rdb.AddHook(limit)
+		if readRdb != rdb {
+			readRdb.AddHook(limit)
+		}
+	}
+
+	jobCtx, cancelJob := context.WithCancelCause(context.Background())
+
+	e := &ComputeEngine{
+		l1Cache:     newL1Cache(cfg),
+		redisClient: rdb,
+		readClient:  readRdb,
+		podID:       cfg.PodID,
+		totalPods:   cfg.TotalPods,
+		podWeights:  cfg.PodWeights,
+
+		strictSharding: cfg.StrictSharding,
+
+		dedupeBatches: cfg.BatchDuplicates == config.BatchDuplicatesDedupe,
+		perRBudget:    cfg.PerRBudget,
+
+		ownedCheckpointsOnly: cfg.OwnedCheckpointsOnly,
+
+		coalesceComputes: cfg.CoalesceComputes,
+
+		clampComputes: cfg.ClampComputes,
+
+		staleWhileRevalidate: cfg.StaleWhileRevalidate,
+
+		flushFullSeries:   cfg.FlushFullSeries,
+		convergedTails:    cfg.ConvergedTails,
+		seriesCompression: cfg.SeriesCompression,
+		flushScope:        cfg.FlushScope,
+		flushJitter:       cfg.FlushJitter,
+
+		checkpointEncoding: cfg.CheckpointEncoding,
+		replaceCheckpoints: cfg.CheckpointCollisions == config.CheckpointCollisionsReplace,
+		checkpointDedupe:   cfg.CheckpointDedupeTolerance,
+		bigCheckpoints:     cfg.BigCheckpoints,
+
+		preheatLimit: cfg.PreheatLimit,
+
+		metrics:        m,
+		recorder:       recorder,
+		statsd:         statsd,
+		sampleInterval: cfg.CheckpointSampleInterval,
+		sampleKeys:     cfg.CheckpointSampleKeys,
+
+		nonLocalLogEvery: cfg.NonLocalLogEvery,
+
+		pool:      worker.NewPool(cfg.Workers, cfg.QueueSize, cfg.StarvationLimit),
+		jobTTL:    cfg.JobTTL,
+		jobCtx:    jobCtx,
+		cancelJob: cancelJob,
+		jobStream: cfg.JobStream,
+
+		checkpointChannel: cfg.CheckpointChannel,
+
+		signingKey:     []byte(cfg.ResultSigningKey),
+		compareEpsilon: cfg.CompareEpsilon,
+
+		x0: cfg.X0,
+	}
+	e.checkpointMod.Store(int64(cfg.CheckpointMod))
+	e.checkpointTTL.Store(int64(cfg.CheckpointTTL))
+	e.minRedisN.Store(int64(minRedisN(cfg)))
+	e.cancelCheckStride.Store(int64(cfg.CancelCheckStride))
+
+	for _, priority := range worker.Priorities {
+		priority := priority
+		recorder.WatchQueueDepth(priority.String(), func() int { return e.pool.QueueDepthAt(priority) })
+	}
+
+	e.caches = map[string]*cache.L1Cache{config.DefaultTenant: e.l1Cache}
+	e.tenants = []string{config.DefaultTenant}
+	for _, tenant := range cfg.Tenants {
+		if _, ok := e.caches[tenant]; !ok {
+			e.caches[tenant] = newL1Cache(cfg)
+			e.tenants = append(e.tenants, tenant)
+		}
+	}
+
+	for _, r := range cfg.PinnedRValues {
+		for _, tenant := range e.tenants {
+			if err := e.caches[tenant].Pin(e.seriesHash(r, 0)); err != nil {
+				logging.Warnf("Not pinning r=%v for tenant %s: %v", r, tenant, err)
+			}
+		}
+	}
+	return e
+}
+
+// ApplyReload picks up the settings that may change without a restart.
+func (e *ComputeEngine) ApplyReload(cfg *config.Config) {
+	e.checkpointMod.Store(int64(cfg.CheckpointMod))
+	e.checkpointTTL.Store(int64(cfg.CheckpointTTL))
+	e.minRedisN.Store(int64(minRedisN(cfg)))
+	e.cancelCheckStride.Store(int64(cfg.CancelCheckStride))
+	for _, l1 := range e.caches {
+		l1.SetGeneration(int64(cfg.CacheGeneration))
+	}
+}
+
+// newRedisClient connects to the Redis server at addr.
+func newRedisClient(addr string) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:         addr,
 		DialTimeout:  2 * time.Second,
 		ReadTimeout:  1 * time.Second,
 		WriteTimeout: 1 * time.Second,
 		PoolSize:     10,
 	})
+}
+
+// newL1Cache builds one tenant's L1 cache.
+func newL1Cache(cfg *config.Config) *cache.L1Cache {
+	if cfg.DisableL1 {
+		return cache.NewDisabledL1Cache()
+	}
+	l1 := cache.NewL1Cache(cfg.CacheSize)
+	l1.SetGeneration(int64(cfg.CacheGeneration))
+	return l1
+}
+
+// minRedisN resolves MinRedisN, which defaults to the checkpoint interval.
+func minRedisN(cfg *config.Config) int {
+	if cfg.MinRedisN == 0 {
+		return cfg.CheckpointMod
+	}
+	return cfg.MinRedisN
+}
+
+// Compute returns x_n of the logistic map at r from X0: the value
+// logistic.ComputeLogistic returns, resumed from L1 or a checkpoint where
+// one is known.
+func (e *ComputeEngine) Compute(ctx context.Context, r float64, n int64) (float64, error) {
+	x, _, err := e.compute(ctx, r, n, computeOpts{})
+	return x, err
+}
+
+// ComputePerturbed computes x_n of the perturbed map x = r*x*(1-x) + c, cached
+// under its own key. c == 0 is exactly Compute. It returns ErrDiverged if the
+// orbit escapes.
+func (e *ComputeEngine) ComputePerturbed(ctx context.Context, r, c float64, n int64) (float64, error) {
+	x, _, err := e.compute(ctx, r, n, computeOpts{c: c})
+	return x, err
+}
+
+// ComputeWithin iterates toward x_n but stops once budget has elapsed,
+// returning the value reached so far and the n it belongs to. reached < n
+// means the result is partial; every step up to reached is cached as usual,
+// so a later call resumes from there.
+func (e *ComputeEngine) ComputeWithin(ctx context.Context, r float64, n int64, budget time.Duration) (x float64, reached int64, err error) {
+	return e.compute(ctx, r, n, computeOpts{deadline: time.Now().Add(budget)})
+}
+
+// ComputeProgress is Compute with progress reporting: progress is called
+// with the current n every 1024 iterations while x_n is computed.
+// It runs on the computing goroutine and must be cheap.
+func (e *ComputeEngine) ComputeProgress(ctx context.Context, r float64, n int64, progress func(i int64)) (float64, error) {
+	x, _, err := e.compute(ctx, r, n, computeOpts{progress: progress})
+	return x, err
+}
+
+// ComputeCheckpoints is Compute that also returns the checkpoint-aligned
+// points it resumed from or passed on the way to x_n, in ascending n.
+func (e *ComputeEngine) ComputeCheckpoints(ctx context.Context, r float64, n int64) (float64, []models.Checkpoint, error) {
+	var checkpoints []models.Checkpoint
+	x, _, err := e.compute(ctx, r, n, computeOpts{checkpoint: collectCheckpoints(&checkpoints)})
+	return x, checkpoints, err
+}
+
+// ComputeFresh computes x_n from x0 without reading L1 or any checkpoint,
+// for clients checking results against a reference. With store the series
+// is written to L1 and Redis as usual, checkpoints replacing any already
+// stored at the same n; without it nothing is written. Fresh computes are
+// never coalesced with others.
+func (e *ComputeEngine) ComputeFresh(ctx context.Context, r float64, n int64, store bool) (float64, error) {
+	opts := computeOpts{fresh: true}
+	if !store {
+		opts.into = cache.NewL1Cache(1)
+	}
+	x, _, err := e.compute(ctx, r, n, opts)
+	return x, err
+}
+
+// collectCheckpoints returns a computeOpts.checkpoint callback appending to
+// dst.
+func collectCheckpoints(dst *[]models.Checkpoint) func(n int64, x float64) {
+	return func(n int64, x float64) {
+		*dst = append(*dst, models.Checkpoint{N: n, Value: x})
+	}
+}
+
+// computeOpts are the optional parts of a compute. The zero value is a plain
+// logistic compute with no wall-clock limit.
+type computeOpts struct {
+	c        float64   // perturbation added each step
+	deadline time.Time // zero means no limit
+	progress func(i int64)
+
+	// checkpoint, if set, is called with every checkpoint-aligned point from
+	// the one the compute starts at up to n. Once an absorbing state is
+	// reached the remaining points all equal the last one and are skipped.
+	checkpoint func(n int64, x float64)
+
+	// stats, if set, accumulates the iterations taken and L1 hits.
+	stats *models.BatchMeta
+
+	// into, if set, receives the series in place of the tenant's L1, and
+	// neither L1 nor Redis is read or written; see refresh.
+	into *cache.L1Cache
+
+	// resumed, if set, receives the n the compute started from, or -1 when
+	// x_n was cached in L1.
+	resumed *int64
 
-	return &ComputeEngine{
-		l1Cache:       cache.NewL1Cache(75),
-		redisClient:   rdb,
-		checkpointMod: 1000,
-		podID:         podID,
-		totalPods:     totalPods,
+	// fresh, if set, starts from x0 without reading L1 or Redis. What is
+	// computed is still written unless into is set; see ComputeFresh.
+	fresh bool
+
+	// step, if set, iterates x = step(r, x) instead of the logistic map,
+	// under the series key of kind. c is not applied to it.
+	kind string
+	step func(r, x float64) float64
+
+	// clamp, if set, applies ClampX to x after every step and keys the
+	// series apart from the unclamped one.
+	clamp bool
+}
+
+// clampHi is the largest float64 below 1. The logistic map sends 1 to 0,
+// so an x clamped to 1 itself would be absorbed there on the next step.
+var clampHi = math.Nextafter(1, 0)
+
+// ClampX clamps x into [0, clampHi]. It keeps an orbit that rounding, a
+// perturbation or an r above 4 pushes just outside [0, 1] within it, and a
+// step at r near 4 that rounds up to 1 goes on from just below 1 instead of
+// collapsing to 0. The clamped orbit differs from the unclamped one from the
+// first step that is clamped, so the result is not x_n of the map but of
+// its clamped version; NaN is left as it is.
+func ClampX(x float64) float64 {
+	if x < 0 {
+		return 0
+	}
+	if x > clampHi {
+		return clampHi
 	}
+	return x
 }
 
-func (e *ComputeEngine) Compute(ctx context.Context, r float64, n int) (float64, error) {
-	rHash := HashFloat64(r)
+// seriesKey keys the series computed with opts at r.
+func (e *ComputeEngine) seriesKey(r float64, opts computeOpts) uint64 {
+	rHash := e.seriesHash(r, opts.c)
+	if opts.step != nil {
+		rHash = HashMapSeries(opts.kind, r, e.x0)
+	}
+	if opts.clamp {
+		rHash = HashClampedSeries(rHash)
+	}
+	return rHash
+}
 
-	if val, ok := e.l1Cache.Get(rHash, n); ok {
-		return val, nil
+// compute is the shared iteration behind the Compute* methods. Identical
+// computes running at the same time share one iteration when coalescing is
+// on and opts allow it.
+func (e *ComputeEngine) compute(ctx context.Context, r float64, n int64, opts computeOpts) (float64, int64, error) {
+	if e.clampComputes {
+		opts.clamp = true
+	}
+	if !e.coalesceComputes || !opts.coalescable() {
+		return e.iterate(ctx, r, n, opts)
 	}
+	key := flightKey{tenant: TenantFrom(ctx), kind: opts.kind, r: r, c: opts.c, clamp: opts.clamp, n: n}
+	return e.coalesce(ctx, key, func() (float64, int64, error) {
+		return e.iterate(ctx, r, n, opts)
+	})
+}
 
-	if !e.isLocalR(rHash) {
-		log.Printf("Warning: Computing non-local r=%.6f", r)
+func (e *ComputeEngine) iterate(ctx context.Context, r float64, n int64, opts computeOpts) (float64, int64, error) {
+	start := time.Now()
+	var iterations int64
+	defer func() { e.recorder.Compute(time.Since(start), iterations) }()
+
+	c, deadline := opts.c, opts.deadline
+	rHash := e.seriesKey(r, opts)
+	checkpointMod := e.checkpointMod.Load()
+	aligned := func(i int64, x float64) {
+		if opts.checkpoint != nil && i > 0 && i%checkpointMod == 0 {
+			opts.checkpoint(i, x)
+		}
 	}
 
-	checkpoint, startN := e.findNearestCheckpoint(ctx, rHash, n)
+	l1, err := e.cacheFor(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	if opts.into != nil {
+		l1 = opts.into
+	} else if l1.Stale(rHash) && !opts.fresh {
+		return e.revalidate(ctx, l1, rHash, r, n, opts)
+	}
+	if val, ok := l1.Get(rHash, n); ok && !opts.fresh {
+		if opts.stats != nil {
+			opts.stats.CacheHits++
+		}
+		opts.setResumed(-1)
+		aligned(n, val)
+		return val, n, nil
+	}
+	if n == 0 {
+		// x_0 is the seed itself: there is nothing to look up or iterate.
+		l1.Set(rHash, 0, e.x0)
+		opts.setResumed(0)
+		return e.x0, 0, nil
+	}
+
+	local := e.isLocalR(rHash)
+	if !local {
+		if e.strictSharding {
+			return 0, 0, &NotOwnerError{R: r, Owner: e.ownerOf(rHash)}
+		}
+		e.noteNonLocal(r, rHash)
+	}
+	// Below minRedisN a Redis round trip costs more than recomputing, so
+	// small queries neither look up nor store checkpoints.
+	useRedis := n >= e.minRedisN.Load() && opts.into == nil
+	// Leave checkpoints of r values owned elsewhere to their owner.
+	writeCheckpoints := useRedis && (local || !e.ownedCheckpointsOnly)
+
+	key := checkpointKey(TenantFrom(ctx), rHash)
+	var checkpoint *float64
+	var startN int64
+	if useRedis && !opts.fresh {
+		checkpoint, startN = e.findNearestCheckpoint(ctx, key, n)
+	}
 
 	var x float64
-	var computeFrom int
+	var computeFrom int64
 
 	if checkpoint != nil {
 		x = *checkpoint
 		computeFrom = startN
+		// Read through into L1, so the next query at startN, which the loop
+		// below never writes, is answered without Redis.
+		l1.Set(rHash, startN, x)
 	} else {
-		x = 0.5
+		x = e.x0
 		computeFrom = 0
 	}
 
-	for i := computeFrom; i < n; i++ {
-		x = r * x * (1 - x)
-		e.l1Cache.Set(rHash, i+1, x)
+	// Resume from the closest cached step below n if it is past the
+	// checkpoint. Only steps below n qualify, so a small n asked after a large
+	// one never picks up a value from later in the series.
+	if cachedN, cachedX, ok := l1.Floor(rHash, n, computeFrom); ok && !opts.fresh {
+		x = cachedX
+		computeFrom = cachedN
+	}
+	dedupe := checkpointDedupe{tolerance: e.checkpointDedupe}
+	if checkpoint != nil && computeFrom == startN {
+		e.noteCheckpointResume(startN)
+		dedupe.seen(x)
+	}
+	aligned(computeFrom, x)
+	opts.setResumed(computeFrom)
+	defer l1.SetParams(rHash, cache.SeriesParams{R: r, C: c, X0: e.x0})
+
+	// i is left at the last step taken however the loop exits.
+	var i int64
+	defer func(from int64) {
+		iterations = i - from
+		if opts.stats != nil {
+			opts.stats.TotalIterations += iterations
+		}
+	}(computeFrom)
+	stride := e.cancelCheckStride.Load()
+	for i = computeFrom; i < n; i++ {
+		if (i+1)%stride == 0 {
+			if err := ctx.Err(); err != nil {
+				if writeCheckpoints && i > computeFrom && errors.Is(context.Cause(ctx), ErrShuttingDown) {
+					// Keep the position reached, so the compute resumes
+					// from here after the restart.
+					e.storeCheckpoint(context.WithoutCancel(ctx), key, rHash, i, x)
+					noteSavedProgress(ctx)
+				}
+				return 0, i, err
+			}
+			if !deadline.IsZero() && time.Now().After(deadline) {
+				return x, i, nil
+			}
+			if opts.progress != nil {
+				opts.progress(i + 1)
+			}
+		}
+
+		var next float64
+		if opts.step != nil {
+			next = opts.step(r, x)
+		} else {
+			next = logistic.Step(r, x)
+		}
+		if next == 1 && x != 0.5 && c == 0 && opts.step == nil && !opts.clamp {
+			// Only r=4 reaches 1, and exactly only from x=0.5. From any other
+			// x the true value is just below 1 and the orbit goes on; the
+			// rounded one is absorbed at 0 two steps later.
+			return 0, i, fmt.Errorf("r=%v: %w at n=%d", r, ErrDegenerate, i+1)
+		}
+		if c != 0 {
+			next += c
+			if math.IsNaN(next) || math.Abs(next) > divergenceBound {
+				return 0, i, fmt.Errorf("r=%v c=%v: %w at n=%d", r, c, ErrDiverged, i+1)
+			}
+		}
+		if opts.clamp {
+			next = ClampX(next)
+		}
+		if next == x {
+			// Absorbing state (x=0, or the exact fixed point 1-1/r): every
+			// later x_i is the same, so skip the remaining iterations.
+			if e.convergedTails {
+				l1.SetConverged(rHash, i, x)
+			} else {
+				l1.Set(rHash, n, x)
+			}
+			return x, n, nil
+		}
+		x = next
+		l1.Set(rHash, i+1, x)
+
+		if writeCheckpoints && (i+1)%checkpointMod == 0 {
+			switch {
+			case opts.fresh:
+				e.replaceCheckpoint(ctx, key, rHash, i+1, x)
+			case dedupe.skip(x):
+				e.checkpointsSkipped.Add(1)
+				e.recorder.CheckpointSkipped()
+			default:
+				e.storeCheckpoint(ctx, key, rHash, i+1, x)
+			}
+		}
+		aligned(i+1, x)
+	}
+
+	return x, n, nil
+}
+
+func (opts computeOpts) setResumed(n int64) {
+	if opts.resumed != nil {
+		*opts.resumed = n
+	}
+}
+
+// X0 returns x_0 of every series.
+func (e *ComputeEngine) X0() float64 {
+	return e.x0
+}
 
-		if (i+1)%e.checkpointMod == 0 {
-			e.storeCheckpoint(ctx, rHash, i+1, x)
+// seriesHash keys the series of (r, c) from this engine's x0.
+func (e *ComputeEngine) seriesHash(r, c float64) uint64 {
+	return HashSeriesFrom(r, c, e.x0)
+}
+
+// Peek returns x_n only if it is already known: cached in L1 under the
+// current generation or stored as a checkpoint at exactly n. It never
+// computes and never writes to the cache.
+func (e *ComputeEngine) Peek(ctx context.Context, r float64, n int64) (float64, bool) {
+	rHash := e.seriesKey(r, computeOpts{clamp: e.clampComputes})
+
+	l1, err := e.cacheFor(ctx)
+	if err != nil {
+		return 0, false
+	}
+	if !l1.Stale(rHash) {
+		if val, ok := l1.Get(rHash, n); ok {
+			return val, true
 		}
 	}
 
-	return x, nil
+	key := checkpointKey(TenantFrom(ctx), rHash)
+	x, ok, err := e.checkpointAt(ctx, key, n)
+	if errors.Is(err, errCorruptCheckpoint) {
+		logging.Warnf("Ignoring checkpoint %s at n=%d: %v", key, n, err)
+	}
+	return x, ok
+}
+
+// checkpointAt returns the checkpoint stored under key at exactly n, if
+// there is one. Above maxExactScore several checkpoints may share n's score,
+// so each candidate's own n is checked.
+func (e *ComputeEngine) checkpointAt(ctx context.Context, key string, n int64) (float64, bool, error) {
+	score := strconv.FormatInt(n, 10)
+	result, err := e.readClient.ZRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{
+		Min:   score,
+		Max:   score,
+		Count: checkpointCandidates,
+	}).Result()
+	if err != nil {
+		return 0, false, err
+	}
+	for _, z := range result {
+		at, x, err := parseCheckpointZ(z)
+		if err != nil {
+			return 0, false, err
+		}
+		if at == n {
+			return x, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// Sign sets the signature of a computed response when result signing is
+// enabled. A partial response is signed for the n it actually reached.
+func (e *ComputeEngine) Sign(resp *models.Response) {
+	if len(e.signingKey) == 0 || resp.Error != "" {
+		return
+	}
+	n := resp.N
+	if resp.Partial {
+		n = resp.ReachedN
+	}
+	resp.Signature = signature.Sign(e.signingKey, resp.R, resp.C, n, e.x0, resp.Result)
+}
+
+// noteCheckpointResume counts a compute resumed from a Redis checkpoint at
+// n, which spared it the n steps from x0. A compute that L1 takes further
+// than the checkpoint is not counted, as it owes nothing to Redis.
+func (e *ComputeEngine) noteCheckpointResume(n int64) {
+	e.checkpointResumes.Add(1)
+	e.iterationsSaved.Add(n)
+	e.recorder.CheckpointResume(n)
+}
+
+// Stats reports the checkpoint resumes and skipped checkpoint writes of
+// this engine so far.
+func (e *ComputeEngine) Stats() models.Stats {
+	return models.Stats{
+		PodID:             e.podID,
+		CheckpointResumes: e.checkpointResumes.Load(),
+		IterationsSaved:   e.iterationsSaved.Load(),
+
+		CheckpointsSkipped: e.checkpointsSkipped.Load(),
+	}
+}
+
+// CachedKeys summarizes the r values currently held in the L1 cache of the
+// tenant carried by ctx.
+func (e *ComputeEngine) CachedKeys(ctx context.Context) ([]cache.SeriesInfo, error) {
+	l1, err := e.cacheFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return l1.Keys(), nil
 }
 
 func (e *ComputeEngine) isLocalR(rHash uint64) bool {
-	return GetPodForR(rHash, e.totalPods) == ParsePodID(e.podID)
+	// A POD_ID that does not parse owns nothing; main refuses to start
+	// with one.
+	id, err := ParsePodID(e.podID)
+	return err == nil && e.ownerOf(rHash) == id
 }
 
-func (e *ComputeEngine) findNearestCheckpoint(ctx context.Context, rHash uint64, n int) (*float64, int) {
-	key := fmt.Sprintf("cp:%d", rHash)
+// ownerOf returns the index of the pod that owns rHash.
+func (e *ComputeEngine) ownerOf(rHash uint64) int {
+	if len(e.podWeights) > 0 {
+		return GetPodForRWeighted(rHash, e.podWeights)
+	}
+	return GetPodForR(rHash, e.totalPods)
+}
+
+// noteNonLocal counts a compute for an r owned by another pod. Only one in
+// every nonLocalLogEvery occurrences is logged so misconfigured sharding shows
+// up in the counter rather than flooding the logs.
+func (e *ComputeEngine) noteNonLocal(r float64, rHash uint64) {
+	e.recorder.NonLocalCompute()
+	count := e.nonLocalCount.Add(1)
+	if e.nonLocalLogEvery > 0 && (count-1)%uint64(e.nonLocalLogEvery) == 0 {
+		logging.Warnf("Computing non-local r=%.6f (owned by pod %d, %d non-local computes so far)",
+			r, e.ownerOf(rHash), count)
+	}
+}
 
-	result, err := e.redisClient.ZRevRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{
+// findNearestCheckpoint returns the checkpoint under key closest below or at
+// n. It reads from the replica when there is one; a checkpoint the replica
+// has yet to receive only makes the compute resume from an earlier one.
+func (e *ComputeEngine) findNearestCheckpoint(ctx context.Context, key string, n int64) (*float64, int64) {
+	result, err := e.readClient.ZRevRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{
 		Min:    "0",
-		Max:    fmt.Sprintf("%d", n),
+		Max:    strconv.FormatInt(n, 10),
 		Offset: 0,
-		Count:  1,
+		Count:  checkpointCandidates,
 	}).Result()
 
-	if err != nil || len(result) == 0 {
+	if err != nil {
 		return nil, 0
 	}
 
-	checkpointN := int(result[0].Score)
-	var x float64
-	fmt.Sscanf(result[0].Member.(string), "%f", &x)
+	// A corrupt member is a miss, never x=0: fall back to the next lower
+	// checkpoint, and to a full compute if none of them decode. Above
+	// maxExactScore n's score may be rounded up past checkpoints beyond n,
+	// which are skipped.
+	for _, z := range result {
+		at, x, err := parseCheckpointZ(z)
+		if err != nil {
+			logging.Warnf("Ignoring checkpoint %s at n=%d: %v", key, int64(z.Score), err)
+			continue
+		}
+		if at > n {
+			continue
+		}
+		return &x, at
+	}
+	return nil, 0
+}
 
-	return &x, checkpointN
+// storeCheckpoint writes x_n under key and announces it, following the
+// checkpoint collision policy.
+func (e *ComputeEngine) storeCheckpoint(ctx context.Context, key string, rHash uint64, n int64, x float64) {
+	e.writeCheckpoint(ctx, key, rHash, n, x, e.replaceCheckpoints)
 }
 
-func (e *ComputeEngine) storeCheckpoint(ctx context.Context, rHash uint64, n int, x float64) {
-	key := fmt.Sprintf("cp:%d", rHash)
-	member := fmt.Sprintf("%.15e", x)
+// replaceCheckpoint is storeCheckpoint that replaces what is stored at n
+// whatever the collision policy.
+func (e *ComputeEngine) replaceCheckpoint(ctx context.Context, key string, rHash uint64, n int64, x float64) {
+	e.writeCheckpoint(ctx, key, rHash, n, x, true)
+}
 
+func (e *ComputeEngine) writeCheckpoint(ctx context.Context, key string, rHash uint64, n int64, x float64, replace bool) {
 	pipe := e.redisClient.Pipeline()
-	pipe.ZAdd(ctx, key, redis.Z{Score: float64(n), Member: member})
-	pipe.Expire(ctx, key, time.Hour)
+	e.addCheckpoint(ctx, pipe, key, n, x, replace)
+	pipe.Expire(ctx, key, time.Duration(e.checkpointTTL.Load()))
+	e.announceCheckpoint(ctx, pipe, rHash, n, x)
 	pipe.Exec(ctx)
 }
 
+// preheatScanCount is the COUNT hint of the SCANs behind PreheatCache.
+const preheatScanCount = 50
+
+// PreheatCache loads up to the preheat limit of checkpoints or full series
+// into the L1 cache of each tenant, preferring r values this pod owns.
 func (e *ComputeEngine) PreheatCache(ctx context.Context) {
-	log.Println("Preheating cache...")
-	iter := e.redisClient.Scan(ctx, 0, "cp:*", 50).Iterator()
+	if e.preheatLimit == 0 {
+		return
+	}
+	ctx = withBulk(ctx)
+	logging.Infof("Preheating cache...")
 	loaded := 0
+	for _, tenant := range e.tenants {
+		loaded += e.preheatTenant(ctx, tenant)
+	}
+	logging.Infof("Preheated %d entries", loaded)
+}
 
-	for iter.Next(ctx) {
-		key := iter.Val()
-		var rHash uint64
-		fmt.Sscanf(key, "cp:%d", &rHash)
+func (e *ComputeEngine) preheatTenant(ctx context.Context, tenant string) int {
+	prefix := tenantPrefix(tenant)
+	l1 := e.caches[tenant]
+	loaded := 0
 
-		result, err := e.redisClient.ZRevRangeWithScores(ctx, key, 0, 0).Result()
+	for _, rHash := range e.preheatKeys(ctx, prefix, "cp", e.preheatLimit) {
+		key := checkpointKey(tenant, rHash)
+		result, err := e.readClient.ZRevRangeWithScores(ctx, key, 0, 0).Result()
 		if err != nil || len(result) == 0 {
 			continue
 		}
 
-		n := int(result[0].Score)
-		var x float64
-		fmt.Sscanf(result[0].Member.(string), "%f", &x)
+		n, x, err := parseCheckpointZ(result[0])
+		if err != nil {
+			logging.Warnf("Not preheating %s: %v", key, err)
+			continue
+		}
 
-		e.l1Cache.Set(rHash, n, x)
+		l1.Set(rHash, n, x)
 		loaded++
+	}
 
-		if loaded >= 50 {
-			break
+	if loaded < e.preheatLimit {
+		loaded += e.preheatSeries(ctx, tenant, e.preheatLimit-loaded)
+	}
+	return loaded
+}
+
+// preheatKeys scans the keys named prefix+kind+":<r hash>" and returns up to
+// limit of their r hashes, owned ones first. The whole keyspace is scanned
+// unless limit owned keys turn up earlier. SCAN may return a key more than
+// once while Redis rehashes; each is kept once.
+func (e *ComputeEngine) preheatKeys(ctx context.Context, prefix, kind string, limit int) []uint64 {
+	seen := make(map[uint64]bool)
+	var owned, others []uint64
+
+	iter := e.readClient.Scan(ctx, 0, prefix+kind+":*", preheatScanCount).Iterator()
+	for len(owned) < limit && iter.Next(ctx) {
+		var rHash uint64
+		if _, err := fmt.Sscanf(iter.Val()[len(prefix):], kind+":%d", &rHash); err != nil || seen[rHash] {
+			continue
+		}
+		seen[rHash] = true
+		if e.isLocalR(rHash) {
+			owned = append(owned, rHash)
+		} else if len(others) < limit {
+			others = append(others, rHash)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		logging.Warnf("Preheat scan of %s%s keys stopped: %v", prefix, kind, err)
+	}
+
+	keys := append(owned, others...)
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys
+}
+
+// FlushToRedis persists the L1 cache on shutdown according to the flush
+// scope, after the random jitter delay. It returns the number of checkpoints
+// written.
+func (e *ComputeEngine) FlushToRedis(ctx context.Context) (int, error) {
+	if e.flushScope == config.FlushScopeNone {
+		logging.Infof("Skipping cache flush (scope none)")
+		return 0, nil
+	}
+
+	// Spread flushes of pods restarted together so they don't hit Redis at
+	// the same instant.
+	if e.flushJitter > 0 {
+		select {
+		case <-time.After(time.Duration(rand.Int63n(int64(e.flushJitter)))):
+		case <-ctx.Done():
+			return 0, ctx.Err()
 		}
 	}
 
-	log.Printf("Preheated %d entries", loaded)
+	return e.Flush(ctx)
 }
 
-func (e *ComputeEngine) FlushToRedis(ctx context.Context) {
-	log.Println("Flushing cache...")
-	entries := e.l1Cache.GetAllEntries()
+// Flush writes the L1 cache to Redis now and returns the number of
+// checkpoints written. Every tenant's cache is flushed under its own keys.
+// Entries are streamed out of each cache one stripe at a time under its read
+// lock into a pipeline, which is sent once the stripe is released, so
+// computes are only held up while a stripe is visited and the commands
+// queued at once never cover more than one stripe. With full series flushing
+// only the series being visited is copied, never the whole cache. Stale
+// series are left out, so values from an earlier cache generation are not
+// persisted. If a send fails, the checkpoints already sent are counted and
+// the rest are not attempted.
+// FLUSH_SCOPE=owned restricts it to owned r values; the none scope only skips
+// the shutdown flush.
+func (e *ComputeEngine) Flush(ctx context.Context) (int, error) {
+	scope := e.flushScope
+	if scope == config.FlushScopeNone {
+		scope = config.FlushScopeAll
+	}
+
+	logging.Infof("Flushing cache (scope %s)...", scope)
+	ctx = withBulk(ctx)
 	pipe := e.redisClient.Pipeline()
-	count := 0
+	count, seriesCount := 0, 0
+	sentCount, sentSeries := 0, 0
+	checkpointMod := e.checkpointMod.Load()
+	ttl := time.Duration(e.checkpointTTL.Load())
 
-	for rHash, series := range entries {
-		for n, x := range series {
-			if n%e.checkpointMod == 0 {
-				key := fmt.Sprintf("cp:%d", rHash)
-				member := fmt.Sprintf("%.15e", x)
-				pipe.ZAdd(ctx, key, redis.Z{Score: float64(n), Member: member})
-				pipe.Expire(ctx, key, time.Hour)
-				count++
+	var (
+		tenant  string
+		started bool
+		current uint64
+		skip    bool
+		series  map[int64]float64
+	)
+	endSeries := func() {
+		if series != nil {
+			pipe.Set(ctx, seriesKey(tenant, current), encodeSeries(series, e.seriesCompression), ttl)
+			seriesCount++
+		}
+	}
+
+	for _, tenant = range e.tenants {
+		l1 := e.caches[tenant]
+		for i := 0; i < l1.Stripes(); i++ {
+			started, series = false, nil
+			l1.ForEachCurrentIn(i, func(rHash uint64, n int64, x float64) {
+				if !started || rHash != current {
+					endSeries()
+					started, current, series = true, rHash, nil
+					skip = scope == config.FlushScopeOwned && !e.isLocalR(rHash)
+					if e.flushFullSeries && !skip {
+						series = make(map[int64]float64)
+					}
+				}
+				if skip {
+					return
+				}
+
+				if series != nil {
+					series[n] = x
+				}
+				if n > 0 && n%checkpointMod == 0 {
+					key := checkpointKey(tenant, rHash)
+					e.addCheckpoint(ctx, pipe, key, n, x, e.replaceCheckpoints)
+					pipe.Expire(ctx, key, ttl)
+					count++
+				}
+			})
+			endSeries()
+
+			if count == sentCount && seriesCount == sentSeries {
+				continue
+			}
+			if _, err := pipe.Exec(ctx); err != nil {
+				return sentCount, err
 			}
+			sentCount, sentSeries = count, seriesCount
 		}
 	}
 
-	if count > 0 {
-		pipe.Exec(ctx)
-		log.Printf("Flushed %d checkpoints", count)
+	if count == 0 && seriesCount == 0 {
+		return 0, nil
 	}
+	logging.Infof("Flushed %d checkpoints, %d full series", count, seriesCount)
+	return count, nil
 }
 
+// WarmUp computes each owned r in rs up to n so the first requests for them
+// are cache hits. It stops early when ctx is done.
+func (e *ComputeEngine) WarmUp(ctx context.Context, rs []float64, n int64) {
+	warmed := 0
+	for _, r := range rs {
+		if !e.isLocalR(e.seriesHash(r, 0)) {
+			continue
+		}
+		if _, err := e.Compute(ctx, r, n); err != nil {
+			logging.Warnf("Warm-up stopped after %d r values: %v", warmed, err)
+			return
+		}
+		warmed++
+	}
+	logging.Infof("Warmed %d r values to n=%d", warmed, n)
+}
+
+// preheatSeries loads up to limit of tenant's full series blobs written by a
+// previous full flush and returns how many were loaded.
+func (e *ComputeEngine) preheatSeries(ctx context.Context, tenant string, limit int) int {
+	prefix := tenantPrefix(tenant)
+	l1 := e.caches[tenant]
+	loaded := 0
+
+	for _, rHash := range e.preheatKeys(ctx, prefix, "series", limit) {
+		key := seriesKey(tenant, rHash)
+		blob, err := e.readClient.Get(ctx, key).Bytes()
+		if err != nil {
+			continue
+		}
+
+		series, err := decodeSeries(blob)
+		if err != nil {
+			logging.Warnf("Skipping series %s: %v", key, err)
+			continue
+		}
+
+		for n, x := range series {
+			l1.Set(rHash, n, x)
+		}
+		loaded++
+	}
+
+	return loaded
+}
+
+// Close cancels running async jobs, waits for the worker pool to drain and
+// closes the Redis connection.
 func (e *ComputeEngine) Close() {
+	e.cancelJob(ErrShuttingDown)
+	e.pool.Close()
 	e.redisClient.Close()
+	if e.readClient != e.redisClient {
+		e.readClient.Close()
+	}
+	if e.statsd != nil {
+		e.statsd.Close()
+	}
 }
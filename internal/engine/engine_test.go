@@ -0,0 +1,1456 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"resilientrecursion/internal/models"
+	"resilientrecursion/pkg/config"
+	"resilientrecursion/pkg/logistic"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestEngine(t *testing.T) (*ComputeEngine, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	e := NewComputeEngine(cfg)
+	t.Cleanup(e.Close)
+	return e, mr
+}
+
+// A huge n only finishes in test time if the absorbing state short-circuits
+// the loop.
+const absorbingN = 1 << 40
+
+func TestComputeShortCircuitsAtZero(t *testing.T) {
+	e, mr := newTestEngine(t)
+
+	// Resume from a checkpoint that is exactly x=0.
+	mr.ZAdd(fmt.Sprintf("cp:%d", HashFloat64(3.7)), 1000, "0.000000000000000e+00")
+
+	got, err := e.Compute(context.Background(), 3.7, absorbingN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 0 {
+		t.Errorf("Compute from x=0 = %v, want 0", got)
+	}
+}
+
+func TestComputeShortCircuitsFromOne(t *testing.T) {
+	e, mr := newTestEngine(t)
+
+	// x=1 maps to 0 on the next step and stays there.
+	mr.ZAdd(fmt.Sprintf("cp:%d", HashFloat64(3.2)), 1000, "1.000000000000000e+00")
+
+	got, err := e.Compute(context.Background(), 3.2, absorbingN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 0 {
+		t.Errorf("Compute from x=1 = %v, want 0", got)
+	}
+
+	// At r=4 the seed 0.5 itself maps to 1 and then to 0.
+	got, err = e.Compute(context.Background(), 4, absorbingN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 0 {
+		t.Errorf("Compute(r=4) = %v, want 0", got)
+	}
+}
+
+func TestComputeShortCircuitsAtFixedPoint(t *testing.T) {
+	e, _ := newTestEngine(t)
+
+	// For r=2 the seed 0.5 is already the fixed point 1-1/r.
+	got, err := e.Compute(context.Background(), 2, absorbingN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 0.5 {
+		t.Errorf("Compute(r=2) = %v, want 0.5", got)
+	}
+
+	if val, ok := e.l1Cache.Get(HashFloat64(2), absorbingN); !ok || val != 0.5 {
+		t.Errorf("expected x_n cached after short-circuit, got %v, %v", val, ok)
+	}
+}
+
+func TestComputeMatchesDirectIteration(t *testing.T) {
+	e, _ := newTestEngine(t)
+
+	want := 0.5
+	for i := 0; i < 2500; i++ {
+		want = 3.9 * want * (1 - want)
+	}
+
+	got, err := e.Compute(context.Background(), 3.9, 2500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("Compute(3.9, 2500) = %v, want %v", got, want)
+	}
+}
+
+func directIterate(r float64, n int64) float64 {
+	x := 0.5
+	for i := int64(0); i < n; i++ {
+		x = r * x * (1 - x)
+	}
+	return x
+}
+
+// TestComputeMatchesComputeLogistic checks the cached path against the
+// plain map for random r and n. One pod resumes each later point from L1,
+// the other from the first pod's checkpoints.
+func TestComputeMatchesComputeLogistic(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	newEngine := func() *ComputeEngine {
+		cfg := config.Default()
+		cfg.RedisAddr = mr.Addr()
+		cfg.TotalPods = 1
+		cfg.CheckpointMod = 100
+		// Text checkpoints round x to 16 digits; only binary ones resume
+		// with its exact bits.
+		cfg.CheckpointEncoding = config.CheckpointEncodingBinary
+		e := NewComputeEngine(cfg)
+		t.Cleanup(e.Close)
+		return e
+	}
+	first, second := newEngine(), newEngine()
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		// r = 4 exactly is left out: the engine reports its degenerate
+		// orbits as errors.
+		r := 4 * rng.Float64()
+		ns := []int64{rng.Int63n(5000), rng.Int63n(5000), rng.Int63n(5000)}
+		for _, e := range []*ComputeEngine{first, second} {
+			for _, n := range ns {
+				want := logistic.ComputeLogistic(r, n, e.X0())
+				got, err := e.Compute(ctx, r, n)
+				if err != nil {
+					t.Fatalf("Compute(%v, %d): %v", r, n, err)
+				}
+				if math.Float64bits(got) != math.Float64bits(want) {
+					t.Fatalf("Compute(%v, %d) = %v, want %v", r, n, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestConvergedTailsCollapseEntries(t *testing.T) {
+	ctx := context.Background()
+	entries := func(convergedTails bool) int {
+		e, _ := newTestEngine(t)
+		e.convergedTails = convergedTails
+		// r = 2.8 reaches its fixed point 9/14 exactly after about 150
+		// steps.
+		for n := int64(100); n <= 5000; n += 100 {
+			got, err := e.Compute(ctx, 2.8, n)
+			if err != nil || got != directIterate(2.8, n) {
+				t.Fatalf("Compute(2.8, %d) = %v, %v; want %v", n, got, err, directIterate(2.8, n))
+			}
+		}
+		if got, ok := e.Peek(ctx, 2.8, 1<<40); convergedTails && (!ok || got != directIterate(2.8, 5000)) {
+			t.Errorf("Peek(2.8, 2^40) = %v, %v; want the converged value", got, ok)
+		}
+		keys, _ := e.CachedKeys(ctx)
+		return keys[0].Entries
+	}
+
+	plain, collapsed := entries(false), entries(true)
+	if collapsed > plain-49 {
+		t.Errorf("%d entries with converged tails, %d without; want none for the 49 converged n past the first", collapsed, plain)
+	}
+}
+
+func TestComputeSmallNAfterLargeN(t *testing.T) {
+	e, _ := newTestEngine(t)
+	ctx := context.Background()
+
+	if _, err := e.Compute(ctx, 3.7, 5000); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int64{1, 10, 999, 1000, 1001, 4999} {
+		got, err := e.Compute(ctx, 3.7, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := directIterate(3.7, n); got != want {
+			t.Errorf("Compute(3.7, %d) after n=5000 = %v, want %v", n, got, want)
+		}
+	}
+}
+
+func TestComputeBelowLowestCachedPoint(t *testing.T) {
+	e, mr := newTestEngine(t)
+	ctx := context.Background()
+
+	// Preheating from a lone checkpoint leaves L1 holding only n=5000.
+	mr.ZAdd(fmt.Sprintf("cp:%d", HashFloat64(3.7)), 5000,
+		encodeCheckpoint(directIterate(3.7, 5000), config.CheckpointEncodingText))
+	e.PreheatCache(ctx)
+
+	got, err := e.Compute(ctx, 3.7, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := directIterate(3.7, 10); got != want {
+		t.Errorf("Compute(3.7, 10) below the cached n=5000 = %v, want %v", got, want)
+	}
+}
+
+func TestPreheatPrefersOwnedKeysAcrossScanPages(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.PreheatLimit = 10
+	e := NewComputeEngine(cfg)
+	t.Cleanup(e.Close)
+
+	// Many more keys than one SCAN page, with only a few owned by this pod.
+	var owned []uint64
+	for i := 0; i < 10*preheatScanCount; i++ {
+		rHash := HashFloat64(3 + float64(i)/10000)
+		if e.isLocalR(rHash) {
+			if len(owned) == 5 {
+				continue
+			}
+			owned = append(owned, rHash)
+		}
+		mr.ZAdd(fmt.Sprintf("cp:%d", rHash), 1000, encodeCheckpoint(0.5, config.CheckpointEncodingText))
+	}
+	if len(owned) < 5 {
+		t.Fatalf("only %d owned r values generated", len(owned))
+	}
+
+	e.PreheatCache(context.Background())
+
+	for _, rHash := range owned {
+		if !e.l1Cache.Contains(rHash) {
+			t.Errorf("owned series %d not preheated", rHash)
+		}
+	}
+	if got := len(e.l1Cache.Keys()); got != 10 {
+		t.Errorf("%d series preheated, want the limit of 10", got)
+	}
+}
+
+func TestComputeResumesFromCachedStep(t *testing.T) {
+	e, _ := newTestEngine(t)
+
+	// A marker value no real trajectory passes through shows which step the
+	// compute resumed from.
+	rHash := HashFloat64(3.7)
+	e.l1Cache.Set(rHash, 100, 0.25)
+	e.l1Cache.Set(rHash, 200, 0.75)
+
+	got, err := e.Compute(context.Background(), 3.7, 101)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, marker := 3.7, 0.25
+	if want := r * marker * (1 - marker); got != want {
+		t.Errorf("Compute(3.7, 101) = %v, want %v resumed from n=100", got, want)
+	}
+}
+
+func TestFlushToRedisScopes(t *testing.T) {
+	for _, tc := range []struct {
+		scope           string
+		owned, foreign  bool
+		wantCheckpoints int
+	}{
+		{config.FlushScopeAll, true, true, 4},
+		{config.FlushScopeOwned, true, false, 2},
+		{config.FlushScopeNone, false, false, 0},
+	} {
+		mr := miniredis.RunT(t)
+		cfg := config.Default()
+		cfg.RedisAddr = mr.Addr()
+		cfg.TotalPods = 2
+		cfg.FlushScope = tc.scope
+		cfg.FlushJitter = 0
+		e := NewComputeEngine(cfg)
+		t.Cleanup(e.Close)
+		ctx := context.Background()
+
+		var owned, foreign float64
+		for r := 3.5; owned == 0 || foreign == 0; r += 0.01 {
+			if e.isLocalR(HashFloat64(r)) {
+				owned = r
+			} else {
+				foreign = r
+			}
+		}
+		for _, r := range []float64{owned, foreign} {
+			if _, err := e.Compute(ctx, r, 2500); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Drop what the computes wrote, so only the flush is counted.
+		mr.FlushAll()
+
+		count, err := e.FlushToRedis(ctx)
+		if err != nil || count != tc.wantCheckpoints {
+			t.Errorf("scope %s: FlushToRedis = %d, %v; want %d checkpoints", tc.scope, count, err, tc.wantCheckpoints)
+		}
+		if got := mr.Exists(checkpointKey(config.DefaultTenant, HashFloat64(owned))); got != tc.owned {
+			t.Errorf("scope %s: owned r flushed = %v, want %v", tc.scope, got, tc.owned)
+		}
+		if got := mr.Exists(checkpointKey(config.DefaultTenant, HashFloat64(foreign))); got != tc.foreign {
+			t.Errorf("scope %s: foreign r flushed = %v, want %v", tc.scope, got, tc.foreign)
+		}
+	}
+}
+
+func TestFlushToRedisJitter(t *testing.T) {
+	e, mr := newTestEngine(t)
+	if _, err := e.Compute(context.Background(), 3.7, 2500); err != nil {
+		t.Fatal(err)
+	}
+	mr.FlushAll()
+
+	// A shutdown budget shorter than the jitter gives up before writing.
+	e.flushJitter = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := e.FlushToRedis(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("FlushToRedis past its budget: err = %v, want DeadlineExceeded", err)
+	}
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Errorf("flush cut short by the jitter wrote %v", keys)
+	}
+
+	e.flushJitter = 20 * time.Millisecond
+	if count, err := e.FlushToRedis(context.Background()); err != nil || count != 2 {
+		t.Errorf("FlushToRedis after the jitter = %d, %v; want 2 checkpoints", count, err)
+	}
+}
+
+// flushPipelines records the size of every pipeline that sets a TTL, which
+// only flush pipelines do.
+type flushPipelines struct {
+	mu    sync.Mutex
+	sizes []int
+}
+
+func (h *flushPipelines) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h *flushPipelines) ProcessHook(next redis.ProcessHook) redis.ProcessHook { return next }
+
+func (h *flushPipelines) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			if cmd.Name() == "expire" {
+				h.mu.Lock()
+				h.sizes = append(h.sizes, len(cmds))
+				h.mu.Unlock()
+				break
+			}
+		}
+		return next(ctx, cmds)
+	}
+}
+
+func TestFlushSendsEachStripeSeparately(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	// Room for every series whatever stripe it lands in.
+	cfg.CacheSize = 1024
+	e := NewComputeEngine(cfg)
+	t.Cleanup(e.Close)
+	ctx := context.Background()
+
+	rs := make([]float64, 64)
+	for i := range rs {
+		rs[i] = 3.9 + float64(i)/1000
+		if _, err := e.Compute(ctx, rs[i], 2500); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mr.FlushAll()
+
+	hook := &flushPipelines{}
+	e.redisClient.AddHook(hook)
+	if _, err := e.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	stripes := e.caches[config.DefaultTenant].Stripes()
+	if len(hook.sizes) < 2 || len(hook.sizes) > stripes {
+		t.Errorf("Flush sent %d pipelines, want between 2 and %d", len(hook.sizes), stripes)
+	}
+	total := 0
+	for _, size := range hook.sizes {
+		total += size
+	}
+	for _, size := range hook.sizes {
+		if size == total {
+			t.Errorf("one pipeline carried all %d commands", total)
+		}
+	}
+	for _, r := range rs {
+		if !mr.Exists(checkpointKey(config.DefaultTenant, HashFloat64(r))) {
+			t.Errorf("r=%v: no checkpoints flushed", r)
+		}
+	}
+}
+
+// BenchmarkFlushToRedis flushes a cache of 1000 series of 2500 steps to
+// miniredis. max-pipeline-cmds is the most commands queued at once, which
+// bounds what a flush holds beyond the cache; B/op also counts miniredis's
+// own allocations, since it runs in process.
+func BenchmarkFlushToRedis(b *testing.B) {
+	for _, full := range []bool{false, true} {
+		b.Run(fmt.Sprintf("full=%v", full), func(b *testing.B) {
+			mr := miniredis.RunT(b)
+			cfg := config.Default()
+			cfg.RedisAddr = mr.Addr()
+			cfg.TotalPods = 1
+			cfg.CacheSize = 1000
+			cfg.FlushJitter = 0
+			cfg.FlushFullSeries = full
+			e := NewComputeEngine(cfg)
+			b.Cleanup(e.Close)
+			ctx := context.Background()
+			for i := 0; i < cfg.CacheSize; i++ {
+				if _, err := e.Compute(ctx, 3.9+float64(i)/1e4, 2500); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			hook := &flushPipelines{}
+			e.redisClient.AddHook(hook)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := e.FlushToRedis(ctx); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+
+			largest := 0
+			for _, size := range hook.sizes {
+				largest = max(largest, size)
+			}
+			b.ReportMetric(float64(largest), "max-pipeline-cmds")
+		})
+	}
+}
+
+func TestFlushStreamsFullSeries(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	cfg.FlushFullSeries = true
+	e := NewComputeEngine(cfg)
+	t.Cleanup(e.Close)
+	ctx := context.Background()
+
+	rs := []float64{3.5, 3.7, 3.9}
+	for _, r := range rs {
+		if _, err := e.Compute(ctx, r, 2500); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, err := e.Flush(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 2 * len(rs); count != want {
+		t.Errorf("Flush wrote %d checkpoints, want %d", count, want)
+	}
+
+	for _, r := range rs {
+		blob, err := mr.Get(seriesKey(config.DefaultTenant, HashFloat64(r)))
+		if err != nil {
+			t.Fatalf("r=%v: %v", r, err)
+		}
+		series, err := decodeSeries([]byte(blob))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(series) != 2500 || series[2500] != directIterate(r, 2500) {
+			t.Errorf("r=%v: series has %d entries, x_2500 = %v", r, len(series), series[2500])
+		}
+	}
+}
+
+func TestFlushCompressesFullSeries(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	cfg.FlushFullSeries = true
+	cfg.SeriesCompression = config.SeriesCompressionGzip
+	e := NewComputeEngine(cfg)
+	t.Cleanup(e.Close)
+	ctx := context.Background()
+
+	if _, err := e.Compute(ctx, 3.2, 2500); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	blob, err := mr.Get(seriesKey(config.DefaultTenant, HashFloat64(3.2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blob[0] != seriesBlobGzip {
+		t.Errorf("series blob starts with %#x, want gzip", blob[0])
+	}
+
+	restarted := NewComputeEngine(cfg)
+	t.Cleanup(restarted.Close)
+	if loaded := restarted.preheatSeries(ctx, config.DefaultTenant, 10); loaded != 1 {
+		t.Fatalf("preheatSeries loaded %d series, want 1", loaded)
+	}
+	if x, ok := restarted.caches[config.DefaultTenant].Get(HashFloat64(3.2), 2500); !ok || x != directIterate(3.2, 2500) {
+		t.Errorf("preheated x_2500 = %v, %v, want %v", x, ok, directIterate(3.2, 2500))
+	}
+}
+
+func TestComputePerturbedZeroMatchesCompute(t *testing.T) {
+	e, mr := newTestEngine(t)
+	ctx := context.Background()
+
+	if HashSeries(3.7, 0) != HashFloat64(3.7) {
+		t.Fatal("HashSeries(r, 0) must keep the plain r key")
+	}
+
+	got, err := e.ComputePerturbed(ctx, 3.7, 0, 2500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := directIterate(3.7, 2500); got != want {
+		t.Errorf("ComputePerturbed(3.7, 0, 2500) = %v, want %v", got, want)
+	}
+	if !mr.Exists(fmt.Sprintf("cp:%d", HashFloat64(3.7))) {
+		t.Error("c=0 did not write the plain r checkpoint key")
+	}
+}
+
+func TestComputePerturbedSmallC(t *testing.T) {
+	e, _ := newTestEngine(t)
+	ctx := context.Background()
+	r, c := 3.5, 0.01
+
+	// Cache the plain series first; it must not leak into the perturbed one.
+	if _, err := e.Compute(ctx, r, 2500); err != nil {
+		t.Fatal(err)
+	}
+
+	want := 0.5
+	for i := 0; i < 2500; i++ {
+		want = r*want*(1-want) + c
+	}
+	got, err := e.ComputePerturbed(ctx, r, c, 2500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("ComputePerturbed(%v, %v, 2500) = %v, want %v", r, c, got, want)
+	}
+	if plain, _ := e.Compute(ctx, r, 2500); plain == got {
+		t.Errorf("perturbed and plain series share x_2500 = %v", got)
+	}
+}
+
+func TestComputePerturbedDiverges(t *testing.T) {
+	e, _ := newTestEngine(t)
+
+	_, err := e.ComputePerturbed(context.Background(), 3.9, 0.5, 1000)
+	if !errors.Is(err, ErrDiverged) {
+		t.Fatalf("ComputePerturbed(3.9, 0.5) error = %v, want ErrDiverged", err)
+	}
+}
+
+func TestWarmUpComputesOwnedRValues(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 2
+	e := NewComputeEngine(cfg)
+	t.Cleanup(e.Close)
+
+	var owned, foreign []float64
+	for r := 3.5; len(owned) < 2 || len(foreign) < 2; r += 0.01 {
+		if e.isLocalR(HashFloat64(r)) {
+			owned = append(owned, r)
+		} else {
+			foreign = append(foreign, r)
+		}
+	}
+
+	e.WarmUp(context.Background(), append(owned, foreign...), 500)
+	for _, r := range owned {
+		if x, ok := e.l1Cache.Get(HashFloat64(r), 500); !ok || x != directIterate(r, 500) {
+			t.Errorf("owned r=%v: x_500 = %v, cached %v; want %v", r, x, ok, directIterate(r, 500))
+		}
+	}
+	for _, r := range foreign {
+		if _, _, ok := e.l1Cache.Floor(HashFloat64(r), 500, 0); ok {
+			t.Errorf("r=%v owned by another pod was warmed", r)
+		}
+	}
+
+	// A warm-up out of time leaves the rest cold.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	e.WarmUp(ctx, owned, 100000)
+	if _, _, ok := e.l1Cache.Floor(HashFloat64(owned[1]), 100000, 500); ok {
+		t.Errorf("r=%v warmed after the timeout", owned[1])
+	}
+}
+
+func TestOwnedCheckpointsOnlySkipsNonLocal(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 2
+	cfg.OwnedCheckpointsOnly = true
+	e := NewComputeEngine(cfg)
+	t.Cleanup(e.Close)
+	ctx := context.Background()
+
+	var owned, foreign float64
+	for r := 3.5; owned == 0 || foreign == 0; r += 0.01 {
+		if e.isLocalR(HashFloat64(r)) {
+			owned = r
+		} else {
+			foreign = r
+		}
+	}
+
+	for _, r := range []float64{owned, foreign} {
+		if _, err := e.Compute(ctx, r, 2500); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !mr.Exists(fmt.Sprintf("cp:%d", HashFloat64(owned))) {
+		t.Errorf("no checkpoints written for owned r=%v", owned)
+	}
+	if mr.Exists(fmt.Sprintf("cp:%d", HashFloat64(foreign))) {
+		t.Errorf("checkpoints written for non-owned r=%v", foreign)
+	}
+	if _, ok := e.l1Cache.Get(HashFloat64(foreign), 2500); !ok {
+		t.Errorf("non-owned r=%v missing from L1", foreign)
+	}
+}
+
+func TestCancellationHonoredWithinStride(t *testing.T) {
+	for _, stride := range []int{100, 4096} {
+		mr := miniredis.RunT(t)
+		cfg := config.Default()
+		cfg.RedisAddr = mr.Addr()
+		cfg.TotalPods = 1
+		cfg.CancelCheckStride = stride
+		e := NewComputeEngine(cfg)
+		t.Cleanup(e.Close)
+
+		// Cancel at the first check; the compute must stop by the next one.
+		ctx, cancel := context.WithCancel(context.Background())
+		var cancelledAt int64
+		_, reached, err := e.compute(ctx, 3.7, 1000000, computeOpts{progress: func(i int64) {
+			if cancelledAt == 0 {
+				cancelledAt = i
+				cancel()
+			}
+		}})
+		if err != context.Canceled {
+			t.Fatalf("stride %d: err = %v, want context.Canceled", stride, err)
+		}
+		if cancelledAt != int64(stride) || reached-cancelledAt >= int64(stride) {
+			t.Errorf("stride %d: cancelled at %d, stopped at %d; want to stop within %d steps of %d",
+				stride, cancelledAt, reached, stride, stride)
+		}
+	}
+}
+
+func TestComputeProgressReportsIncreasingN(t *testing.T) {
+	e, _ := newTestEngine(t)
+
+	var seen []int64
+	got, err := e.ComputeProgress(context.Background(), 3.7, 10000, func(i int64) {
+		seen = append(seen, i)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := directIterate(3.7, 10000); got != want {
+		t.Errorf("ComputeProgress(3.7, 10000) = %v, want %v", got, want)
+	}
+	if stride := e.cancelCheckStride.Load(); int64(len(seen)) != 10000/stride {
+		t.Fatalf("%d progress calls, want %d", len(seen), 10000/stride)
+	}
+	for i := 1; i < len(seen); i++ {
+		if seen[i] <= seen[i-1] || seen[i] > 10000 {
+			t.Fatalf("progress went %v", seen)
+		}
+	}
+}
+
+func TestPinnedRValueSurvivesEviction(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	cfg.CacheSize = 16
+	cfg.PinnedRValues = []float64{3.7}
+	e := NewComputeEngine(cfg)
+	t.Cleanup(e.Close)
+	ctx := context.Background()
+
+	if _, err := e.Compute(ctx, 3.7, 100); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		if _, err := e.Compute(ctx, 3.0+float64(i)*1e-3, 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, ok := e.l1Cache.Get(HashFloat64(3.7), 100); !ok {
+		t.Error("pinned r=3.7 was evicted")
+	}
+}
+
+func TestCheckpointReadsThroughIntoL1(t *testing.T) {
+	e, mr := newTestEngine(t)
+	ctx := context.Background()
+	want := directIterate(3.7, 2000)
+	mr.ZAdd(fmt.Sprintf("cp:%d", HashFloat64(3.7)), 2000, encodeCheckpoint(want, config.CheckpointEncodingBinary))
+
+	if got, err := e.Compute(ctx, 3.7, 2000); err != nil || got != want {
+		t.Fatalf("first Compute(3.7, 2000) = %v, %v; want %v", got, err, want)
+	}
+	before := mr.CommandCount()
+	if got, err := e.Compute(ctx, 3.7, 2000); err != nil || got != want {
+		t.Fatalf("second Compute(3.7, 2000) = %v, %v; want %v", got, err, want)
+	}
+	if n := mr.CommandCount() - before; n != 0 {
+		t.Errorf("second query sent %d Redis commands, want an L1 hit", n)
+	}
+}
+
+func TestCheckpointResumeCountsIterationsSaved(t *testing.T) {
+	e, mr := newTestEngine(t)
+	ctx := context.Background()
+	mr.ZAdd(fmt.Sprintf("cp:%d", HashFloat64(3.7)), 2000,
+		encodeCheckpoint(directIterate(3.7, 2000), config.CheckpointEncodingBinary))
+
+	// Resuming at 2000 spares the 2000 steps from x0; the compute from x0
+	// at another r spares none.
+	if _, err := e.Compute(ctx, 3.7, 2500); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Compute(ctx, 3.2, 2500); err != nil {
+		t.Fatal(err)
+	}
+	if got := e.Stats(); got.CheckpointResumes != 1 || got.IterationsSaved != 2000 {
+		t.Errorf("after one resume at 2000: %+v, want 1 resume saving 2000 iterations", got)
+	}
+
+	// Further along the series L1 resumes at 2500, past the checkpoint, so
+	// Redis saved nothing more.
+	if _, err := e.Compute(ctx, 3.7, 3000); err != nil {
+		t.Fatal(err)
+	}
+	if got := e.Stats(); got.CheckpointResumes != 1 || got.IterationsSaved != 2000 {
+		t.Errorf("after an L1 resume: %+v, want the counts unchanged", got)
+	}
+}
+
+func TestComputeSkipsCorruptCheckpoint(t *testing.T) {
+	e, mr := newTestEngine(t)
+	ctx := context.Background()
+	key := fmt.Sprintf("cp:%d", HashFloat64(3.7))
+
+	// A garbage member above a good one: resume from the good one.
+	mr.ZAdd(key, 1000, encodeCheckpoint(directIterate(3.7, 1000), config.CheckpointEncodingBinary))
+	mr.ZAdd(key, 2000, "not-a-float")
+
+	got, err := e.Compute(ctx, 3.7, 2500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := directIterate(3.7, 2500); got != want {
+		t.Errorf("Compute past a corrupt checkpoint = %v, want %v", got, want)
+	}
+
+	// Only garbage: compute from x0, never from x=0.
+	mr.ZAdd(fmt.Sprintf("cp:%d", HashFloat64(3.6)), 1000, "garbage")
+	got, err = e.Compute(ctx, 3.6, 1500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := directIterate(3.6, 1500); got != want {
+		t.Errorf("Compute over only a corrupt checkpoint = %v, want %v", got, want)
+	}
+}
+
+func TestComputeBelowMinRedisNSkipsRedis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	cfg.MinRedisN = 5000
+	e := NewComputeEngine(cfg)
+	t.Cleanup(e.Close)
+	ctx := context.Background()
+
+	got, err := e.Compute(ctx, 3.7, 4000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := directIterate(3.7, 4000); got != want {
+		t.Errorf("Compute(3.7, 4000) = %v, want %v", got, want)
+	}
+	if n := mr.CommandCount(); n != 0 {
+		t.Errorf("%d Redis commands below MIN_REDIS_N, want 0", n)
+	}
+
+	if _, err := e.Compute(ctx, 3.7, 6000); err != nil {
+		t.Fatal(err)
+	}
+	if mr.CommandCount() == 0 {
+		t.Error("no Redis commands at n >= MIN_REDIS_N")
+	}
+}
+
+func TestComputeAtZeroReturnsSeed(t *testing.T) {
+	for _, x0 := range []float64{config.DefaultX0, 0.2} {
+		mr := miniredis.RunT(t)
+		cfg := config.Default()
+		cfg.RedisAddr = mr.Addr()
+		cfg.TotalPods = 1
+		cfg.X0 = x0
+		e := NewComputeEngine(cfg)
+		t.Cleanup(e.Close)
+		ctx := context.Background()
+
+		got, err := e.Compute(ctx, 3.7, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != x0 {
+			t.Errorf("x0=%v: Compute(3.7, 0) = %v", x0, got)
+		}
+		if n := mr.CommandCount(); n != 0 {
+			t.Errorf("x0=%v: %d Redis commands at n=0, want 0", x0, n)
+		}
+		if _, ok := e.l1Cache.Get(HashSeriesFrom(3.7, 0, x0), 0); !ok {
+			t.Errorf("x0=%v: n=0 not cached", x0)
+		}
+	}
+}
+
+func TestComputeFromCustomX0(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	cfg.X0 = 0.2
+	e := NewComputeEngine(cfg)
+	t.Cleanup(e.Close)
+
+	got, err := e.Compute(context.Background(), 3.7, 2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := 0.2
+	for i := 0; i < 2000; i++ {
+		want = 3.7 * want * (1 - want)
+	}
+	if got != want {
+		t.Errorf("Compute(3.7, 2000) from 0.2 = %v, want %v", got, want)
+	}
+
+	// Checkpoints of the default seed are left alone.
+	if mr.Exists(fmt.Sprintf("cp:%d", HashFloat64(3.7))) {
+		t.Error("custom x0 wrote to the default series' checkpoints")
+	}
+	if !mr.Exists(fmt.Sprintf("cp:%d", HashSeriesFrom(3.7, 0, 0.2))) {
+		t.Error("no checkpoint written for the custom x0 series")
+	}
+}
+
+func TestComputeWithL1Disabled(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	cfg.DisableL1 = true
+	cfg.PinnedRValues = []float64{3.7}
+	e := NewComputeEngine(cfg)
+	t.Cleanup(e.Close)
+	ctx := context.Background()
+
+	// The second compute resumes from the Redis checkpoint the first wrote.
+	for i := 0; i < 2; i++ {
+		got, err := e.Compute(ctx, 3.7, 2500)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := directIterate(3.7, 2500); got != want {
+			t.Errorf("compute %d: Compute(3.7, 2500) = %v, want %v", i, got, want)
+		}
+	}
+	if !mr.Exists(fmt.Sprintf("cp:%d", HashFloat64(3.7))) {
+		t.Error("no checkpoint written with L1 disabled")
+	}
+	if keys := e.l1Cache.Keys(); len(keys) != 0 {
+		t.Errorf("L1 holds %d series, want none", len(keys))
+	}
+	if _, ok := e.l1Cache.Get(HashFloat64(3.7), 2500); ok {
+		t.Error("L1 hit with L1 disabled")
+	}
+}
+
+func TestTenantsAreIsolated(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	cfg.Tenants = []string{"team-a"}
+	e := NewComputeEngine(cfg)
+	t.Cleanup(e.Close)
+	teamA := WithTenant(context.Background(), "team-a")
+
+	if _, err := e.Compute(teamA, 3.7, 2500); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := e.l1Cache.Get(HashFloat64(3.7), 2500); ok {
+		t.Error("team-a compute landed in the public L1 cache")
+	}
+	if _, ok := e.Peek(teamA, 3.7, 2500); !ok {
+		t.Error("team-a compute missing from its own cache")
+	}
+	if mr.Exists(fmt.Sprintf("cp:%d", HashFloat64(3.7))) {
+		t.Error("team-a checkpoints written under public keys")
+	}
+	if !mr.Exists(fmt.Sprintf("t:team-a:cp:%d", HashFloat64(3.7))) {
+		t.Error("no team-a checkpoints written")
+	}
+
+	unknown := WithTenant(context.Background(), "team-b")
+	if _, err := e.Compute(unknown, 3.7, 10); !errors.Is(err, ErrUnknownTenant) {
+		t.Errorf("Compute for unconfigured tenant: error = %v, want ErrUnknownTenant", err)
+	}
+}
+
+func TestComputeBeyondInt32(t *testing.T) {
+	e, _ := newTestEngine(t)
+	ctx := context.Background()
+
+	// x=0.5 is the exact fixed point at r=2, so the loop ends on its first
+	// step and only the key at n has to survive the widening.
+	const n = 1<<31 + 1
+	x, err := e.Compute(ctx, 2, n)
+	if err != nil || x != 0.5 {
+		t.Fatalf("Compute(2, 2^31+1) = %v, %v; want 0.5", x, err)
+	}
+	if got, ok := e.Peek(ctx, 2, n); !ok || got != 0.5 {
+		t.Errorf("Peek(2, 2^31+1) = %v, %v; want 0.5 from L1", got, ok)
+	}
+	if _, ok := e.Peek(ctx, 2, n-1<<31); ok {
+		t.Error("Peek(2, 1) hit: n was truncated to 32 bits")
+	}
+}
+
+func TestComputeCheckpointsListsAlignedPoints(t *testing.T) {
+	e, _ := newTestEngine(t)
+	ctx := context.Background()
+
+	x, checkpoints, err := e.ComputeCheckpoints(ctx, 3.7, 3500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := directIterate(3.7, 3500); x != want {
+		t.Errorf("ComputeCheckpoints(3.7, 3500) = %v, want %v", x, want)
+	}
+	if len(checkpoints) != 3 {
+		t.Fatalf("checkpoints = %+v, want n=1000, 2000, 3000", checkpoints)
+	}
+	for i, cp := range checkpoints {
+		if cp.N != int64(i+1)*1000 || cp.Value != directIterate(3.7, cp.N) {
+			t.Errorf("checkpoint %d = %+v, want x_%d", i, cp, (i+1)*1000)
+		}
+	}
+
+	// Resuming from the cache starts at the nearest point below n.
+	_, checkpoints, err = e.ComputeCheckpoints(ctx, 3.7, 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checkpoints) == 0 || checkpoints[len(checkpoints)-1].N != 5000 {
+		t.Errorf("resumed checkpoints = %+v, want them to end at n=5000", checkpoints)
+	}
+}
+
+func TestComputeFlagsRoundingOntoOne(t *testing.T) {
+	e, mr := newTestEngine(t)
+
+	// 4x(1-x) = 1 - 4e-18 for x = 0.5 + 1e-9, which rounds to exactly 1.
+	x := 0.5 + 1e-9
+	if 4*x*(1-x) != 1 {
+		t.Fatalf("4x(1-x) = %v, want it to round to 1", 4*x*(1-x))
+	}
+	mr.ZAdd(fmt.Sprintf("cp:%d", HashFloat64(4)), 1000, encodeCheckpoint(x, config.CheckpointEncodingBinary))
+
+	_, err := e.Compute(context.Background(), 4, absorbingN)
+	if !errors.Is(err, ErrDegenerate) {
+		t.Errorf("Compute(r=4) through a rounded x=1: error = %v, want ErrDegenerate", err)
+	}
+}
+
+func TestComputeWithinReturnsPartialResults(t *testing.T) {
+	e, _ := newTestEngine(t)
+	ctx := context.Background()
+	rHash := e.seriesHash(3.7, 0)
+
+	// r = 3.7 is chaotic, so the point is out of reach within the budget.
+	x, reached, err := e.ComputeWithin(ctx, 3.7, absorbingN, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reached <= 0 || reached >= absorbingN {
+		t.Fatalf("reached n=%d, want a partial result", reached)
+	}
+	if want := directIterate(3.7, reached); x != want {
+		t.Errorf("x_%d = %v, want %v", reached, x, want)
+	}
+	if cached, ok := e.l1Cache.Get(rHash, reached); !ok || cached != x {
+		t.Errorf("x_%d cached as %v, %v; want %v", reached, cached, ok, x)
+	}
+
+	// Another budget picks up where the first stopped.
+	_, further, err := e.ComputeWithin(ctx, 3.7, absorbingN, 20*time.Millisecond)
+	if err != nil || further <= reached {
+		t.Errorf("second budget reached n=%d, %v; want past %d", further, err, reached)
+	}
+
+	// A point within reach is computed in full.
+	if x, reached, err := e.ComputeWithin(ctx, 3.5, 1000, time.Second); err != nil || reached != 1000 || x != directIterate(3.5, 1000) {
+		t.Errorf("ComputeWithin(3.5, 1000) = %v at n=%d, %v; want the full result", x, reached, err)
+	}
+
+	resp, err := e.ComputeRequest(ctx, models.Request{R: 3.8, N: absorbingN, BudgetMs: 20})
+	if err != nil || !resp.Partial || resp.ReachedN <= 0 || resp.Result != directIterate(3.8, resp.ReachedN) {
+		t.Errorf("request with budget_ms = %+v, %v; want a partial response", resp, err)
+	}
+}
+
+func TestComputeMultiAlignsToInput(t *testing.T) {
+	for _, tc := range []struct {
+		name              string
+		workers, queueCap int
+	}{
+		{"pool", 4, 100},
+		// With no queue every r is computed on the caller instead.
+		{"queue full", 1, 0},
+	} {
+		mr := miniredis.RunT(t)
+		cfg := config.Default()
+		cfg.RedisAddr = mr.Addr()
+		cfg.TotalPods = 1
+		cfg.Workers, cfg.QueueSize = tc.workers, tc.queueCap
+		e := NewComputeEngine(cfg)
+		t.Cleanup(e.Close)
+
+		rs := []float64{3.9, 3.2, 3.9, 2.5, 3.57, 4}
+		responses := e.ComputeMulti(context.Background(), rs, 80)
+		if len(responses) != len(rs) {
+			t.Fatalf("%s: %d responses for %d r values", tc.name, len(responses), len(rs))
+		}
+		for i, r := range rs {
+			resp := responses[i]
+			if resp.R != r || resp.N != 80 {
+				t.Errorf("%s: response %d is for r=%v n=%d, want r=%v", tc.name, i, resp.R, resp.N, r)
+				continue
+			}
+			if resp.Error != "" || resp.Result != directIterate(r, 80) {
+				t.Errorf("%s: r=%v = %v %q, want %v", tc.name, r, resp.Result, resp.Error, directIterate(r, 80))
+			}
+		}
+	}
+}
+
+func TestComputeBatchDuplicatePolicies(t *testing.T) {
+	requests := []models.Request{
+		{R: 3.7, N: 200},
+		{R: 3.7, N: 100, BudgetMs: 1000},
+		{R: 3.5, N: 100},
+		{R: 3.7, N: 100},
+		{R: 3.7, N: 100, C: 0.01},
+	}
+
+	for _, tc := range []struct {
+		policy string
+		want   int
+	}{
+		{config.BatchDuplicatesPreserve, 5},
+		{config.BatchDuplicatesDedupe, 4},
+	} {
+		mr := miniredis.RunT(t)
+		cfg := config.Default()
+		cfg.RedisAddr = mr.Addr()
+		cfg.TotalPods = 1
+		cfg.BatchDuplicates = tc.policy
+		e := NewComputeEngine(cfg)
+		t.Cleanup(e.Close)
+
+		responses := e.ComputeBatch(context.Background(), requests)
+		if len(responses) != tc.want {
+			t.Fatalf("%s: %d responses, want %d: %+v", tc.policy, len(responses), tc.want, responses)
+		}
+		plain := 0
+		for _, resp := range responses {
+			if resp.R == 3.7 && resp.N == 100 && resp.C == 0 {
+				plain++
+				if resp.Result != directIterate(3.7, 100) {
+					t.Errorf("%s: x_100 = %v, want %v", tc.policy, resp.Result, directIterate(3.7, 100))
+				}
+			}
+		}
+		if want := tc.want - 3; plain != want {
+			t.Errorf("%s: r=3.7 n=100 returned %d times, want %d", tc.policy, plain, want)
+		}
+	}
+}
+
+func TestComputeBatchPerRBudget(t *testing.T) {
+	e, _ := newTestEngine(t)
+	e.perRBudget = 50 * time.Millisecond
+
+	// r = 3.7 is chaotic and never short-circuits, so without the budget its
+	// point would hold up the batch for hours.
+	start := time.Now()
+	responses := e.ComputeBatch(context.Background(), []models.Request{
+		{R: 3.7, N: absorbingN},
+		{R: 3.2, N: 1000},
+		{R: 3.5, N: 1000},
+	})
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("batch took %v, want the expensive r cut off after its budget", elapsed)
+	}
+
+	if len(responses) != 3 {
+		t.Fatalf("%d responses, want 3", len(responses))
+	}
+	for _, resp := range responses {
+		if resp.R == 3.7 {
+			if !resp.Partial || resp.ReachedN <= 0 || resp.RBudgetMs != 50 {
+				t.Errorf("r=3.7: partial %v at n=%d, r_budget_ms %d; want a partial result under the 50ms budget",
+					resp.Partial, resp.ReachedN, resp.RBudgetMs)
+			}
+			continue
+		}
+		if resp.Partial || resp.RBudgetMs != 0 || resp.Result != directIterate(resp.R, resp.N) {
+			t.Errorf("r=%v: %+v, want the full result %v", resp.R, resp, directIterate(resp.R, resp.N))
+		}
+	}
+}
+
+func TestComputeBatchEachKeepsRequestOrder(t *testing.T) {
+	e, _ := newTestEngine(t)
+	e.perRBudget = 50 * time.Millisecond
+	requests := []models.Request{
+		{R: 3.5, N: 1000},
+		{R: 3.7, N: absorbingN},
+		{R: 3.5, N: 10},
+		{R: 3.7, N: 20},
+	}
+
+	var order []int
+	e.ComputeBatchEach(context.Background(), requests, func(i int, resp models.Response, ok bool) {
+		order = append(order, i)
+		req := requests[i]
+		if !ok || resp.R != req.R || resp.N != req.N {
+			t.Errorf("item %d = %+v, ok %v; want the response to %+v", i, resp, ok, req)
+			return
+		}
+		if req.N == absorbingN {
+			if !resp.Partial || resp.RBudgetMs != 50 {
+				t.Errorf("item %d: partial %v, r_budget_ms %d; want cut off by the per-r budget", i, resp.Partial, resp.RBudgetMs)
+			}
+			return
+		}
+		if resp.Partial || resp.Result != directIterate(req.R, req.N) {
+			t.Errorf("item %d = %+v, want the full result %v", i, resp, directIterate(req.R, req.N))
+		}
+	})
+	if len(order) != len(requests) {
+		t.Fatalf("emitted %v, want every index", order)
+	}
+	for i, got := range order {
+		if got != i {
+			t.Fatalf("emitted %v, want request order", order)
+		}
+	}
+
+	// A cancelled batch stops before its next item.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	e.ComputeBatchEach(ctx, requests, func(i int, _ models.Response, _ bool) {
+		t.Errorf("item %d emitted after the cancel", i)
+	})
+}
+
+func TestComputeFreshSkipsCacheAndCheckpoints(t *testing.T) {
+	e, mr := newTestEngine(t)
+	ctx := context.Background()
+	r, n := 3.7, int64(2500)
+	want := directIterate(r, n)
+
+	// A wrong checkpoint, as left by an earlier precision, is picked up by
+	// a plain compute and from there fills L1.
+	rHash := e.seriesHash(r, 0)
+	key := checkpointKey(config.DefaultTenant, rHash)
+	e.storeCheckpoint(ctx, key, rHash, 2000, 0.42)
+	if x, err := e.Compute(ctx, r, n); err != nil || x == want {
+		t.Fatalf("plain compute = %v, %v: want the planted checkpoint used", x, err)
+	}
+
+	// Holding Redis blocks any checkpoint lookup, so this only returns if
+	// the fresh compute makes none.
+	mr.Lock()
+	done := make(chan struct{})
+	var x float64
+	var err error
+	go func() {
+		defer close(done)
+		x, err = e.ComputeFresh(ctx, r, n, false)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		mr.Unlock()
+		t.Fatal("fresh compute without store waited on Redis")
+	}
+	mr.Unlock()
+	if err != nil || x != want {
+		t.Fatalf("fresh compute = %v, %v, want %v", x, err, want)
+	}
+	if x, _ := e.Compute(ctx, r, n); x == want {
+		t.Error("fresh compute without store replaced the cached value")
+	}
+
+	if x, err := e.ComputeFresh(ctx, r, n, true); err != nil || x != want {
+		t.Fatalf("fresh compute with store = %v, %v, want %v", x, err, want)
+	}
+	if x, err := e.Compute(ctx, r, n); err != nil || x != want {
+		t.Errorf("plain compute after fresh store = %v, %v, want %v", x, err, want)
+	}
+	got, ok, err := e.checkpointAt(ctx, key, 2000)
+	if err != nil || !ok || got != directIterate(r, 2000) {
+		t.Errorf("checkpoint at 2000 = %v, %v, %v, want %v", got, ok, err, directIterate(r, 2000))
+	}
+	if members, _ := mr.ZMembers(key); len(members) != 2 {
+		t.Errorf("checkpoints = %v, want one each at 1000 and 2000", members)
+	}
+}
+
+func TestReplicaServesCheckpointReads(t *testing.T) {
+	primary, replica := miniredis.RunT(t), miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = primary.Addr()
+	cfg.RedisReplicaAddr = replica.Addr()
+	cfg.TotalPods = 1
+	e := NewComputeEngine(cfg)
+	t.Cleanup(e.Close)
+	ctx := context.Background()
+
+	// Only the replica has this checkpoint, so resuming from it shows the
+	// lookup went there.
+	r := 3.7
+	rHash := e.seriesHash(r, 0)
+	key := checkpointKey(config.DefaultTenant, rHash)
+	replica.ZAdd(key, 2000, encodeCheckpoint(0.42, e.checkpointEncoding))
+
+	x, err := e.Compute(ctx, r, 3500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x == directIterate(r, 3500) {
+		t.Error("compute did not resume from the replica's checkpoint")
+	}
+	if members, _ := primary.ZMembers(key); len(members) != 1 {
+		t.Errorf("primary checkpoints = %v, want the one at 3000 written there", members)
+	}
+	if members, _ := replica.ZMembers(key); len(members) != 1 {
+		t.Errorf("replica checkpoints = %v, want only the planted one", members)
+	}
+
+	// A checkpoint missing from a lagging replica just means a longer
+	// resume from x0.
+	other := 3.8
+	otherKey := checkpointKey(config.DefaultTenant, e.seriesHash(other, 0))
+	primary.ZAdd(otherKey, 2000, encodeCheckpoint(0.42, e.checkpointEncoding))
+	if x, err := e.Compute(ctx, other, 2500); err != nil || x != directIterate(other, 2500) {
+		t.Errorf("compute with the checkpoint only on the primary = %v, %v, want %v", x, err, directIterate(other, 2500))
+	}
+
+	restarted := NewComputeEngine(cfg)
+	t.Cleanup(restarted.Close)
+	reads := replica.CommandCount()
+	restarted.PreheatCache(ctx)
+	if replica.CommandCount() == reads {
+		t.Error("preheat did not read from the replica")
+	}
+	if got, ok := restarted.l1Cache.Get(rHash, 2000); !ok || got != 0.42 {
+		t.Errorf("preheated x_2000 = %v, %v, want the replica's 0.42", got, ok)
+	}
+}
+
+func clampedIterate(r float64, n int64) float64 {
+	x := 0.5
+	for i := int64(0); i < n; i++ {
+		x = ClampX(r * x * (1 - x))
+	}
+	return x
+}
+
+func TestComputeClampedNearFour(t *testing.T) {
+	e, _ := newTestEngine(t)
+	ctx := context.Background()
+
+	for _, r := range []float64{4, 4 + 1e-9} {
+		unclamped, err := e.Compute(ctx, r, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := e.ComputeRequest(ctx, models.Request{R: r, N: 100, Clamp: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		clamped := resp.Result
+
+		// From x0 = 0.5 the unclamped orbit overshoots 1 and then collapses
+		// to 0 (r = 4) or runs off to -Inf (r > 4); the clamped one stays
+		// inside [0, 1].
+		if unclamped != directIterate(r, 100) || (unclamped != 0 && !math.IsInf(unclamped, -1)) {
+			t.Errorf("r=%v: unclamped x_100 = %v, want the plain orbit's %v", r, unclamped, directIterate(r, 100))
+		}
+		if clamped != clampedIterate(r, 100) || !(clamped > 0 && clamped < 1) {
+			t.Errorf("r=%v: clamped x_100 = %v, want %v inside (0, 1)", r, clamped, clampedIterate(r, 100))
+		}
+		if !resp.Clamped {
+			t.Errorf("r=%v: clamped response not marked clamped", r)
+		}
+
+		// Each is cached under its own series.
+		if again, _ := e.Compute(ctx, r, 100); again != unclamped {
+			t.Errorf("r=%v: unclamped x_100 after a clamped compute = %v, want %v", r, again, unclamped)
+		}
+	}
+}
+
+func TestLoadSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	ndjson := filepath.Join(dir, "warm.ndjson")
+	os.WriteFile(ndjson, []byte(`{"r": 3.7, "n": 1000, "result": 0.25}
+{"r": 3.5, "c": 0.01, "n": 50, "result": 0.5}
+
+{"r": 3.6, "n": 10, "result": 0.75, "clamped": true}
+not json
+{"r": 3.7, "n": 2000}
+{"r": 3.7, "n": -1, "result": 0.5}
+{"r": 3.9, "n": 10, "result": 0.5, "error": "request cancelled"}
+{"r": 3.9, "n": 20, "result": 0.5, "partial": true, "reached_n": 10}
+`), 0o644)
+	csvFile := filepath.Join(dir, "warm.csv")
+	os.WriteFile(csvFile, []byte("r,n,result\n3.2,400,0.125\n3.3,10,\n3.4,x,0.5\n3.4,20,0.5,extra\n"), 0o644)
+
+	e, _ := newTestEngine(t)
+	loaded, skipped, err := e.LoadSnapshot(ndjson)
+	if err != nil || loaded != 3 || skipped != 5 {
+		t.Fatalf("NDJSON snapshot: loaded %d, skipped %d, err %v; want 3, 5", loaded, skipped, err)
+	}
+	loaded, skipped, err = e.LoadSnapshot(csvFile)
+	if err != nil || loaded != 1 || skipped != 3 {
+		t.Fatalf("CSV snapshot: loaded %d, skipped %d, err %v; want 1, 3", loaded, skipped, err)
+	}
+
+	ctx := context.Background()
+	// The snapshot values are made up, so getting them back shows they were
+	// served from L1 rather than computed.
+	checks := []struct {
+		name string
+		got  func() (float64, error)
+		want float64
+	}{
+		{"r=3.7", func() (float64, error) { return e.Compute(ctx, 3.7, 1000) }, 0.25},
+		{"perturbed", func() (float64, error) { return e.ComputePerturbed(ctx, 3.5, 0.01, 50) }, 0.5},
+		{"clamped", func() (float64, error) {
+			resp, err := e.ComputeRequest(ctx, models.Request{R: 3.6, N: 10, Clamp: true})
+			return resp.Result, err
+		}, 0.75},
+		{"CSV", func() (float64, error) { return e.Compute(ctx, 3.2, 400) }, 0.125},
+	}
+	for _, c := range checks {
+		got, err := c.got()
+		if err != nil || got != c.want {
+			t.Errorf("%s: got %v, %v; want %v from the snapshot", c.name, got, err, c.want)
+		}
+	}
+
+	if _, _, err := e.LoadSnapshot(filepath.Join(dir, "missing.ndjson")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing snapshot: err = %v", err)
+	}
+}
+
+func TestLoadSnapshotSkipsOversizedLines(t *testing.T) {
+	long := `{"r": 3.8, "n": 10, "result": 0.5, "pad": "` + strings.Repeat("x", snapshotMaxLine) + `"}`
+	for _, tc := range []struct {
+		name, body string
+		loaded     int
+	}{
+		{"between entries", `{"r": 3.7, "n": 1000, "result": 0.25}` + "\n" + long + "\n" + `{"r": 3.2, "n": 400, "result": 0.125}`, 2},
+		{"last line", `{"r": 3.7, "n": 1000, "result": 0.25}` + "\n" + long, 1},
+		{"several in a row", long + "\n" + long + "\n" + `{"r": 3.2, "n": 400, "result": 0.125}` + "\n", 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "warm.ndjson")
+			os.WriteFile(path, []byte(tc.body), 0o644)
+			e, _ := newTestEngine(t)
+			loaded, skipped, err := e.LoadSnapshot(path)
+			if err != nil || loaded != tc.loaded || skipped != strings.Count(tc.body, long) {
+				t.Errorf("loaded %d, skipped %d, err %v; want %d, %d", loaded, skipped, err, tc.loaded, strings.Count(tc.body, long))
+			}
+			if x, err := e.Compute(context.Background(), 3.2, 400); strings.Contains(tc.body, `"r": 3.2`) && (err != nil || x != 0.125) {
+				t.Errorf("entry after the long line: got %v, %v; want 0.125", x, err)
+			}
+		})
+	}
+}
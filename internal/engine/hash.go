@@ -5,20 +5,118 @@ import (
     "fmt"
     "hash/fnv"
     "math"
+    "strconv"
+    "strings"
+
+    "resilientrecursion/pkg/config"
 )
 
 func HashFloat64(r float64) uint64 {
     return math.Float64bits(r)
 }
 
+// HashSeries keys the map x = r*x*(1-x) + c in the cache and in Redis. With
+// c == 0 it is HashFloat64(r), so plain logistic series keep their keys.
+func HashSeries(r, c float64) uint64 {
+    if c == 0 {
+        return HashFloat64(r)
+    }
+    h := fnv.New64a()
+    binary.Write(h, binary.LittleEndian, math.Float64bits(r))
+    binary.Write(h, binary.LittleEndian, math.Float64bits(c))
+    return h.Sum64()
+}
+
+// HashSeriesFrom is HashSeries for a series started at x0. Series from
+// config.DefaultX0 keep the HashSeries key; any other x0 is hashed in, so
+// its values never mix with theirs in L1 or Redis.
+func HashSeriesFrom(r, c, x0 float64) uint64 {
+    if x0 == config.DefaultX0 {
+        return HashSeries(r, c)
+    }
+    h := fnv.New64a()
+    binary.Write(h, binary.LittleEndian, math.Float64bits(r))
+    binary.Write(h, binary.LittleEndian, math.Float64bits(c))
+    binary.Write(h, binary.LittleEndian, math.Float64bits(x0))
+    return h.Sum64()
+}
+
+// HashMapSeries keys the series of a map kind other than the logistic map,
+// started at x0. The kind is hashed in, so each kind has its own L1 entries
+// and checkpoints even at the same r.
+func HashMapSeries(kind string, r, x0 float64) uint64 {
+    h := fnv.New64a()
+    h.Write([]byte(kind))
+    h.Write([]byte{0})
+    binary.Write(h, binary.LittleEndian, math.Float64bits(r))
+    binary.Write(h, binary.LittleEndian, math.Float64bits(x0))
+    return h.Sum64()
+}
+
+// HashClampedSeries keys the clamped variant of the series keyed rHash, so
+// clamped values never mix with unclamped ones in L1 or Redis.
+func HashClampedSeries(rHash uint64) uint64 {
+    h := fnv.New64a()
+    h.Write([]byte("clamp"))
+    h.Write([]byte{0})
+    binary.Write(h, binary.LittleEndian, rHash)
+    return h.Sum64()
+}
+
 func GetPodForR(rHash uint64, totalPods int) int {
     h := fnv.New32a()
     binary.Write(h, binary.LittleEndian, rHash)
     return int(h.Sum32() % uint32(totalPods))
 }
 
-func ParsePodID(podID string) int {
-    var id int
-    fmt.Sscanf(podID, "pod-%d", &id)
-    return id
+// GetPodForRWeighted assigns rHash to a pod with probability proportional to
+// its weight; weights must be positive. Every pod must be given the same
+// weights so they agree on ownership.
+func GetPodForRWeighted(rHash uint64, weights []float64) int {
+    var total float64
+    for _, w := range weights {
+        total += w
+    }
+
+    h := fnv.New32a()
+    binary.Write(h, binary.LittleEndian, rHash)
+    point := float64(h.Sum32()) / (1 << 32) * total
+
+    for i, w := range weights {
+        if point < w {
+            return i
+        }
+        point -= w
+    }
+    return len(weights) - 1
+}
+
+// ParsePodID returns the index n of a pod named pod-<n>. Any other name is
+// an error, so a misnamed pod is not taken for pod 0.
+func ParsePodID(podID string) (int, error) {
+    digits, ok := strings.CutPrefix(podID, "pod-")
+    if !ok || digits == "" || strings.TrimLeft(digits, "0123456789") != "" {
+        return 0, fmt.Errorf("POD_ID must be pod-<n>, got %q", podID)
+    }
+    id, err := strconv.Atoi(digits)
+    if err != nil {
+        return 0, fmt.Errorf("POD_ID %q: %w", podID, err)
+    }
+    return id, nil
+}
+
+// ValidatePodID checks that podID parses to an index that exists in a
+// cluster of totalPods, so the pod owns some share of the r values.
+func ValidatePodID(podID string, totalPods int) error {
+    if totalPods < 1 {
+        return fmt.Errorf("TOTAL_PODS must be at least 1, got %d", totalPods)
+    }
+    id, err := ParsePodID(podID)
+    if err != nil {
+        return err
+    }
+    if id >= totalPods {
+        return fmt.Errorf("POD_ID %q parses to index %d, outside [0, %d)", podID, id, totalPods)
+    }
+    return nil
 }
\ No newline at end of file
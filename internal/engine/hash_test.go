@@ -0,0 +1,62 @@
+package engine
+
+import (
+	"math"
+	"testing"
+)
+
+func TestValidatePodID(t *testing.T) {
+	tests := []struct {
+		podID     string
+		totalPods int
+		wantErr   bool
+	}{
+		{"pod-0", 3, false},
+		{"pod-2", 3, false},
+		{"pod-3", 3, true},
+		{"pod-5", 3, true},
+		{"pod-0", 0, true},
+		{"pod-10", 11, false},
+		{"web-2", 3, true},
+		{"resilient-7f9c", 3, true},
+		{"pod-", 3, true},
+		{"pod--1", 3, true},
+		{"pod-+1", 3, true},
+		{"pod-1x", 3, true},
+		{"pod-1 ", 3, true},
+		{"pod-99999999999999999999", 3, true},
+	}
+
+	for _, tt := range tests {
+		err := ValidatePodID(tt.podID, tt.totalPods)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ValidatePodID(%q, %d) error = %v, wantErr %v", tt.podID, tt.totalPods, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParsePodID(t *testing.T) {
+	if id, err := ParsePodID("pod-12"); err != nil || id != 12 {
+		t.Errorf("ParsePodID(pod-12) = %d, %v; want 12", id, err)
+	}
+	if _, err := ParsePodID("web-2"); err == nil {
+		t.Error("ParsePodID(web-2) accepted")
+	}
+}
+
+func TestGetPodForRWeightedDistribution(t *testing.T) {
+	weights := []float64{3, 1, 1}
+	const samples = 20000
+
+	counts := make([]int, len(weights))
+	for i := 0; i < samples; i++ {
+		counts[GetPodForRWeighted(HashFloat64(3+float64(i)*1e-5), weights)]++
+	}
+
+	for i, w := range weights {
+		share := float64(counts[i]) / samples
+		if want := w / 5; math.Abs(share-want) > 0.03 {
+			t.Errorf("pod %d owns %.3f of r values, want about %.3f", i, share, want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// Interval is a closed range [Lo, Hi] of float64 values.
+type Interval struct {
+	Lo, Hi float64
+}
+
+// Mid returns the midpoint of iv.
+func (iv Interval) Mid() float64 { return iv.Lo + (iv.Hi-iv.Lo)/2 }
+
+// Width returns Hi - Lo rounded up, so it never understates the spread.
+func (iv Interval) Width() float64 { return subUp(iv.Hi, iv.Lo) }
+
+// Directed rounding. Go only rounds to nearest, so each operation is done
+// that way and its exact error, from TwoSum or an FMA, tells which way the
+// result was rounded. When it was rounded the wrong way for the bound wanted,
+// the result moves one ulp outward.
+
+func twoSumErr(a, b, s float64) float64 {
+	bb := s - a
+	return (a - (s - bb)) + (b - bb)
+}
+
+func addDown(a, b float64) float64 {
+	s := a + b
+	if twoSumErr(a, b, s) < 0 {
+		return math.Nextafter(s, math.Inf(-1))
+	}
+	return s
+}
+
+func addUp(a, b float64) float64 {
+	s := a + b
+	if twoSumErr(a, b, s) > 0 {
+		return math.Nextafter(s, math.Inf(1))
+	}
+	return s
+}
+
+func subDown(a, b float64) float64 { return addDown(a, -b) }
+
+func subUp(a, b float64) float64 { return addUp(a, -b) }
+
+func mulDown(a, b float64) float64 {
+	p := a * b
+	if math.FMA(a, b, -p) < 0 {
+		return math.Nextafter(p, math.Inf(-1))
+	}
+	return p
+}
+
+func mulUp(a, b float64) float64 {
+	p := a * b
+	if math.FMA(a, b, -p) > 0 {
+		return math.Nextafter(p, math.Inf(1))
+	}
+	return p
+}
+
+// logisticInterval bounds r*x*(1-x) over every x in iv, for r in [0, 4].
+// The map is evaluated as r*(1/4 - (x-1/2)^2), in which x appears once, so
+// interval evaluation bounds the true image up to outward rounding instead
+// of compounding the dependency between x and 1-x. The image of [0, 1]
+// always lies in [0, r/4], and the result is clipped to that.
+func logisticInterval(r float64, iv Interval) Interval {
+	lo, hi := subDown(iv.Lo, 0.5), subUp(iv.Hi, 0.5)
+
+	var sq Interval
+	switch {
+	case lo >= 0:
+		sq = Interval{mulDown(lo, lo), mulUp(hi, hi)}
+	case hi <= 0:
+		sq = Interval{mulDown(hi, hi), mulUp(lo, lo)}
+	default:
+		m := math.Max(-lo, hi)
+		sq = Interval{0, mulUp(m, m)}
+	}
+
+	next := Interval{mulDown(r, subDown(0.25, sq.Hi)), mulUp(r, subUp(0.25, sq.Lo))}
+	next.Lo = math.Max(next.Lo, 0)
+	next.Hi = math.Min(next.Hi, r/4)
+	return next
+}
+
+// ComputeInterval returns an interval that provably contains the exact x_n
+// of the logistic map at r from x0, with every rounding error of the
+// iteration accounted for. r must be in [0, 4] and x0 in [0, 1].
+//
+// For a stable r the width stays within a few ulps of x_n. In a chaotic
+// regime it grows by about e^λ per step, λ being the Lyapunov exponent, until
+// it covers [0, r/4] and the float64 x_n carries no information. Each step
+// costs several times a plain one and nothing is cached. The iteration stops
+// early once the interval maps onto itself.
+func (e *ComputeEngine) ComputeInterval(ctx context.Context, r, x0 float64, n int) (Interval, error) {
+	if !(r >= 0 && r <= 4) || !(x0 >= 0 && x0 <= 1) {
+		return Interval{}, fmt.Errorf("interval compute needs r in [0, 4] and x0 in [0, 1], got r=%v x0=%v", r, x0)
+	}
+
+	iv := Interval{x0, x0}
+	stride := int(e.cancelCheckStride.Load())
+	for i := 0; i < n; i++ {
+		if (i+1)%stride == 0 {
+			if err := ctx.Err(); err != nil {
+				return Interval{}, err
+			}
+		}
+		next := logisticInterval(r, iv)
+		if next == iv {
+			break
+		}
+		iv = next
+	}
+	return iv, nil
+}
@@ -0,0 +1,83 @@
+package engine
+
+import (
+	"context"
+	"math"
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+func TestDirectedRoundingBracketsExactResult(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	exact := func(f float64) *big.Float { return new(big.Float).SetPrec(2100).SetFloat64(f) }
+	check := func(op string, a, b, lo, hi float64, want *big.Float) {
+		if exact(lo).Cmp(want) > 0 || exact(hi).Cmp(want) < 0 || math.Nextafter(lo, hi) < hi {
+			t.Fatalf("%v %s %v: [%v, %v] does not tightly bracket %v", a, op, b, lo, hi, want.Text('g', 30))
+		}
+	}
+	for i := 0; i < 10000; i++ {
+		a, b := rng.Float64()-0.5, rng.Float64()*math.Pow(2, float64(rng.Intn(40)-20))
+		check("+", a, b, addDown(a, b), addUp(a, b), new(big.Float).SetPrec(2100).Add(exact(a), exact(b)))
+		check("*", a, b, mulDown(a, b), mulUp(a, b), new(big.Float).SetPrec(2100).Mul(exact(a), exact(b)))
+	}
+}
+
+func TestIntervalContainsExactOrbit(t *testing.T) {
+	e, _ := newTestEngine(t)
+	// x_n takes about 53 * (2^(n+1) - 1) bits, so 8192 hold it exactly up
+	// to n = 6.
+	const prec = 8192
+	for _, r := range []float64{2.8, 3.5, 3.9} {
+		for n := 1; n <= 6; n++ {
+			x := new(big.Float).SetPrec(prec).SetFloat64(0.3)
+			br := new(big.Float).SetPrec(prec).SetFloat64(r)
+			one := new(big.Float).SetPrec(prec).SetInt64(1)
+			for i := 0; i < n; i++ {
+				rest := new(big.Float).SetPrec(prec).Sub(one, x)
+				x.Mul(br, x).Mul(x, rest)
+			}
+			iv, err := e.ComputeInterval(context.Background(), r, 0.3, n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lo, hi := new(big.Float).SetFloat64(iv.Lo), new(big.Float).SetFloat64(iv.Hi)
+			if lo.Cmp(x) > 0 || hi.Cmp(x) < 0 {
+				t.Errorf("r=%v n=%d: [%v, %v] misses %v", r, n, iv.Lo, iv.Hi, x.Text('g', 20))
+			}
+		}
+	}
+}
+
+func TestIntervalWidthStableVersusChaotic(t *testing.T) {
+	e, _ := newTestEngine(t)
+	ctx := context.Background()
+	width := func(r float64, n int) float64 {
+		iv, err := e.ComputeInterval(ctx, r, 0.3, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return iv.Width()
+	}
+
+	// At r=2.8 the orbit contracts onto 1-1/r, so rounding errors are damped
+	// and the width stays at a few ulps however long it runs.
+	for _, n := range []int{10, 1000, 1000000} {
+		if w := width(2.8, n); w > 1e-15 {
+			t.Errorf("r=2.8 n=%d: width %v, want a few ulps", n, w)
+		}
+	}
+	if iv, _ := e.ComputeInterval(ctx, 2.8, 0.3, 1000000); math.Abs(iv.Mid()-(1-1/2.8)) > 1e-15 {
+		t.Errorf("r=2.8: midpoint %v, want 1-1/r", iv.Mid())
+	}
+
+	// At r=3.9 the width grows by about e^0.5 per step until it spans most
+	// of the attractor.
+	small, grown := width(3.9, 10), width(3.9, 40)
+	if small > 1e-12 || grown <= 1000*small {
+		t.Errorf("r=3.9: widths %v at n=10 and %v at n=40, want fast growth", small, grown)
+	}
+	if w := width(3.9, 200); w < 0.5 {
+		t.Errorf("r=3.9 n=200: width %v, want most of [0, r/4]", w)
+	}
+}
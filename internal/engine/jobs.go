@@ -0,0 +1,344 @@
+package engine
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"resilientrecursion/internal/logging"
+	"resilientrecursion/internal/models"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrJobNotFound is returned by Job for unknown or expired job IDs.
+var ErrJobNotFound = errors.New("job not found")
+
+// ErrShuttingDown is why StopJobs cancels the jobs still running. Computes
+// cancelled for it store the position they reached as a checkpoint.
+var ErrShuttingDown = errors.New("pod shutting down")
+
+// ErrJobCancelled is why CancelJob cancels a running job.
+var ErrJobCancelled = errors.New("job cancelled")
+
+// ErrJobFinished is returned by CancelJob for a job that is already done,
+// failed or cancelled.
+var ErrJobFinished = errors.New("job already finished")
+
+// jobPollInterval is how often StopJobs checks whether the jobs it cancelled
+// have returned.
+const jobPollInterval = 10 * time.Millisecond
+
+// jobCancelPoll is how often a running job checks its record for a
+// cancellation made on another pod.
+const jobCancelPoll = time.Second
+
+// jobCancels holds the cancel functions of the jobs running on this pod, by
+// job key, so CancelJob stops them without waiting for their next poll.
+type jobCancels struct {
+	mu      sync.Mutex
+	running map[string]context.CancelCauseFunc
+}
+
+func (jc *jobCancels) add(key string, cancel context.CancelCauseFunc) {
+	jc.mu.Lock()
+	defer jc.mu.Unlock()
+	if jc.running == nil {
+		jc.running = make(map[string]context.CancelCauseFunc)
+	}
+	jc.running[key] = cancel
+}
+
+func (jc *jobCancels) remove(key string) {
+	jc.mu.Lock()
+	defer jc.mu.Unlock()
+	delete(jc.running, key)
+}
+
+func (jc *jobCancels) cancel(key string) {
+	jc.mu.Lock()
+	defer jc.mu.Unlock()
+	if cancel, ok := jc.running[key]; ok {
+		cancel(ErrJobCancelled)
+	}
+}
+
+func jobKey(tenant, id string) string {
+	return fmt.Sprintf("%sjob:%s", tenantPrefix(tenant), id)
+}
+
+// idempotencyKey maps a tenant's Idempotency-Key to the job it created.
+func idempotencyKey(tenant, key string) string {
+	return fmt.Sprintf("%sidem:%s", tenantPrefix(tenant), key)
+}
+
+func newJobID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// SubmitJob records a queued job in Redis and hands the batch to the worker
+// pool. Job records live in Redis so any pod can answer status queries; they
+// expire after the configured job TTL. The job computes as the tenant carried
+// by ctx and is queued at its priority.
+func (e *ComputeEngine) SubmitJob(ctx context.Context, requests []models.Request) (*models.Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+	return e.submitJob(ctx, id, requests)
+}
+
+// SubmitJobOnce is SubmitJob for a client-supplied idempotency key: the first
+// submission with a key creates the job, and repeats within the job TTL
+// return that job in its current state instead of creating another.
+func (e *ComputeEngine) SubmitJobOnce(ctx context.Context, key string, requests []models.Request) (*models.Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+
+	idemKey := idempotencyKey(TenantFrom(ctx), key)
+	created, err := e.redisClient.SetNX(ctx, idemKey, id, e.jobTTL).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		existing, err := e.redisClient.Get(ctx, idemKey).Result()
+		if err != nil {
+			return nil, err
+		}
+		return e.Job(ctx, existing)
+	}
+
+	job, err := e.submitJob(ctx, id, requests)
+	if err != nil {
+		// Let a retry with the same key try again.
+		e.redisClient.Del(ctx, idemKey)
+		return nil, err
+	}
+	return job, nil
+}
+
+// QueueWait estimates how long a job submitted now would wait for a worker.
+func (e *ComputeEngine) QueueWait() time.Duration {
+	return e.pool.EstimatedWait()
+}
+
+func (e *ComputeEngine) submitJob(ctx context.Context, id string, requests []models.Request) (*models.Job, error) {
+	job := &models.Job{ID: id, Status: models.JobQueued}
+	if err := e.saveJob(ctx, job); err != nil {
+		return nil, err
+	}
+
+	tenant := TenantFrom(ctx)
+	err := e.pool.Submit(priorityFrom(ctx), func() {
+		e.runJob(id, tenant, requests)
+	})
+	if err != nil {
+		e.redisClient.Del(ctx, jobKey(tenant, id))
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// Job loads the current state of an async job of the tenant carried by ctx.
+func (e *ComputeEngine) Job(ctx context.Context, id string) (*models.Job, error) {
+	data, err := e.redisClient.Get(ctx, jobKey(TenantFrom(ctx), id)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var job models.Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// savedProgressKey carries a flag set by computes that stored their position
+// on shutdown; see noteSavedProgress.
+type savedProgressKey struct{}
+
+func withSavedProgress(ctx context.Context) (context.Context, *atomic.Bool) {
+	saved := new(atomic.Bool)
+	return context.WithValue(ctx, savedProgressKey{}, saved), saved
+}
+
+// noteSavedProgress records on ctx that a compute of its job stored the
+// position it reached.
+func noteSavedProgress(ctx context.Context) {
+	if saved, ok := ctx.Value(savedProgressKey{}).(*atomic.Bool); ok {
+		saved.Store(true)
+	}
+}
+
+// StopJobs cancels the async jobs in progress and waits, until ctx is done,
+// for them and the jobs still queued to return. A compute it interrupts
+// stores the position it reached as a checkpoint, so after a restart the
+// same compute resumes from there, and its job is marked failed with
+// ErrShuttingDown. It returns how many jobs stored partial progress.
+func (e *ComputeEngine) StopJobs(ctx context.Context) int {
+	e.cancelJob(ErrShuttingDown)
+	drained := make(chan struct{})
+	go func() {
+		e.pool.Close()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+	}
+
+	// Jobs from the queue backend run outside the pool.
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+	for e.jobsRunning.Load() > 0 && ctx.Err() == nil {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+		}
+	}
+	if n := e.jobsRunning.Load(); n > 0 {
+		logging.Warnf("%d jobs still running after the job stop budget", n)
+	}
+	return int(e.jobsSaved.Load())
+}
+
+// CancelJob cancels an async job of the tenant carried by ctx and returns
+// it marked cancelled. A queued job is dropped when a worker reaches it. A
+// running one stops within CANCEL_CHECK_STRIDE iterations when it runs on
+// this pod, and within jobCancelPoll when it runs on another. It returns
+// ErrJobFinished, with the job, when there is nothing left to cancel.
+func (e *ComputeEngine) CancelJob(ctx context.Context, id string) (*models.Job, error) {
+	job, err := e.Job(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != models.JobQueued && job.Status != models.JobRunning {
+		return job, ErrJobFinished
+	}
+
+	job = &models.Job{ID: id, Status: models.JobCancelled}
+	if err := e.saveJob(ctx, job); err != nil {
+		return nil, err
+	}
+	e.jobCancels.cancel(jobKey(TenantFrom(ctx), id))
+	return job, nil
+}
+
+// jobCancelled reports whether the record of job id says it was cancelled.
+func (e *ComputeEngine) jobCancelled(ctx context.Context, id string) bool {
+	job, err := e.Job(ctx, id)
+	return err == nil && job.Status == models.JobCancelled
+}
+
+// watchCancel cancels a running job with ErrJobCancelled once its record
+// says it was cancelled, until ctx is done.
+func (e *ComputeEngine) watchCancel(ctx context.Context, id string, cancel context.CancelCauseFunc) {
+	ticker := time.NewTicker(jobCancelPoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if e.jobCancelled(ctx, id) {
+				cancel(ErrJobCancelled)
+				return
+			}
+		}
+	}
+}
+
+func (e *ComputeEngine) runJob(id, tenant string, requests []models.Request) {
+	e.jobsRunning.Add(1)
+	defer e.jobsRunning.Add(-1)
+	// Status writes must still land when the job itself was cancelled.
+	saveCtx := WithTenant(context.Background(), tenant)
+
+	// The cancel func is registered before the record is checked, so a
+	// CancelJob that lands in between still stops the compute.
+	ctx, saved := withSavedProgress(WithTenant(e.jobCtx, tenant))
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	key := jobKey(tenant, id)
+	e.jobCancels.add(key, cancel)
+	defer e.jobCancels.remove(key)
+
+	job := &models.Job{ID: id, Status: models.JobRunning}
+	started, err := e.startJob(saveCtx, job)
+	if err != nil {
+		logging.Errorf("Job %s: %v", id, err)
+		return
+	}
+	if !started {
+		return
+	}
+	go e.watchCancel(ctx, id, cancel)
+
+	job.Results = e.ComputeBatch(ctx, requests)
+	job.Status = models.JobDone
+	if err := ctx.Err(); err != nil {
+		job.Results = nil
+		job.Status = models.JobFailed
+		job.Error = context.Cause(ctx).Error()
+	}
+	if saved.Load() {
+		e.jobsSaved.Add(1)
+	}
+	// A cancellation that lands as the job finishes stands.
+	if errors.Is(context.Cause(ctx), ErrJobCancelled) || e.jobCancelled(saveCtx, id) {
+		return
+	}
+
+	if err := e.saveJob(saveCtx, job); err != nil {
+		logging.Errorf("Job %s: %v", id, err)
+	}
+}
+
+// startJobScript writes ARGV[2] to the job record KEYS[1] with a TTL of
+// ARGV[3] milliseconds, but only while the stored status is ARGV[1].
+var startJobScript = redis.NewScript(`
+local job = redis.call('GET', KEYS[1])
+if not job or cjson.decode(job).status ~= ARGV[1] then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+return 1
+`)
+
+// startJob saves job as the record of its ID if that record is still
+// queued, and reports whether it did. A job cancelled or expired before a
+// worker reached it is left as it is.
+func (e *ComputeEngine) startJob(ctx context.Context, job *models.Job) (bool, error) {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return false, err
+	}
+	key := jobKey(TenantFrom(ctx), job.ID)
+	started, err := startJobScript.Run(ctx, e.redisClient, []string{key},
+		models.JobQueued, data, e.jobTTL.Milliseconds()).Int()
+	return started == 1, err
+}
+
+func (e *ComputeEngine) saveJob(ctx context.Context, job *models.Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return e.redisClient.Set(ctx, jobKey(TenantFrom(ctx), job.ID), data, e.jobTTL).Err()
+}
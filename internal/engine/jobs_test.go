@@ -0,0 +1,286 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"resilientrecursion/internal/models"
+	"resilientrecursion/pkg/config"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// waitJob polls job id until it leaves the queued and running states.
+func waitJob(t *testing.T, e *ComputeEngine, id string) *models.Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := e.Job(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status != models.JobQueued && job.Status != models.JobRunning {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s still %s", id, job.Status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSubmitJobReportsResults(t *testing.T) {
+	e, _ := newTestEngine(t)
+	ctx := context.Background()
+	requests := []models.Request{{R: 3.2, N: 100}, {R: 2.5, N: 50}}
+
+	job, err := e.SubmitJob(ctx, requests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.ID == "" || job.Status != models.JobQueued {
+		t.Errorf("SubmitJob = %+v, want a queued job with an ID", job)
+	}
+
+	done := waitJob(t, e, job.ID)
+	if done.Status != models.JobDone || len(done.Results) != len(requests) {
+		t.Fatalf("finished job = %s with %d results, want done with %d", done.Status, len(done.Results), len(requests))
+	}
+	// Batches come back grouped by series, not in request order.
+	got := make(map[float64]float64)
+	for _, resp := range done.Results {
+		got[resp.R] = resp.Result
+	}
+	for _, req := range requests {
+		want, err := e.ComputeRequest(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got[req.R] != want.Result {
+			t.Errorf("result for r=%v = %v, want %v", req.R, got[req.R], want.Result)
+		}
+	}
+
+	if _, err := e.Job(ctx, "unknown"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Job of an unknown ID: %v, want ErrJobNotFound", err)
+	}
+}
+
+func TestJobExpiresAfterTTL(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	cfg.JobTTL = time.Minute
+	e := NewComputeEngine(cfg)
+	t.Cleanup(e.Close)
+	ctx := context.Background()
+
+	job, err := e.SubmitJob(ctx, []models.Request{{R: 3.2, N: 100}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitJob(t, e, job.ID)
+
+	mr.FastForward(59 * time.Second)
+	if _, err := e.Job(ctx, job.ID); err != nil {
+		t.Fatalf("Job before the TTL: %v", err)
+	}
+	mr.FastForward(2 * time.Second)
+	if _, err := e.Job(ctx, job.ID); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Job after the TTL: %v, want ErrJobNotFound", err)
+	}
+}
+
+func TestJobStatusFromAnotherEngine(t *testing.T) {
+	e, mr := newTestEngine(t)
+	ctx := context.Background()
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	other := NewComputeEngine(cfg)
+	t.Cleanup(other.Close)
+
+	job, err := e.SubmitJob(ctx, []models.Request{{R: 3.2, N: 100}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := waitJob(t, e, job.ID)
+
+	got, err := other.Job(ctx, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != models.JobDone || len(got.Results) != 1 || got.Results[0].Result != done.Results[0].Result {
+		t.Errorf("other engine sees %+v, want %+v", got, done)
+	}
+
+	// Job records are per tenant.
+	if _, err := other.Job(WithTenant(ctx, "other"), job.ID); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Job as another tenant: %v, want ErrJobNotFound", err)
+	}
+}
+
+func TestStopJobsPersistsPartialProgress(t *testing.T) {
+	e, mr := newTestEngine(t)
+	ctx := context.Background()
+	rHash := e.seriesHash(3.7, 0)
+
+	// r = 3.7 is chaotic, so this job runs until it is stopped.
+	job, err := e.SubmitJob(ctx, []models.Request{{R: 3.7, N: absorbingN}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if reached, _, ok := e.l1Cache.Floor(rHash, absorbingN, 0); ok && reached > 100000 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("job made no progress")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	stopCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if saved := e.StopJobs(stopCtx); saved != 1 {
+		t.Errorf("StopJobs = %d jobs saved, want 1", saved)
+	}
+
+	stopped, err := e.Job(ctx, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stopped.Status != models.JobFailed || stopped.Error != ErrShuttingDown.Error() {
+		t.Errorf("stopped job = %s %q, want failed with %q", stopped.Status, stopped.Error, ErrShuttingDown)
+	}
+
+	key := checkpointKey(config.DefaultTenant, rHash)
+	x, at := e.findNearestCheckpoint(ctx, key, absorbingN)
+	if x == nil || at <= 100000 {
+		t.Fatalf("last checkpoint at n=%d, want the position the job reached", at)
+	}
+	// Text checkpoints keep 16 significant digits.
+	if cached, ok := e.l1Cache.Get(rHash, at); !ok || math.Abs(cached-*x) > 1e-15 {
+		t.Errorf("checkpoint x_%d = %v, want the computed %v", at, *x, cached)
+	}
+
+	// After a restart the same series resumes from there.
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	restarted := NewComputeEngine(cfg)
+	defer restarted.Close()
+	resp, err := restarted.ComputeRequest(ctx, models.Request{R: 3.7, N: at + 10, Debug: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *resp.ResumedFrom != at {
+		t.Errorf("restarted compute resumed from n=%d, want %d", *resp.ResumedFrom, at)
+	}
+}
+
+func TestCancelJobStopsCompute(t *testing.T) {
+	e, _ := newTestEngine(t)
+	ctx := context.Background()
+	rHash := e.seriesHash(3.7, 0)
+
+	job, err := e.SubmitJob(ctx, []models.Request{{R: 3.7, N: absorbingN}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress := func() int64 {
+		reached, _, _ := e.l1Cache.Floor(rHash, absorbingN, 0)
+		return reached
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for progress() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("job never started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancelled, err := e.CancelJob(ctx, job.ID)
+	if err != nil || cancelled.Status != models.JobCancelled {
+		t.Fatalf("CancelJob = %+v, %v; want the job cancelled", cancelled, err)
+	}
+	for e.jobsRunning.Load() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("cancelled job still running")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	stopped := progress()
+	time.Sleep(20 * time.Millisecond)
+	if reached := progress(); reached != stopped {
+		t.Errorf("compute went on from n=%d to %d after the cancel", stopped, reached)
+	}
+	if got, err := e.Job(ctx, job.ID); err != nil || got.Status != models.JobCancelled {
+		t.Errorf("Job after cancel = %+v, %v; want it cancelled", got, err)
+	}
+	if _, err := e.CancelJob(ctx, job.ID); !errors.Is(err, ErrJobFinished) {
+		t.Errorf("second CancelJob error = %v, want ErrJobFinished", err)
+	}
+}
+
+func TestCancelQueuedJobNeverRuns(t *testing.T) {
+	e, _ := newTestEngine(t)
+	ctx := context.Background()
+
+	// Submit straight to the record and call runJob as a worker would.
+	job := &models.Job{ID: "queued", Status: models.JobQueued}
+	if err := e.saveJob(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.CancelJob(ctx, job.ID); err != nil {
+		t.Fatal(err)
+	}
+	e.runJob(job.ID, config.DefaultTenant, []models.Request{{R: 3.2, N: 100}})
+
+	if _, ok := e.l1Cache.Get(e.seriesHash(3.2, 0), 100); ok {
+		t.Error("cancelled queued job was computed")
+	}
+	if got, _ := e.Job(ctx, job.ID); got.Status != models.JobCancelled {
+		t.Errorf("status = %s, want cancelled", got.Status)
+	}
+}
+
+func TestStartJobOnlyFromQueued(t *testing.T) {
+	e, _ := newTestEngine(t)
+	ctx := context.Background()
+
+	for _, status := range []string{models.JobQueued, models.JobRunning, models.JobCancelled, models.JobDone} {
+		id := "job-" + status
+		if err := e.saveJob(ctx, &models.Job{ID: id, Status: status}); err != nil {
+			t.Fatal(err)
+		}
+		started, err := e.startJob(ctx, &models.Job{ID: id, Status: models.JobRunning})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := status == models.JobQueued; started != want {
+			t.Errorf("startJob from %s = %v, want %v", status, started, want)
+		}
+		want := status
+		if started {
+			want = models.JobRunning
+		}
+		if got, _ := e.Job(ctx, id); got.Status != want {
+			t.Errorf("status after startJob from %s = %s, want %s", status, got.Status, want)
+		}
+	}
+
+	// An expired record is not brought back.
+	if started, err := e.startJob(ctx, &models.Job{ID: "gone", Status: models.JobRunning}); err != nil || started {
+		t.Errorf("startJob of a missing job = %v, %v; want false", started, err)
+	}
+	if _, err := e.Job(ctx, "gone"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Job after startJob of a missing job: %v, want ErrJobNotFound", err)
+	}
+}
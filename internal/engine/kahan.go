@@ -0,0 +1,21 @@
+package engine
+
+// KahanSum is a compensated float64 accumulator. It carries the low-order
+// bits lost by each addition forward into the next one, so the error of a
+// long running sum stays bounded instead of growing with the number of terms.
+// The zero value is an empty sum.
+type KahanSum struct {
+	sum float64
+	c   float64
+}
+
+func (k *KahanSum) Add(v float64) {
+	y := v - k.c
+	t := k.sum + y
+	k.c = (t - k.sum) - y
+	k.sum = t
+}
+
+func (k *KahanSum) Sum() float64 {
+	return k.sum
+}
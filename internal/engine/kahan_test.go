@@ -0,0 +1,29 @@
+package engine
+
+import (
+	"math"
+	"testing"
+)
+
+func TestKahanSumPathological(t *testing.T) {
+	// One large term followed by many terms each below half an ulp of it:
+	// naive summation rounds every small term away.
+	const terms = 10000000
+	const small = 1e-16
+	want := 1.0 + terms*small
+
+	naive := 1.0
+	var kahan KahanSum
+	kahan.Add(1.0)
+	for i := 0; i < terms; i++ {
+		naive += small
+		kahan.Add(small)
+	}
+
+	if naive != 1.0 {
+		t.Fatalf("expected naive sum to lose every small term, got %.17g", naive)
+	}
+	if got := kahan.Sum(); math.Abs(got-want) > 1e-15 {
+		t.Errorf("Kahan sum = %.17g, want %.17g", got, want)
+	}
+}
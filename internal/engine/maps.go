@@ -0,0 +1,107 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// MapLogistic is the map every other compute uses, x = r*x*(1-x).
+const MapLogistic = "logistic"
+
+var (
+	// ErrUnknownMap is returned for a map kind that was never registered.
+	ErrUnknownMap = errors.New("unknown map kind")
+
+	// ErrMapRegistered is returned by RegisterMap for a name already taken,
+	// built-in kinds included.
+	ErrMapRegistered = errors.New("map kind already registered")
+)
+
+// Iterator is one step of a map: Next returns x_{i+1} from r and x_i. It is
+// called on the computing goroutine for every step and must be cheap and
+// safe for concurrent use.
+type Iterator interface {
+	Next(r, x float64) float64
+}
+
+// IteratorFunc adapts a plain function to an Iterator.
+type IteratorFunc func(r, x float64) float64
+
+func (f IteratorFunc) Next(r, x float64) float64 { return f(r, x) }
+
+// mapName is the form of a registered map kind: it is used as a JSON key
+// and in URLs, so it is kept to a safe alphabet.
+var mapName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+var (
+	mapsMu sync.RWMutex
+
+	// mapSteps holds the map kinds besides the logistic one. The built-in
+	// ones are scaled so that, as for the logistic map, r in [0, 4] keeps x
+	// in [0, 1] and r = 4 is fully chaotic.
+	mapSteps = map[string]Iterator{
+		// tent: x = (r/2) * min(x, 1-x)
+		"tent": IteratorFunc(func(r, x float64) float64 { return r / 2 * math.Min(x, 1-x) }),
+		// sine: x = (r/4) * sin(pi*x)
+		"sine": IteratorFunc(func(r, x float64) float64 { return r / 4 * math.Sin(math.Pi*x) }),
+	}
+)
+
+// RegisterMap adds the map x = fn(r, x) under name, so ComputeMap and
+// POST /calculate/maps accept it. It is RegisterIterator for a plain
+// function.
+func RegisterMap(name string, fn func(r, x float64) float64) error {
+	return RegisterIterator(name, IteratorFunc(fn))
+}
+
+// RegisterIterator adds the map stepped by it under name. The name is part
+// of the map's series keys in L1 and Redis, so every pod sharing a Redis
+// must register the same function under the same name, and a changed
+// function needs a new name. Names cannot be reused.
+func RegisterIterator(name string, it Iterator) error {
+	if !mapName.MatchString(name) {
+		return fmt.Errorf("invalid map kind %q", name)
+	}
+	mapsMu.Lock()
+	defer mapsMu.Unlock()
+	if _, ok := mapSteps[name]; ok || name == MapLogistic {
+		return fmt.Errorf("%w: %q", ErrMapRegistered, name)
+	}
+	mapSteps[name] = it
+	return nil
+}
+
+// MapKinds lists the map kinds ComputeMap accepts, in name order.
+func MapKinds() []string {
+	mapsMu.RLock()
+	defer mapsMu.RUnlock()
+	kinds := []string{MapLogistic}
+	for kind := range mapSteps {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// ComputeMap computes x_n at r of the named map kind. The logistic kind is
+// exactly Compute; the others are cached and checkpointed under series keys
+// of their own, and share no iterations with each other, since their orbits
+// differ from the first step.
+func (e *ComputeEngine) ComputeMap(ctx context.Context, kind string, r float64, n int64) (float64, error) {
+	if kind == MapLogistic {
+		return e.Compute(ctx, r, n)
+	}
+	mapsMu.RLock()
+	it, ok := mapSteps[kind]
+	mapsMu.RUnlock()
+	if !ok {
+		return 0, ErrUnknownMap
+	}
+	x, _, err := e.compute(ctx, r, n, computeOpts{kind: kind, step: it.Next})
+	return x, err
+}
@@ -0,0 +1,101 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+
+	"resilientrecursion/pkg/config"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestComputeMapMatchesDirectIteration(t *testing.T) {
+	e, _ := newTestEngine(t)
+	ctx := context.Background()
+
+	steps := map[string]func(r, x float64) float64{
+		MapLogistic: func(r, x float64) float64 { return r * x * (1 - x) },
+		"tent":      func(r, x float64) float64 { return r / 2 * math.Min(x, 1-x) },
+		"sine":      func(r, x float64) float64 { return r / 4 * math.Sin(math.Pi*x) },
+	}
+	for kind, step := range steps {
+		for _, r := range []float64{2.5, 3.7} {
+			want := 0.5
+			for i := 0; i < 1500; i++ {
+				want = step(r, want)
+			}
+			got, err := e.ComputeMap(ctx, kind, r, 1500)
+			if err != nil || got != want {
+				t.Errorf("ComputeMap(%s, %v, 1500) = %v, %v; want %v", kind, r, got, err, want)
+			}
+		}
+	}
+}
+
+func TestComputeMapKeepsKindsApart(t *testing.T) {
+	e, _ := newTestEngine(t)
+	ctx := context.Background()
+
+	sine, err := e.ComputeMap(ctx, "sine", 3.7, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logistic, err := e.Compute(ctx, 3.7, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sine == logistic {
+		t.Fatalf("sine and logistic x_300 both %v", sine)
+	}
+	if logistic != directIterate(3.7, 300) {
+		t.Errorf("logistic x_300 = %v after a sine compute, want %v", logistic, directIterate(3.7, 300))
+	}
+	// The sine series is cached under its own key, so it answers again
+	// without the logistic entries getting in the way.
+	if again, _ := e.ComputeMap(ctx, "sine", 3.7, 300); again != sine {
+		t.Errorf("second sine x_300 = %v, want %v", again, sine)
+	}
+
+	if _, err := e.ComputeMap(ctx, "henon", 3.7, 300); !errors.Is(err, ErrUnknownMap) {
+		t.Errorf("unknown kind: err = %v, want ErrUnknownMap", err)
+	}
+}
+
+func TestRegisterMapRejectsTakenNames(t *testing.T) {
+	for _, name := range []string{MapLogistic, "tent", "sine"} {
+		if err := RegisterMap(name, func(r, x float64) float64 { return x }); !errors.Is(err, ErrMapRegistered) {
+			t.Errorf("RegisterMap(%q) = %v, want ErrMapRegistered", name, err)
+		}
+	}
+	if err := RegisterMap("no spaces", func(r, x float64) float64 { return x }); err == nil {
+		t.Error("RegisterMap accepted a name with spaces")
+	}
+}
+
+func ExampleRegisterMap() {
+	// The cubic map x = r*x*(1-x^2), registered once at startup.
+	if err := RegisterMap("cubic", func(r, x float64) float64 { return r * x * (1 - x*x) }); err != nil {
+		panic(err)
+	}
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		panic(err)
+	}
+	defer mr.Close()
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	e := NewComputeEngine(cfg)
+	defer e.Close()
+
+	x, err := e.ComputeMap(context.Background(), "cubic", 1.5, 2)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("%v %.6f\n", MapKinds(), x)
+	// Output: [cubic logistic sine tent] 0.576782
+}
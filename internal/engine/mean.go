@@ -0,0 +1,53 @@
+package engine
+
+import "context"
+
+// welford accumulates a running mean and sum of squared deviations with
+// Welford's method, which keeps the variance accurate where subtracting the
+// squared mean from the mean square would cancel.
+type welford struct {
+	n    int64
+	mean float64
+	m2   float64
+}
+
+func (w *welford) add(x float64) {
+	w.n++
+	delta := x - w.mean
+	w.mean += delta / float64(w.n)
+	w.m2 += delta * (x - w.mean)
+}
+
+// variance returns the population variance of the values added so far.
+func (w *welford) variance() float64 {
+	if w.n == 0 {
+		return 0
+	}
+	return w.m2 / float64(w.n)
+}
+
+// TimeAverage returns the mean and the population variance of x_1..x_n for
+// r, the time average of the orbit. Cached values are read from L1 and the
+// rest computed; only x_n is written back, so a long average doesn't flood
+// the cache.
+func (e *ComputeEngine) TimeAverage(ctx context.Context, r float64, n int64) (mean, variance float64, err error) {
+	l1, err := e.cacheFor(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var w welford
+	var last float64
+	err = e.walk(ctx, r, n, false, func(i int64, x float64) {
+		if i > 0 {
+			w.add(x)
+		}
+		last = x
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	l1.Set(e.seriesHash(r, 0), n, last)
+	return w.mean, w.variance(), nil
+}
@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"context"
+	"math"
+	"math/big"
+	"testing"
+
+	"resilientrecursion/pkg/config"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestTimeAverageMatchesReference(t *testing.T) {
+	e, _ := newTestEngine(t)
+	ctx := context.Background()
+	const n = 200000
+
+	// Reference: the same float64 orbit summed exactly.
+	r := 3.9
+	sum, sumSq := new(big.Float).SetPrec(512), new(big.Float).SetPrec(512)
+	x := e.X0()
+	for i := 1; i <= n; i++ {
+		x = r * x * (1 - x)
+		bx := new(big.Float).SetPrec(512).SetFloat64(x)
+		sum.Add(sum, bx)
+		sumSq.Add(sumSq, bx.Mul(bx, bx))
+	}
+	wantMean, _ := new(big.Float).Quo(sum, big.NewFloat(n)).Float64()
+	meanSq, _ := new(big.Float).Quo(sumSq, big.NewFloat(n)).Float64()
+	wantVar := meanSq - wantMean*wantMean
+
+	// Half the orbit is cached and read back, the rest computed.
+	if _, err := e.Compute(ctx, r, n/2); err != nil {
+		t.Fatal(err)
+	}
+	mean, variance, err := e.TimeAverage(ctx, r, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(mean-wantMean) > 1e-12 || math.Abs(variance-wantVar) > 1e-9 {
+		t.Errorf("mean %v, variance %v; want %v and %v", mean, variance, wantMean, wantVar)
+	}
+}
+
+func TestTimeAverageOfKnownAttractors(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	// From the default 0.5, r=4 goes straight to 1 and then 0.
+	cfg.X0 = 0.3
+	e := NewComputeEngine(cfg)
+	t.Cleanup(e.Close)
+	ctx := context.Background()
+
+	// At r=4 the invariant density 1/(pi*sqrt(x(1-x))) has mean 1/2 and
+	// variance 1/8.
+	mean, variance, err := e.TimeAverage(ctx, 4, 1000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(mean-0.5) > 0.01 || math.Abs(variance-0.125) > 0.01 {
+		t.Errorf("r=4: mean %v, variance %v; want about 1/2 and 1/8", mean, variance)
+	}
+
+	// At r=2.8 the orbit settles on 1-1/r, so the average converges to it.
+	mean, variance, _ = e.TimeAverage(ctx, 2.8, 1000000)
+	if math.Abs(mean-(1-1/2.8)) > 1e-4 || variance > 1e-4 {
+		t.Errorf("r=2.8: mean %v, variance %v; want about %v and 0", mean, variance, 1-1/2.8)
+	}
+}
@@ -0,0 +1,126 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"math"
+
+	"resilientrecursion/internal/models"
+)
+
+// ErrInvalidRange is returned for an empty r range or a non-positive number
+// of steps.
+var ErrInvalidRange = errors.New("r range must be increasing with a positive step count")
+
+// detectPeriod iterates x0 under r for transient steps, then looks for the
+// smallest p <= maxPeriod with |x_{t+p} - x_t| <= tol, confirmed over a
+// second period. It returns 0 when no period up to maxPeriod is found
+// (chaos, or a transient too short to settle) or when the orbit leaves
+// [0, 1]. It never touches the cache.
+func (e *ComputeEngine) detectPeriod(ctx context.Context, r, x0 float64, transient, maxPeriod int, tol float64) (int, error) {
+	x := x0
+	stride := int(e.cancelCheckStride.Load())
+	for i := 0; i < transient; i++ {
+		if (i+1)%stride == 0 {
+			if err := ctx.Err(); err != nil {
+				return 0, err
+			}
+		}
+		x = r * x * (1 - x)
+	}
+	if x < 0 || x > 1 || math.IsNaN(x) {
+		return 0, nil
+	}
+
+	orbit := make([]float64, 2*maxPeriod+1)
+	orbit[0] = x
+	for i := 1; i < len(orbit); i++ {
+		orbit[i] = r * orbit[i-1] * (1 - orbit[i-1])
+	}
+	return periodAt(orbit, maxPeriod, tol), nil
+}
+
+// periodAt returns the smallest p <= maxPeriod with orbit[p] and orbit[2p]
+// both within tol of the previous period's value, or 0 if there is none.
+// orbit holds at least 2*maxPeriod+1 consecutive iterates.
+func periodAt(orbit []float64, maxPeriod int, tol float64) int {
+	for p := 1; p <= maxPeriod; p++ {
+		if math.Abs(orbit[p]-orbit[0]) <= tol && math.Abs(orbit[2*p]-orbit[p]) <= tol {
+			return p
+		}
+	}
+	return 0
+}
+
+// TransientLength iterates x0 under r and returns the first n from which the
+// orbit repeats with some period up to maxPeriod, by the same test as
+// detectPeriod, along with that period. It returns -1 and period 0 if the
+// orbit has not settled by maxN (chaos, or a transient longer than maxN), or
+// if it leaves [0, 1]. It never touches the cache.
+func (e *ComputeEngine) TransientLength(ctx context.Context, r, x0 float64, maxN, maxPeriod int, tol float64) (length, period int, err error) {
+	orbit := make([]float64, 2*maxPeriod+1)
+	orbit[0] = x0
+	for i := 1; i < len(orbit); i++ {
+		orbit[i] = r * orbit[i-1] * (1 - orbit[i-1])
+	}
+
+	last := len(orbit) - 1
+	stride := int(e.cancelCheckStride.Load())
+	for n := 0; n <= maxN; n++ {
+		if (n+1)%stride == 0 {
+			if err := ctx.Err(); err != nil {
+				return 0, 0, err
+			}
+		}
+		if x := orbit[last]; x < 0 || x > 1 || math.IsNaN(x) {
+			return -1, 0, nil
+		}
+		if p := periodAt(orbit, maxPeriod, tol); p > 0 {
+			return n, p, nil
+		}
+
+		next := r * orbit[last] * (1 - orbit[last])
+		copy(orbit, orbit[1:])
+		orbit[last] = next
+	}
+	return -1, 0, nil
+}
+
+// PeriodDoublings scans steps+1 evenly spaced r values over [rMin, rMax],
+// detects the attractor period at each, and returns the points where the
+// period doubles from the last detected one. R in each result is the first
+// scanned r with the doubled period, so a bifurcation lies in
+// (PreviousR, R].
+func (e *ComputeEngine) PeriodDoublings(ctx context.Context, rMin, rMax float64, steps, transient, maxPeriod int, tol float64) ([]models.Bifurcation, error) {
+	if steps <= 0 || !(rMax > rMin) {
+		return nil, ErrInvalidRange
+	}
+
+	bifurcations := []models.Bifurcation{}
+	lastR, lastPeriod := 0.0, 0
+	for i := 0; i <= steps; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		r := rMin + (rMax-rMin)*float64(i)/float64(steps)
+		period, err := e.detectPeriod(ctx, r, e.x0, transient, maxPeriod, tol)
+		if err != nil {
+			return nil, err
+		}
+		if period == 0 {
+			continue
+		}
+
+		if lastPeriod > 0 && period == 2*lastPeriod {
+			bifurcations = append(bifurcations, models.Bifurcation{
+				R:         r,
+				PreviousR: lastR,
+				Period:    period,
+			})
+		}
+		lastR, lastPeriod = r, period
+	}
+
+	return bifurcations, nil
+}
@@ -0,0 +1,101 @@
+package engine
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"resilientrecursion/pkg/config"
+)
+
+func TestDetectPeriod(t *testing.T) {
+	e, _ := newTestEngine(t)
+	tests := []struct {
+		r    float64
+		want int
+	}{
+		{2.8, 1},
+		{3.2, 2},
+		{3.5, 4},
+		{3.56, 8},
+		{3.9, 0}, // chaotic
+	}
+	for _, tt := range tests {
+		got, err := e.detectPeriod(context.Background(), tt.r, config.DefaultX0, 10000, 64, 1e-9)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("detectPeriod(%v) = %d, want %d", tt.r, got, tt.want)
+		}
+	}
+}
+
+func TestPeriodDoublingsFindsFeigenbaumCascade(t *testing.T) {
+	e, _ := newTestEngine(t)
+
+	got, err := e.PeriodDoublings(context.Background(), 2.9, 3.56, 660, 10000, 64, 1e-6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first three period doublings of the logistic map.
+	want := []struct {
+		r      float64
+		period int
+	}{
+		{3, 2},
+		{1 + math.Sqrt(6), 4},
+		{3.5441, 8},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d bifurcations %+v, want %d", len(got), got, len(want))
+	}
+	for i, w := range want {
+		if got[i].Period != w.period || math.Abs(got[i].R-w.r) > 0.01 {
+			t.Errorf("bifurcation %d = %+v, want period %d near r=%v", i, got[i], w.period, w.r)
+		}
+	}
+}
+
+func TestPeriodDoublingsCancelled(t *testing.T) {
+	e, _ := newTestEngine(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := e.PeriodDoublings(ctx, 2.9, 3.6, 100, 10000, 64, 1e-9); err != context.Canceled {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+}
+
+func TestTransientLength(t *testing.T) {
+	e, _ := newTestEngine(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		r, x0          float64
+		length, period int
+	}{
+		{2, 0.5, 0, 1},    // x0 is already the fixed point 1-1/r
+		{4, 0.5, 2, 1},    // 0.5 -> 1 -> 0, then absorbed
+		{3.9, 0.5, -1, 0}, // chaotic: never settles
+	}
+	for _, tt := range tests {
+		length, period, err := e.TransientLength(ctx, tt.r, tt.x0, 5000, 64, 1e-9)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if length != tt.length || period != tt.period {
+			t.Errorf("TransientLength(%v, %v) = %d, %d, want %d, %d", tt.r, tt.x0, length, period, tt.length, tt.period)
+		}
+	}
+
+	// Away from the cycle it takes a while to settle onto it.
+	length, period, err := e.TransientLength(ctx, 3.2, 0.3, 5000, 64, 1e-9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if length <= 0 || period != 2 {
+		t.Errorf("TransientLength(3.2, 0.3) = %d, %d, want a positive length and period 2", length, period)
+	}
+}
@@ -0,0 +1,16 @@
+package engine
+
+// Float64Epsilon is the default comparison tolerance for float64 results. x
+// is bounded by 1, so it leaves about seven orders of magnitude above the
+// ~1e-16 rounding of a single step.
+const Float64Epsilon = 1e-9
+
+// Epsilon is the tolerance diagnostic endpoints use to decide whether two
+// results agree: COMPARE_EPSILON when set, otherwise the default for the
+// precision the engine computes in, which is always float64.
+func (e *ComputeEngine) Epsilon() float64 {
+	if e.compareEpsilon > 0 {
+		return e.compareEpsilon
+	}
+	return Float64Epsilon
+}
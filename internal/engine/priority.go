@@ -0,0 +1,23 @@
+package engine
+
+import (
+	"context"
+
+	"resilientrecursion/internal/worker"
+)
+
+type priorityCtxKey struct{}
+
+// WithPriority returns a context whose work on the worker pool is queued at
+// priority.
+func WithPriority(ctx context.Context, priority worker.Priority) context.Context {
+	return context.WithValue(ctx, priorityCtxKey{}, priority)
+}
+
+// priorityFrom returns the priority carried by ctx, or worker.Low.
+func priorityFrom(ctx context.Context) worker.Priority {
+	if priority, ok := ctx.Value(priorityCtxKey{}).(worker.Priority); ok {
+		return priority
+	}
+	return worker.Low
+}
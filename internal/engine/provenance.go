@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+
+	"resilientrecursion/internal/models"
+)
+
+// Version identifies the arithmetic of computes. Bump it with any change
+// that can alter a bit of some result, so that a result recorded with its
+// provenance can be told apart from one this build would return.
+const Version = "1"
+
+// Provenance returns what a logistic compute at r, with perturbation c and
+// clamping as asked or as the engine enforces, depends on: enough to repeat
+// it bit for bit without the engine's defaults. r and c are given as the hex
+// of their IEEE-754 bits. Each step is evaluated in float64, rounding to
+// nearest after every operation, in the order Arithmetic shows. The
+// architecture is recorded because Go may fuse a multiply and an add into
+// one FMA on some of them, which rounds once instead of twice.
+func (e *ComputeEngine) Provenance(r, c float64, clamp bool) *models.Provenance {
+	step := "(r*x)*(1-x)"
+	if c != 0 {
+		step += "+c"
+	}
+	if clamp || e.clampComputes {
+		step = fmt.Sprintf("min(max(%s, 0), %v)", step, clampHi)
+	}
+	p := &models.Provenance{
+		Map:           MapLogistic,
+		RBits:         floatBits(r),
+		X0:            e.x0,
+		Precision:     "float64",
+		Arithmetic:    step,
+		Arch:          runtime.GOARCH,
+		EngineVersion: Version,
+	}
+	if c != 0 {
+		p.CBits = floatBits(c)
+	}
+	return p
+}
+
+func floatBits(v float64) string {
+	return fmt.Sprintf("%016x", math.Float64bits(v))
+}
@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"resilientrecursion/internal/logging"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// peerBacklog bounds the announcements received but not yet applied. Once it
+// is full, further ones are dropped until the subscriber catches up.
+const peerBacklog = 1024
+
+// peerCheckpoint is one checkpoint announced on the checkpoint channel.
+type peerCheckpoint struct {
+	pod    string
+	tenant string
+	rHash  uint64
+	n      int64
+	x      float64
+}
+
+// announceCheckpoint queues, on pipe, the announcement of a checkpoint of the
+// tenant carried by ctx. x travels as its exact bits.
+func (e *ComputeEngine) announceCheckpoint(ctx context.Context, pipe redis.Pipeliner, rHash uint64, n int64, x float64) {
+	if e.checkpointChannel == "" {
+		return
+	}
+	pipe.Publish(ctx, e.checkpointChannel,
+		fmt.Sprintf("%s %s %d %d %x", e.podID, TenantFrom(ctx), rHash, n, math.Float64bits(x)))
+}
+
+func parsePeerCheckpoint(payload string) (peerCheckpoint, error) {
+	var p peerCheckpoint
+	var bits uint64
+	if _, err := fmt.Sscanf(payload, "%s %s %d %d %x", &p.pod, &p.tenant, &p.rHash, &p.n, &bits); err != nil {
+		return p, err
+	}
+	p.x = math.Float64frombits(bits)
+	return p, nil
+}
+
+// SubscribeCheckpoints fills L1 from the checkpoints peer pods announce,
+// until ctx is done. It does nothing when no checkpoint channel is
+// configured.
+//
+// Delivery is best effort. Redis pub/sub keeps nothing for a pod that is
+// not subscribed, and announcements arriving faster than they are applied
+// are dropped. An announcement is applied only when this pod already caches
+// the series or owns its r, so peers' traffic never evicts series of its
+// own.
+func (e *ComputeEngine) SubscribeCheckpoints(ctx context.Context) {
+	if e.checkpointChannel == "" {
+		return
+	}
+
+	ps := e.redisClient.Subscribe(ctx, e.checkpointChannel)
+	defer ps.Close()
+
+	backlog := make(chan peerCheckpoint, peerBacklog)
+	defer close(backlog)
+	go func() {
+		for p := range backlog {
+			e.applyPeerCheckpoint(p)
+		}
+	}()
+
+	messages := ps.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			p, err := parsePeerCheckpoint(msg.Payload)
+			if err != nil {
+				logging.Warnf("Ignoring malformed checkpoint announcement %q: %v", msg.Payload, err)
+				continue
+			}
+			if p.pod == e.podID {
+				continue
+			}
+			select {
+			case backlog <- p:
+			default:
+				e.recorder.PeerCheckpoint("dropped")
+			}
+		}
+	}
+}
+
+func (e *ComputeEngine) applyPeerCheckpoint(p peerCheckpoint) {
+	l1, ok := e.caches[p.tenant]
+	if !ok || !(l1.Contains(p.rHash) || e.isLocalR(p.rHash)) {
+		e.recorder.PeerCheckpoint("skipped")
+		return
+	}
+	l1.Set(p.rHash, p.n, p.x)
+	e.recorder.PeerCheckpoint("applied")
+}
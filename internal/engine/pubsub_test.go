@@ -0,0 +1,74 @@
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"resilientrecursion/pkg/config"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestPeerCheckpointsFillL1(t *testing.T) {
+	mr := miniredis.RunT(t)
+	newEngine := func(podID string) *ComputeEngine {
+		cfg := config.Default()
+		cfg.RedisAddr = mr.Addr()
+		cfg.TotalPods = 2
+		cfg.PodID = podID
+		cfg.CheckpointChannel = "checkpoints"
+		e := NewComputeEngine(cfg)
+		t.Cleanup(e.Close)
+		return e
+	}
+	writer, peer := newEngine("pod-0"), newEngine("pod-1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go peer.SubscribeCheckpoints(ctx)
+	for deadline := time.Now().Add(5 * time.Second); mr.PubSubNumSub("checkpoints")["checkpoints"] == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("peer never subscribed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The peer applies checkpoints of an r it owns, and ignores those of an
+	// r it neither owns nor caches.
+	var owned, foreign float64
+	for r := 3.6; owned == 0 || foreign == 0; r += 0.01 {
+		if GetPodForR(HashFloat64(r), 2) == 1 {
+			owned = r
+		} else {
+			foreign = r
+		}
+	}
+	if _, err := writer.Compute(ctx, foreign, 2000); err != nil {
+		t.Fatal(err)
+	}
+	want, err := writer.Compute(ctx, owned, 2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rHash := HashFloat64(owned)
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if got, ok := peer.l1Cache.Get(rHash, 2000); ok {
+			if got != want {
+				t.Fatalf("peer L1 at n=2000 = %v, want %v", got, want)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("peer L1 never received the checkpoint")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := peer.l1Cache.Get(rHash, 1000); !ok {
+		t.Error("peer L1 missing the n=1000 checkpoint")
+	}
+	if peer.l1Cache.Contains(HashFloat64(foreign)) {
+		t.Error("peer cached a checkpoint of an r it neither owns nor caches")
+	}
+}
@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// purgeScanCount is the SCAN page size for PurgeCheckpoints; each page is
+// checked and deleted before the next is fetched, so Redis is never blocked
+// for long.
+const purgeScanCount = 100
+
+// PurgeCheckpoints deletes the checkpoint keys of the tenant carried by ctx
+// and returns how many it removed. With olderThan == 0 every one goes.
+// Otherwise only keys last written more than olderThan ago are removed. A
+// key's age is derived from its remaining TTL, since every write refreshes
+// the TTL to the checkpoint TTL. Keys with no expiry count as older than any
+// cutoff.
+func (e *ComputeEngine) PurgeCheckpoints(ctx context.Context, olderThan time.Duration) (int, error) {
+	ctx = withBulk(ctx)
+	ttl := time.Duration(e.checkpointTTL.Load())
+	pattern := tenantPrefix(TenantFrom(ctx)) + "cp:*"
+	deleted := 0
+	var cursor uint64
+
+	for {
+		keys, next, err := e.redisClient.Scan(ctx, cursor, pattern, purgeScanCount).Result()
+		if err != nil {
+			return deleted, err
+		}
+
+		if olderThan > 0 && len(keys) > 0 {
+			keys, err = e.keysOlderThan(ctx, keys, ttl-olderThan)
+			if err != nil {
+				return deleted, err
+			}
+		}
+		if len(keys) > 0 {
+			n, err := e.redisClient.Unlink(ctx, keys...).Result()
+			if err != nil {
+				return deleted, err
+			}
+			deleted += int(n)
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return deleted, nil
+		}
+	}
+}
+
+// keysOlderThan keeps the keys whose remaining TTL is below remaining, or
+// that have no expiry.
+func (e *ComputeEngine) keysOlderThan(ctx context.Context, keys []string, remaining time.Duration) ([]string, error) {
+	pipe := e.redisClient.Pipeline()
+	ttls := make([]*redis.DurationCmd, len(keys))
+	for i, key := range keys {
+		ttls[i] = pipe.TTL(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	old := keys[:0]
+	for i, key := range keys {
+		left, err := ttls[i].Result()
+		if err != nil {
+			continue
+		}
+		// go-redis reports "no expiry" as -1 and a missing key as -2.
+		if left == -1 || (left >= 0 && left < remaining) {
+			old = append(old, key)
+		}
+	}
+	return old, nil
+}
@@ -0,0 +1,194 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"resilientrecursion/internal/metrics"
+	"resilientrecursion/pkg/config"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeRecorder records the engine's instrumentation calls by name, and the
+// iterations of each compute apart from them.
+type fakeRecorder struct {
+	metrics.Nop
+	mu         sync.Mutex
+	calls      []string
+	iterations []int64
+}
+
+func (f *fakeRecorder) record(call string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, call)
+}
+
+func (f *fakeRecorder) NonLocalCompute()              { f.record("nonlocal") }
+func (f *fakeRecorder) PeerCheckpoint(outcome string) { f.record("peer " + outcome) }
+func (f *fakeRecorder) CheckpointSample(keys int, avg, max float64) {
+	f.record(fmt.Sprintf("sample %d %v %v", keys, avg, max))
+}
+
+func (f *fakeRecorder) Compute(d time.Duration, iterations int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.iterations = append(f.iterations, iterations)
+}
+
+func (f *fakeRecorder) take() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := f.calls
+	f.calls = nil
+	return calls
+}
+
+func TestEngineRecordsThroughRecorder(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.CheckpointSampleKeys = 10
+	e := NewComputeEngine(cfg)
+	t.Cleanup(e.Close)
+	fake := &fakeRecorder{}
+	e.recorder = fake
+	ctx := context.Background()
+
+	var owned, foreign float64
+	for r := 3.5; owned == 0 || foreign == 0; r += 0.001 {
+		if e.isLocalR(HashFloat64(r)) {
+			owned = r
+		} else {
+			foreign = r
+		}
+	}
+
+	e.Compute(ctx, owned, 10)
+	if calls := fake.take(); len(calls) != 0 {
+		t.Errorf("owned compute recorded %v", calls)
+	}
+	e.Compute(ctx, foreign, 10)
+	if calls := fake.take(); len(calls) != 1 || calls[0] != "nonlocal" {
+		t.Errorf("non-local compute recorded %v, want [nonlocal]", calls)
+	}
+
+	e.applyPeerCheckpoint(peerCheckpoint{tenant: config.DefaultTenant, rHash: HashFloat64(owned), n: 1000, x: 0.5})
+	e.applyPeerCheckpoint(peerCheckpoint{tenant: "unknown", rHash: HashFloat64(owned), n: 1000, x: 0.5})
+	if calls := fake.take(); fmt.Sprint(calls) != "[peer applied peer skipped]" {
+		t.Errorf("peer checkpoints recorded %v", calls)
+	}
+
+	mr.ZAdd("cp:1", 1000, "0.5")
+	mr.ZAdd("cp:1", 2000, "0.25")
+	mr.ZAdd("cp:2", 1000, "0.5")
+	e.sampleCheckpointsOnce(ctx)
+	if calls := fake.take(); fmt.Sprint(calls) != "[sample 2 1.5 2]" {
+		t.Errorf("checkpoint sample recorded %v, want [sample 2 1.5 2]", calls)
+	}
+}
+
+func TestComputeRecordsIterations(t *testing.T) {
+	e, _ := newTestEngine(t)
+	fake := &fakeRecorder{}
+	e.recorder = fake
+	ctx := context.Background()
+
+	e.Compute(ctx, 3.7, 1500)
+	e.Compute(ctx, 3.7, 1500)
+	e.Compute(ctx, 3.7, 2000)
+	if got := fmt.Sprint(fake.iterations); got != "[1500 0 500]" {
+		t.Errorf("computes recorded %s iterations, want [1500 0 500]", got)
+	}
+}
+
+func TestSampleCheckpointsBoundsEachRound(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.CheckpointSampleKeys = 3
+	e := NewComputeEngine(cfg)
+	t.Cleanup(e.Close)
+	fake := &fakeRecorder{}
+	e.recorder = fake
+
+	for i := 0; i < 10; i++ {
+		mr.ZAdd(fmt.Sprintf("cp:%d", i), 1000, "0.5")
+	}
+	mr.ZAdd("t:team-a:cp:1", 1000, "0.5")
+	mr.ZAdd("t:team-a:cp:1", 2000, "0.25")
+	mr.Set("series:1", "blob")
+
+	e.sampleCheckpointsOnce(context.Background())
+	calls := fake.take()
+	if len(calls) != 1 || !strings.HasPrefix(calls[0], "sample 3 ") {
+		t.Errorf("sample recorded %v, want 3 keys", calls)
+	}
+
+	// Sampling is off with no keys or no interval, and then returns at once.
+	for _, off := range []struct {
+		interval time.Duration
+		keys     int
+	}{{0, 3}, {time.Millisecond, 0}} {
+		e.sampleInterval, e.sampleKeys = off.interval, off.keys
+		done := make(chan struct{})
+		go func() {
+			e.SampleCheckpoints(context.Background())
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("SampleCheckpoints with interval %v, %d keys did not return", off.interval, off.keys)
+		}
+	}
+	if calls := fake.take(); len(calls) != 0 {
+		t.Errorf("disabled sampling recorded %v", calls)
+	}
+}
+
+func TestNonLocalWarningIsSampled(t *testing.T) {
+	for _, tc := range []struct {
+		every, computes, logged int
+	}{
+		{3, 7, 3}, // the 1st, 4th and 7th
+		{1, 4, 4},
+		{0, 4, 0},
+	} {
+		mr := miniredis.RunT(t)
+		cfg := config.Default()
+		cfg.RedisAddr = mr.Addr()
+		cfg.TotalPods = 2
+		cfg.NonLocalLogEvery = tc.every
+		e := NewComputeEngine(cfg)
+		t.Cleanup(e.Close)
+
+		foreign := 3.5
+		for e.isLocalR(HashFloat64(foreign)) {
+			foreign += 0.001
+		}
+
+		var logs strings.Builder
+		log.SetOutput(&logs)
+		// Each n is new, so none is an L1 hit that skips the ownership check.
+		for i := 0; i < tc.computes; i++ {
+			e.Compute(context.Background(), foreign, int64(10+i))
+		}
+		log.SetOutput(os.Stderr)
+
+		if got := strings.Count(logs.String(), "non-local r="); got != tc.logged {
+			t.Errorf("NONLOCAL_LOG_EVERY=%d: %d of %d non-local computes logged, want %d", tc.every, got, tc.computes, tc.logged)
+		}
+		if got := testutil.ToFloat64(e.metrics.NonLocalComputes); got != float64(tc.computes) {
+			t.Errorf("NONLOCAL_LOG_EVERY=%d: counter at %v, want %d", tc.every, got, tc.computes)
+		}
+	}
+}
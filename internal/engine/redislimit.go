@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type bulkKey struct{}
+
+// withBulk marks ctx as carrying bulk work: a flush, a preheat, a purge or a
+// batch. Redis commands and pipelines sent with it count against
+// REDIS_MAX_CONCURRENT.
+func withBulk(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bulkKey{}, true)
+}
+
+func isBulk(ctx context.Context) bool {
+	bulk, _ := ctx.Value(bulkKey{}).(bool)
+	return bulk
+}
+
+// redisLimit is a go-redis hook that lets at most cap(l) commands or
+// pipelines of bulk work be in flight at once, across both connections, so
+// bulk work cannot saturate Redis. A pipeline counts once, however many
+// commands it holds. Other work, such as a single compute, is never held up
+// by it.
+type redisLimit chan struct{}
+
+func newRedisLimit(max int) redisLimit {
+	if max <= 0 {
+		return nil
+	}
+	return make(redisLimit, max)
+}
+
+// acquire waits for a slot, or for ctx to be done.
+func (l redisLimit) acquire(ctx context.Context) error {
+	select {
+	case l <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l redisLimit) release() { <-l }
+
+// holding marks ctx as holding a slot. Commands go-redis sends under ctx
+// while preparing a connection, such as HELLO, pass through the hooks again
+// and must not wait for a second slot.
+func holding(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bulkKey{}, false)
+}
+
+func (l redisLimit) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (l redisLimit) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if !isBulk(ctx) {
+			return next(ctx, cmd)
+		}
+		if err := l.acquire(ctx); err != nil {
+			cmd.SetErr(err)
+			return err
+		}
+		defer l.release()
+		return next(holding(ctx), cmd)
+	}
+}
+
+func (l redisLimit) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if !isBulk(ctx) {
+			return next(ctx, cmds)
+		}
+		if err := l.acquire(ctx); err != nil {
+			for _, cmd := range cmds {
+				cmd.SetErr(err)
+			}
+			return err
+		}
+		defer l.release()
+		return next(holding(ctx), cmds)
+	}
+}
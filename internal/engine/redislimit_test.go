@@ -0,0 +1,110 @@
+package engine
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"resilientrecursion/internal/models"
+	"resilientrecursion/pkg/config"
+)
+
+// inFlightHook tracks how many commands and pipelines are past the hooks
+// added before it, holding each for a moment so they overlap. Commands sent
+// while one of them is being processed, such as the HELLO of a new
+// connection, are part of it.
+type inFlightHook struct {
+	current, max atomic.Int64
+}
+
+type inFlightKey struct{}
+
+func (h *inFlightHook) enter() {
+	n := h.current.Add(1)
+	for {
+		m := h.max.Load()
+		if n <= m || h.max.CompareAndSwap(m, n) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+}
+
+func (h *inFlightHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h *inFlightHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if ctx.Value(inFlightKey{}) != nil {
+			return next(ctx, cmd)
+		}
+		h.enter()
+		defer h.current.Add(-1)
+		return next(context.WithValue(ctx, inFlightKey{}, true), cmd)
+	}
+}
+
+func (h *inFlightHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if ctx.Value(inFlightKey{}) != nil {
+			return next(ctx, cmds)
+		}
+		h.enter()
+		defer h.current.Add(-1)
+		return next(context.WithValue(ctx, inFlightKey{}, true), cmds)
+	}
+}
+
+func TestRedisMaxConcurrentBoundsBulkWork(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	cfg.RedisMaxConcurrent = 2
+	e := NewComputeEngine(cfg)
+	t.Cleanup(e.Close)
+
+	hook := &inFlightHook{}
+	e.redisClient.AddHook(hook)
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := 3.5 + float64(i)/100
+			e.ComputeBatch(ctx, []models.Request{{R: r, N: 100}, {R: r, N: 200}})
+			if _, err := e.Flush(ctx); err != nil {
+				t.Error(err)
+			}
+			if _, err := e.PurgeCheckpoints(ctx, 0); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := hook.max.Load(); got == 0 || got > 2 {
+		t.Errorf("at most %d bulk Redis operations in flight, want 1 or 2", got)
+	}
+
+	// Work that is not bulk is not held to the bound.
+	hook.max.Store(0)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.redisClient.Get(ctx, "missing")
+		}()
+	}
+	wg.Wait()
+	if got := hook.max.Load(); got <= 2 {
+		t.Errorf("at most %d other Redis operations in flight, want more than 2", got)
+	}
+}
@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// maxReplayWindow caps how many iterations one replay may recompute.
+const maxReplayWindow = 1000000
+
+var (
+	// ErrCheckpointNotFound is returned by Replay when no checkpoint is
+	// stored at exactly the requested n.
+	ErrCheckpointNotFound = errors.New("checkpoint not found")
+
+	// ErrReplayWindow is returned when the gap to the previous checkpoint
+	// exceeds maxReplayWindow.
+	ErrReplayWindow = errors.New("replay window too large")
+)
+
+// Replay re-derives the checkpoint stored at n for (r, c) by iterating from
+// the previous checkpoint, or from the seed when there is none, and returns
+// where it started along with the stored and recomputed values. It only
+// reads from Redis and never touches L1, so it cannot mask or spread a bad
+// value.
+func (e *ComputeEngine) Replay(ctx context.Context, r, c float64, n int64) (fromN int64, stored, recomputed float64, err error) {
+	key := checkpointKey(TenantFrom(ctx), e.seriesHash(r, c))
+
+	stored, found, err := e.checkpointAt(ctx, key, n)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if !found {
+		return 0, 0, 0, ErrCheckpointNotFound
+	}
+
+	// The score bound is inclusive because, above maxExactScore, an earlier
+	// checkpoint can share n's score; n itself is skipped by its member.
+	prev, err := e.readClient.ZRevRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{
+		Min:   "0",
+		Max:   strconv.FormatInt(n, 10),
+		Count: checkpointCandidates,
+	}).Result()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	x := e.x0
+	for _, z := range prev {
+		at, px, err := parseCheckpointZ(z)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if at < n {
+			fromN, x = at, px
+			break
+		}
+	}
+	if n-fromN > maxReplayWindow {
+		return 0, 0, 0, fmt.Errorf("%w: %d iterations from n=%d", ErrReplayWindow, n-fromN, fromN)
+	}
+
+	stride := e.cancelCheckStride.Load()
+	for i := fromN; i < n; i++ {
+		if (i+1)%stride == 0 {
+			if err := ctx.Err(); err != nil {
+				return 0, 0, 0, err
+			}
+		}
+		x = r*x*(1-x) + c
+	}
+
+	return fromN, stored, x, nil
+}
@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"context"
+	"sync"
+
+	"resilientrecursion/internal/cache"
+	"resilientrecursion/internal/logging"
+)
+
+// refreshKey identifies a series being refreshed in the background.
+type refreshKey struct {
+	tenant string
+	rHash  uint64
+}
+
+// refreshes tracks the background refreshes in progress, so a stale series
+// read many times is recomputed once.
+type refreshes struct {
+	mu      sync.Mutex
+	running map[refreshKey]bool
+}
+
+// start reports whether key was not already being refreshed, and marks it.
+func (rs *refreshes) start(key refreshKey) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.running[key] {
+		return false
+	}
+	if rs.running == nil {
+		rs.running = make(map[refreshKey]bool)
+	}
+	rs.running[key] = true
+	return true
+}
+
+func (rs *refreshes) done(key refreshKey) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	delete(rs.running, key)
+}
+
+// revalidate answers a compute whose series l1 holds from an earlier cache
+// generation. With stale-while-revalidate a cached x_n is returned at once
+// and the series is refreshed in the background, on the engine's job context
+// so shutdown stops it. Otherwise, or if x_n is not cached, the series is
+// refreshed first.
+func (e *ComputeEngine) revalidate(ctx context.Context, l1 *cache.L1Cache, rHash uint64, r float64, n int64, opts computeOpts) (float64, int64, error) {
+	val, ok := l1.Get(rHash, n)
+	if !ok || !e.staleWhileRevalidate {
+		return e.refresh(ctx, l1, rHash, r, n, opts)
+	}
+
+	key := refreshKey{TenantFrom(ctx), rHash}
+	if e.refreshes.start(key) {
+		bg := WithTenant(e.jobCtx, key.tenant)
+		// Callbacks and stats belong to the request, which has its answer.
+		bgOpts := computeOpts{c: opts.c, kind: opts.kind, step: opts.step, clamp: opts.clamp}
+		go func() {
+			defer e.refreshes.done(key)
+			if _, _, err := e.refresh(bg, l1, rHash, r, n, bgOpts); err != nil {
+				logging.Warnf("Refreshing stale r=%v up to n=%d: %v", r, n, err)
+			}
+		}()
+	}
+	if opts.stats != nil {
+		opts.stats.CacheHits++
+	}
+	opts.setResumed(-1)
+	return val, n, nil
+}
+
+// refresh recomputes the series of rHash from x0 up to n, reading neither L1
+// nor Redis, and replaces what l1 holds for it with the result. Entries
+// past n go with the stale series.
+func (e *ComputeEngine) refresh(ctx context.Context, l1 *cache.L1Cache, rHash uint64, r float64, n int64, opts computeOpts) (float64, int64, error) {
+	opts.into = cache.NewL1Cache(1)
+	x, reached, err := e.iterate(ctx, r, n, opts)
+	if err != nil {
+		return x, reached, err
+	}
+	series := make(map[int64]float64)
+	opts.into.ForEach(func(_ uint64, n int64, x float64) { series[n] = x })
+	l1.Replace(rHash, series)
+	return x, reached, nil
+}
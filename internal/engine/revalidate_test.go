@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"resilientrecursion/pkg/config"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// newGenerationEngine returns an engine with x_1000 of r cached under its
+// first generation as marker, standing in for a value computed before the
+// math changed, and a config that bumps the generation when reloaded.
+func newGenerationEngine(t *testing.T, swr bool, r, marker float64) (*ComputeEngine, *config.Config) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	cfg.StaleWhileRevalidate = swr
+	e := NewComputeEngine(cfg)
+	t.Cleanup(e.Close)
+
+	if _, err := e.Compute(context.Background(), r, 1000); err != nil {
+		t.Fatal(err)
+	}
+	e.l1Cache.Set(HashFloat64(r), 1000, marker)
+
+	next := *cfg
+	next.CacheGeneration++
+	return e, &next
+}
+
+func TestStaleWhileRevalidateServesStaleThenRefreshes(t *testing.T) {
+	const r, marker = 3.7, 0.125
+	e, next := newGenerationEngine(t, true, r, marker)
+	ctx := context.Background()
+
+	if got, _ := e.Compute(ctx, r, 1000); got != marker {
+		t.Fatalf("before the reload got %v, want the cached %v", got, marker)
+	}
+	e.ApplyReload(next)
+
+	if got, err := e.Compute(ctx, r, 1000); err != nil || got != marker {
+		t.Fatalf("stale read = %v, %v; want %v at once", got, err, marker)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for e.l1Cache.Stale(HashFloat64(r)) {
+		if time.Now().After(deadline) {
+			t.Fatal("series still stale after 5s")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got, _ := e.Compute(ctx, r, 1000); got != directIterate(r, 1000) {
+		t.Errorf("after the refresh got %v, want %v", got, directIterate(r, 1000))
+	}
+}
+
+func TestStaleSeriesRecomputedBeforeAnswering(t *testing.T) {
+	const r, marker = 3.7, 0.125
+	e, next := newGenerationEngine(t, false, r, marker)
+	e.ApplyReload(next)
+
+	// The stale x_1000 is neither served nor resumed from.
+	if got, err := e.Compute(context.Background(), r, 1500); err != nil || got != directIterate(r, 1500) {
+		t.Errorf("Compute(1500) = %v, %v; want %v", got, err, directIterate(r, 1500))
+	}
+	if e.l1Cache.Stale(HashFloat64(r)) {
+		t.Error("series still stale after recomputing")
+	}
+}
+
+func TestGenerationBumpHidesStaleEntriesFromOtherReaders(t *testing.T) {
+	const r, marker = 3.7, 0.125
+	e, next := newGenerationEngine(t, false, r, marker)
+	ctx := context.Background()
+	e.ApplyReload(next)
+
+	if got, ok := e.Peek(ctx, r, 1000); !ok || got != directIterate(r, 1000) {
+		t.Errorf("Peek = %v, %v, want the checkpoint %v over the stale entry", got, ok, directIterate(r, 1000))
+	}
+	points, err := e.Trajectory(ctx, r, 1000, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := points[len(points)-1]; last.X != directIterate(r, 1000) {
+		t.Errorf("trajectory x_1000 = %v, want %v recomputed", last.X, directIterate(r, 1000))
+	}
+	if !e.l1Cache.Stale(HashFloat64(r)) {
+		t.Error("trajectory extended the stale series")
+	}
+	if n, err := e.Flush(ctx); err != nil || n != 0 {
+		t.Errorf("Flush = %d, %v; want the stale series left out", n, err)
+	}
+}
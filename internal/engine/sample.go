@@ -0,0 +1,112 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+
+	"resilientrecursion/internal/models"
+)
+
+// samplePeriod is the longest attractor period SampleAttractors reports.
+const samplePeriod = 64
+
+// SampleRs draws count r values uniformly from [a, b). A seeded math/rand
+// source yields the same sequence on every pod and Go release, so a seed
+// reproduces a run anywhere.
+func SampleRs(a, b float64, count int, seed int64) []float64 {
+	rng := rand.New(rand.NewSource(seed))
+	rs := make([]float64, count)
+	for i := range rs {
+		rs[i] = a + (b-a)*rng.Float64()
+	}
+	return rs
+}
+
+// SampleAttractors summarizes the attractor at each r drawn by SampleRs: it
+// iterates x0 for transient steps, then gathers statistics over the next n
+// and detects the period from where they end, as PeriodDoublings does. The r
+// values are computed concurrently on the worker pool at the priority carried
+// by ctx, falling back to the calling goroutine when the queue is full. The
+// cache is never touched.
+func (e *ComputeEngine) SampleAttractors(ctx context.Context, a, b float64, count int, seed int64, n, transient int) ([]models.SampleStats, error) {
+	if !(b > a) || count <= 0 {
+		return nil, ErrInvalidRange
+	}
+
+	rs := SampleRs(a, b, count, seed)
+	stats := make([]models.SampleStats, len(rs))
+	errs := make([]error, len(rs))
+	var wg sync.WaitGroup
+
+	for i, r := range rs {
+		i, r := i, r
+		task := func() {
+			defer wg.Done()
+			stats[i], errs[i] = e.attractorStats(ctx, r, n, transient)
+		}
+
+		wg.Add(1)
+		if err := e.pool.Submit(priorityFrom(ctx), task); err != nil {
+			task()
+		}
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for i, err := range errs {
+		if err != nil {
+			stats[i].Error = err.Error()
+		}
+	}
+	return stats, nil
+}
+
+func (e *ComputeEngine) attractorStats(ctx context.Context, r float64, n, transient int) (models.SampleStats, error) {
+	s := models.SampleStats{R: r}
+	x := e.x0
+	stride := int(e.cancelCheckStride.Load())
+	for i := 0; i < transient; i++ {
+		if (i+1)%stride == 0 {
+			if err := ctx.Err(); err != nil {
+				return s, err
+			}
+		}
+		x = r * x * (1 - x)
+	}
+
+	var w welford
+	s.Min, s.Max = math.Inf(1), math.Inf(-1)
+	for i := 1; i <= n; i++ {
+		if i%stride == 0 {
+			if err := ctx.Err(); err != nil {
+				return s, err
+			}
+		}
+		x = r * x * (1 - x)
+		if x < 0 || x > 1 || math.IsNaN(x) {
+			return models.SampleStats{R: r}, fmt.Errorf("r=%v: %w at n=%d", r, ErrDiverged, transient+i)
+		}
+		w.add(x)
+		s.Min, s.Max = math.Min(s.Min, x), math.Max(s.Max, x)
+	}
+	s.Mean = w.mean
+	if n == 0 {
+		s.Min, s.Max = x, x
+		s.Mean = x
+	}
+	if n > 1 {
+		s.StdDev = math.Sqrt(w.m2 / float64(n-1))
+	}
+
+	period, err := e.detectPeriod(ctx, r, x, 0, samplePeriod, e.Epsilon())
+	if err != nil {
+		return s, err
+	}
+	s.Period = period
+	return s, nil
+}
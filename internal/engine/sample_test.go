@@ -0,0 +1,51 @@
+package engine
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestSampleAttractorsIsReproducible(t *testing.T) {
+	e, _ := newTestEngine(t)
+	ctx := context.Background()
+
+	first, err := e.SampleAttractors(ctx, 2.9, 3.3, 20, 42, 1000, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := e.SampleAttractors(ctx, 2.9, 3.3, 20, 42, 1000, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(first, again) {
+		t.Error("same seed gave different samples")
+	}
+
+	for _, s := range first {
+		if s.R < 2.9 || s.R >= 3.3 {
+			t.Errorf("r=%v outside [2.9, 3.3)", s.R)
+		}
+		// Below 3 the attractor is a fixed point, above it a 2-cycle.
+		want := 1
+		if s.R > 3.01 {
+			want = 2
+		} else if s.R > 2.99 {
+			continue // slow convergence near the bifurcation
+		}
+		if s.Period != want || s.Error != "" {
+			t.Errorf("r=%v: %+v, want period %d", s.R, s, want)
+		}
+		if s.Min > s.Mean || s.Mean > s.Max {
+			t.Errorf("r=%v: mean %v outside [%v, %v]", s.R, s.Mean, s.Min, s.Max)
+		}
+	}
+
+	other, err := e.SampleAttractors(ctx, 2.9, 3.3, 20, 43, 1000, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other[0].R == first[0].R {
+		t.Error("different seeds drew the same r")
+	}
+}
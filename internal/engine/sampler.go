@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"context"
+	"time"
+
+	"resilientrecursion/internal/logging"
+	"resilientrecursion/internal/metrics"
+)
+
+// SampleCheckpoints periodically estimates checkpoint set sizes by running
+// ZCARD over a handful of checkpoint keys of any tenant and publishing the
+// result as gauges. Each round resumes the SCAN cursor where the previous one
+// stopped, so the whole keyspace is covered over time at a fixed cost per
+// round. It returns when ctx is done, and does nothing if sampling is
+// disabled.
+func (e *ComputeEngine) SampleCheckpoints(ctx context.Context) {
+	if e.sampleInterval <= 0 || e.sampleKeys <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(e.sampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.sampleCheckpointsOnce(ctx)
+		}
+	}
+}
+
+func (e *ComputeEngine) sampleCheckpointsOnce(ctx context.Context) {
+	keys, cursor, err := e.readClient.Scan(ctx, e.sampleCursor, "*cp:*", int64(e.sampleKeys)).Result()
+	if err != nil {
+		logging.Errorf("Checkpoint sample error: %v", err)
+		return
+	}
+	e.sampleCursor = cursor
+
+	if len(keys) > e.sampleKeys {
+		keys = keys[:e.sampleKeys]
+	}
+
+	var total, max int64
+	sampled := 0
+	for _, key := range keys {
+		count, err := e.readClient.ZCard(ctx, key).Result()
+		if err != nil {
+			continue
+		}
+		total += count
+		if count > max {
+			max = count
+		}
+		sampled++
+	}
+
+	var avg float64
+	if sampled > 0 {
+		avg = float64(total) / float64(sampled)
+	}
+	e.recorder.CheckpointSample(sampled, avg, float64(max))
+}
+
+// Metrics exposes the engine's Prometheus collectors, e.g. for the /metrics
+// endpoint.
+func (e *ComputeEngine) Metrics() *metrics.Metrics {
+	return e.metrics
+}
+
+// Recorder is where measurements taken outside the engine, such as per
+// tenant request counts, are recorded.
+func (e *ComputeEngine) Recorder() metrics.Recorder {
+	return e.recorder
+}
@@ -0,0 +1,54 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// Sensitivity returns dx_n/dx_0 for the orbit of x0 under r, the product of
+// the map's derivatives r*(1-2*x_i) for i = 0..n-1. The product over- or
+// underflows float64 within a few hundred chaotic steps, so it is returned as
+// its sign (-1, 0 or 1) and the natural log of its magnitude, summed with
+// KahanSum. A zero factor makes the product exactly zero: sign is then 0 and
+// logAbs -Inf. logAbs/n is the finite-time Lyapunov exponent of the orbit.
+//
+// It returns ErrDiverged if the orbit leaves [0, 1]. It never touches the
+// cache.
+func (e *ComputeEngine) Sensitivity(ctx context.Context, r, x0 float64, n int) (sign int, logAbs float64, err error) {
+	sign = 1
+	var sum KahanSum
+	x := x0
+	stride := int(e.cancelCheckStride.Load())
+	for i := 0; i < n; i++ {
+		if (i+1)%stride == 0 {
+			if err := ctx.Err(); err != nil {
+				return 0, 0, err
+			}
+		}
+		if x < 0 || x > 1 || math.IsNaN(x) {
+			return 0, 0, fmt.Errorf("r=%v: %w at n=%d", r, ErrDiverged, i)
+		}
+
+		d := r * (1 - 2*x)
+		if d == 0 {
+			return 0, math.Inf(-1), nil
+		}
+		if d < 0 {
+			sign = -sign
+		}
+		next := r * x * (1 - x)
+		if next == x {
+			// A fixed point repeats the same factor for every step left.
+			steps := n - i
+			if d < 0 && steps%2 == 0 {
+				sign = -sign
+			}
+			sum.Add(float64(steps) * math.Log(math.Abs(d)))
+			return sign, sum.Sum(), nil
+		}
+		sum.Add(math.Log(math.Abs(d)))
+		x = next
+	}
+	return sign, sum.Sum(), nil
+}
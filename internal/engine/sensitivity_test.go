@@ -0,0 +1,61 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestSensitivityMatchesDirectProduct(t *testing.T) {
+	e, _ := newTestEngine(t)
+
+	for _, r := range []float64{2.5, 3.2, 3.7} {
+		want, x := 1.0, 0.3
+		for i := 0; i < 50; i++ {
+			want *= r * (1 - 2*x)
+			x = r * x * (1 - x)
+		}
+
+		sign, logAbs, err := e.Sensitivity(context.Background(), r, 0.3, 50)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := float64(sign) * math.Exp(logAbs); math.Abs(got-want) > 1e-9*math.Abs(want) {
+			t.Errorf("r=%v: derivative = %v, want %v", r, got, want)
+		}
+	}
+}
+
+func TestSensitivityInLogSpace(t *testing.T) {
+	e, _ := newTestEngine(t)
+	ctx := context.Background()
+
+	// At r=2.5 the orbit settles on x=0.6, where each factor is -0.5, so
+	// one more step flips the sign.
+	sign, logAbs, err := e.Sensitivity(ctx, 2.5, 0.3, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, _, _ := e.Sensitivity(ctx, 2.5, 0.3, 10001)
+	if rate := logAbs / 10000; next != -sign || math.Abs(rate-math.Log(0.5)) > 1e-3 {
+		t.Errorf("r=2.5: signs %d then %d, log_abs/n %v, want opposite signs and %v", sign, next, rate, math.Log(0.5))
+	}
+
+	// At r=4 the Lyapunov exponent is ln 2, so the product overflows float64
+	// long before n.
+	_, logAbs, err = e.Sensitivity(ctx, 4, 0.3, 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate := logAbs / 5000; math.Abs(rate-math.Ln2) > 0.05 {
+		t.Errorf("r=4: log_abs/n = %v, want about ln 2", rate)
+	}
+
+	if sign, logAbs, _ := e.Sensitivity(ctx, 3.7, 0.5, 10); sign != 0 || !math.IsInf(logAbs, -1) {
+		t.Errorf("x0=0.5: sign %d, log_abs %v, want 0 and -Inf", sign, logAbs)
+	}
+	if _, _, err := e.Sensitivity(ctx, 4.5, 0.3, 100); !errors.Is(err, ErrDiverged) {
+		t.Errorf("r=4.5: err = %v, want ErrDiverged", err)
+	}
+}
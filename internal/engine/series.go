@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"resilientrecursion/pkg/config"
+)
+
+// seriesBlobVersion identifies the layout written by encodeSeries. Bump it
+// when the layout changes and keep decoding older versions.
+//
+// Version 1 layout: one version byte, then one record per cached n in
+// ascending order, each a uvarint delta from the previous n followed by the
+// 8 little-endian bytes of the float64. A contiguous series costs 9 bytes per
+// entry, so a fully cached r with 100k iterations is roughly 900KB in Redis.
+const seriesBlobVersion = 1
+
+// seriesBlobGzip is the first byte of a gzip-compressed series blob. It is
+// also the first byte of gzip's own magic number, so the whole blob is a
+// plain gzip stream wrapping a versioned blob, and decodeSeries tells the two
+// apart by this byte alone.
+const seriesBlobGzip = 0x1f
+
+var errSeriesBlob = errors.New("malformed series blob")
+
+func seriesKey(tenant string, rHash uint64) string {
+	return fmt.Sprintf("%sseries:%d", tenantPrefix(tenant), rHash)
+}
+
+// encodeSeries lays out series as a version 1 blob, gzipped when compression
+// is config.SeriesCompressionGzip.
+func encodeSeries(series map[int64]float64, compression string) []byte {
+	ns := make([]int64, 0, len(series))
+	for n := range series {
+		ns = append(ns, n)
+	}
+	sort.Slice(ns, func(i, j int) bool { return ns[i] < ns[j] })
+
+	buf := make([]byte, 0, 1+len(ns)*9)
+	buf = append(buf, seriesBlobVersion)
+
+	var prev int64
+	for _, n := range ns {
+		buf = binary.AppendUvarint(buf, uint64(n-prev))
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(series[n]))
+		prev = n
+	}
+	if compression != config.SeriesCompressionGzip {
+		return buf
+	}
+
+	// Writes to a bytes.Buffer cannot fail. BestSpeed keeps the shutdown
+	// flush short; higher levels save little on chaotic values.
+	var zipped bytes.Buffer
+	zw, _ := gzip.NewWriterLevel(&zipped, gzip.BestSpeed)
+	zw.Write(buf)
+	zw.Close()
+	return zipped.Bytes()
+}
+
+// decodeSeries reads a blob written by encodeSeries with any compression.
+
+func decodeSeries(blob []byte) (map[int64]float64, error) {
+	if len(blob) == 0 {
+		return nil, errSeriesBlob
+	}
+	if blob[0] == seriesBlobGzip {
+		zr, err := gzip.NewReader(bytes.NewReader(blob))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errSeriesBlob, err)
+		}
+		if blob, err = io.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("%w: %v", errSeriesBlob, err)
+		}
+		if len(blob) == 0 || blob[0] == seriesBlobGzip {
+			return nil, errSeriesBlob
+		}
+	}
+	if blob[0] != seriesBlobVersion {
+		return nil, fmt.Errorf("unsupported series blob version %d", blob[0])
+	}
+
+	series := make(map[int64]float64)
+	rest := blob[1:]
+	var n int64
+	for len(rest) > 0 {
+		delta, read := binary.Uvarint(rest)
+		if read <= 0 || len(rest) < read+8 {
+			return nil, errSeriesBlob
+		}
+		n += int64(delta)
+		series[n] = math.Float64frombits(binary.LittleEndian.Uint64(rest[read : read+8]))
+		rest = rest[read+8:]
+	}
+	return series, nil
+}
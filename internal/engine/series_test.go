@@ -0,0 +1,135 @@
+package engine
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+
+	"resilientrecursion/pkg/config"
+)
+
+// trajectorySeries returns x_1..x_n for r from x0 = 0.5, as a full flush
+// would write a contiguously cached r.
+func trajectorySeries(r float64, n int64) map[int64]float64 {
+	series := make(map[int64]float64, n)
+	x := 0.5
+	for i := int64(1); i <= n; i++ {
+		x = r * x * (1 - x)
+		series[i] = x
+	}
+	return series
+}
+
+func TestSeriesBlobCompression(t *testing.T) {
+	series := trajectorySeries(3.2, 10000)
+	plain := encodeSeries(series, config.SeriesCompressionNone)
+	zipped := encodeSeries(series, config.SeriesCompressionGzip)
+
+	if plain[0] != seriesBlobVersion || zipped[0] != seriesBlobGzip {
+		t.Fatalf("header bytes %#x and %#x, want %#x and %#x", plain[0], zipped[0], seriesBlobVersion, seriesBlobGzip)
+	}
+	if len(zipped) >= len(plain)/10 {
+		t.Errorf("period-2 series gzipped to %d of %d bytes", len(zipped), len(plain))
+	}
+
+	for name, blob := range map[string][]byte{"none": plain, "gzip": zipped} {
+		got, err := decodeSeries(blob)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(got) != len(series) || got[10000] != series[10000] {
+			t.Errorf("%s: decoded %d entries, x_10000 = %v, want %d and %v", name, len(got), got[10000], len(series), series[10000])
+		}
+	}
+
+	if _, err := decodeSeries(zipped[:len(zipped)/2]); !errors.Is(err, errSeriesBlob) {
+		t.Errorf("truncated gzip blob: err = %v, want errSeriesBlob", err)
+	}
+}
+
+func TestSeriesBlobRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		series map[int64]float64
+	}{
+		{"empty", map[int64]float64{}},
+		{"single entry", map[int64]float64{1000: 0.25}},
+		{"n = 0", map[int64]float64{0: 0.5, 1: 0.9}},
+		{"sparse", map[int64]float64{3: 0.1, 1 << 40: 0.2, 1<<40 + 1: math.SmallestNonzeroFloat64}},
+		{"contiguous", trajectorySeries(3.9, 100)},
+	} {
+		for _, compression := range []string{config.SeriesCompressionNone, config.SeriesCompressionGzip} {
+			got, err := decodeSeries(encodeSeries(tc.series, compression))
+			if err != nil {
+				t.Errorf("%s/%s: %v", tc.name, compression, err)
+				continue
+			}
+			if len(got) != len(tc.series) {
+				t.Errorf("%s/%s: decoded %d entries, want %d", tc.name, compression, len(got), len(tc.series))
+			}
+			for n, x := range tc.series {
+				if math.Float64bits(got[n]) != math.Float64bits(x) {
+					t.Errorf("%s/%s: x_%d = %v, want %v", tc.name, compression, n, got[n], x)
+				}
+			}
+		}
+	}
+}
+
+func TestSeriesBlobRejectsCorruptInput(t *testing.T) {
+	valid := encodeSeries(map[int64]float64{10: 0.25, 20: 0.5}, config.SeriesCompressionNone)
+	for _, tc := range []struct {
+		name string
+		blob []byte
+	}{
+		{"empty", nil},
+		{"truncated value", valid[:len(valid)-3]},
+		{"delta without value", append([]byte{seriesBlobVersion}, 10)},
+		{"overlong delta", []byte{seriesBlobVersion, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{"gzip of nothing", encodeSeriesGzip(nil)},
+		{"gzip of gzip", encodeSeriesGzip([]byte{seriesBlobGzip})},
+		{"bad gzip header", []byte{seriesBlobGzip, 0x00}},
+	} {
+		if got, err := decodeSeries(tc.blob); !errors.Is(err, errSeriesBlob) {
+			t.Errorf("%s: decoded %v, err %v; want errSeriesBlob", tc.name, got, err)
+		}
+	}
+
+	if _, err := decodeSeries([]byte{seriesBlobVersion + 1}); err == nil {
+		t.Error("unknown version accepted")
+	}
+}
+
+// encodeSeriesGzip gzips raw as encodeSeries does a versioned blob.
+func encodeSeriesGzip(raw []byte) []byte {
+	var zipped bytes.Buffer
+	zw := gzip.NewWriter(&zipped)
+	zw.Write(raw)
+	zw.Close()
+	return zipped.Bytes()
+}
+
+// BenchmarkSeriesBlob reports the blob size of a 100k-entry series per
+// compression, for a chaotic r and a periodic one.
+func BenchmarkSeriesBlob(b *testing.B) {
+	for _, r := range []float64{3.2, 3.9} {
+		series := trajectorySeries(r, 100000)
+		for _, compression := range []string{config.SeriesCompressionNone, config.SeriesCompressionGzip} {
+			blob := encodeSeries(series, compression)
+			b.Run(fmt.Sprintf("r=%v/%s/encode", r, compression), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					encodeSeries(series, compression)
+				}
+				b.ReportMetric(float64(len(blob)), "blob-bytes")
+			})
+			b.Run(fmt.Sprintf("r=%v/%s/decode", r, compression), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					decodeSeries(blob)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package engine
+
+import "fmt"
+
+// NotOwnerError is returned in strict sharding mode when asked to compute an
+// r that another pod owns. Owner is that pod's index, so clients can retry
+// against pod-<Owner>.
+type NotOwnerError struct {
+	R     float64
+	Owner int
+}
+
+func (e *NotOwnerError) Error() string {
+	return fmt.Sprintf("r=%v is owned by pod %d", e.R, e.Owner)
+}
+
+// ShardMap returns the index of the pod that owns the plain logistic series
+// of each r in rs, and how many of them each pod owns. It only hashes, so it
+// is cheap and reads no cache.
+func (e *ComputeEngine) ShardMap(rs []float64) (owners, counts []int) {
+	owners = make([]int, len(rs))
+	counts = make([]int, e.totalPods)
+	for i, r := range rs {
+		owners[i] = e.ownerOf(e.seriesHash(r, 0))
+		counts[owners[i]]++
+	}
+	return owners, counts
+}
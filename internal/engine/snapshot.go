@@ -0,0 +1,200 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"resilientrecursion/internal/cache"
+	"resilientrecursion/internal/logging"
+	"resilientrecursion/internal/models"
+)
+
+// snapshotMaxLine bounds one NDJSON line of a warm snapshot, far above any
+// response. A longer line is skipped as malformed.
+const snapshotMaxLine = 64 * 1024
+
+// errSnapshotEntry is wrapped by the parsers for an entry that cannot be
+// loaded.
+var errSnapshotEntry = errors.New("malformed snapshot entry")
+
+// LoadSnapshot fills the default tenant's L1 from a file of results
+// computed earlier, so a pod can answer common points at once on a cold
+// start, before or without Redis. A .csv file has the r,n,result rows of a
+// CSV /calculate response, with an optional c column; anything else is read
+// as NDJSON, one /calculate response per line. The results must come from a
+// pod with the same X0.
+//
+// Entries are validated and a malformed one is skipped: r, c and the result
+// must be finite and n non-negative, and responses with an error or a
+// partial result are left out. It returns how many entries were loaded and
+// skipped, and an error only if the file cannot be read.
+func (e *ComputeEngine) LoadSnapshot(path string) (loaded, skipped int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	load := func(resp models.Response, parseErr error) {
+		if parseErr == nil {
+			parseErr = validSnapshotEntry(resp)
+		}
+		if parseErr != nil {
+			skipped++
+			logging.Debugf("Skipping warm snapshot entry: %v", parseErr)
+			return
+		}
+		rHash := e.seriesKey(resp.R, computeOpts{c: resp.C, clamp: resp.Clamped})
+		e.l1Cache.Set(rHash, resp.N, resp.Result)
+		e.l1Cache.SetParams(rHash, cache.SeriesParams{R: resp.R, C: resp.C, X0: e.x0})
+		loaded++
+	}
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		err = readSnapshotCSV(f, load)
+	} else {
+		err = readSnapshotNDJSON(f, load)
+	}
+	if err != nil {
+		return loaded, skipped, fmt.Errorf("reading warm snapshot %s: %w", path, err)
+	}
+
+	logging.Infof("Loaded %d entries from warm snapshot %s", loaded, path)
+	if skipped > 0 {
+		logging.Warnf("Skipped %d malformed entries of warm snapshot %s", skipped, path)
+	}
+	return loaded, skipped, nil
+}
+
+func validSnapshotEntry(resp models.Response) error {
+	switch {
+	case resp.Error != "" || resp.Partial:
+		return fmt.Errorf("%w: r=%v n=%d has no complete result", errSnapshotEntry, resp.R, resp.N)
+	case !isFinite(resp.R) || !isFinite(resp.C) || !isFinite(resp.Result):
+		return fmt.Errorf("%w: r=%v c=%v result=%v is not finite", errSnapshotEntry, resp.R, resp.C, resp.Result)
+	case resp.N < 0:
+		return fmt.Errorf("%w: negative n=%d", errSnapshotEntry, resp.N)
+	}
+	return nil
+}
+
+func isFinite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+// readSnapshotNDJSON passes each non-blank line to load. An entry must at
+// least carry r, n and result.
+func readSnapshotNDJSON(r io.Reader, load func(models.Response, error)) error {
+	br := bufio.NewReaderSize(r, snapshotMaxLine)
+	for line := 1; ; line++ {
+		raw, err := br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// Drop the rest of the line; the ones after it may be fine.
+			for err == bufio.ErrBufferFull {
+				_, err = br.ReadSlice('\n')
+			}
+			raw = nil
+			load(models.Response{}, fmt.Errorf("%w at line %d: longer than %d bytes", errSnapshotEntry, line, snapshotMaxLine))
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if text := bytes.TrimSpace(raw); len(text) > 0 {
+			load(parseSnapshotLine(text, line))
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// parseSnapshotLine decodes one NDJSON entry, the text of line number line.
+func parseSnapshotLine(text []byte, line int) (models.Response, error) {
+	var entry struct {
+		models.Response
+		Result *float64 `json:"result"`
+	}
+	if err := json.Unmarshal(text, &entry); err != nil {
+		return models.Response{}, fmt.Errorf("%w at line %d: %v", errSnapshotEntry, line, err)
+	}
+	if entry.Result == nil {
+		return models.Response{}, fmt.Errorf("%w at line %d: no result", errSnapshotEntry, line)
+	}
+	entry.Response.Result = *entry.Result
+	return entry.Response, nil
+}
+
+// readSnapshotCSV passes each row after the header to load. The header
+// names the columns, so they may come in any order.
+func readSnapshotCSV(r io.Reader, load func(models.Response, error)) error {
+	// Every row must have as many fields as the header.
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return fmt.Errorf("CSV header: %w", err)
+	}
+	columns := map[string]int{"c": -1}
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"r", "n", "result"} {
+		if _, ok := columns[name]; !ok {
+			return fmt.Errorf("CSV header has no %s column", name)
+		}
+	}
+
+	for row := 2; ; row++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if errors.Is(err, csv.ErrFieldCount) || errors.Is(err, csv.ErrQuote) || errors.Is(err, csv.ErrBareQuote) {
+			load(models.Response{}, fmt.Errorf("%w at row %d: %v", errSnapshotEntry, row, err))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		resp, err := snapshotRow(record, columns)
+		if err != nil {
+			err = fmt.Errorf("%w at row %d: %v", errSnapshotEntry, row, err)
+		}
+		load(resp, err)
+	}
+}
+
+func snapshotRow(record []string, columns map[string]int) (models.Response, error) {
+	field := func(name string) string {
+		if i := columns[name]; i >= 0 && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+	var resp models.Response
+	var err error
+	if resp.R, err = strconv.ParseFloat(field("r"), 64); err != nil {
+		return resp, err
+	}
+	if c := field("c"); c != "" {
+		if resp.C, err = strconv.ParseFloat(c, 64); err != nil {
+			return resp, err
+		}
+	}
+	if resp.N, err = strconv.ParseInt(field("n"), 10, 64); err != nil {
+		return resp, err
+	}
+	// An error or partial result is written with an empty result.
+	if resp.Result, err = strconv.ParseFloat(field("result"), 64); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
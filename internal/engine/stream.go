@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"resilientrecursion/internal/logging"
+	"resilientrecursion/internal/models"
+	"resilientrecursion/pkg/config"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// jobGroup is the consumer group shared by every pod that consumes the job
+// stream, so each published job is computed once.
+const jobGroup = "compute-workers"
+
+// How long a consumer blocks waiting for new entries, and how long it backs
+// off after a Redis error.
+const (
+	streamBlock   = 2 * time.Second
+	streamBackoff = time.Second
+)
+
+// PublishJob records a queued job and appends the batch to the job stream
+// instead of the local worker pool. Any pod consuming the stream may compute
+// it as the tenant carried by ctx; the status is read back with Job as for
+// SubmitJob.
+func (e *ComputeEngine) PublishJob(ctx context.Context, requests []models.Request) (*models.Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(requests)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &models.Job{ID: id, Status: models.JobQueued}
+	if err := e.saveJob(ctx, job); err != nil {
+		return nil, err
+	}
+
+	err = e.redisClient.XAdd(ctx, &redis.XAddArgs{
+		Stream: e.jobStream,
+		Values: map[string]interface{}{"id": id, "tenant": TenantFrom(ctx), "requests": payload},
+	}).Err()
+	if err != nil {
+		e.redisClient.Del(ctx, jobKey(TenantFrom(ctx), id))
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// ConsumeJobs computes jobs from the stream one at a time as a member of
+// jobGroup, acknowledging each once its result is saved. Entries delivered to
+// this pod before a restart but never acknowledged are processed first. It
+// returns when ctx is done.
+func (e *ComputeEngine) ConsumeJobs(ctx context.Context) {
+	for !e.ensureJobGroup(ctx) {
+		if !sleepCtx(ctx, streamBackoff) {
+			return
+		}
+	}
+
+	// "0" replays this consumer's pending entries; once they are drained,
+	// ">" asks for entries never delivered to anyone.
+	start := "0"
+	for ctx.Err() == nil {
+		streams, err := e.redisClient.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    jobGroup,
+			Consumer: e.podID,
+			Streams:  []string{e.jobStream, start},
+			Count:    1,
+			Block:    streamBlock,
+		}).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logging.Errorf("Job stream read error: %v", err)
+			sleepCtx(ctx, streamBackoff)
+			continue
+		}
+
+		if len(streams) == 0 || len(streams[0].Messages) == 0 {
+			start = ">"
+			continue
+		}
+		for _, msg := range streams[0].Messages {
+			e.runStreamJob(msg)
+		}
+	}
+}
+
+func (e *ComputeEngine) ensureJobGroup(ctx context.Context) bool {
+	err := e.redisClient.XGroupCreateMkStream(ctx, e.jobStream, jobGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		logging.Errorf("Job stream group error: %v", err)
+		return false
+	}
+	return true
+}
+
+func (e *ComputeEngine) runStreamJob(msg redis.XMessage) {
+	// Acknowledge with a fresh context so a job finished during shutdown is
+	// not redelivered.
+	defer e.redisClient.XAck(context.Background(), e.jobStream, jobGroup, msg.ID)
+
+	id, _ := msg.Values["id"].(string)
+	tenant, _ := msg.Values["tenant"].(string)
+	payload, _ := msg.Values["requests"].(string)
+
+	var requests []models.Request
+	if err := json.Unmarshal([]byte(payload), &requests); err != nil || id == "" {
+		logging.Errorf("Dropping malformed job stream entry %s", msg.ID)
+		return
+	}
+
+	if tenant == "" {
+		tenant = config.DefaultTenant
+	}
+	e.runJob(id, tenant, requests)
+}
+
+// sleepCtx waits for d and reports false if ctx was done first.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,59 @@
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"resilientrecursion/internal/models"
+)
+
+func TestStreamJobRoundTrip(t *testing.T) {
+	e, _ := newTestEngine(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	job, err := e.PublishJob(ctx, []models.Request{{R: 3.5, N: 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != models.JobQueued {
+		t.Fatalf("published status = %q, want %q", job.Status, models.JobQueued)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		e.ConsumeJobs(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, err := e.Job(ctx, job.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status == models.JobDone {
+			want, _ := e.Compute(ctx, 3.5, 10)
+			if len(got.Results) != 1 || got.Results[0].Result != want {
+				t.Fatalf("results = %+v, want one result %v", got.Results, want)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job still %q after 5s", got.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	<-done
+
+	pending, err := e.redisClient.XPending(context.Background(), e.jobStream, jobGroup).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending.Count != 0 {
+		t.Errorf("%d stream entries left unacknowledged", pending.Count)
+	}
+}
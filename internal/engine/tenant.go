@@ -0,0 +1,64 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"resilientrecursion/internal/cache"
+	"resilientrecursion/pkg/config"
+)
+
+// ErrUnknownTenant is returned when a context names a tenant that is not
+// configured.
+var ErrUnknownTenant = errors.New("unknown tenant")
+
+type tenantCtxKey struct{}
+
+// WithTenant returns a context whose computes use tenant's cache and keys.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantCtxKey{}, tenant)
+}
+
+// TenantFrom returns the tenant carried by ctx, or config.DefaultTenant.
+func TenantFrom(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantCtxKey{}).(string); ok && tenant != "" {
+		return tenant
+	}
+	return config.DefaultTenant
+}
+
+// HasTenant reports whether tenant is configured on this engine.
+func (e *ComputeEngine) HasTenant(tenant string) bool {
+	_, ok := e.caches[tenant]
+	return ok
+}
+
+// cacheFor returns the L1 cache of the tenant carried by ctx.
+func (e *ComputeEngine) cacheFor(ctx context.Context) (*cache.L1Cache, error) {
+	tenant := TenantFrom(ctx)
+	c, ok := e.caches[tenant]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownTenant, tenant)
+	}
+	return c, nil
+}
+
+// tenantPrefix namespaces a tenant's Redis keys. The default tenant has none,
+// so keys written before tenants existed stay in use.
+func tenantPrefix(tenant string) string {
+	if tenant == config.DefaultTenant {
+		return ""
+	}
+	return "t:" + tenant + ":"
+}
+
+func checkpointKey(tenant string, rHash uint64) string {
+	return fmt.Sprintf("%scp:%d", tenantPrefix(tenant), rHash)
+}
+
+// bigCheckpointKey is the sorted set of big.Float checkpoints of rHash at
+// prec bits. Values at different precisions differ, so each has its own.
+func bigCheckpointKey(tenant string, rHash uint64, prec uint) string {
+	return fmt.Sprintf("%sbcp:%d:%d", tenantPrefix(tenant), rHash, prec)
+}
@@ -0,0 +1,126 @@
+package engine
+
+import (
+	"context"
+	"errors"
+
+	"resilientrecursion/internal/cache"
+)
+
+// ErrInvalidStride is returned when a trajectory stride is not positive.
+var ErrInvalidStride = errors.New("stride must be positive")
+
+// ErrInvalidBase is returned when a log-spaced trajectory's base is below 2.
+var ErrInvalidBase = errors.New("base must be at least 2")
+
+// Point is a single sample of a trajectory.
+type Point struct {
+	N int64
+	X float64
+}
+
+// walk visits x_0..x_n for r in order, taking each value from L1 when cached
+// and computing it otherwise. Computed values are written back to L1 only if
+// store is set, so very long read-only walks don't flood the cache. A stale
+// series is neither read nor extended; computes replace it.
+func (e *ComputeEngine) walk(ctx context.Context, r float64, n int64, store bool, visit func(i int64, x float64)) error {
+	rHash := e.seriesHash(r, 0)
+	x := e.x0
+	l1, err := e.cacheFor(ctx)
+	if err != nil {
+		return err
+	}
+	useL1 := !l1.Stale(rHash)
+
+	stride := e.cancelCheckStride.Load()
+	for i := int64(0); i <= n; i++ {
+		if i > 0 {
+			if i%stride == 0 {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+			}
+			if val, ok := l1.Get(rHash, i); ok && useL1 {
+				x = val
+			} else {
+				x = r * x * (1 - x)
+				if store && useL1 {
+					l1.Set(rHash, i, x)
+				}
+			}
+		}
+		visit(i, x)
+	}
+
+	if store && useL1 {
+		l1.SetParams(rHash, cache.SeriesParams{R: r, X0: e.x0})
+	}
+	return nil
+}
+
+// Trajectory walks x_0..x_n for r through the L1 cache and returns the values
+// at every multiple of stride, plus x_n itself. Values not yet cached are
+// computed and stored as the walk passes them.
+func (e *ComputeEngine) Trajectory(ctx context.Context, r float64, n, stride int64) ([]Point, error) {
+	if stride <= 0 {
+		return nil, ErrInvalidStride
+	}
+
+	points := make([]Point, 0, n/stride+2)
+	err := e.walk(ctx, r, n, true, func(i int64, x float64) {
+		if i%stride == 0 || i == n {
+			points = append(points, Point{N: i, X: x})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return points, nil
+}
+
+// LogSpacedN returns the powers of base from 1 up to maxN: 1, base, base^2
+// and so on.
+func LogSpacedN(maxN, base int64) []int64 {
+	var ns []int64
+	for n := int64(1); n <= maxN; n *= base {
+		ns = append(ns, n)
+		if n > maxN/base {
+			break
+		}
+	}
+	return ns
+}
+
+// LogTrajectory returns x at every n of LogSpacedN(maxN, base), collected in
+// one walk to maxN. Only the returned points are written to L1, so a long
+// walk doesn't flood the cache.
+func (e *ComputeEngine) LogTrajectory(ctx context.Context, r float64, maxN, base int64) ([]Point, error) {
+	if base < 2 {
+		return nil, ErrInvalidBase
+	}
+	ns := LogSpacedN(maxN, base)
+	if len(ns) == 0 {
+		return nil, nil
+	}
+	l1, err := e.cacheFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	points := make([]Point, 0, len(ns))
+	err = e.walk(ctx, r, ns[len(ns)-1], false, func(i int64, x float64) {
+		if len(points) < len(ns) && i == ns[len(points)] {
+			points = append(points, Point{N: i, X: x})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rHash := e.seriesHash(r, 0)
+	for _, p := range points {
+		l1.Set(rHash, p.N, p.X)
+	}
+	return points, nil
+}
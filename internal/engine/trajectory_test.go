@@ -0,0 +1,57 @@
+package engine
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestLogSpacedN(t *testing.T) {
+	for _, tc := range []struct {
+		maxN, base int64
+		want       []int64
+	}{
+		{1, 2, []int64{1}},
+		{100, 2, []int64{1, 2, 4, 8, 16, 32, 64}},
+		{128, 2, []int64{1, 2, 4, 8, 16, 32, 64, 128}},
+		{1000, 10, []int64{1, 10, 100, 1000}},
+		{0, 2, nil},
+	} {
+		if got := LogSpacedN(tc.maxN, tc.base); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("LogSpacedN(%d, %d) = %v, want %v", tc.maxN, tc.base, got, tc.want)
+		}
+	}
+	// Powers near the top of int64 must not overflow into a loop.
+	if got := LogSpacedN(1<<62, 2); len(got) != 63 {
+		t.Errorf("LogSpacedN(2^62, 2) has %d points, want 63", len(got))
+	}
+}
+
+func TestLogTrajectory(t *testing.T) {
+	e, _ := newTestEngine(t)
+	ctx := context.Background()
+
+	points, err := e.LogTrajectory(ctx, 3.7, 5000, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantN := []int64{1, 3, 9, 27, 81, 243, 729, 2187}
+	if len(points) != len(wantN) {
+		t.Fatalf("%d points, want %d", len(points), len(wantN))
+	}
+	for i, p := range points {
+		if p.N != wantN[i] || p.X != directIterate(3.7, p.N) {
+			t.Errorf("point %d = %+v, want n=%d x=%v", i, p, wantN[i], directIterate(3.7, wantN[i]))
+		}
+		if _, ok := e.l1Cache.Get(HashFloat64(3.7), p.N); !ok {
+			t.Errorf("n=%d not written to L1", p.N)
+		}
+	}
+	if _, ok := e.l1Cache.Get(HashFloat64(3.7), 2); ok {
+		t.Error("n=2, between points, was written to L1")
+	}
+
+	if _, err := e.LogTrajectory(ctx, 3.7, 100, 1); err != ErrInvalidBase {
+		t.Errorf("base 1: err = %v, want ErrInvalidBase", err)
+	}
+}
@@ -0,0 +1,66 @@
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+type Level int32
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = map[Level]string{
+	Debug: "debug",
+	Info:  "info",
+	Warn:  "warn",
+	Error: "error",
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel accepts the names printed by Level.String, case-insensitively.
+func ParseLevel(s string) (Level, error) {
+	for level, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return level, nil
+		}
+	}
+	return Info, fmt.Errorf("unknown log level %q", s)
+}
+
+var current atomic.Int32
+
+func init() {
+	current.Store(int32(Info))
+}
+
+// SetLevel changes the minimum level that is written. It is safe to call
+// while other goroutines are logging.
+func SetLevel(l Level) {
+	current.Store(int32(l))
+}
+
+func GetLevel() Level {
+	return Level(current.Load())
+}
+
+func logf(l Level, format string, args ...interface{}) {
+	if l < GetLevel() {
+		return
+	}
+	log.Printf("["+strings.ToUpper(l.String())+"] "+format, args...)
+}
+
+func Debugf(format string, args ...interface{}) { logf(Debug, format, args...) }
+func Infof(format string, args ...interface{})  { logf(Info, format, args...) }
+func Warnf(format string, args ...interface{})  { logf(Warn, format, args...) }
+func Errorf(format string, args ...interface{}) { logf(Error, format, args...) }
@@ -0,0 +1,203 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Recorder receives the measurements taken across the service, one method
+// per instrumentation point. Metrics records them for Prometheus and StatsD
+// sends them to a StatsD agent; Nop drops them and Multi fans them out.
+type Recorder interface {
+	RedisCommand(command string, d time.Duration)
+	CheckpointSample(keys int, avgMembers, maxMembers float64)
+	NonLocalCompute()
+	TenantRequest(tenant string)
+	RateLimitedRequest(tenant string)
+	PeerCheckpoint(outcome string)
+	CoalescedCompute()
+
+	// CheckpointResume records a compute that resumed from a Redis
+	// checkpoint, sparing the saved iterations a compute from x0 would have
+	// taken to reach it.
+	CheckpointResume(saved int64)
+
+	// CheckpointSkipped records a checkpoint write skipped because its
+	// value repeated the last one written.
+	CheckpointSkipped()
+
+	// Compute records one compute that took d and iterated the map
+	// iterations times; cache hits iterate zero times.
+	Compute(d time.Duration, iterations int64)
+
+	// WatchQueueDepth reports depth as the worker queue depth of priority
+	// whenever the recorder publishes.
+	WatchQueueDepth(priority string, depth func() int)
+}
+
+// Metrics holds the collectors for one engine. Each instance owns its own
+// registry so several engines (e.g. in tests) can coexist.
+type Metrics struct {
+	registry *prometheus.Registry
+	podID    string
+
+	RedisLatency        *prometheus.HistogramVec
+	CheckpointMembers   prometheus.Gauge
+	CheckpointMaxMember prometheus.Gauge
+	CheckpointSampled   prometheus.Gauge
+	NonLocalComputes    prometheus.Counter
+	TenantRequests      *prometheus.CounterVec
+	TenantRateLimited   *prometheus.CounterVec
+	PeerCheckpoints     *prometheus.CounterVec
+	CoalescedComputes   prometheus.Counter
+	CheckpointResumes   prometheus.Counter
+	IterationsSaved     prometheus.Counter
+	CheckpointsSkipped  prometheus.Counter
+	ComputeDuration     prometheus.Histogram
+	ComputeIterations   prometheus.Histogram
+}
+
+// New creates the collectors for podID, with durationBuckets (in seconds)
+// and iterationBuckets as the upper bounds of the compute histograms.
+func New(podID string, durationBuckets, iterationBuckets []float64) *Metrics {
+	labels := prometheus.Labels{"pod": podID}
+
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		podID:    podID,
+		RedisLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        "resilientrecursion_redis_command_duration_seconds",
+			Help:        "Latency of Redis commands by command name.",
+			ConstLabels: labels,
+			Buckets:     prometheus.ExponentialBuckets(0.0001, 2, 16),
+		}, []string{"command"}),
+		CheckpointMembers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "resilientrecursion_checkpoint_members_avg",
+			Help:        "Average checkpoint count per r across the last sampled keys.",
+			ConstLabels: labels,
+		}),
+		CheckpointMaxMember: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "resilientrecursion_checkpoint_members_max",
+			Help:        "Largest checkpoint count per r across the last sampled keys.",
+			ConstLabels: labels,
+		}),
+		CheckpointSampled: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "resilientrecursion_checkpoint_keys_sampled",
+			Help:        "Number of checkpoint keys in the last sample.",
+			ConstLabels: labels,
+		}),
+		NonLocalComputes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "resilientrecursion_nonlocal_computes_total",
+			Help:        "Computes for r values owned by another pod.",
+			ConstLabels: labels,
+		}),
+		TenantRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "resilientrecursion_tenant_requests_total",
+			Help:        "HTTP requests by tenant.",
+			ConstLabels: labels,
+		}, []string{"tenant"}),
+		TenantRateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "resilientrecursion_tenant_rate_limited_total",
+			Help:        "HTTP requests rejected by the per-tenant rate limit.",
+			ConstLabels: labels,
+		}, []string{"tenant"}),
+		PeerCheckpoints: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "resilientrecursion_peer_checkpoints_total",
+			Help:        "Checkpoints announced by peer pods, by outcome: applied, skipped or dropped.",
+			ConstLabels: labels,
+		}, []string{"outcome"}),
+		CoalescedComputes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "resilientrecursion_coalesced_computes_total",
+			Help:        "Computes that waited for an identical one in flight instead of iterating.",
+			ConstLabels: labels,
+		}),
+		CheckpointResumes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "resilientrecursion_checkpoint_resumes_total",
+			Help:        "Computes that resumed from a Redis checkpoint.",
+			ConstLabels: labels,
+		}),
+		IterationsSaved: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "resilientrecursion_checkpoint_iterations_saved_total",
+			Help:        "Map steps from x0 skipped by resuming from Redis checkpoints.",
+			ConstLabels: labels,
+		}),
+		CheckpointsSkipped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "resilientrecursion_checkpoint_writes_skipped_total",
+			Help:        "Checkpoint writes skipped as duplicates of the last checkpoint written.",
+			ConstLabels: labels,
+		}),
+		ComputeDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:        "resilientrecursion_compute_duration_seconds",
+			Help:        "Latency of computes, from cache hits to cold iterations.",
+			ConstLabels: labels,
+			Buckets:     durationBuckets,
+		}),
+		ComputeIterations: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:        "resilientrecursion_compute_iterations",
+			Help:        "Map steps taken per compute.",
+			ConstLabels: labels,
+			Buckets:     iterationBuckets,
+		}),
+	}
+
+	m.registry.MustRegister(m.RedisLatency, m.CheckpointMembers, m.CheckpointMaxMember, m.CheckpointSampled,
+		m.NonLocalComputes, m.TenantRequests, m.TenantRateLimited, m.PeerCheckpoints, m.CoalescedComputes,
+		m.CheckpointResumes, m.IterationsSaved, m.CheckpointsSkipped, m.ComputeDuration, m.ComputeIterations)
+	return m
+}
+
+func (m *Metrics) RedisCommand(command string, d time.Duration) {
+	m.RedisLatency.WithLabelValues(command).Observe(d.Seconds())
+}
+
+// CheckpointSample publishes a checkpoint sample. The member gauges keep
+// their previous values when no key was sampled.
+func (m *Metrics) CheckpointSample(keys int, avgMembers, maxMembers float64) {
+	m.CheckpointSampled.Set(float64(keys))
+	if keys > 0 {
+		m.CheckpointMembers.Set(avgMembers)
+		m.CheckpointMaxMember.Set(maxMembers)
+	}
+}
+
+func (m *Metrics) NonLocalCompute() { m.NonLocalComputes.Inc() }
+
+func (m *Metrics) TenantRequest(tenant string) { m.TenantRequests.WithLabelValues(tenant).Inc() }
+
+func (m *Metrics) RateLimitedRequest(tenant string) {
+	m.TenantRateLimited.WithLabelValues(tenant).Inc()
+}
+
+func (m *Metrics) PeerCheckpoint(outcome string) { m.PeerCheckpoints.WithLabelValues(outcome).Inc() }
+
+func (m *Metrics) CoalescedCompute() { m.CoalescedComputes.Inc() }
+
+func (m *Metrics) CheckpointResume(saved int64) {
+	m.CheckpointResumes.Inc()
+	m.IterationsSaved.Add(float64(saved))
+}
+
+func (m *Metrics) CheckpointSkipped() { m.CheckpointsSkipped.Inc() }
+
+func (m *Metrics) Compute(d time.Duration, iterations int64) {
+	m.ComputeDuration.Observe(d.Seconds())
+	m.ComputeIterations.Observe(float64(iterations))
+}
+
+// WatchQueueDepth exports depth as the worker queue depth of priority,
+// sampled on every scrape.
+func (m *Metrics) WatchQueueDepth(priority string, depth func() int) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        "resilientrecursion_worker_queue_depth",
+		Help:        "Tasks waiting for a worker by priority.",
+		ConstLabels: prometheus.Labels{"pod": m.podID, "priority": priority},
+	}, func() float64 { return float64(depth()) }))
+}
+
+// Handler serves the registry in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
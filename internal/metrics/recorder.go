@@ -0,0 +1,88 @@
+package metrics
+
+import "time"
+
+// Nop is a Recorder that drops every measurement. Embedding it lets a type
+// implement only the methods it cares about.
+type Nop struct{}
+
+func (Nop) RedisCommand(string, time.Duration)     {}
+func (Nop) CheckpointSample(int, float64, float64) {}
+func (Nop) NonLocalCompute()                       {}
+func (Nop) TenantRequest(string)                   {}
+func (Nop) RateLimitedRequest(string)              {}
+func (Nop) PeerCheckpoint(string)                  {}
+func (Nop) CoalescedCompute()                      {}
+func (Nop) CheckpointResume(int64)                 {}
+func (Nop) CheckpointSkipped()                     {}
+func (Nop) Compute(time.Duration, int64)           {}
+func (Nop) WatchQueueDepth(string, func() int)     {}
+
+// Multi sends every measurement to each of its recorders in turn.
+type Multi []Recorder
+
+func (m Multi) RedisCommand(command string, d time.Duration) {
+	for _, r := range m {
+		r.RedisCommand(command, d)
+	}
+}
+
+func (m Multi) CheckpointSample(keys int, avgMembers, maxMembers float64) {
+	for _, r := range m {
+		r.CheckpointSample(keys, avgMembers, maxMembers)
+	}
+}
+
+func (m Multi) NonLocalCompute() {
+	for _, r := range m {
+		r.NonLocalCompute()
+	}
+}
+
+func (m Multi) TenantRequest(tenant string) {
+	for _, r := range m {
+		r.TenantRequest(tenant)
+	}
+}
+
+func (m Multi) RateLimitedRequest(tenant string) {
+	for _, r := range m {
+		r.RateLimitedRequest(tenant)
+	}
+}
+
+func (m Multi) PeerCheckpoint(outcome string) {
+	for _, r := range m {
+		r.PeerCheckpoint(outcome)
+	}
+}
+
+func (m Multi) CoalescedCompute() {
+	for _, r := range m {
+		r.CoalescedCompute()
+	}
+}
+
+func (m Multi) CheckpointResume(saved int64) {
+	for _, r := range m {
+		r.CheckpointResume(saved)
+	}
+}
+
+func (m Multi) CheckpointSkipped() {
+	for _, r := range m {
+		r.CheckpointSkipped()
+	}
+}
+
+func (m Multi) Compute(d time.Duration, iterations int64) {
+	for _, r := range m {
+		r.Compute(d, iterations)
+	}
+}
+
+func (m Multi) WatchQueueDepth(priority string, depth func() int) {
+	for _, r := range m {
+		r.WatchQueueDepth(priority, depth)
+	}
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisHook records the latency of every command and pipeline sent through a
+// go-redis client.
+type RedisHook struct {
+	rec Recorder
+}
+
+func NewRedisHook(rec Recorder) RedisHook {
+	return RedisHook{rec: rec}
+}
+
+func (h RedisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		start := time.Now()
+		conn, err := next(ctx, network, addr)
+		h.rec.RedisCommand("dial", time.Since(start))
+		return conn, err
+	}
+}
+
+func (h RedisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		h.rec.RedisCommand(cmd.Name(), time.Since(start))
+		return err
+	}
+}
+
+func (h RedisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		h.rec.RedisCommand("pipeline", time.Since(start))
+		return err
+	}
+}
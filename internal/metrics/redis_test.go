@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// commandRecorder records the Redis commands it is told about.
+type commandRecorder struct {
+	Nop
+	mu       sync.Mutex
+	commands []string
+}
+
+func (c *commandRecorder) RedisCommand(command string, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.commands = append(c.commands, command)
+}
+
+func TestRedisHookRecordsCommands(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rec := &commandRecorder{}
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	client.AddHook(NewRedisHook(rec))
+	ctx := context.Background()
+
+	client.Set(ctx, "k", "v", 0)
+	client.Get(ctx, "k")
+	pipe := client.Pipeline()
+	pipe.ZAdd(ctx, "cp:1", redis.Z{Score: 1000, Member: "0.5"})
+	pipe.ZCard(ctx, "cp:1")
+	if _, err := pipe.Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first command dials and runs the connection handshake; a pipeline
+	// counts once, whatever it holds.
+	got := strings.Join(rec.commands, " ")
+	if !strings.HasPrefix(got, "dial ") || !strings.HasSuffix(got, " set get pipeline") {
+		t.Errorf("recorded %q, want a dial first and set get pipeline last", got)
+	}
+}
+
+func TestMetricsCarryPodLabel(t *testing.T) {
+	m := New("pod-3", []float64{0.1}, []float64{100})
+	m.RedisCommand("zcard", 3*time.Millisecond)
+	m.CheckpointSample(4, 2.5, 7)
+	// An empty round keeps the last member counts.
+	m.CheckpointSample(0, 0, 0)
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`resilientrecursion_redis_command_duration_seconds_count{command="zcard",pod="pod-3"} 1`,
+		`resilientrecursion_checkpoint_members_avg{pod="pod-3"} 2.5`,
+		`resilientrecursion_checkpoint_members_max{pod="pod-3"} 7`,
+		`resilientrecursion_checkpoint_keys_sampled{pod="pod-3"} 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics lack %s", want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package metrics
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statsdPrefix starts every StatsD metric name, as resilientrecursion_ does
+// for Prometheus.
+const statsdPrefix = "resilientrecursion."
+
+// statsdPacketSize keeps each UDP packet within the payload a 1500-byte MTU
+// carries without fragmentation.
+const statsdPacketSize = 1432
+
+// statsdFlushInterval bounds how long a buffered line waits to be sent, and
+// is how often queue depths are reported.
+const statsdFlushInterval = time.Second
+
+// statsdTagEscaper replaces the characters that delimit StatsD lines.
+var statsdTagEscaper = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ",", "_", "\n", "_")
+
+// StatsD sends measurements to a StatsD agent over UDP, under the names of the
+// Prometheus metrics with their _total and unit suffixes dropped. Redis and
+// compute latency go out as timers, compute iterations as histograms, gauges
+// as gauges and counters as counts. Every line carries the pod as a tag.
+// Agents that do not accept DogStatsD tags can have the tag values appended
+// to the name instead.
+//
+// Lines are buffered and sent in packets of up to statsdPacketSize bytes, at
+// least every statsdFlushInterval. Sends are best effort: UDP write errors
+// are ignored, as a StatsD client would.
+type StatsD struct {
+	conn net.Conn
+	pod  string
+	tags bool
+
+	mu     sync.Mutex
+	buf    []byte
+	depths map[string]func() int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewStatsD sends to the agent at addr, as pod. With tags false, tag values
+// are appended to metric names rather than sent as DogStatsD tags.
+func NewStatsD(addr, pod string, tags bool) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &StatsD{
+		conn:   conn,
+		pod:    pod,
+		tags:   tags,
+		depths: make(map[string]func() int),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *StatsD) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(statsdFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			s.flush()
+			return
+		case <-ticker.C:
+			s.flush()
+		}
+	}
+}
+
+// flush reports queue depths and sends what is buffered.
+func (s *StatsD) flush() {
+	s.mu.Lock()
+	depths := make(map[string]func() int, len(s.depths))
+	for priority, depth := range s.depths {
+		depths[priority] = depth
+	}
+	s.mu.Unlock()
+	for priority, depth := range depths {
+		s.send("worker_queue_depth", strconv.Itoa(depth()), "g", "priority", priority)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sendBuffered()
+}
+
+// sendBuffered writes out the buffer. The caller holds mu.
+func (s *StatsD) sendBuffered() {
+	if len(s.buf) == 0 {
+		return
+	}
+	s.conn.Write(s.buf)
+	s.buf = s.buf[:0]
+}
+
+// send buffers one line of kind with the tags given as key, value pairs.
+func (s *StatsD) send(name, value, kind string, tags ...string) {
+	var line strings.Builder
+	line.WriteString(statsdPrefix)
+	line.WriteString(name)
+	if !s.tags {
+		line.WriteString("." + statsdTagEscaper.Replace(s.pod))
+		for i := 1; i < len(tags); i += 2 {
+			line.WriteString("." + statsdTagEscaper.Replace(tags[i]))
+		}
+	}
+	line.WriteString(":" + value + "|" + kind)
+	if s.tags {
+		line.WriteString("|#pod:" + statsdTagEscaper.Replace(s.pod))
+		for i := 0; i+1 < len(tags); i += 2 {
+			line.WriteString("," + tags[i] + ":" + statsdTagEscaper.Replace(tags[i+1]))
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.buf) > 0 && len(s.buf)+1+line.Len() > statsdPacketSize {
+		s.sendBuffered()
+	}
+	if len(s.buf) > 0 {
+		s.buf = append(s.buf, '\n')
+	}
+	s.buf = append(s.buf, line.String()...)
+}
+
+func (s *StatsD) count(name string, tags ...string) {
+	s.send(name, "1", "c", tags...)
+}
+
+func (s *StatsD) gauge(name string, value float64) {
+	s.send(name, strconv.FormatFloat(value, 'g', -1, 64), "g")
+}
+
+func (s *StatsD) RedisCommand(command string, d time.Duration) {
+	s.send("redis_command_duration", strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64), "ms",
+		"command", command)
+}
+
+// CheckpointSample sends the member gauges only when a key was sampled, as
+// Metrics keeps them.
+func (s *StatsD) CheckpointSample(keys int, avgMembers, maxMembers float64) {
+	s.gauge("checkpoint_keys_sampled", float64(keys))
+	if keys > 0 {
+		s.gauge("checkpoint_members_avg", avgMembers)
+		s.gauge("checkpoint_members_max", maxMembers)
+	}
+}
+
+func (s *StatsD) NonLocalCompute() { s.count("nonlocal_computes") }
+
+func (s *StatsD) TenantRequest(tenant string) { s.count("tenant_requests", "tenant", tenant) }
+
+func (s *StatsD) RateLimitedRequest(tenant string) { s.count("tenant_rate_limited", "tenant", tenant) }
+
+func (s *StatsD) PeerCheckpoint(outcome string) { s.count("peer_checkpoints", "outcome", outcome) }
+
+func (s *StatsD) CoalescedCompute() { s.count("coalesced_computes") }
+
+func (s *StatsD) CheckpointResume(saved int64) {
+	s.count("checkpoint_resumes")
+	s.send("checkpoint_iterations_saved", strconv.FormatInt(saved, 10), "c")
+}
+
+func (s *StatsD) CheckpointSkipped() { s.count("checkpoint_writes_skipped") }
+
+func (s *StatsD) Compute(d time.Duration, iterations int64) {
+	s.send("compute_duration", strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64), "ms")
+	s.send("compute_iterations", strconv.FormatInt(iterations, 10), "h")
+}
+
+func (s *StatsD) WatchQueueDepth(priority string, depth func() int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.depths[priority] = depth
+}
+
+// Close sends what is still buffered and closes the connection.
+func (s *StatsD) Close() {
+	close(s.stop)
+	<-s.done
+	s.conn.Close()
+}
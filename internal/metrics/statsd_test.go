@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readStatsD returns the lines of the next packet sent to conn.
+func readStatsD(t *testing.T, conn net.PacketConn) []string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 2*statsdPacketSize)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(string(buf[:n]), "\n")
+}
+
+func TestStatsDLines(t *testing.T) {
+	for _, tc := range []struct {
+		tags bool
+		want []string
+	}{
+		{true, []string{
+			"resilientrecursion.tenant_requests:1|c|#pod:pod-0,tenant:acme",
+			"resilientrecursion.redis_command_duration:1.500|ms|#pod:pod-0,command:get",
+			"resilientrecursion.checkpoint_keys_sampled:0|g|#pod:pod-0",
+			"resilientrecursion.worker_queue_depth:3|g|#pod:pod-0,priority:high",
+		}},
+		{false, []string{
+			"resilientrecursion.tenant_requests.pod-0.acme:1|c",
+			"resilientrecursion.redis_command_duration.pod-0.get:1.500|ms",
+			"resilientrecursion.checkpoint_keys_sampled.pod-0:0|g",
+			"resilientrecursion.worker_queue_depth.pod-0.high:3|g",
+		}},
+	} {
+		agent, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer agent.Close()
+
+		s, err := NewStatsD(agent.LocalAddr().String(), "pod-0", tc.tags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.WatchQueueDepth("high", func() int { return 3 })
+		s.TenantRequest("acme")
+		s.RedisCommand("get", 1500*time.Microsecond)
+		s.CheckpointSample(0, 0, 0)
+		s.Close()
+
+		got := readStatsD(t, agent)
+		if strings.Join(got, "\n") != strings.Join(tc.want, "\n") {
+			t.Errorf("tags=%v: lines\n%s\nwant\n%s", tc.tags, strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+		}
+	}
+}
+
+func TestStatsDSplitsPackets(t *testing.T) {
+	agent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer agent.Close()
+
+	s, err := NewStatsD(agent.LocalAddr().String(), "pod-0", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for i := 0; i < 100; i++ {
+		s.CoalescedCompute()
+	}
+
+	// 100 lines do not fit one packet, so the first goes out before any flush.
+	lines := readStatsD(t, agent)
+	if len(lines) == 0 || len(lines) >= 100 || len(strings.Join(lines, "\n")) > statsdPacketSize {
+		t.Errorf("first packet has %d lines, %d bytes", len(lines), len(strings.Join(lines, "\n")))
+	}
+}
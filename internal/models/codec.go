@@ -0,0 +1,55 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Codec marshals the models to one wire format and back. Implementations
+// must be safe for concurrent use.
+type Codec interface {
+	// ContentType is the media type of the format, without parameters.
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSON is the format the models are declared for.
+var JSON Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string                { return "application/json" }
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{
+		JSON.ContentType():        JSON,
+		MessagePack.ContentType(): MessagePack,
+	}
+)
+
+// RegisterCodec adds c under its content type, so the HTTP layer accepts and
+// answers in it; this is how a Protobuf codec, say, is plugged in. A content
+// type cannot be registered twice.
+func RegisterCodec(c Codec) error {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	if _, ok := codecs[c.ContentType()]; ok {
+		return fmt.Errorf("codec for %s already registered", c.ContentType())
+	}
+	codecs[c.ContentType()] = c
+	return nil
+}
+
+// CodecFor returns the codec registered for mediaType, which carries no
+// parameters.
+func CodecFor(mediaType string) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[mediaType]
+	return c, ok
+}
@@ -0,0 +1,116 @@
+package models
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestCodecsRoundTrip(t *testing.T) {
+	resumed := int64(2000)
+	owner := 2
+	values := []any{
+		&Request{R: 3.7, N: 5000, C: -1e-9, BudgetMs: 20, Debug: true, Provenance: true},
+		&[]Request{{R: 3.2, N: 10}, {R: 3.9, N: 1 << 40, Clamp: true}},
+		&Response{
+			R: 3.7, N: 5000, Result: 0.1 + 0.2, Partial: true, ReachedN: 4200,
+			Checkpoints: []Checkpoint{{N: 1000, Value: math.Nextafter(0.5, 1)}},
+			ResumedFrom: &resumed,
+			Provenance:  &Provenance{Map: "logistic", RBits: "400d99999999999a", X0: 0.5, Precision: "float64"},
+		},
+		&Response{R: 3.9, N: 7, Error: "owned by pod 2", OwnerPod: &owner},
+		&Job{ID: "job-1", Status: JobDone, Results: []Response{{R: 2.5, N: 3, Result: 0.6}}},
+		&BatchEnvelope{Meta: BatchMeta{PodID: "pod-0", TotalIterations: 123456789012, CacheHits: -1}, Results: []Response{}},
+		&MapsResponse{},
+		&MapsResponse{R: 4, N: 9, Results: map[string]float64{"tent": 0.25, "sine": 1}},
+	}
+	for _, codec := range []Codec{JSON, MessagePack} {
+		for _, v := range values {
+			data, err := codec.Marshal(v)
+			if err != nil {
+				t.Fatalf("%s: Marshal(%T): %v", codec.ContentType(), v, err)
+			}
+			got := reflect.New(reflect.TypeOf(v).Elem())
+			if err := codec.Unmarshal(data, got.Interface()); err != nil {
+				t.Fatalf("%s: Unmarshal(%T): %v", codec.ContentType(), v, err)
+			}
+			if !reflect.DeepEqual(got.Interface(), v) {
+				t.Errorf("%s: %T round-tripped to %+v, want %+v", codec.ContentType(), v, got.Elem(), reflect.ValueOf(v).Elem())
+			}
+		}
+	}
+}
+
+func TestMessagePackEncoding(t *testing.T) {
+	data, err := MessagePack.Marshal(Checkpoint{N: -1, Value: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// fixmap of 2, "n": -1 as a negative fixint, "value": 0.5 as a float64.
+	want := "82" + "a16e" + "ff" + "a576616c7565" + "cb3fe0000000000000"
+	if got := hex.EncodeToString(data); got != want {
+		t.Errorf("Checkpoint encoded as %s, want %s", got, want)
+	}
+
+	// Floats keep their bits, NaN included, and omitempty leaves c out.
+	data, err = MessagePack.Marshal(Response{R: math.NaN(), N: 300, Result: math.Inf(-1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("\xa1c")) {
+		t.Errorf("empty c encoded: %x", data)
+	}
+	var resp Response
+	if err := MessagePack.Unmarshal(data, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !math.IsNaN(resp.R) || resp.N != 300 || !math.IsInf(resp.Result, -1) {
+		t.Errorf("decoded %+v", resp)
+	}
+}
+
+func TestMessagePackDecodeErrors(t *testing.T) {
+	var req Request
+	for name, data := range map[string]string{
+		"truncated":     "82a172cb3fe0",
+		"trailing":      "80c0",
+		"wrong type":    "81a16ea3616263",
+		"overflow":      "81a16ecf8000000000000000",
+		"huge length":   "dfffffffff",
+		"not a pointer": "",
+	} {
+		raw, _ := hex.DecodeString(data)
+		var err error
+		if name == "not a pointer" {
+			err = MessagePack.Unmarshal(raw, req)
+		} else {
+			err = MessagePack.Unmarshal(raw, &req)
+		}
+		if err == nil {
+			t.Errorf("%s: decoded %x without error", name, raw)
+		}
+	}
+
+	// Unknown keys are skipped, and keys match case-insensitively as in JSON.
+	raw, _ := hex.DecodeString("83" + "a3787878" + "92c3a0" + "a152" + "cb400c000000000000" + "a16e" + "cd0100")
+	if err := MessagePack.Unmarshal(raw, &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.R != 3.5 || req.N != 256 {
+		t.Errorf("decoded %+v, want r=3.5 n=256", req)
+	}
+}
+
+func TestRegisterCodec(t *testing.T) {
+	if err := RegisterCodec(MessagePack); err == nil {
+		t.Error("registered a second codec for application/msgpack")
+	}
+	if c, ok := CodecFor("application/msgpack"); !ok || c != MessagePack {
+		t.Errorf("CodecFor(application/msgpack) = %v, %v", c, ok)
+	}
+	if _, ok := CodecFor("application/x-protobuf"); ok {
+		t.Error("found a codec for an unregistered type")
+	}
+}
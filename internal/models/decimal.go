@@ -0,0 +1,95 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+)
+
+// ErrInvalidR is returned for an r that is not a finite decimal number.
+var ErrInvalidR = errors.New("invalid r")
+
+// decimalNumber is the syntax of a JSON number, which r strings share.
+var decimalNumber = regexp.MustCompile(`^-?(?:0|[1-9][0-9]*)(?:\.[0-9]+)?(?:[eE]([+-]?[0-9]+))?$`)
+
+// maxDecimalExponent bounds the exponent of a decimal r, which keeps the
+// exact comparison in ParseR cheap. Anything beyond it is far outside the
+// float64 range anyway.
+const maxDecimalExponent = 10000
+
+// ParseR reads r from a decimal, which may carry more digits than a float64
+// holds. It returns the float64 nearest to it, and whether the decimal was
+// rounded: whether it differs from the shortest decimal of that float64,
+// which is how strconv and encoding/json write it. "3.7" and "3.70" are not
+// rounded, though neither is exactly a float64; "3.14159265358979323846"
+// is.
+func ParseR(s string) (r float64, rounded bool, err error) {
+	m := decimalNumber.FindStringSubmatch(s)
+	if m == nil {
+		return 0, false, fmt.Errorf("%w: %q is not a decimal number", ErrInvalidR, s)
+	}
+	if exp, err := strconv.Atoi(m[1]); m[1] != "" && (err != nil || exp > maxDecimalExponent || exp < -maxDecimalExponent) {
+		return 0, false, fmt.Errorf("%w: exponent of %q out of range", ErrInvalidR, s)
+	}
+	r, err = strconv.ParseFloat(s, 64)
+	if err != nil || math.IsInf(r, 0) {
+		return 0, false, fmt.Errorf("%w: %q is outside the float64 range", ErrInvalidR, s)
+	}
+	given, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, false, fmt.Errorf("%w: %q is not a decimal number", ErrInvalidR, s)
+	}
+	shortest, _ := new(big.Rat).SetString(strconv.FormatFloat(r, 'g', -1, 64))
+	return r, given.Cmp(shortest) != 0, nil
+}
+
+// ExactDecimal returns every digit of the finite float64 f, which a
+// shortest representation such as strconv's leaves out: 0.1 is
+// 0.1000000000000000055511151231257827021181583404541015625.
+func ExactDecimal(f float64) string {
+	rat := new(big.Rat).SetFloat64(f)
+	if rat == nil {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	// The denominator is a power of two, 2^k, which takes exactly k decimal
+	// places.
+	return rat.FloatString(rat.Denom().BitLen() - 1)
+}
+
+// UnmarshalJSON reads r as a JSON number or as a string holding one, for
+// clients with more digits than a float64 holds. Either way R is the nearest
+// float64, and RRounded is set when ParseR rounded it. An r sent as a
+// string, or rounded, sets RUsed.
+func (req *Request) UnmarshalJSON(data []byte) error {
+	type plain Request
+	var aux struct {
+		*plain
+		R json.RawMessage `json:"r"`
+	}
+	aux.plain = (*plain)(req)
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if aux.R == nil || string(aux.R) == "null" {
+		return nil
+	}
+
+	text := string(aux.R)
+	quoted := aux.R[0] == '"'
+	if quoted {
+		if err := json.Unmarshal(aux.R, &text); err != nil {
+			return err
+		}
+	}
+	r, rounded, err := ParseR(text)
+	if err != nil {
+		return err
+	}
+	req.R, req.RRounded = r, rounded
+	req.RUsed = req.RUsed || quoted || rounded
+	return nil
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseR(t *testing.T) {
+	cases := []struct {
+		in      string
+		r       float64
+		rounded bool
+	}{
+		{"3.7", 3.7, false},
+		{"3.70", 3.7, false},
+		{"37e-1", 3.7, false},
+		{"4", 4, false},
+		{"3.14159265358979323846", math.Pi, true},
+		{"3.141592653589793", math.Pi, false},
+		{"0.1000000000000000055511151231257827021181583404541015625", 0.1, true},
+	}
+	for _, tc := range cases {
+		r, rounded, err := ParseR(tc.in)
+		if err != nil || r != tc.r || rounded != tc.rounded {
+			t.Errorf("ParseR(%q) = %v, %v, %v; want %v, %v", tc.in, r, rounded, err, tc.r, tc.rounded)
+		}
+	}
+	for _, in := range []string{"", "x", "3.7abc", " 3.7", "+3.7", "0x1p2", "NaN", "Inf", "1e400", "1e99999999"} {
+		if _, _, err := ParseR(in); !errors.Is(err, ErrInvalidR) {
+			t.Errorf("ParseR(%q) error = %v, want ErrInvalidR", in, err)
+		}
+	}
+}
+
+func TestExactDecimal(t *testing.T) {
+	cases := map[float64]string{
+		0.1:  "0.1000000000000000055511151231257827021181583404541015625",
+		4:    "4",
+		3.5:  "3.5",
+		-0.5: "-0.5",
+	}
+	for f, want := range cases {
+		if got := ExactDecimal(f); got != want {
+			t.Errorf("ExactDecimal(%v) = %q, want %q", f, got, want)
+		}
+	}
+}
+
+func TestRequestDecodesRString(t *testing.T) {
+	var reqs []Request
+	body := `[{"r": "3.14159265358979323846", "n": 5}, {"r": "3.5", "n": 5}, {"r": 3.5, "n": 5}, {"r": 3.5, "n": 5, "r_used": true}]`
+	if err := json.Unmarshal([]byte(body), &reqs); err != nil {
+		t.Fatal(err)
+	}
+	want := []Request{
+		{R: math.Pi, N: 5, RUsed: true, RRounded: true},
+		{R: 3.5, N: 5, RUsed: true},
+		{R: 3.5, N: 5},
+		{R: 3.5, N: 5, RUsed: true},
+	}
+	for i, req := range reqs {
+		if req.R != want[i].R || req.N != want[i].N || req.RUsed != want[i].RUsed || req.RRounded != want[i].RRounded {
+			t.Errorf("request %d = %+v, want %+v", i, req, want[i])
+		}
+	}
+
+	var req Request
+	if err := json.Unmarshal([]byte(`{"r": "three", "n": 5}`), &req); !errors.Is(err, ErrInvalidR) {
+		t.Errorf("r as a non-number string: error = %v, want ErrInvalidR", err)
+	}
+}
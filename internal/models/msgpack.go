@@ -0,0 +1,638 @@
+package models
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MessagePack encodes the models as MessagePack (https://msgpack.org).
+// Structs become maps keyed by their JSON field names, with omitempty and
+// "-" honoured as encoding/json does, so a payload carries the same keys in
+// either format. Integers take their smallest encoding, float64 values are
+// always written as 64-bit floats, so they round-trip exactly, NaN and
+// infinities included, and map keys are sorted, so equal values encode to
+// equal bytes. Decoding accepts any integer or float encoding that fits the
+// target and skips unknown keys. Embedded structs are not flattened.
+var MessagePack Codec = msgpackCodec{}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string { return "application/msgpack" }
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	return appendMsgpack(nil, reflect.ValueOf(v))
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return errors.New("msgpack: Unmarshal needs a non-nil pointer")
+	}
+	d := msgpackDecoder{data: data}
+	if err := d.decode(rv.Elem()); err != nil {
+		return err
+	}
+	if d.off != len(data) {
+		return fmt.Errorf("msgpack: %d bytes after the value", len(data)-d.off)
+	}
+	return nil
+}
+
+// MessagePack type codes; the fix* ones carry a small value or length in
+// their low bits.
+const (
+	mpFixmap    = 0x80
+	mpFixarray  = 0x90
+	mpFixstr    = 0xa0
+	mpNil       = 0xc0
+	mpFalse     = 0xc2
+	mpTrue      = 0xc3
+	mpBin8      = 0xc4
+	mpBin16     = 0xc5
+	mpBin32     = 0xc6
+	mpFloat32   = 0xca
+	mpFloat64   = 0xcb
+	mpUint8     = 0xcc
+	mpUint16    = 0xcd
+	mpUint32    = 0xce
+	mpUint64    = 0xcf
+	mpInt8      = 0xd0
+	mpInt16     = 0xd1
+	mpInt32     = 0xd2
+	mpInt64     = 0xd3
+	mpStr8      = 0xd9
+	mpStr16     = 0xda
+	mpStr32     = 0xdb
+	mpArray16   = 0xdc
+	mpArray32   = 0xdd
+	mpMap16     = 0xde
+	mpMap32     = 0xdf
+	mpNegFixint = 0xe0
+)
+
+// msgpackField is a struct field as it is keyed on the wire.
+type msgpackField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var msgpackFieldCache sync.Map // reflect.Type -> []msgpackField
+
+var anyType = reflect.TypeOf((*any)(nil)).Elem()
+
+func msgpackFields(t reflect.Type) []msgpackField {
+	if fields, ok := msgpackFieldCache.Load(t); ok {
+		return fields.([]msgpackField)
+	}
+	var fields []msgpackField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		omitEmpty := false
+		for _, opt := range strings.Split(opts, ",") {
+			omitEmpty = omitEmpty || opt == "omitempty"
+		}
+		fields = append(fields, msgpackField{name: name, index: f.Index, omitEmpty: omitEmpty})
+	}
+	msgpackFieldCache.Store(t, fields)
+	return fields
+}
+
+// isEmptyValue reports whether omitempty leaves v out, as for encoding/json.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Pointer:
+		return v.IsNil()
+	}
+	return false
+}
+
+func appendMsgpack(b []byte, v reflect.Value) ([]byte, error) {
+	if !v.IsValid() {
+		return append(b, mpNil), nil
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return append(b, mpNil), nil
+		}
+		return appendMsgpack(b, v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			return append(b, mpTrue), nil
+		}
+		return append(b, mpFalse), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendMsgpackInt(b, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return appendMsgpackUint(b, v.Uint()), nil
+	case reflect.Float32:
+		return binary.BigEndian.AppendUint32(append(b, mpFloat32), math.Float32bits(float32(v.Float()))), nil
+	case reflect.Float64:
+		return binary.BigEndian.AppendUint64(append(b, mpFloat64), math.Float64bits(v.Float())), nil
+	case reflect.String:
+		return appendMsgpackString(b, v.String()), nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return append(b, mpNil), nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return appendMsgpackBin(b, v.Bytes()), nil
+		}
+		return appendMsgpackArray(b, v)
+	case reflect.Array:
+		return appendMsgpackArray(b, v)
+	case reflect.Map:
+		if v.IsNil() {
+			return append(b, mpNil), nil
+		}
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("msgpack: unsupported map key type %s", v.Type().Key())
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		b = appendMsgpackLen(b, len(keys), mpFixmap, mpMap16)
+		var err error
+		for _, k := range keys {
+			b = appendMsgpackString(b, k.String())
+			if b, err = appendMsgpack(b, v.MapIndex(k)); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case reflect.Struct:
+		var kept []msgpackField
+		for _, f := range msgpackFields(v.Type()) {
+			if !f.omitEmpty || !isEmptyValue(v.FieldByIndex(f.index)) {
+				kept = append(kept, f)
+			}
+		}
+		b = appendMsgpackLen(b, len(kept), mpFixmap, mpMap16)
+		var err error
+		for _, f := range kept {
+			b = appendMsgpackString(b, f.name)
+			if b, err = appendMsgpack(b, v.FieldByIndex(f.index)); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type %s", v.Type())
+}
+
+func appendMsgpackArray(b []byte, v reflect.Value) ([]byte, error) {
+	b = appendMsgpackLen(b, v.Len(), mpFixarray, mpArray16)
+	var err error
+	for i := 0; i < v.Len(); i++ {
+		if b, err = appendMsgpack(b, v.Index(i)); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// appendMsgpackLen writes an array or map header. The 32-bit form of either
+// follows its 16-bit form.
+func appendMsgpackLen(b []byte, n int, fix, code16 byte) []byte {
+	switch {
+	case n < 16:
+		return append(b, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, code16), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(b, code16+1), uint32(n))
+	}
+}
+
+func appendMsgpackString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, mpFixstr|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, mpStr8, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, mpStr16), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, mpStr32), uint32(n))
+	}
+	return append(b, s...)
+}
+
+func appendMsgpackBin(b []byte, data []byte) []byte {
+	switch n := len(data); {
+	case n <= math.MaxUint8:
+		b = append(b, mpBin8, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, mpBin16), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, mpBin32), uint32(n))
+	}
+	return append(b, data...)
+}
+
+func appendMsgpackInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0:
+		return appendMsgpackUint(b, uint64(i))
+	case i >= -32:
+		return append(b, byte(i))
+	case i >= math.MinInt8:
+		return append(b, mpInt8, byte(i))
+	case i >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(b, mpInt16), uint16(i))
+	case i >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(b, mpInt32), uint32(i))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, mpInt64), uint64(i))
+	}
+}
+
+func appendMsgpackUint(b []byte, u uint64) []byte {
+	switch {
+	case u < mpFixmap:
+		return append(b, byte(u))
+	case u <= math.MaxUint8:
+		return append(b, mpUint8, byte(u))
+	case u <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, mpUint16), uint16(u))
+	case u <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, mpUint32), uint32(u))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, mpUint64), u)
+	}
+}
+
+type msgpackDecoder struct {
+	data []byte
+	off  int
+}
+
+func (d *msgpackDecoder) read(n int) ([]byte, error) {
+	if n < 0 || n > len(d.data)-d.off {
+		return nil, fmt.Errorf("msgpack: %w", io.ErrUnexpectedEOF)
+	}
+	p := d.data[d.off : d.off+n]
+	d.off += n
+	return p, nil
+}
+
+func (d *msgpackDecoder) peek() (byte, error) {
+	if d.off >= len(d.data) {
+		return 0, fmt.Errorf("msgpack: %w", io.ErrUnexpectedEOF)
+	}
+	return d.data[d.off], nil
+}
+
+// uintN reads a big-endian unsigned integer of size bytes.
+func (d *msgpackDecoder) uintN(size int) (uint64, error) {
+	p, err := d.read(size)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, c := range p {
+		u = u<<8 | uint64(c)
+	}
+	return u, nil
+}
+
+func mismatch(c byte, t reflect.Type) error {
+	return fmt.Errorf("msgpack: cannot decode type 0x%02x into %s", c, t)
+}
+
+// integer reads any integer encoding. For a negative one, neg is set and u
+// holds its two's complement bits.
+func (d *msgpackDecoder) integer(t reflect.Type) (u uint64, neg bool, err error) {
+	c, _ := d.peek()
+	d.off++
+	switch {
+	case c < mpFixmap:
+		return uint64(c), false, nil
+	case c >= mpNegFixint:
+		return uint64(int64(int8(c))), true, nil
+	case c >= mpUint8 && c <= mpUint64:
+		u, err = d.uintN(1 << (c - mpUint8))
+		return u, false, err
+	case c >= mpInt8 && c <= mpInt64:
+		size := 1 << (c - mpInt8)
+		if u, err = d.uintN(size); err != nil {
+			return 0, false, err
+		}
+		shift := 64 - 8*size
+		i := int64(u<<shift) >> shift
+		return uint64(i), i < 0, nil
+	}
+	d.off--
+	return 0, false, mismatch(c, t)
+}
+
+func (d *msgpackDecoder) float(t reflect.Type) (float64, error) {
+	c, _ := d.peek()
+	switch c {
+	case mpFloat32:
+		d.off++
+		u, err := d.uintN(4)
+		return float64(math.Float32frombits(uint32(u))), err
+	case mpFloat64:
+		d.off++
+		u, err := d.uintN(8)
+		return math.Float64frombits(u), err
+	}
+	u, neg, err := d.integer(t)
+	if neg {
+		return float64(int64(u)), err
+	}
+	return float64(u), err
+}
+
+// length reads the header of a string, binary, array or map, whichever
+// kind is, and returns its length.
+func (d *msgpackDecoder) length(kind reflect.Kind, t reflect.Type) (int, error) {
+	c, _ := d.peek()
+	d.off++
+	var n uint64
+	var err error
+	switch {
+	case kind == reflect.String && c&0xe0 == mpFixstr:
+		n = uint64(c & 0x1f)
+	case kind == reflect.String && c >= mpStr8 && c <= mpStr32:
+		n, err = d.uintN(1 << (c - mpStr8))
+	case kind == reflect.Uint8 && c >= mpBin8 && c <= mpBin32:
+		n, err = d.uintN(1 << (c - mpBin8))
+	case kind == reflect.Slice && c&0xf0 == mpFixarray:
+		n = uint64(c & 0x0f)
+	case kind == reflect.Slice && (c == mpArray16 || c == mpArray32):
+		n, err = d.uintN(2 << (c - mpArray16))
+	case kind == reflect.Map && c&0xf0 == mpFixmap:
+		n = uint64(c & 0x0f)
+	case kind == reflect.Map && (c == mpMap16 || c == mpMap32):
+		n, err = d.uintN(2 << (c - mpMap16))
+	default:
+		d.off--
+		return 0, mismatch(c, t)
+	}
+	if err != nil {
+		return 0, err
+	}
+	// Every element takes at least a byte, which bounds what a corrupt
+	// length can make us allocate.
+	if n > uint64(len(d.data)-d.off) {
+		return 0, fmt.Errorf("msgpack: %w", io.ErrUnexpectedEOF)
+	}
+	return int(n), nil
+}
+
+func (d *msgpackDecoder) string(t reflect.Type) (string, error) {
+	n, err := d.length(reflect.String, t)
+	if err != nil {
+		return "", err
+	}
+	p, err := d.read(n)
+	return string(p), err
+}
+
+func (d *msgpackDecoder) decode(v reflect.Value) error {
+	c, err := d.peek()
+	if err != nil {
+		return err
+	}
+	if c == mpNil {
+		// As with JSON null, nil clears what can be nil and leaves the rest.
+		d.off++
+		switch v.Kind() {
+		case reflect.Pointer, reflect.Interface, reflect.Slice, reflect.Map:
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return nil
+	}
+
+	t := v.Type()
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(t.Elem()))
+		}
+		return d.decode(v.Elem())
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return fmt.Errorf("msgpack: unsupported type %s", t)
+		}
+		x, err := d.any()
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(x))
+		return nil
+	case reflect.Bool:
+		if c != mpFalse && c != mpTrue {
+			return mismatch(c, t)
+		}
+		d.off++
+		v.SetBool(c == mpTrue)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		u, neg, err := d.integer(t)
+		if err != nil {
+			return err
+		}
+		if (!neg && u > math.MaxInt64) || v.OverflowInt(int64(u)) {
+			return fmt.Errorf("msgpack: %d overflows %s", u, t)
+		}
+		v.SetInt(int64(u))
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, neg, err := d.integer(t)
+		if err != nil {
+			return err
+		}
+		if neg || v.OverflowUint(u) {
+			return fmt.Errorf("msgpack: %d overflows %s", int64(u), t)
+		}
+		v.SetUint(u)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		f, err := d.float(t)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+		return nil
+	case reflect.String:
+		s, err := d.string(t)
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+		return nil
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			n, err := d.length(reflect.Uint8, t)
+			if err != nil {
+				return err
+			}
+			p, err := d.read(n)
+			v.SetBytes(append([]byte(nil), p...))
+			return err
+		}
+		n, err := d.length(reflect.Slice, t)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.MakeSlice(t, n, n))
+		for i := 0; i < n; i++ {
+			if err := d.decode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Array:
+		n, err := d.length(reflect.Slice, t)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.Zero(t))
+		for i := 0; i < n; i++ {
+			if i >= v.Len() {
+				if _, err := d.any(); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := d.decode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return fmt.Errorf("msgpack: unsupported map key type %s", t.Key())
+		}
+		n, err := d.length(reflect.Map, t)
+		if err != nil {
+			return err
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(t, n))
+		}
+		for i := 0; i < n; i++ {
+			k, err := d.string(t.Key())
+			if err != nil {
+				return err
+			}
+			elem := reflect.New(t.Elem()).Elem()
+			if err := d.decode(elem); err != nil {
+				return err
+			}
+			v.SetMapIndex(reflect.ValueOf(k).Convert(t.Key()), elem)
+		}
+		return nil
+	case reflect.Struct:
+		n, err := d.length(reflect.Map, t)
+		if err != nil {
+			return err
+		}
+		fields := msgpackFields(t)
+		for i := 0; i < n; i++ {
+			k, err := d.string(reflect.TypeOf(""))
+			if err != nil {
+				return err
+			}
+			f := fieldNamed(fields, k)
+			if f == nil {
+				if _, err := d.any(); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := d.decode(v.FieldByIndex(f.index)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("msgpack: unsupported type %s", t)
+}
+
+// fieldNamed finds the field keyed name, preferring an exact match and
+// otherwise matching case-insensitively, as encoding/json does.
+func fieldNamed(fields []msgpackField, name string) *msgpackField {
+	var fold *msgpackField
+	for i := range fields {
+		if fields[i].name == name {
+			return &fields[i]
+		}
+		if fold == nil && strings.EqualFold(fields[i].name, name) {
+			fold = &fields[i]
+		}
+	}
+	return fold
+}
+
+// any decodes the next value into the types encoding/json uses for an
+// interface{}, except that integers become int64 or uint64 and binary
+// becomes []byte. It is also how unknown values are skipped.
+func (d *msgpackDecoder) any() (any, error) {
+	c, err := d.peek()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case c == mpNil:
+		d.off++
+		return nil, nil
+	case c == mpFalse || c == mpTrue:
+		d.off++
+		return c == mpTrue, nil
+	case c == mpFloat32 || c == mpFloat64:
+		return d.float(anyType)
+	case c < mpFixmap || c >= mpNegFixint || (c >= mpUint8 && c <= mpInt64):
+		u, neg, err := d.integer(anyType)
+		if neg {
+			return int64(u), err
+		}
+		if u <= math.MaxInt64 {
+			return int64(u), err
+		}
+		return u, err
+	case c&0xe0 == mpFixstr || (c >= mpStr8 && c <= mpStr32):
+		return d.string(anyType)
+	case c >= mpBin8 && c <= mpBin32:
+		var p []byte
+		err := d.decode(reflect.ValueOf(&p).Elem())
+		return p, err
+	case c&0xf0 == mpFixarray || c == mpArray16 || c == mpArray32:
+		var a []any
+		err := d.decode(reflect.ValueOf(&a).Elem())
+		return a, err
+	case c&0xf0 == mpFixmap || c == mpMap16 || c == mpMap32:
+		var m map[string]any
+		err := d.decode(reflect.ValueOf(&m).Elem())
+		return m, err
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type 0x%02x", c)
+}
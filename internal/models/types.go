@@ -2,11 +2,504 @@
 
 type Request struct {
     R float64 `json:"r"`
-    N int     `json:"n"`
+    N int64   `json:"n"`
+
+    // C, when non-zero, adds a constant each step: x = r*x*(1-x) + c.
+    C float64 `json:"c,omitempty"`
+
+    // BudgetMs, when positive, bounds the wall-clock time spent on this
+    // point; the result may then be partial.
+    BudgetMs int `json:"budget_ms,omitempty"`
+
+    // IncludeCheckpoints returns the checkpoint-aligned points of the
+    // compute in Response.Checkpoints.
+    IncludeCheckpoints bool `json:"include_checkpoints,omitempty"`
+
+    // Debug reports in Response.ResumedFrom where the compute started.
+    Debug bool `json:"debug,omitempty"`
+
+    // Clamp keeps x in [0, 1] after every step, which changes the orbit
+    // wherever the unclamped one would leave it; see engine.ClampX.
+    Clamp bool `json:"clamp,omitempty"`
+
+    // Provenance returns in Response.Provenance what the result depends
+    // on, to reproduce it later.
+    Provenance bool `json:"provenance,omitempty"`
+
+    // RUsed returns in Response.RUsed every digit of the float64 R. It is
+    // also set when r is sent as a string or rounded; see UnmarshalJSON.
+    RUsed bool `json:"r_used,omitempty"`
+
+    // RRounded is set by decoding when r had more digits than a float64
+    // holds; see ParseR.
+    RRounded bool `json:"-"`
 }
 
 type Response struct {
     R      float64 `json:"r"`
-    N      int     `json:"n"`
+    N      int64   `json:"n"`
+    C      float64 `json:"c,omitempty"`
     Result float64 `json:"result"`
+
+    // RUsed, when requested, is the exact decimal value of R, the float64
+    // the result was computed for.
+    RUsed string `json:"r_used,omitempty"`
+
+    // Clamped is set when x was clamped into [0, 1] at every step.
+    Clamped bool `json:"clamped,omitempty"`
+
+    // Partial is set when the budget ran out first; Result is then x at
+    // ReachedN rather than at N.
+    Partial  bool  `json:"partial,omitempty"`
+    ReachedN int64 `json:"reached_n,omitempty"`
+
+    // RBudgetMs is set on a partial result when the batch's per-r budget,
+    // rather than BudgetMs, ran out: it is that budget.
+    RBudgetMs int64 `json:"r_budget_ms,omitempty"`
+
+    // Error is set, and Result left zero, when a point in an aligned
+    // response could not be computed. OwnerPod is set alongside it when the
+    // pod refused an r owned by another pod under strict sharding.
+    Error    string `json:"error,omitempty"`
+    OwnerPod *int   `json:"owner_pod,omitempty"`
+
+    // Signature is the hex HMAC of the result when result signing is
+    // enabled; see pkg/signature.
+    Signature string `json:"signature,omitempty"`
+
+    // Checkpoints are the checkpoint-aligned points the compute resumed
+    // from or passed, when requested.
+    Checkpoints []Checkpoint `json:"checkpoints,omitempty"`
+
+    // ResumedFrom, when debugging was requested, is the n the compute
+    // resumed from: a checkpoint or cached step, 0 for a compute from x0, or
+    // -1 when x_n itself was cached in L1.
+    ResumedFrom *int64 `json:"resumed_from,omitempty"`
+
+    // Provenance, when requested, describes how Result was computed.
+    Provenance *Provenance `json:"provenance,omitempty"`
+}
+
+// Provenance is everything a logistic result depends on, so it can be
+// reproduced exactly even after the engine's defaults change. RBits and
+// CBits are the hex IEEE-754 bits of r and c; CBits is empty when c is 0.
+type Provenance struct {
+    Map           string  `json:"map"`
+    RBits         string  `json:"r_bits"`
+    CBits         string  `json:"c_bits,omitempty"`
+    X0            float64 `json:"x0"`
+    Precision     string  `json:"precision"`
+    Arithmetic    string  `json:"arithmetic"`
+    Arch          string  `json:"arch"`
+    EngineVersion string  `json:"engine_version"`
+}
+
+// Checkpoint is one checkpoint-aligned point of a series.
+type Checkpoint struct {
+    N     int64   `json:"n"`
+    Value float64 `json:"value"`
+}
+
+// BatchMeta summarizes how a POST /calculate batch was answered.
+// TotalIterations counts map steps actually taken; CacheHits counts items
+// answered from L1 without iterating.
+type BatchMeta struct {
+    PodID           string `json:"pod_id"`
+    TotalIterations int64  `json:"total_iterations"`
+    CacheHits       int    `json:"cache_hits"`
+    ElapsedMs       int64  `json:"elapsed_ms"`
+}
+
+// BatchEnvelope is the body of POST /calculate?envelope=true.
+type BatchEnvelope struct {
+    Meta    BatchMeta  `json:"meta"`
+    Results []Response `json:"results"`
+}
+
+// ProgressEvent is the payload of a /calculate/stream progress event.
+type ProgressEvent struct {
+    N       int64   `json:"n"`
+    Percent float64 `json:"percent"`
+}
+
+// MultiRRequest asks for the same n at several arbitrary r values.
+type MultiRRequest struct {
+    Rs []float64 `json:"rs"`
+    N  int64     `json:"n"`
+}
+
+// RemoteRequest names a dataset of requests, one JSON object per line or a
+// JSON array, for the server to fetch and compute.
+type RemoteRequest struct {
+    URL string `json:"url"`
+}
+
+// MapsRequest asks for x_n at the same (r, n) under several map kinds.
+type MapsRequest struct {
+    R    float64  `json:"r"`
+    N    int64    `json:"n"`
+    Maps []string `json:"maps"`
+}
+
+// MapsResponse holds x_n keyed by map kind. A kind that could not be
+// computed is left out of Results and its error given in Errors.
+type MapsResponse struct {
+    R       float64            `json:"r"`
+    N       int64              `json:"n"`
+    Results map[string]float64 `json:"results"`
+    Errors  map[string]string  `json:"errors,omitempty"`
+}
+
+type TrajectoryCompareRequest struct {
+    R1     float64 `json:"r1"`
+    R2     float64 `json:"r2"`
+    N      int64   `json:"n"`
+    Stride int64   `json:"stride"`
+}
+
+type TrajectoryPair struct {
+    N  int64   `json:"n"`
+    X1 float64 `json:"x1"`
+    X2 float64 `json:"x2"`
+}
+
+type TrajectoryCompareResponse struct {
+    Points []TrajectoryPair `json:"points"`
+}
+
+// LogTrajectoryRequest asks for x at n = 1, Base, Base^2, ... up to MaxN.
+// Base defaults to 2.
+type LogTrajectoryRequest struct {
+    R    float64 `json:"r"`
+    MaxN int64   `json:"max_n"`
+    Base int64   `json:"base,omitempty"`
+}
+
+type TrajectoryPoint struct {
+    N int64   `json:"n"`
+    X float64 `json:"x"`
+}
+
+type LogTrajectoryResponse struct {
+    R      float64           `json:"r"`
+    Base   int64             `json:"base"`
+    Points []TrajectoryPoint `json:"points"`
+}
+
+// TimeAverageRequest asks for the mean of x_1..x_N at R, and their variance
+// if Variance is set.
+type TimeAverageRequest struct {
+    R        float64 `json:"r"`
+    N        int64   `json:"n"`
+    Variance bool    `json:"variance,omitempty"`
+}
+
+type TimeAverageResponse struct {
+    R        float64  `json:"r"`
+    N        int64    `json:"n"`
+    Mean     float64  `json:"mean"`
+    Variance *float64 `json:"variance,omitempty"`
+}
+
+// Job statuses reported by the async compute endpoints.
+const (
+    JobQueued    = "queued"
+    JobRunning   = "running"
+    JobDone      = "done"
+    JobFailed    = "failed"
+    JobCancelled = "cancelled"
+)
+
+type Job struct {
+    ID      string     `json:"id"`
+    Status  string     `json:"status"`
+    Results []Response `json:"results,omitempty"`
+    Error   string     `json:"error,omitempty"`
+}
+
+// KeyInfo summarizes one cached series. R, C and X0 are what it was
+// computed from, and are left out when this pod did not compute it, as for
+// series preheated from Redis, whose key cannot be read back.
+type KeyInfo struct {
+    RHash   uint64   `json:"r_hash"`
+    R       *float64 `json:"r,omitempty"`
+    C       float64  `json:"c,omitempty"`
+    X0      *float64 `json:"x0,omitempty"`
+    Entries int      `json:"entries"`
+    MaxN    int64    `json:"max_n"`
+}
+
+type KeysResponse struct {
+    Total  int       `json:"total"`
+    Offset int       `json:"offset"`
+    Keys   []KeyInfo `json:"keys"`
+}
+
+// ReplayRequest asks to re-verify the checkpoint stored at N. Tolerance is
+// the largest accepted |stored - recomputed|.
+type ReplayRequest struct {
+    R         float64 `json:"r"`
+    C         float64 `json:"c,omitempty"`
+    N         int64   `json:"n"`
+    Tolerance float64 `json:"tolerance,omitempty"`
+}
+
+type ReplayResponse struct {
+    R           float64 `json:"r"`
+    N           int64   `json:"n"`
+    FromN       int64   `json:"from_n"`
+    Stored      float64 `json:"stored"`
+    Recomputed  float64 `json:"recomputed"`
+    Discrepancy float64 `json:"discrepancy"`
+    Match       bool    `json:"match"`
+}
+
+// FlushResponse reports how many checkpoints a manual flush wrote.
+type FlushResponse struct {
+    Checkpoints int `json:"checkpoints"`
+}
+
+// PurgeRequest selects checkpoints to delete: those last written more than
+// OlderThan (a duration such as "6h") ago, or every one with All.
+type PurgeRequest struct {
+    OlderThan string `json:"older_than,omitempty"`
+    All       bool   `json:"all,omitempty"`
+}
+
+type PurgeResponse struct {
+    Deleted int `json:"deleted"`
+}
+
+type DensityRequest struct {
+    R         float64 `json:"r"`
+    N         int     `json:"n"`
+    Bins      int     `json:"bins"`
+    Transient int     `json:"transient"`
+}
+
+type DensityResponse struct {
+    Counts   []int   `json:"counts"`
+    BinWidth float64 `json:"bin_width"`
+    Samples  int     `json:"samples"`
+    Outside  int     `json:"outside"`
+}
+
+// BifurcationRequest scans Steps+1 r values over [RMin, RMax] for period
+// doublings.
+type BifurcationRequest struct {
+    RMin      float64 `json:"r_min"`
+    RMax      float64 `json:"r_max"`
+    Steps     int     `json:"steps"`
+    Transient int     `json:"transient,omitempty"`
+    MaxPeriod int     `json:"max_period,omitempty"`
+    Tolerance float64 `json:"tolerance,omitempty"`
+}
+
+// ShardMapRequest lists the r values to look up, either as Rs or as the
+// Steps+1 evenly spaced values over [RMin, RMax].
+type ShardMapRequest struct {
+    Rs    []float64 `json:"rs,omitempty"`
+    RMin  float64   `json:"r_min,omitempty"`
+    RMax  float64   `json:"r_max,omitempty"`
+    Steps int       `json:"steps,omitempty"`
+}
+
+// ShardAssignment is the index of the pod that owns R.
+type ShardAssignment struct {
+    R   float64 `json:"r"`
+    Pod int     `json:"pod"`
+}
+
+// ShardMapResponse gives the owner of each requested r, in request order,
+// and Counts, how many of them each of the TotalPods pods owns.
+type ShardMapResponse struct {
+    TotalPods   int               `json:"total_pods"`
+    Assignments []ShardAssignment `json:"assignments"`
+    Counts      []int             `json:"counts"`
+}
+
+// Bifurcation is a detected period doubling: the period is Period at R and
+// half that at PreviousR, the last scanned r with a detected period.
+type Bifurcation struct {
+    R         float64 `json:"r"`
+    PreviousR float64 `json:"previous_r"`
+    Period    int     `json:"period"`
+}
+
+type BifurcationResponse struct {
+    Bifurcations []Bifurcation `json:"bifurcations"`
+}
+
+// TransientRequest asks how long the orbit of X0 under R takes to settle
+// onto a cycle of period up to MaxPeriod, checking up to MaxN. X0 defaults
+// to the configured x_0.
+type TransientRequest struct {
+    R         float64  `json:"r"`
+    X0        *float64 `json:"x0,omitempty"`
+    MaxN      int      `json:"max_n,omitempty"`
+    MaxPeriod int      `json:"max_period,omitempty"`
+    Tolerance float64  `json:"tolerance,omitempty"`
+}
+
+// TransientResponse gives the first n of the settled orbit and its period.
+// TransientLength is -1 and Period 0 when it had not settled by CheckedN.
+type TransientResponse struct {
+    R               float64 `json:"r"`
+    X0              float64 `json:"x0"`
+    TransientLength int     `json:"transient_length"`
+    Period          int     `json:"period"`
+    CheckedN        int     `json:"checked_n"`
+}
+
+// BasinRequest asks for the attractor of every cell of a grid of Steps+1 r
+// values over RRange by Steps+1 x_0 values over X0Range. Each cell discards
+// Transient steps and then looks for a period up to N/2 in the next N.
+type BasinRequest struct {
+    RRange    [2]float64 `json:"r_range"`
+    X0Range   [2]float64 `json:"x0_range"`
+    Steps     int        `json:"steps"`
+    N         int        `json:"n,omitempty"`
+    Transient int        `json:"transient,omitempty"`
+}
+
+// BasinCell is the attractor the orbit of X0 under R settles onto. Value is
+// the smallest point of the cycle, so cells on the same attractor agree
+// whatever its phase; with Period 0, when no period was found, it is x
+// after the transient. Escaped is set, and Value is 0, when the orbit left
+// [0, 1].
+type BasinCell struct {
+    R       float64 `json:"r"`
+    X0      float64 `json:"x0"`
+    Value   float64 `json:"value"`
+    Period  int     `json:"period"`
+    Escaped bool    `json:"escaped,omitempty"`
+}
+
+// BasinResponse lists the cells r-major: every x_0 of the first r, then of
+// the next.
+type BasinResponse struct {
+    Cells []BasinCell `json:"cells"`
+}
+
+// SensitivityRequest asks for dx_N/dx_0 along the orbit of X0 under R. X0
+// defaults to the configured x_0.
+type SensitivityRequest struct {
+    R  float64  `json:"r"`
+    X0 *float64 `json:"x0,omitempty"`
+    N  int      `json:"n"`
+}
+
+// SensitivityResponse gives dx_N/dx_0 as its sign and the natural log of
+// its magnitude. LogAbs is absent when the derivative is exactly zero, and
+// Derivative when it overflows float64.
+type SensitivityResponse struct {
+    R          float64  `json:"r"`
+    X0         float64  `json:"x0"`
+    N          int      `json:"n"`
+    Sign       int      `json:"sign"`
+    LogAbs     *float64 `json:"log_abs,omitempty"`
+    Derivative *float64 `json:"derivative,omitempty"`
+}
+
+// IntervalRequest asks for a rigorous enclosure of x_N along the orbit of X0
+// under R. X0 defaults to the configured x_0.
+type IntervalRequest struct {
+    R  float64  `json:"r"`
+    X0 *float64 `json:"x0,omitempty"`
+    N  int      `json:"n"`
+}
+
+// IntervalResponse gives an interval [Lo, Hi] that contains the exact x_N,
+// its midpoint and its width.
+type IntervalResponse struct {
+    R     float64 `json:"r"`
+    X0    float64 `json:"x0"`
+    N     int     `json:"n"`
+    Lo    float64 `json:"lo"`
+    Hi    float64 `json:"hi"`
+    Mid   float64 `json:"mid"`
+    Width float64 `json:"width"`
+}
+
+// AdaptiveRequest asks for x_n at R computed with increasing precision until
+// the result is stable to RelTol relative error.
+type AdaptiveRequest struct {
+    R      float64 `json:"r"`
+    N      int     `json:"n"`
+    RelTol float64 `json:"rel_tol,omitempty"`
+}
+
+// AdaptiveResponse carries the stable value both rounded to float64 and as a
+// decimal string with the digits RelTol guarantees, and the mantissa
+// precision it took.
+type AdaptiveResponse struct {
+    R             float64 `json:"r"`
+    N             int     `json:"n"`
+    Result        float64 `json:"result"`
+    Decimal       string  `json:"decimal"`
+    PrecisionBits uint    `json:"precision_bits"`
+}
+
+// CorrelationRequest asks for the correlation dimension of the attractor at
+// R, estimated from the iterates after Transient up to N over Radii radii
+// between EpsMin and EpsMax.
+type CorrelationRequest struct {
+    R         float64 `json:"r"`
+    N         int     `json:"n"`
+    Transient int     `json:"transient"`
+    Radii     int     `json:"radii,omitempty"`
+    EpsMin    float64 `json:"eps_min,omitempty"`
+    EpsMax    float64 `json:"eps_max,omitempty"`
+}
+
+// CorrelationResponse lists the correlation integral at each radius and the
+// log-log slope fitted through them, the dimension estimate.
+type CorrelationResponse struct {
+    R         float64   `json:"r"`
+    Points    int       `json:"points"`
+    Radii     []float64 `json:"radii"`
+    Integrals []float64 `json:"integrals"`
+    Slope     float64   `json:"slope"`
+}
+
+// SampleRequest draws Count r values uniformly from [A, B) with Seed and
+// summarizes the attractor at each: Transient steps are discarded and the
+// next N are summarized.
+type SampleRequest struct {
+    A         float64 `json:"a"`
+    B         float64 `json:"b"`
+    Count     int     `json:"count"`
+    Seed      int64   `json:"seed"`
+    N         int     `json:"n"`
+    Transient int     `json:"transient"`
+}
+
+// SampleStats summarizes the N post-transient iterates at R. Period is 0
+// when no period up to 64 was detected. Error is set when the orbit left
+// [0, 1].
+type SampleStats struct {
+    R      float64 `json:"r"`
+    Mean   float64 `json:"mean"`
+    StdDev float64 `json:"stddev"`
+    Min    float64 `json:"min"`
+    Max    float64 `json:"max"`
+    Period int     `json:"period"`
+    Error  string  `json:"error,omitempty"`
+}
+
+type SampleResponse struct {
+    Samples []SampleStats `json:"samples"`
+}
+
+// Stats reports what checkpoints have saved a pod since it started.
+// IterationsSaved counts the map steps from x0 that computes resumed from a
+// Redis checkpoint did not have to take.
+type Stats struct {
+    PodID             string `json:"pod_id"`
+    CheckpointResumes int64  `json:"checkpoint_resumes"`
+    IterationsSaved   int64  `json:"iterations_saved"`
+
+    // CheckpointsSkipped counts checkpoint writes left out as duplicates;
+    // see CHECKPOINT_DEDUPE_TOLERANCE.
+    CheckpointsSkipped int64 `json:"checkpoints_skipped"`
 }
\ No newline at end of file
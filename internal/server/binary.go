@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+
+	"resilientrecursion/internal/models"
+)
+
+// binaryContentType selects the packed batch format on POST /calculate.
+const binaryContentType = "application/octet-stream"
+
+// A binary request record is a little-endian float64 r followed by a
+// little-endian int64 n; a response record is a little-endian float64
+// result.
+const (
+	binaryRequestSize = 16
+	binaryResultSize  = 8
+)
+
+// errBinaryLength is returned for a body that is not a whole number of
+// records.
+var errBinaryLength = errors.New("body length is not a multiple of 16 bytes")
+
+func isBinaryBatch(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == binaryContentType
+}
+
+// handleCalculateBinary serves POST /calculate with a packed body: one
+// result per record, in request order. Items that could not be computed come
+// back as NaN.
+func (s *Server) handleCalculateBinary(w http.ResponseWriter, r *http.Request) {
+	if s.queueBackend {
+		http.Error(w, "Binary batches are not supported with the queue backend", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body := r.Body
+	if s.maxBatchSize > 0 {
+		// One record over the limit is enough to report the batch as too
+		// large without reading all of it.
+		body = http.MaxBytesReader(w, r.Body, int64(s.maxBatchSize+1)*binaryRequestSize)
+	}
+	data, err := io.ReadAll(body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("Batch exceeds the limit of %d items", s.maxBatchSize),
+			http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Could not read body", http.StatusBadRequest)
+		return
+	}
+
+	requests, err := decodeBinaryBatch(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !s.checkBatchSize(w, len(requests)) || !s.checkDistinctR(w, distinctSeries(requests)) {
+		return
+	}
+	release, ok := s.admitMemory(w, requests)
+	if !ok {
+		return
+	}
+	defer release()
+
+	type point struct {
+		r float64
+		n int64
+	}
+	results := make(map[point]float64, len(requests))
+	for _, resp := range s.engine.ComputeBatch(r.Context(), requests) {
+		if resp.Error == "" && !resp.Partial {
+			results[point{resp.R, resp.N}] = resp.Result
+		}
+	}
+
+	ordered := make([]float64, len(requests))
+	for i, req := range requests {
+		x, ok := results[point{req.R, req.N}]
+		if !ok {
+			x = math.NaN()
+		}
+		ordered[i] = x
+	}
+
+	w.Header().Set("Content-Type", binaryContentType)
+	w.Write(encodeBinaryResults(ordered))
+}
+
+func decodeBinaryBatch(data []byte) ([]models.Request, error) {
+	if len(data)%binaryRequestSize != 0 {
+		return nil, errBinaryLength
+	}
+	requests := make([]models.Request, len(data)/binaryRequestSize)
+	for i := range requests {
+		record := data[i*binaryRequestSize:]
+		requests[i] = models.Request{
+			R: math.Float64frombits(binary.LittleEndian.Uint64(record)),
+			N: int64(binary.LittleEndian.Uint64(record[8:])),
+		}
+		// JSON cannot carry a NaN or infinite r, but these bits can.
+		if err := validateRecord(requests[i]); err != nil {
+			return nil, fmt.Errorf("record %d: %v", i+1, err)
+		}
+	}
+	return requests, nil
+}
+
+func encodeBinaryResults(results []float64) []byte {
+	data := make([]byte, len(results)*binaryResultSize)
+	for i, x := range results {
+		binary.LittleEndian.PutUint64(data[i*binaryResultSize:], math.Float64bits(x))
+	}
+	return data
+}
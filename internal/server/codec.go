@@ -0,0 +1,79 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"resilientrecursion/internal/logging"
+	"resilientrecursion/internal/models"
+)
+
+// requestCodec returns the codec registered for the Content-Type of r, when
+// that is not JSON. JSON bodies keep their own decoding, which reports
+// malformed batches in more detail.
+func requestCodec(r *http.Request) (models.Codec, bool) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType == models.JSON.ContentType() {
+		return nil, false
+	}
+	return models.CodecFor(mediaType)
+}
+
+// responseCodec returns the codec of the first registered media type the
+// Accept header of r lists with a non-zero q, in the order listed. JSON
+// stays the default.
+func responseCodec(r *http.Request) models.Codec {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+			continue
+		}
+		if codec, ok := models.CodecFor(mediaType); ok {
+			return codec
+		}
+	}
+	return models.JSON
+}
+
+// decodeBatchWith reads a batch of requests in codec's format from the body
+// of r, writing a 400 and reporting false when it cannot.
+func decodeBatchWith(w http.ResponseWriter, r *http.Request, codec models.Codec) ([]models.Request, bool) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Could not read request body", http.StatusBadRequest)
+		return nil, false
+	}
+	var requests []models.Request
+	if err := codec.Unmarshal(data, &requests); err != nil || requests == nil {
+		http.Error(w, "Request body is not an array of requests in "+codec.ContentType(), http.StatusBadRequest)
+		return nil, false
+	}
+	return requests, validateBatch(w, requests)
+}
+
+// writeModel writes v with status in codec's format. JSON goes through
+// json.Encoder, as every other response does.
+func writeModel(w http.ResponseWriter, codec models.Codec, status int, v any) {
+	if codec == models.JSON {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+		return
+	}
+	data, err := codec.Marshal(v)
+	if err != nil {
+		logging.Errorf("Encoding %s response: %v", codec.ContentType(), err)
+		http.Error(w, "Could not encode response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", codec.ContentType())
+	w.WriteHeader(status)
+	w.Write(data)
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/csv"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"resilientrecursion/internal/models"
+)
+
+const csvContentType = "text/csv"
+
+// csvFlushRows and csvFlushInterval bound how many rows, and for how long,
+// rows are buffered before a flush, so a large batch reaches the client as
+// it is computed rather than all at the end.
+const (
+	csvFlushRows     = 1000
+	csvFlushInterval = 100 * time.Millisecond
+)
+
+// acceptsCSV reports whether the Accept header of r asks for CSV. JSON stays
+// the default, so only an explicit text/csv with a non-zero q selects it.
+func acceptsCSV(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil || mediaType != csvContentType {
+			continue
+		}
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// streamsCSV reports whether r is a /calculate request answered in CSV,
+// which streams its rows like the routes in streamRoutes.
+func streamsCSV(r *http.Request) bool {
+	return r.URL.Path == "/calculate" && r.Method == http.MethodPost && acceptsCSV(r)
+}
+
+// writeCSV computes requests in request order and writes one r,n,result row
+// for each, under a header row, as soon as it is computed. Items with an
+// error or a partial result have an empty result, so they read as missing
+// values rather than as x_n. A client that goes away stops the batch.
+func (s *Server) writeCSV(w http.ResponseWriter, r *http.Request, requests []models.Request) {
+	// The rows outlive the server's write timeout by design.
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", csvContentType+"; charset=utf-8")
+	flusher, _ := w.(http.Flusher)
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"r", "n", "result"})
+	lastFlush := time.Now()
+	s.engine.ComputeBatchEach(r.Context(), requests, func(i int, resp models.Response, ok bool) {
+		var result string
+		if ok && resp.Error == "" && !resp.Partial {
+			result = strconv.FormatFloat(resp.Result, 'g', -1, 64)
+		}
+		req := requests[i]
+		cw.Write([]string{strconv.FormatFloat(req.R, 'g', -1, 64), strconv.FormatInt(req.N, 10), result})
+
+		if (i+1)%csvFlushRows == 0 || time.Since(lastFlush) >= csvFlushInterval {
+			cw.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+			lastFlush = time.Now()
+		}
+	})
+	cw.Flush()
+}
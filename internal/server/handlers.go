@@ -1,54 +1,1470 @@
-﻿package server
+package server
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"io"
+	"math"
 	"net/http"
-	"sort"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	"resilientrecursion/internal/engine"
+	"resilientrecursion/internal/logging"
 	"resilientrecursion/internal/models"
+	"resilientrecursion/internal/worker"
 )
 
 func (s *Server) handleCalculate(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		s.handleCalculateOne(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if isBinaryBatch(r) {
+		s.handleCalculateBinary(w, r)
+		return
+	}
+
+	var requests []models.Request
+	var ok bool
+	if codec, custom := requestCodec(r); custom {
+		requests, ok = decodeBatchWith(w, r, codec)
+	} else {
+		requests, ok = decodeBatch(w, r)
+	}
+	if !ok {
+		return
+	}
+	if !s.checkBatchSize(w, len(requests)) || !s.checkDistinctR(w, distinctSeries(requests)) || !s.checkRoundedR(w, requests) {
+		return
+	}
+	if r.URL.Query().Get("include_checkpoints") == "true" {
+		for i := range requests {
+			requests[i].IncludeCheckpoints = true
+		}
+	}
+	if r.URL.Query().Get("debug") == "true" {
+		for i := range requests {
+			requests[i].Debug = true
+		}
+	}
+	if r.URL.Query().Get("provenance") == "true" {
+		for i := range requests {
+			requests[i].Provenance = true
+		}
+	}
+	// An empty batch has nothing to queue and is answered inline.
+	if s.queueBackend && len(requests) > 0 {
+		s.publishBatch(w, r, requests)
+		return
+	}
+	release, ok := s.admitMemory(w, requests)
+	if !ok {
+		return
+	}
+	defer release()
+
+	if acceptsCSV(r) {
+		s.writeCSV(w, r, requests)
+		return
+	}
+	if r.URL.Query().Get("envelope") == "true" {
+		responses, meta := s.engine.ComputeBatchMeta(r.Context(), requests)
+		writeModel(w, responseCodec(r), http.StatusOK, models.BatchEnvelope{Meta: meta, Results: responses})
+		return
+	}
+
+	responses := s.engine.ComputeBatch(r.Context(), requests)
+
+	writeModel(w, responseCodec(r), http.StatusOK, responses)
+}
+
+// decodeBatch reads a batch of requests from the body of r, writing a 400
+// and reporting false when there is none. A missing body and a body that is
+// valid JSON but not an array are reported apart from malformed JSON, and an
+// item validateRecord refuses is reported by its position. An empty array is
+// a valid, empty batch.
+func decodeBatch(w http.ResponseWriter, r *http.Request) ([]models.Request, bool) {
+	var requests []models.Request
+	err := json.NewDecoder(r.Body).Decode(&requests)
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, io.EOF):
+		http.Error(w, "Missing request body: expected a JSON array of requests", http.StatusBadRequest)
+	case errors.As(err, &typeErr) && typeErr.Field == "" && typeErr.Type == reflect.TypeOf(requests):
+		http.Error(w, fmt.Sprintf("Request body is a JSON %s: expected an array of requests", typeErr.Value), http.StatusBadRequest)
+	case errors.Is(err, models.ErrInvalidR):
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+	case err != nil:
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	case requests == nil:
+		http.Error(w, "Request body is JSON null: expected an array of requests", http.StatusBadRequest)
+	default:
+		return requests, validateBatch(w, requests)
+	}
+	return nil, false
+}
+
+// validateBatch checks every item of a decoded batch with validateRecord,
+// writing a 400 naming the first bad one and reporting false if any fails.
+// Every batch format goes through it, so no format lets through what another
+// refuses.
+func validateBatch(w http.ResponseWriter, requests []models.Request) bool {
+	for i, req := range requests {
+		if err := validateRecord(req); err != nil {
+			http.Error(w, fmt.Sprintf("record %d: %v", i+1, err), http.StatusBadRequest)
+			return false
+		}
+	}
+	return true
+}
+
+// publishBatch hands a /calculate batch to the job stream and answers like
+// POST /compute/async, so clients poll /compute/async/{id} for the results.
+func (s *Server) publishBatch(w http.ResponseWriter, r *http.Request, requests []models.Request) {
+	job, err := s.engine.PublishJob(r.Context(), requests)
+	if err != nil {
+		logging.Errorf("Job publish error: %v", err)
+		http.Error(w, "Could not submit job", http.StatusServiceUnavailable)
+		return
+	}
+
+	writeModel(w, responseCodec(r), http.StatusAccepted, job)
+}
+
+// handleCalculateRs serves POST /calculate/rs: one n at many r values,
+// computed in parallel and returned in the order of rs.
+func (s *Server) handleCalculateRs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.MultiRRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.N < 0 {
+		http.Error(w, "Invalid n", http.StatusBadRequest)
+		return
+	}
+	if !s.checkBatchSize(w, len(req.Rs)) || !s.checkDistinctR(w, distinctRs(req.Rs)) || !s.checkPoints(w, len(req.Rs)) {
+		return
+	}
+	points := make([]models.Request, len(req.Rs))
+	for i, rVal := range req.Rs {
+		points[i] = models.Request{R: rVal, N: req.N}
+	}
+	release, ok := s.admitMemory(w, points)
+	if !ok {
+		return
+	}
+	defer release()
+
+	responses := s.engine.ComputeMulti(r.Context(), req.Rs, req.N)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responses)
+}
+
+// handleCalculateMaps serves POST /calculate/maps: x_n at one (r, n) under
+// each of the listed map kinds, keyed by kind.
+func (s *Server) handleCalculateMaps(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.MapsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.N < 0 {
+		http.Error(w, "Invalid n", http.StatusBadRequest)
+		return
+	}
+	if len(req.Maps) == 0 {
+		http.Error(w, "maps must list at least one map kind", http.StatusBadRequest)
+		return
+	}
+	if s.maxMaps > 0 && len(req.Maps) > s.maxMaps {
+		http.Error(w, fmt.Sprintf("Request asks for %d maps, above the limit of %d", len(req.Maps), s.maxMaps),
+			http.StatusUnprocessableEntity)
+		return
+	}
+	known := engine.MapKinds()
+	seen := make(map[string]bool, len(req.Maps))
+	for _, kind := range req.Maps {
+		if !slices.Contains(known, kind) {
+			http.Error(w, fmt.Sprintf("Unknown map kind %q; expected one of %s", kind, strings.Join(known, ", ")),
+				http.StatusBadRequest)
+			return
+		}
+		if seen[kind] {
+			http.Error(w, fmt.Sprintf("Map kind %q is listed twice", kind), http.StatusBadRequest)
+			return
+		}
+		seen[kind] = true
+	}
+
+	resp := models.MapsResponse{R: req.R, N: req.N, Results: make(map[string]float64, len(req.Maps))}
+	for _, kind := range req.Maps {
+		x, err := s.engine.ComputeMap(r.Context(), kind, req.R, req.N)
+		if err != nil {
+			if resp.Errors == nil {
+				resp.Errors = make(map[string]string)
+			}
+			resp.Errors[kind] = err.Error()
+			continue
+		}
+		resp.Results[kind] = x
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// checkBatchSize rejects batches above the configured item cap with 422 and
+// reports whether the request may proceed.
+func (s *Server) checkBatchSize(w http.ResponseWriter, size int) bool {
+	if s.maxBatchSize > 0 && size > s.maxBatchSize {
+		http.Error(w, fmt.Sprintf("Batch of %d items exceeds the limit of %d", size, s.maxBatchSize),
+			http.StatusUnprocessableEntity)
+		return false
+	}
+	return true
+}
+
+// checkRoundedR rejects, under ROUNDED_R=reject, batches with an r that
+// was rounded to fit a float64, with 422, and reports whether the request
+// may proceed.
+func (s *Server) checkRoundedR(w http.ResponseWriter, requests []models.Request) bool {
+	if !s.rejectRoundedR {
+		return true
+	}
+	for _, req := range requests {
+		if req.RRounded {
+			http.Error(w, roundedRMessage(req.R), http.StatusUnprocessableEntity)
+			return false
+		}
+	}
+	return true
+}
+
+func roundedRMessage(r float64) string {
+	return fmt.Sprintf("r has more digits than a float64 holds; the nearest float64 is %s (exactly %s)",
+		strconv.FormatFloat(r, 'g', -1, 64), models.ExactDecimal(r))
+}
+
+// checkDistinctR rejects batches spanning more distinct r values than the
+// configured cap with 422 and reports whether the request may proceed.
+func (s *Server) checkDistinctR(w http.ResponseWriter, distinct int) bool {
+	if s.maxDistinctR > 0 && distinct > s.maxDistinctR {
+		http.Error(w, fmt.Sprintf("Batch spans %d distinct r values, above the limit of %d; split it into batches of at most %d r values",
+			distinct, s.maxDistinctR, s.maxDistinctR), http.StatusUnprocessableEntity)
+		return false
+	}
+	return true
+}
+
+// checkPoints rejects a request that would return more than the configured
+// number of points with 422 and reports whether it may proceed. Every
+// endpoint returning a series of points counts them against the same cap.
+func (s *Server) checkPoints(w http.ResponseWriter, points int) bool {
+	if s.maxPoints > 0 && points > s.maxPoints {
+		http.Error(w, fmt.Sprintf("Request asks for %d points, above the limit of %d", points, s.maxPoints),
+			http.StatusUnprocessableEntity)
+		return false
+	}
+	return true
+}
+
+// distinctSeries counts the distinct (r, c) series in a batch; each one
+// takes its own L1 slot.
+func distinctSeries(requests []models.Request) int {
+	seen := make(map[[2]float64]struct{}, len(requests))
+	for _, req := range requests {
+		seen[[2]float64{req.R, req.C}] = struct{}{}
+	}
+	return len(seen)
+}
+
+func distinctRs(rs []float64) int {
+	seen := make(map[float64]struct{}, len(rs))
+	for _, r := range rs {
+		seen[r] = struct{}{}
+	}
+	return len(seen)
+}
+
+// handleCalculateOne serves GET /calculate?r=..&n=.. for a single point. With
+// cached_only=true it answers only from L1 or an exact checkpoint and returns
+// 404 rather than computing. With no_cache=true it computes from x0 without
+// reading L1 or checkpoints, writing the result unless store=false. With
+// include_checkpoints=true a computed response also lists its
+// checkpoint-aligned points, and with debug=true the n it resumed from.
+// clamp=true computes the clamped orbit; see engine.ClampX.
+func (s *Server) handleCalculateOne(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	rVal, err := strconv.ParseFloat(query.Get("r"), 64)
+	if err != nil {
+		http.Error(w, "Invalid r", http.StatusBadRequest)
+		return
+	}
+	_, rounded, err := models.ParseR(query.Get("r"))
+	rounded = rounded && err == nil
+	if rounded && s.rejectRoundedR {
+		http.Error(w, roundedRMessage(rVal), http.StatusUnprocessableEntity)
+		return
+	}
+	rUsed := rounded || query.Get("r_used") == "true"
+	n, err := strconv.ParseInt(query.Get("n"), 10, 64)
+	if err != nil || n < 0 {
+		http.Error(w, "Invalid n", http.StatusBadRequest)
+		return
+	}
+
+	noCache := query.Get("no_cache") == "true"
+	if noCache && (query.Get("cached_only") == "true" || query.Get("include_checkpoints") == "true") {
+		http.Error(w, "no_cache cannot be combined with cached_only or include_checkpoints", http.StatusBadRequest)
+		return
+	}
+	clamp := query.Get("clamp") == "true"
+	if clamp && (noCache || query.Get("cached_only") == "true") {
+		http.Error(w, "clamp cannot be combined with cached_only or no_cache", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	response := models.Response{R: rVal, N: n}
+	debug := query.Get("debug") == "true"
+	provenance := query.Get("provenance") == "true"
+	if query.Get("cached_only") == "true" {
+		var ok bool
+		if response.Result, ok = s.engine.Peek(ctx, rVal, n); !ok {
+			http.Error(w, "Not cached", http.StatusNotFound)
+			return
+		}
+	} else {
+		if noCache {
+			response.Result, err = s.engine.ComputeFresh(ctx, rVal, n, query.Get("store") != "false")
+			if debug {
+				response.ResumedFrom = new(int64)
+			}
+		} else {
+			response, err = s.engine.ComputeRequest(ctx, models.Request{
+				R:                  rVal,
+				N:                  n,
+				IncludeCheckpoints: query.Get("include_checkpoints") == "true",
+				Debug:              debug,
+				Clamp:              clamp,
+				Provenance:         provenance,
+				RUsed:              rUsed,
+			})
+		}
+		var notOwner *engine.NotOwnerError
+		if errors.As(err, &notOwner) {
+			w.Header().Set("X-Owner-Pod", strconv.Itoa(notOwner.Owner))
+			http.Error(w, fmt.Sprintf("Misdirected: r is owned by pod %d", notOwner.Owner), http.StatusMisdirectedRequest)
+			return
+		}
+		if errors.Is(err, engine.ErrDegenerate) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if err != nil {
+			logging.Errorf("Compute error: %v", err)
+			http.Error(w, "Compute failed", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if provenance && response.Provenance == nil {
+		response.Provenance = s.engine.Provenance(rVal, 0, false)
+	}
+	if rUsed {
+		response.RUsed = models.ExactDecimal(rVal)
+	}
+	s.engine.Sign(&response)
+
+	writeModel(w, responseCodec(r), http.StatusOK, response)
+}
+
+// computeFailed answers a request whose compute returned err: 400 when the
+// engine rejected the input, otherwise 500 after logging err under what.
+func computeFailed(w http.ResponseWriter, what string, err error) {
+	switch {
+	case errors.Is(err, engine.ErrInvalidStride),
+		errors.Is(err, engine.ErrInvalidRange),
+		errors.Is(err, engine.ErrInvalidBase),
+		errors.Is(err, engine.ErrInvalidBins):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		logging.Errorf("%s error: %v", what, err)
+		http.Error(w, what+" failed", http.StatusInternalServerError)
+	}
+}
+
+// Limits for /trajectory/compare. maxTrajectoryN bounds the walk, which
+// stores every step it computes in L1, however large the stride.
+// maxTrajectoryPoints caps the paired samples emitted, whatever
+// MAX_POINTS_PER_REQUEST allows.
+const (
+	maxTrajectoryN      = 1000000
+	maxTrajectoryPoints = 10000
+)
+
+func (s *Server) handleTrajectoryCompare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.TrajectoryCompareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.N < 0 || req.N > maxTrajectoryN || req.Stride <= 0 {
+		http.Error(w, "n must be between 0 and 1000000 and stride positive", http.StatusBadRequest)
+		return
+	}
+	points := req.N/req.Stride + 1
+	if req.N%req.Stride != 0 {
+		points++ // the final n
+	}
+	if points > maxTrajectoryPoints {
+		http.Error(w, "Too many points requested, increase stride", http.StatusBadRequest)
+		return
+	}
+	if !s.checkPoints(w, int(points)) {
+		return
+	}
+
+	ctx := r.Context()
+	first, err := s.engine.Trajectory(ctx, req.R1, req.N, req.Stride)
+	if err != nil {
+		computeFailed(w, "Trajectory", err)
+		return
+	}
+	second, err := s.engine.Trajectory(ctx, req.R2, req.N, req.Stride)
+	if err != nil {
+		computeFailed(w, "Trajectory", err)
+		return
+	}
+
+	response := models.TrajectoryCompareResponse{
+		Points: make([]models.TrajectoryPair, len(first)),
+	}
+	for i := range first {
+		response.Points[i] = models.TrajectoryPair{N: first[i].N, X1: first[i].X, X2: second[i].X}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Limits and defaults for /trajectory/log.
+const (
+	maxLogTrajectoryN        = 100000000
+	defaultLogTrajectoryBase = 2
+)
+
+// handleLogTrajectory serves POST /trajectory/log: x at n = 1, base,
+// base^2, ... up to max_n, from one pass over the series.
+func (s *Server) handleLogTrajectory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.LogTrajectoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Base == 0 {
+		req.Base = defaultLogTrajectoryBase
+	}
+	if req.MaxN < 1 || req.MaxN > maxLogTrajectoryN {
+		http.Error(w, "max_n must be between 1 and 100000000", http.StatusBadRequest)
+		return
+	}
+	if req.Base < 2 {
+		http.Error(w, "base must be at least 2", http.StatusBadRequest)
+		return
+	}
+	if !s.checkPoints(w, len(engine.LogSpacedN(req.MaxN, req.Base))) {
+		return
+	}
+
+	points, err := s.engine.LogTrajectory(r.Context(), req.R, req.MaxN, req.Base)
+	if err != nil {
+		computeFailed(w, "Log trajectory", err)
+		return
+	}
+
+	response := models.LogTrajectoryResponse{R: req.R, Base: req.Base, Points: make([]models.TrajectoryPoint, len(points))}
+	for i, p := range points {
+		response.Points[i] = models.TrajectoryPoint{N: p.N, X: p.X}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// maxTimeAverageN caps the iterations of one /trajectory/mean request.
+const maxTimeAverageN = 100000000
+
+// handleTimeAverage serves POST /trajectory/mean: the time average of x_1..x_n
+// and optionally its variance.
+func (s *Server) handleTimeAverage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.TimeAverageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.N < 1 || req.N > maxTimeAverageN {
+		http.Error(w, "n must be between 1 and 100000000", http.StatusBadRequest)
+		return
+	}
+
+	mean, variance, err := s.engine.TimeAverage(r.Context(), req.R, req.N)
+	if err != nil {
+		computeFailed(w, "Time average", err)
+		return
+	}
+
+	response := models.TimeAverageResponse{R: req.R, N: req.N, Mean: mean}
+	if req.Variance {
+		response.Variance = &variance
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Limits for /density. The bins are also counted against
+// MAX_POINTS_PER_REQUEST, but never exceed maxDensityBins.
+const (
+	maxDensityBins = 1000
+	maxDensityN    = 1000000
+)
+
+func (s *Server) handleDensity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.DensityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.Bins <= 0 || req.Bins > maxDensityBins {
+		http.Error(w, "bins must be between 1 and 1000", http.StatusBadRequest)
+		return
+	}
+	if req.N <= 0 || req.N > maxDensityN {
+		http.Error(w, "n must be between 1 and 1000000", http.StatusBadRequest)
+		return
+	}
+	if req.Transient < 0 || req.Transient >= req.N {
+		http.Error(w, "transient must be non-negative and less than n", http.StatusBadRequest)
+		return
+	}
+	if !s.checkPoints(w, req.Bins) {
+		return
+	}
+
+	counts, outside, err := s.engine.Density(r.Context(), req.R, req.N, req.Transient, req.Bins)
+	if err != nil {
+		computeFailed(w, "Density", err)
+		return
+	}
+
+	response := models.DensityResponse{
+		Counts:   counts,
+		BinWidth: 1 / float64(req.Bins),
+		Samples:  req.N - req.Transient,
+		Outside:  outside,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Defaults and caps for /bifurcations.
+const (
+	maxBifurcationSteps         = 10000
+	maxBifurcationTransient     = 100000
+	maxBifurcationPeriod        = 1024
+	defaultBifurcationTransient = 10000
+	defaultBifurcationPeriod    = 64
+	defaultBifurcationTol       = 1e-9
+)
+
+// handleBifurcations serves POST /bifurcations, locating period doublings
+// over an r range.
+func (s *Server) handleBifurcations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.BifurcationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Transient == 0 {
+		req.Transient = defaultBifurcationTransient
+	}
+	if req.MaxPeriod == 0 {
+		req.MaxPeriod = defaultBifurcationPeriod
+	}
+	if req.Tolerance == 0 {
+		req.Tolerance = defaultBifurcationTol
+	}
+	if req.Steps <= 0 || req.Steps > maxBifurcationSteps {
+		http.Error(w, "steps must be between 1 and 10000", http.StatusBadRequest)
+		return
+	}
+	if req.Transient < 0 || req.Transient > maxBifurcationTransient {
+		http.Error(w, "transient must be between 0 and 100000", http.StatusBadRequest)
+		return
+	}
+	if req.MaxPeriod < 1 || req.MaxPeriod > maxBifurcationPeriod {
+		http.Error(w, "max_period must be between 1 and 1024", http.StatusBadRequest)
+		return
+	}
+	if req.Tolerance < 0 {
+		http.Error(w, "tolerance must be non-negative", http.StatusBadRequest)
+		return
+	}
+	if !s.checkPoints(w, req.Steps+1) {
+		return
+	}
+
+	bifurcations, err := s.engine.PeriodDoublings(r.Context(), req.RMin, req.RMax,
+		req.Steps, req.Transient, req.MaxPeriod, req.Tolerance)
+	if errors.Is(err, engine.ErrInvalidRange) {
+		http.Error(w, "r_max must be greater than r_min", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		computeFailed(w, "Bifurcation scan", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.BifurcationResponse{Bifurcations: bifurcations})
+}
+
+// Defaults and caps for /transient.
+const (
+	maxTransientN          = 1000000
+	maxTransientPeriod     = 1024
+	defaultTransientN      = 100000
+	defaultTransientPeriod = 64
+	defaultTransientTol    = 1e-9
+)
+
+// handleTransient serves POST /transient: how many iterations the orbit
+// takes to enter its attracting cycle.
+func (s *Server) handleTransient(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.TransientRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	x0 := s.engine.X0()
+	if req.X0 != nil {
+		x0 = *req.X0
+	}
+	if req.MaxN == 0 {
+		req.MaxN = defaultTransientN
+	}
+	if req.MaxPeriod == 0 {
+		req.MaxPeriod = defaultTransientPeriod
+	}
+	if req.Tolerance == 0 {
+		req.Tolerance = defaultTransientTol
+	}
+	if !(x0 >= 0 && x0 <= 1) {
+		http.Error(w, "x0 must be between 0 and 1", http.StatusBadRequest)
+		return
+	}
+	if req.MaxN < 0 || req.MaxN > maxTransientN {
+		http.Error(w, "max_n must be between 0 and 1000000", http.StatusBadRequest)
+		return
+	}
+	if req.MaxPeriod < 1 || req.MaxPeriod > maxTransientPeriod {
+		http.Error(w, "max_period must be between 1 and 1024", http.StatusBadRequest)
+		return
+	}
+	if req.Tolerance < 0 {
+		http.Error(w, "tolerance must be non-negative", http.StatusBadRequest)
+		return
+	}
+
+	length, period, err := s.engine.TransientLength(r.Context(), req.R, x0, req.MaxN, req.MaxPeriod, req.Tolerance)
+	if err != nil {
+		computeFailed(w, "Transient length", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.TransientResponse{
+		R:               req.R,
+		X0:              x0,
+		TransientLength: length,
+		Period:          period,
+		CheckedN:        req.MaxN,
+	})
+}
+
+// Defaults and caps for /basins. maxBasinN lets a cell find periods up to
+// 1024, as /bifurcations does.
+const (
+	maxBasinCells         = 250000
+	maxBasinN             = 2048
+	maxBasinTransient     = 100000
+	defaultBasinN         = 128
+	defaultBasinTransient = 1000
+)
+
+// handleBasins serves POST /basins: the attractor each cell of an (r, x0)
+// grid settles onto. POST /basins/stream takes the same body and writes the
+// cells as NDJSON as the rows finish, rather than as one JSON document at
+// the end.
+func (s *Server) handleBasins(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.BasinRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.N == 0 {
+		req.N = defaultBasinN
+	}
+	if req.Transient == 0 {
+		req.Transient = defaultBasinTransient
+	}
+	if !(req.RRange[1] > req.RRange[0]) || !(req.X0Range[1] > req.X0Range[0]) {
+		http.Error(w, "r_range and x0_range must each be [min, max] with max greater than min", http.StatusBadRequest)
+		return
+	}
+	cells := (req.Steps + 1) * (req.Steps + 1)
+	if req.Steps <= 0 || req.Steps >= maxBasinCells || cells > maxBasinCells {
+		http.Error(w, fmt.Sprintf("steps must be positive, with (steps+1)^2 at most %d cells", maxBasinCells), http.StatusBadRequest)
+		return
+	}
+	if req.N < 2 || req.N > maxBasinN {
+		http.Error(w, "n must be between 2 and 2048", http.StatusBadRequest)
+		return
+	}
+	if req.Transient < 0 || req.Transient > maxBasinTransient {
+		http.Error(w, "transient must be between 0 and 100000", http.StatusBadRequest)
+		return
+	}
+	if !s.checkPoints(w, cells) {
+		return
+	}
+
+	if r.URL.Path != "/basins/stream" {
+		cells := make([]models.BasinCell, 0, cells)
+		err := s.engine.BasinGrid(r.Context(), req.RRange[0], req.RRange[1], req.X0Range[0], req.X0Range[1],
+			req.Steps, req.N, req.Transient, func(cell models.BasinCell) { cells = append(cells, cell) })
+		if err != nil {
+			computeFailed(w, "Basin grid", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(models.BasinResponse{Cells: cells})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	// Like /calculate/stream, the response outlives the write timeout.
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+	written := 0
+	err := s.engine.BasinGrid(r.Context(), req.RRange[0], req.RRange[1], req.X0Range[0], req.X0Range[1],
+		req.Steps, req.N, req.Transient, func(cell models.BasinCell) {
+			enc.Encode(cell)
+			if written++; written%(req.Steps+1) == 0 {
+				flusher.Flush()
+			}
+		})
+	// The status went out with the first row, so a failure can only end
+	// the stream early.
+	if err != nil && r.Context().Err() == nil {
+		logging.Errorf("Basin grid error: %v", err)
+	}
+}
+
+// maxSensitivityN caps the iterations of one /sensitivity request.
+const maxSensitivityN = 1000000
+
+// handleSensitivity serves POST /sensitivity: dx_n/dx_0, the product of the
+// map's derivatives along the orbit.
+func (s *Server) handleSensitivity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.SensitivityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	x0 := s.engine.X0()
+	if req.X0 != nil {
+		x0 = *req.X0
+	}
+	if !(x0 >= 0 && x0 <= 1) {
+		http.Error(w, "x0 must be between 0 and 1", http.StatusBadRequest)
+		return
+	}
+	if req.N < 0 || req.N > maxSensitivityN {
+		http.Error(w, "n must be between 0 and 1000000", http.StatusBadRequest)
+		return
+	}
+
+	sign, logAbs, err := s.engine.Sensitivity(r.Context(), req.R, x0, req.N)
+	if errors.Is(err, engine.ErrDiverged) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		computeFailed(w, "Sensitivity", err)
+		return
+	}
+
+	response := models.SensitivityResponse{R: req.R, X0: x0, N: req.N, Sign: sign}
+	if sign != 0 {
+		response.LogAbs = &logAbs
+	}
+	if d := float64(sign) * math.Exp(logAbs); !math.IsInf(d, 0) {
+		response.Derivative = &d
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// maxIntervalN caps the iterations of one /calculate/interval request.
+const maxIntervalN = 1000000
+
+// handleCalculateInterval serves POST /calculate/interval: an interval that
+// provably contains the exact x_n, rounding errors included.
+func (s *Server) handleCalculateInterval(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var requests []models.Request
-	if err := json.NewDecoder(r.Body).Decode(&requests); err != nil {
+	var req models.IntervalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
+	x0 := s.engine.X0()
+	if req.X0 != nil {
+		x0 = *req.X0
+	}
+	if !(req.R >= 0 && req.R <= 4) {
+		http.Error(w, "r must be between 0 and 4", http.StatusBadRequest)
+		return
+	}
+	if !(x0 >= 0 && x0 <= 1) {
+		http.Error(w, "x0 must be between 0 and 1", http.StatusBadRequest)
+		return
+	}
+	if req.N < 0 || req.N > maxIntervalN {
+		http.Error(w, "n must be between 0 and 1000000", http.StatusBadRequest)
+		return
+	}
 
-	grouped := make(map[float64][]int)
-	for _, req := range requests {
-		grouped[req.R] = append(grouped[req.R], req.N)
+	iv, err := s.engine.ComputeInterval(r.Context(), req.R, x0, req.N)
+	if err != nil {
+		computeFailed(w, "Interval compute", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.IntervalResponse{
+		R:     req.R,
+		X0:    x0,
+		N:     req.N,
+		Lo:    iv.Lo,
+		Hi:    iv.Hi,
+		Mid:   iv.Mid(),
+		Width: iv.Width(),
+	})
+}
+
+// Limits and defaults for /calculate/adaptive.
+const (
+	maxAdaptiveN          = 10000
+	defaultAdaptiveRelTol = 1e-12
+)
+
+// handleCalculateAdaptive serves POST /calculate/adaptive: x_n computed at
+// increasing precision until stable. Each request can take seconds, so it is
+// limited per tenant on top of the general rate limit.
+func (s *Server) handleCalculateAdaptive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	for r := range grouped {
-		sort.Ints(grouped[r])
+	var req models.AdaptiveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.RelTol == 0 {
+		req.RelTol = defaultAdaptiveRelTol
+	}
+	if req.N < 0 || req.N > maxAdaptiveN {
+		http.Error(w, "n must be between 0 and 10000", http.StatusBadRequest)
+		return
+	}
+	if !(req.RelTol > 0 && req.RelTol < 1) {
+		http.Error(w, "rel_tol must be between 0 and 1", http.StatusBadRequest)
+		return
 	}
 
 	ctx := r.Context()
-	responses := make([]models.Response, 0, len(requests))
-
-	for r, nValues := range grouped {
-		for _, n := range nValues {
-			result, err := s.engine.Compute(ctx, r, n)
-			if err != nil {
-				log.Printf("Compute error: %v", err)
-				continue
-			}
-			responses = append(responses, models.Response{R: r, N: n, Result: result})
+	if ok, wait := s.adaptiveLimiter.Load().allow(engine.TenantFrom(ctx), time.Now()); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, "Adaptive precision rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+
+	x, prec, err := s.engine.ComputeAdaptive(ctx, req.R, req.N, req.RelTol)
+	if errors.Is(err, engine.ErrNoConvergence) {
+		http.Error(w, fmt.Sprintf("No stable result within %d bits of precision", engine.MaxAdaptivePrecision),
+			http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		computeFailed(w, "Adaptive compute", err)
+		return
+	}
+	result, _ := x.Float64()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.AdaptiveResponse{
+		R:             req.R,
+		N:             req.N,
+		Result:        result,
+		Decimal:       x.Text('g', engine.SignificantDigits(req.RelTol)),
+		PrecisionBits: prec,
+	})
+}
+
+// Limits and defaults for /correlation. Counting close pairs needs the
+// points sorted, so n is capped below /density's.
+const (
+	maxCorrelationN          = 200000
+	maxCorrelationRadii      = 100
+	defaultCorrelationEpsMin = 1e-4
+	defaultCorrelationEpsMax = 1e-1
+	defaultCorrelationRadii  = 20
+)
+
+// handleCorrelation serves POST /correlation, a Grassberger–Procaccia
+// estimate of the attractor's correlation dimension. Like adaptive requests,
+// it is limited per tenant on top of the general rate limit.
+func (s *Server) handleCorrelation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.CorrelationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Radii == 0 {
+		req.Radii = defaultCorrelationRadii
+	}
+	if req.EpsMin == 0 {
+		req.EpsMin = defaultCorrelationEpsMin
+	}
+	if req.EpsMax == 0 {
+		req.EpsMax = defaultCorrelationEpsMax
+	}
+	if req.N <= 0 || req.N > maxCorrelationN {
+		http.Error(w, "n must be between 1 and 200000", http.StatusBadRequest)
+		return
+	}
+	if req.Transient < 0 || req.Transient >= req.N {
+		http.Error(w, "transient must be non-negative and less than n", http.StatusBadRequest)
+		return
+	}
+	if req.Radii < 2 || req.Radii > maxCorrelationRadii {
+		http.Error(w, "radii must be between 2 and 100", http.StatusBadRequest)
+		return
+	}
+	if !(req.EpsMin > 0 && req.EpsMax > req.EpsMin) {
+		http.Error(w, "eps_max must be greater than eps_min, and eps_min positive", http.StatusBadRequest)
+		return
+	}
+	if !s.checkPoints(w, req.Radii) {
+		return
+	}
+
+	ctx := r.Context()
+	if ok, wait := s.correlationLimiter.Load().allow(engine.TenantFrom(ctx), time.Now()); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, "Correlation dimension rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+
+	radii, integrals, slope, err := s.engine.CorrelationDimension(ctx, req.R, req.N, req.Transient,
+		req.Radii, req.EpsMin, req.EpsMax)
+	if errors.Is(err, engine.ErrTooFewPairs) {
+		http.Error(w, "No two radii have close pairs; widen the radii or raise n", http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		computeFailed(w, "Correlation dimension", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.CorrelationResponse{
+		R:         req.R,
+		Points:    req.N - req.Transient,
+		Radii:     radii,
+		Integrals: integrals,
+		Slope:     slope,
+	})
+}
+
+// Limits for /sample.
+const (
+	maxSampleCount     = 1000
+	maxSampleN         = 100000
+	maxSampleTransient = 100000
+)
+
+// handleSample serves POST /sample, summarizing the attractor at
+// reproducibly sampled random r values.
+func (s *Server) handleSample(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.SampleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !(req.B > req.A) {
+		http.Error(w, "b must be greater than a", http.StatusBadRequest)
+		return
+	}
+	if req.Count <= 0 || req.Count > maxSampleCount {
+		http.Error(w, "count must be between 1 and 1000", http.StatusBadRequest)
+		return
+	}
+	if req.N <= 0 || req.N > maxSampleN {
+		http.Error(w, "n must be between 1 and 100000", http.StatusBadRequest)
+		return
+	}
+	if req.Transient < 0 || req.Transient > maxSampleTransient {
+		http.Error(w, "transient must be between 0 and 100000", http.StatusBadRequest)
+		return
+	}
+	if !s.checkPoints(w, req.Count) {
+		return
+	}
+
+	samples, err := s.engine.SampleAttractors(r.Context(), req.A, req.B, req.Count, req.Seed, req.N, req.Transient)
+	if err != nil {
+		computeFailed(w, "Sample", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.SampleResponse{Samples: samples})
+}
+
+// handleReplay serves POST /replay, checking a stored checkpoint against a
+// recomputation from the checkpoint before it.
+func (s *Server) handleReplay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.ReplayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.N <= 0 || req.Tolerance < 0 {
+		http.Error(w, "n must be positive and tolerance non-negative", http.StatusBadRequest)
+		return
+	}
+	tolerance := req.Tolerance
+	if tolerance == 0 {
+		tolerance = s.engine.Epsilon()
+	}
+
+	fromN, stored, recomputed, err := s.engine.Replay(r.Context(), req.R, req.C, req.N)
+	if errors.Is(err, engine.ErrCheckpointNotFound) {
+		http.Error(w, "Checkpoint not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, engine.ErrReplayWindow) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		logging.Errorf("Replay error: %v", err)
+		http.Error(w, "Replay failed", http.StatusInternalServerError)
+		return
+	}
+
+	discrepancy := math.Abs(stored - recomputed)
+	response := models.ReplayResponse{
+		R:           req.R,
+		N:           req.N,
+		FromN:       fromN,
+		Stored:      stored,
+		Recomputed:  recomputed,
+		Discrepancy: discrepancy,
+		Match:       discrepancy <= tolerance,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// maxQueueRetryAfter bounds the Retry-After sent while the queue is full, so
+// a few slow tasks in the average cannot turn clients away for long.
+const maxQueueRetryAfter = 5 * time.Minute
+
+// queueRetryAfter turns an estimated queue wait into Retry-After seconds. It
+// is at least 1, since the queue was full just now.
+func queueRetryAfter(wait time.Duration) int {
+	seconds := int(math.Ceil(wait.Seconds()))
+	return min(max(seconds, 1), int(maxQueueRetryAfter/time.Second))
+}
+
+// maxIdempotencyKeyLen bounds the Idempotency-Key header stored in Redis.
+const maxIdempotencyKeyLen = 255
+
+func (s *Server) handleAsyncSubmit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requests, ok := decodeBatch(w, r)
+	if !ok {
+		return
+	}
+
+	if !s.checkBatchSize(w, len(requests)) || !s.checkDistinctR(w, distinctSeries(requests)) || !s.checkRoundedR(w, requests) {
+		return
+	}
+
+	var job *models.Job
+	var err error
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		if len(key) > maxIdempotencyKeyLen {
+			http.Error(w, "Idempotency-Key too long", http.StatusBadRequest)
+			return
 		}
+		job, err = s.engine.SubmitJobOnce(r.Context(), key, requests)
+	} else {
+		job, err = s.engine.SubmitJob(r.Context(), requests)
+	}
+	if errors.Is(err, worker.ErrQueueFull) {
+		w.Header().Set("Retry-After", strconv.Itoa(queueRetryAfter(s.engine.QueueWait())))
+		http.Error(w, "Queue full, retry later", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		logging.Errorf("Job submit error: %v", err)
+		http.Error(w, "Could not submit job", http.StatusServiceUnavailable)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(responses)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// handleAsyncStatus serves GET /compute/async/{id}, the job's current state,
+// and DELETE, which cancels the job.
+func (s *Server) handleAsyncStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/compute/async/")
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	var job *models.Job
+	var err error
+	if r.Method == http.MethodDelete {
+		job, err = s.engine.CancelJob(r.Context(), id)
+	} else {
+		job, err = s.engine.Job(r.Context(), id)
+	}
+	if errors.Is(err, engine.ErrJobNotFound) {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, engine.ErrJobFinished) {
+		http.Error(w, fmt.Sprintf("Job already %s", job.Status), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		logging.Errorf("Job lookup error: %v", err)
+		http.Error(w, "Could not load job", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// maxShardMapSteps caps the steps of a /shard-map range.
+const maxShardMapSteps = 100000
+
+// handleShardMap serves POST /shard-map: the pod that owns each of a list or
+// range of r values under the current sharding. Nothing is computed.
+func (s *Server) handleShardMap(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.ShardMapRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	rs := req.Rs
+	switch {
+	case len(rs) > 0 && req.Steps != 0:
+		http.Error(w, "Give either rs or a range, not both", http.StatusBadRequest)
+		return
+	case len(rs) == 0:
+		if req.Steps <= 0 || req.Steps > maxShardMapSteps {
+			http.Error(w, "steps must be between 1 and 100000", http.StatusBadRequest)
+			return
+		}
+		if !(req.RMax > req.RMin) {
+			http.Error(w, "r_max must be greater than r_min", http.StatusBadRequest)
+			return
+		}
+		if !s.checkPoints(w, req.Steps+1) {
+			return
+		}
+		rs = make([]float64, req.Steps+1)
+		for i := range rs {
+			rs[i] = req.RMin + (req.RMax-req.RMin)*float64(i)/float64(req.Steps)
+		}
+	default:
+		if !s.checkPoints(w, len(rs)) {
+			return
+		}
+	}
+
+	owners, counts := s.engine.ShardMap(rs)
+	response := models.ShardMapResponse{
+		TotalPods:   len(counts),
+		Assignments: make([]models.ShardAssignment, len(rs)),
+		Counts:      counts,
+	}
+	for i, r := range rs {
+		response.Assignments[i] = models.ShardAssignment{R: r, Pod: owners[i]}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Page sizes for /keys.
+const (
+	defaultKeysLimit = 100
+	maxKeysLimit     = 1000
+)
+
+func (s *Server) handleKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	offset, limit := 0, defaultKeysLimit
+	if v := query.Get("offset"); v != "" {
+		var err error
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		if limit > maxKeysLimit {
+			limit = maxKeysLimit
+		}
+	}
+
+	infos, err := s.engine.CachedKeys(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	response := models.KeysResponse{Total: len(infos), Offset: offset, Keys: []models.KeyInfo{}}
+	for i := offset; i < len(infos) && i < offset+limit; i++ {
+		info := models.KeyInfo{
+			RHash:   infos[i].RHash,
+			Entries: infos[i].Entries,
+			MaxN:    infos[i].MaxN,
+		}
+		if p := infos[i].Params; p != nil {
+			info.R, info.C, info.X0 = &p.R, p.C, &p.X0
+		}
+		response.Keys = append(response.Keys, info)
+	}
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		logging.Errorf("Keys encode error: %v", err)
+		http.Error(w, "Could not encode keys", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(body, '\n'))
+}
+
+// handleFlush serves POST /flush, persisting the warm cache to Redis without
+// a restart.
+func (s *Server) handleFlush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	count, err := s.engine.Flush(r.Context())
+	if err != nil {
+		logging.Errorf("Manual flush error: %v", err)
+		http.Error(w, "Could not flush cache", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.FlushResponse{Checkpoints: count})
+}
+
+// handlePurge serves POST /checkpoints/purge, deleting checkpoints in bulk.
+func (s *Server) handlePurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.PurgeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	// Require an explicit choice so an empty body never wipes everything.
+	var olderThan time.Duration
+	switch {
+	case req.All && req.OlderThan != "":
+		http.Error(w, "Set either all or older_than, not both", http.StatusBadRequest)
+		return
+	case req.All:
+	case req.OlderThan != "":
+		d, err := time.ParseDuration(req.OlderThan)
+		if err != nil || d <= 0 {
+			http.Error(w, "Invalid older_than", http.StatusBadRequest)
+			return
+		}
+		olderThan = d
+	default:
+		http.Error(w, "Set all or older_than", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := s.engine.PurgeCheckpoints(r.Context(), olderThan)
+	if err != nil {
+		logging.Errorf("Checkpoint purge error after %d keys: %v", deleted, err)
+		http.Error(w, fmt.Sprintf("Purge failed after deleting %d keys", deleted), http.StatusServiceUnavailable)
+		return
+	}
+	logging.Infof("Purged %d checkpoint keys", deleted)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.PurgeResponse{Deleted: deleted})
 }
 
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
+
+// handleStats serves GET /stats, the checkpoint savings of this pod.
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.engine.Stats())
+}
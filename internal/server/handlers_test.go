@@ -0,0 +1,2012 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"resilientrecursion/internal/engine"
+	"resilientrecursion/internal/models"
+	"resilientrecursion/pkg/config"
+	"resilientrecursion/pkg/signature"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestServer(t *testing.T) (*Server, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	eng := engine.NewComputeEngine(cfg)
+	t.Cleanup(eng.Close)
+	return NewServer(cfg, eng), mr
+}
+
+func serve(s *Server, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCalculateCachedOnly(t *testing.T) {
+	s, mr := newTestServer(t)
+
+	miss := serve(s, httptest.NewRequest(http.MethodGet, "/calculate?r=3.5&n=10&cached_only=true", nil))
+	if miss.Code != http.StatusNotFound {
+		t.Fatalf("uncached lookup: status = %d, want %d", miss.Code, http.StatusNotFound)
+	}
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Fatalf("cached_only miss wrote to Redis: %v", keys)
+	}
+
+	body, _ := json.Marshal([]models.Request{{R: 3.5, N: 10}})
+	if rec := serve(s, httptest.NewRequest(http.MethodPost, "/calculate", bytes.NewReader(body))); rec.Code != http.StatusOK {
+		t.Fatalf("POST /calculate: status = %d", rec.Code)
+	}
+
+	hit := serve(s, httptest.NewRequest(http.MethodGet, "/calculate?r=3.5&n=10&cached_only=true", nil))
+	if hit.Code != http.StatusOK {
+		t.Fatalf("cached lookup: status = %d, want %d", hit.Code, http.StatusOK)
+	}
+	var resp models.Response
+	if err := json.NewDecoder(hit.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	want := 0.5
+	for i := 0; i < 10; i++ {
+		want = 3.5 * want * (1 - want)
+	}
+	if resp.Result != want {
+		t.Errorf("result = %v, want %v", resp.Result, want)
+	}
+}
+
+func TestCalculateCachedOnlyExactCheckpoint(t *testing.T) {
+	s, mr := newTestServer(t)
+
+	key := fmt.Sprintf("cp:%d", math.Float64bits(3.7))
+	mr.ZAdd(key, 2000, "4.2e-01")
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/calculate?r=3.7&n=2000&cached_only=true", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("checkpoint lookup: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	rec = serve(s, httptest.NewRequest(http.MethodGet, "/calculate?r=3.7&n=2001&cached_only=true", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("lookup past checkpoint: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestCalculateNoCache(t *testing.T) {
+	s, mr := newTestServer(t)
+
+	key := fmt.Sprintf("cp:%d", math.Float64bits(3.7))
+	mr.ZAdd(key, 1000, "4.2e-01")
+
+	want := 0.5
+	for i := 0; i < 1500; i++ {
+		want = 3.7 * want * (1 - want)
+	}
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/calculate?r=3.7&n=1500&no_cache=true&store=false", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var resp models.Response
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Result != want {
+		t.Errorf("result = %v, want %v computed past the checkpoint", resp.Result, want)
+	}
+
+	rec = serve(s, httptest.NewRequest(http.MethodGet, "/calculate?r=3.7&n=1500&no_cache=true&cached_only=true", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("no_cache with cached_only: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestCalculateDebugResumedFrom(t *testing.T) {
+	s, mr := newTestServer(t)
+
+	key := fmt.Sprintf("cp:%d", math.Float64bits(3.7))
+	mr.ZAdd(key, 2000, "4.2e-01")
+
+	for _, tc := range []struct {
+		name  string
+		query string
+		want  int64
+	}{
+		{"cold", "r=3.6&n=500", 0},
+		{"checkpoint", "r=3.7&n=2500", 2000},
+		{"L1 hit", "r=3.7&n=2500", -1},
+	} {
+		rec := serve(s, httptest.NewRequest(http.MethodGet, "/calculate?debug=true&"+tc.query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d", tc.name, rec.Code)
+		}
+		var resp models.Response
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.ResumedFrom == nil || *resp.ResumedFrom != tc.want {
+			t.Errorf("%s: resumed_from = %v, want %d", tc.name, resp.ResumedFrom, tc.want)
+		}
+	}
+
+	body, _ := json.Marshal([]models.Request{{R: 3.7, N: 2500}})
+	rec := serve(s, httptest.NewRequest(http.MethodPost, "/calculate", bytes.NewReader(body)))
+	if strings.Contains(rec.Body.String(), "resumed_from") {
+		t.Errorf("resumed_from reported without debug: %s", rec.Body)
+	}
+}
+
+// reproduce recomputes a logistic result from its provenance alone.
+func reproduce(t *testing.T, p *models.Provenance, n int64) float64 {
+	t.Helper()
+	if p.Map != engine.MapLogistic || p.Precision != "float64" || p.EngineVersion != engine.Version {
+		t.Fatalf("provenance %+v is not of this engine's logistic computes", p)
+	}
+	bits := func(s string) float64 {
+		if s == "" {
+			return 0
+		}
+		b, err := strconv.ParseUint(s, 16, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return math.Float64frombits(b)
+	}
+	r, c := bits(p.RBits), bits(p.CBits)
+	want := "(r*x)*(1-x)"
+	if c != 0 {
+		want += "+c"
+	}
+	if p.Arithmetic != want {
+		t.Fatalf("arithmetic = %q, want %q", p.Arithmetic, want)
+	}
+	x := p.X0
+	for i := int64(0); i < n; i++ {
+		x = r*x*(1-x) + c
+	}
+	return x
+}
+
+func TestCalculateProvenanceReproducesResult(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/calculate?provenance=true&r=3.7&n=1000", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	var resp models.Response
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Provenance == nil {
+		t.Fatalf("no provenance in %+v", resp)
+	}
+	if resp.Provenance.RBits != "400d99999999999a" {
+		t.Errorf("r_bits = %s, want 400d99999999999a", resp.Provenance.RBits)
+	}
+	if got := reproduce(t, resp.Provenance, resp.N); math.Float64bits(got) != math.Float64bits(resp.Result) {
+		t.Errorf("reproduced %v, want %v", got, resp.Result)
+	}
+
+	body, _ := json.Marshal([]models.Request{{R: 3.58, N: 700, C: 1e-9}, {R: 3.2, N: 50}})
+	rec = serve(s, httptest.NewRequest(http.MethodPost, "/calculate?provenance=true", bytes.NewReader(body)))
+	var responses []models.Response
+	if err := json.NewDecoder(rec.Body).Decode(&responses); err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 2 {
+		t.Fatalf("got %d responses, want 2", len(responses))
+	}
+	for _, resp := range responses {
+		if resp.Provenance == nil {
+			t.Fatalf("no provenance in %+v", resp)
+		}
+		if got := reproduce(t, resp.Provenance, resp.N); math.Float64bits(got) != math.Float64bits(resp.Result) {
+			t.Errorf("r=%v: reproduced %v, want %v", resp.R, got, resp.Result)
+		}
+	}
+
+	rec = serve(s, httptest.NewRequest(http.MethodGet, "/calculate?r=3.7&n=1000", nil))
+	if strings.Contains(rec.Body.String(), "provenance") {
+		t.Errorf("provenance reported without asking: %s", rec.Body)
+	}
+}
+
+func TestCalculateHighPrecisionRString(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	const pi = "3.14159265358979323846264338327950288"
+	body := `[{"r": "` + pi + `", "n": 1000}, {"r": "3.7", "n": 1000}, {"r": 3.2, "n": 10}]`
+	rec := serve(s, httptest.NewRequest(http.MethodPost, "/calculate", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var responses []models.Response
+	if err := json.NewDecoder(rec.Body).Decode(&responses); err != nil {
+		t.Fatal(err)
+	}
+	used := make(map[float64]string)
+	for _, resp := range responses {
+		used[resp.R] = resp.RUsed
+	}
+	if got, want := used[math.Pi], "3.141592653589793115997963468544185161590576171875"; got != want {
+		t.Errorf("r_used for pi = %q, want %q", got, want)
+	}
+	if got, want := used[3.7], "3.70000000000000017763568394002504646778106689453125"; got != want {
+		t.Errorf("r_used for \"3.7\" = %q, want %q", got, want)
+	}
+	if got := used[3.2]; got != "" {
+		t.Errorf("r_used = %q for a plain number", got)
+	}
+
+	rec = serve(s, httptest.NewRequest(http.MethodGet, "/calculate?n=1000&r="+pi, nil))
+	var resp models.Response
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.R != math.Pi || resp.RUsed != used[math.Pi] {
+		t.Errorf("GET answered r=%v r_used=%q", resp.R, resp.RUsed)
+	}
+
+	rec = serve(s, httptest.NewRequest(http.MethodPost, "/calculate", strings.NewReader(`[{"r": "3.7abc", "n": 10}]`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("malformed r string: status = %d, want 400", rec.Code)
+	}
+
+	s.rejectRoundedR = true
+	rec = serve(s, httptest.NewRequest(http.MethodPost, "/calculate", strings.NewReader(body)))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("rounded r under ROUNDED_R=reject: status = %d, want 422", rec.Code)
+	}
+	rec = serve(s, httptest.NewRequest(http.MethodPost, "/calculate", strings.NewReader(`[{"r": "3.70", "n": 10}]`)))
+	if rec.Code != http.StatusOK {
+		t.Errorf("r string a float64 holds under ROUNDED_R=reject: status = %d, want 200", rec.Code)
+	}
+}
+
+func TestCalculateMessagePack(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	body, err := models.MessagePack.Marshal([]models.Request{{R: 3.7, N: 1000}, {R: 3.2, N: 40}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/calculate", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/msgpack")
+	req.Header.Set("Accept", "application/msgpack")
+	rec := serve(s, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/msgpack" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	var responses []models.Response
+	if err := models.MessagePack.Unmarshal(rec.Body.Bytes(), &responses); err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 2 {
+		t.Fatalf("got %d responses, want 2", len(responses))
+	}
+	results := make(map[float64]float64)
+	for _, resp := range responses {
+		results[resp.R] = resp.Result
+	}
+
+	// The same results come back as JSON by default.
+	req = httptest.NewRequest(http.MethodPost, "/calculate", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/msgpack")
+	rec = serve(s, req)
+	var asJSON []models.Response
+	if err := json.NewDecoder(rec.Body).Decode(&asJSON); err != nil {
+		t.Fatal(err)
+	}
+	if len(asJSON) != 2 {
+		t.Fatalf("got %d JSON responses, want 2", len(asJSON))
+	}
+	for _, resp := range asJSON {
+		if resp.Result != results[resp.R] {
+			t.Errorf("r=%v: JSON result %v, MessagePack %v", resp.R, resp.Result, results[resp.R])
+		}
+	}
+
+	// MessagePack floats carry NaN, which JSON cannot.
+	bad, err := models.MessagePack.Marshal([]models.Request{{R: math.NaN(), N: 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/calculate", bytes.NewReader(bad))
+	req.Header.Set("Content-Type", "application/msgpack")
+	if rec := serve(s, req); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "r must be finite") {
+		t.Errorf("NaN r: status %d, body %q; want 400", rec.Code, rec.Body)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/calculate?r=3.7&n=1000", nil)
+	req.Header.Set("Accept", "application/msgpack")
+	rec = serve(s, req)
+	var one models.Response
+	if err := models.MessagePack.Unmarshal(rec.Body.Bytes(), &one); err != nil {
+		t.Fatal(err)
+	}
+	if one.R != 3.7 || one.Result != results[3.7] {
+		t.Errorf("GET answered %+v, want result %v", one, results[3.7])
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/calculate", strings.NewReader("\xc1"))
+	req.Header.Set("Content-Type", "application/msgpack")
+	if rec := serve(s, req); rec.Code != http.StatusBadRequest {
+		t.Errorf("malformed body: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestCalculateQueueBackendPublishes(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	cfg.ComputeBackend = config.ComputeBackendQueue
+	eng := engine.NewComputeEngine(cfg)
+	t.Cleanup(eng.Close)
+	s := NewServer(cfg, eng)
+
+	body, _ := json.Marshal([]models.Request{{R: 3.5, N: 10}})
+	rec := serve(s, httptest.NewRequest(http.MethodPost, "/calculate", bytes.NewReader(body)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusAccepted)
+	}
+	var job models.Job
+	if err := json.NewDecoder(rec.Body).Decode(&job); err != nil {
+		t.Fatal(err)
+	}
+	if job.ID == "" || job.Status != models.JobQueued {
+		t.Fatalf("job = %+v, want a queued job with an id", job)
+	}
+
+	entries, err := mr.Stream(cfg.JobStream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("%d stream entries, want 1", len(entries))
+	}
+}
+
+func TestCalculateMaps(t *testing.T) {
+	s, _ := newTestServer(t)
+	post := func(body string) *httptest.ResponseRecorder {
+		return serve(s, httptest.NewRequest(http.MethodPost, "/calculate/maps", strings.NewReader(body)))
+	}
+
+	rec := post(`{"r": 3.7, "n": 1000, "maps": ["logistic", "tent", "sine"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp models.MapsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Results) != 3 || len(resp.Errors) != 0 {
+		t.Fatalf("results %v, errors %v; want the three kinds", resp.Results, resp.Errors)
+	}
+	for _, kind := range []string{"logistic", "tent", "sine"} {
+		want, err := s.engine.ComputeMap(context.Background(), kind, 3.7, 1000)
+		if err != nil || resp.Results[kind] != want {
+			t.Errorf("%s = %v, want %v (err %v)", kind, resp.Results[kind], want, err)
+		}
+	}
+
+	for body, want := range map[string]int{
+		`{"r": 3.7, "n": 10, "maps": []}`:                                    http.StatusBadRequest,
+		`{"r": 3.7, "n": 10, "maps": ["tent", "henon"]}`:                     http.StatusBadRequest,
+		`{"r": 3.7, "n": 10, "maps": ["tent", "tent"]}`:                      http.StatusBadRequest,
+		`{"r": 3.7, "n": -1, "maps": ["tent"]}`:                              http.StatusBadRequest,
+		`{"r": 3.7, "n": 10, "maps": ["a","b","c","d","e","f","g","h","i"]}`: http.StatusUnprocessableEntity,
+	} {
+		if rec := post(body); rec.Code != want {
+			t.Errorf("%s: status = %d, want %d", body, rec.Code, want)
+		}
+	}
+}
+
+func TestAsyncQueueFullSendsRetryAfter(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	cfg.Workers = 1
+	cfg.QueueSize = 1
+	eng := engine.NewComputeEngine(cfg)
+	t.Cleanup(eng.Close)
+	s := NewServer(cfg, eng)
+
+	// Chaotic jobs far too long to finish keep the worker and the queue
+	// busy until Close cancels them.
+	var rec *httptest.ResponseRecorder
+	for i := 0; i < 4; i++ {
+		body := fmt.Sprintf(`[{"r": 3.7%d, "n": 1000000000000}]`, i)
+		rec = serve(s, httptest.NewRequest(http.MethodPost, "/compute/async", strings.NewReader(body)))
+		if rec.Code == http.StatusTooManyRequests {
+			break
+		}
+	}
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d once the queue fills", rec.Code, http.StatusTooManyRequests)
+	}
+	seconds, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+	if err != nil || seconds < 1 || seconds > int(maxQueueRetryAfter/time.Second) {
+		t.Errorf("Retry-After = %q, want whole seconds in [1, %d]", rec.Header().Get("Retry-After"), int(maxQueueRetryAfter/time.Second))
+	}
+}
+
+func TestQueueRetryAfter(t *testing.T) {
+	for wait, want := range map[time.Duration]int{
+		0:                       1,
+		300 * time.Millisecond:  1,
+		2500 * time.Millisecond: 3,
+		time.Hour:               300,
+	} {
+		if got := queueRetryAfter(wait); got != want {
+			t.Errorf("queueRetryAfter(%v) = %d, want %d", wait, got, want)
+		}
+	}
+}
+
+func TestCalculateCSV(t *testing.T) {
+	s, _ := newTestServer(t)
+	post := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/calculate", strings.NewReader(`[{"r": 3.7, "n": 10}, {"r": 2.5, "n": 100}]`))
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		return serve(s, req)
+	}
+
+	rec := post("application/json;q=0.5, text/csv")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("status = %d, Content-Type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	x1, _ := s.engine.Compute(context.Background(), 3.7, 10)
+	x2, _ := s.engine.Compute(context.Background(), 2.5, 100)
+	want := fmt.Sprintf("r,n,result\n3.7,10,%s\n2.5,100,%s\n",
+		strconv.FormatFloat(x1, 'g', -1, 64), strconv.FormatFloat(x2, 'g', -1, 64))
+	if got := rec.Body.String(); got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+
+	for _, accept := range []string{"", "application/json", "text/csv;q=0"} {
+		if rec := post(accept); rec.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("Accept %q: Content-Type = %q, want JSON", accept, rec.Header().Get("Content-Type"))
+		}
+	}
+
+	// The clamped and unclamped series of the same r each get their own row.
+	req := httptest.NewRequest(http.MethodPost, "/calculate",
+		strings.NewReader(`[{"r": 4.2, "n": 20, "clamp": true}, {"r": 4.2, "n": 20}, {"r": 4.2, "n": 20, "clamp": true}]`))
+	req.Header.Set("Accept", csvContentType)
+	rec = serve(s, req)
+	row := func(clamp bool) string {
+		resp, err := s.engine.ComputeRequest(context.Background(), models.Request{R: 4.2, N: 20, Clamp: clamp})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "4.2,20," + strconv.FormatFloat(resp.Result, 'g', -1, 64) + "\n"
+	}
+	if row(true) == row(false) {
+		t.Fatal("clamped and unclamped series agree, pick another r")
+	}
+	if got, want := rec.Body.String(), "r,n,result\n"+row(true)+row(false)+row(true); got != want {
+		t.Errorf("mixed clamp body = %q, want %q", got, want)
+	}
+}
+
+func TestCSVBatchesSkipRouteTimeouts(t *testing.T) {
+	// http.TimeoutHandler buffers the whole response and cannot flush, so
+	// CSV rows only stream past it.
+	var flushes bool
+	h := withRouteTimeouts(time.Minute, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, flushes = w.(http.Flusher)
+	}))
+	for _, tc := range []struct {
+		accept string
+		want   bool
+	}{
+		{csvContentType, true},
+		{"application/json", false},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/calculate", strings.NewReader(`[]`))
+		req.Header.Set("Accept", tc.accept)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if flushes != tc.want {
+			t.Errorf("Accept %s: handler can flush = %v, want %v", tc.accept, flushes, tc.want)
+		}
+	}
+}
+
+func TestPprofOnlyOnAdminListener(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	cfg.AdminToken = "secret"
+	cfg.PprofAddr = "127.0.0.1:6060"
+	eng := engine.NewComputeEngine(cfg)
+	t.Cleanup(eng.Close)
+	s := NewServer(cfg, eng)
+
+	if rec := serve(s, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil)); rec.Code != http.StatusNotFound {
+		t.Errorf("public port: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	profile := func(auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/debug/pprof/heap?debug=1", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		s.pprof.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := profile(""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("unauthenticated profile: status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := profile("Bearer secret"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "heap profile") {
+		t.Errorf("heap profile: status = %d, body %.60q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestFlushWritesCheckpoints(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	cfg.CheckpointMod = 10
+	cfg.AdminToken = "secret"
+	eng := engine.NewComputeEngine(cfg)
+	t.Cleanup(eng.Close)
+	s := NewServer(cfg, eng)
+
+	if _, err := eng.Compute(context.Background(), 3.5, 25); err != nil {
+		t.Fatal(err)
+	}
+	mr.FlushAll()
+
+	if rec := serve(s, httptest.NewRequest(http.MethodPost, "/flush", nil)); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated flush: status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/flush", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := serve(s, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var resp models.FlushResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	// n=10 and n=20 are checkpoint-aligned.
+	if resp.Checkpoints != 2 {
+		t.Errorf("checkpoints = %d, want 2", resp.Checkpoints)
+	}
+	members, err := mr.ZMembers(fmt.Sprintf("cp:%d", engine.HashFloat64(3.5)))
+	if err != nil || len(members) != 2 {
+		t.Errorf("Redis holds %v (err %v), want 2 checkpoints", members, err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	s, mr := newTestServer(t)
+
+	// r=3.2 settles on a stable 2-cycle, so the rounding of text-encoded
+	// checkpoints does not grow over the replayed window. The checkpoints sit
+	// on different phases of the cycle so their members stay distinct.
+	const r = 3.2
+	x := 0.5
+	key := fmt.Sprintf("cp:%d", engine.HashFloat64(r))
+	for i := 1; i <= 300; i++ {
+		x = r * x * (1 - x)
+		switch i {
+		case 100, 201:
+			mr.ZAdd(key, float64(i), fmt.Sprintf("%.15e", x))
+		case 300:
+			mr.ZAdd(key, float64(i), fmt.Sprintf("%.15e", x+1e-3))
+		}
+	}
+
+	replay := func(n int64) (int, models.ReplayResponse) {
+		body, _ := json.Marshal(models.ReplayRequest{R: r, N: n})
+		rec := serve(s, httptest.NewRequest(http.MethodPost, "/replay", bytes.NewReader(body)))
+		var resp models.ReplayResponse
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec.Code, resp
+	}
+
+	if code, resp := replay(201); code != http.StatusOK || !resp.Match || resp.FromN != 100 {
+		t.Errorf("replay n=201: status %d, %+v; want a match from n=100", code, resp)
+	}
+	if code, resp := replay(100); code != http.StatusOK || !resp.Match || resp.FromN != 0 {
+		t.Errorf("replay n=100: status %d, %+v; want a match from the seed", code, resp)
+	}
+	if code, resp := replay(300); code != http.StatusOK || resp.Match || math.Abs(resp.Discrepancy-1e-3) > 1e-9 {
+		t.Errorf("replay n=300: status %d, %+v; want a 1e-3 mismatch", code, resp)
+	}
+	if code, _ := replay(150); code != http.StatusNotFound {
+		t.Errorf("replay n=150: status %d, want %d", code, http.StatusNotFound)
+	}
+
+	if keys, _ := s.engine.CachedKeys(context.Background()); len(keys) != 0 {
+		t.Errorf("replay populated L1: %v", keys)
+	}
+}
+
+func TestStrictShardingRefusesNonLocal(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 2
+	cfg.StrictSharding = true
+	eng := engine.NewComputeEngine(cfg)
+	t.Cleanup(eng.Close)
+	s := NewServer(cfg, eng)
+
+	var owned, foreign float64
+	for r := 3.5; owned == 0 || foreign == 0; r += 0.01 {
+		if engine.GetPodForR(engine.HashFloat64(r), 2) == 0 {
+			owned = r
+		} else {
+			foreign = r
+		}
+	}
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/calculate?r=%v&n=10", foreign), nil))
+	if rec.Code != http.StatusMisdirectedRequest || rec.Header().Get("X-Owner-Pod") != "1" {
+		t.Errorf("GET non-local: status %d, X-Owner-Pod %q; want %d and \"1\"",
+			rec.Code, rec.Header().Get("X-Owner-Pod"), http.StatusMisdirectedRequest)
+	}
+
+	body, _ := json.Marshal([]models.Request{{R: owned, N: 10}, {R: foreign, N: 10}})
+	rec = serve(s, httptest.NewRequest(http.MethodPost, "/calculate", bytes.NewReader(body)))
+	var responses []models.Response
+	if err := json.NewDecoder(rec.Body).Decode(&responses); err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 2 {
+		t.Fatalf("got %d responses, want 2", len(responses))
+	}
+	for _, resp := range responses {
+		switch resp.R {
+		case owned:
+			if resp.Error != "" || resp.OwnerPod != nil {
+				t.Errorf("owned r refused: %+v", resp)
+			}
+		case foreign:
+			if resp.Error == "" || resp.OwnerPod == nil || *resp.OwnerPod != 1 {
+				t.Errorf("non-local r = %+v, want an error with owner_pod 1", resp)
+			}
+		}
+	}
+	keys, _ := eng.CachedKeys(context.Background())
+	for _, key := range keys {
+		if key.RHash == engine.HashFloat64(foreign) {
+			t.Error("non-local r was computed")
+		}
+	}
+}
+
+func TestCalculateStreamSendsResult(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/calculate/stream?r=3.5&n=10", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	want, _ := s.engine.Compute(context.Background(), 3.5, 10)
+	payload, _ := json.Marshal(models.Response{R: 3.5, N: 10, Result: want})
+	if event := fmt.Sprintf("event: result\ndata: %s\n\n", payload); !strings.HasSuffix(rec.Body.String(), event) {
+		t.Errorf("stream = %q, want it to end with %q", rec.Body.String(), event)
+	}
+}
+
+func TestDistinctRCap(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	cfg.MaxDistinctR = 2
+	eng := engine.NewComputeEngine(cfg)
+	t.Cleanup(eng.Close)
+	s := NewServer(cfg, eng)
+
+	post := func(path string, v interface{}) int {
+		body, _ := json.Marshal(v)
+		return serve(s, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))).Code
+	}
+
+	// Many items over two r values stay under the cap.
+	ok := []models.Request{{R: 3.5, N: 1}, {R: 3.5, N: 2}, {R: 3.6, N: 1}, {R: 3.6, N: 2}}
+	if code := post("/calculate", ok); code != http.StatusOK {
+		t.Errorf("two distinct r: status %d, want %d", code, http.StatusOK)
+	}
+
+	tooMany := []models.Request{{R: 3.5, N: 1}, {R: 3.6, N: 1}, {R: 3.7, N: 1}}
+	if code := post("/calculate", tooMany); code != http.StatusUnprocessableEntity {
+		t.Errorf("three distinct r: status %d, want %d", code, http.StatusUnprocessableEntity)
+	}
+	if code := post("/compute/async", tooMany); code != http.StatusUnprocessableEntity {
+		t.Errorf("async three distinct r: status %d, want %d", code, http.StatusUnprocessableEntity)
+	}
+	if code := post("/calculate/rs", models.MultiRRequest{Rs: []float64{3.5, 3.6, 3.7}, N: 1}); code != http.StatusUnprocessableEntity {
+		t.Errorf("rs three distinct r: status %d, want %d", code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestAsyncSubmitIdempotencyKey(t *testing.T) {
+	s, _ := newTestServer(t)
+	body, _ := json.Marshal([]models.Request{{R: 3.5, N: 10}})
+
+	submit := func(key string) models.Job {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/compute/async", bytes.NewReader(body))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		rec := serve(s, req)
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("submit with key %q: status %d", key, rec.Code)
+		}
+		var job models.Job
+		if err := json.NewDecoder(rec.Body).Decode(&job); err != nil {
+			t.Fatal(err)
+		}
+		return job
+	}
+
+	first := submit("retry-me")
+	if again := submit("retry-me"); again.ID != first.ID {
+		t.Errorf("repeat submission created job %s, want %s", again.ID, first.ID)
+	}
+	if other := submit("another"); other.ID == first.ID {
+		t.Error("a different key reused the job")
+	}
+	if plain := submit(""); plain.ID == first.ID {
+		t.Error("a submission without a key reused the job")
+	}
+}
+
+func TestResultSignatures(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	cfg.ResultSigningKey = "audit-key"
+	eng := engine.NewComputeEngine(cfg)
+	t.Cleanup(eng.Close)
+	s := NewServer(cfg, eng)
+
+	body, _ := json.Marshal([]models.Request{{R: 3.5, N: 10}, {R: 3.6, N: 20, C: 0.01}})
+	rec := serve(s, httptest.NewRequest(http.MethodPost, "/calculate", bytes.NewReader(body)))
+	var responses []models.Response
+	if err := json.NewDecoder(rec.Body).Decode(&responses); err != nil {
+		t.Fatal(err)
+	}
+
+	rec = serve(s, httptest.NewRequest(http.MethodGet, "/calculate?r=3.5&n=10&cached_only=true", nil))
+	var one models.Response
+	if err := json.NewDecoder(rec.Body).Decode(&one); err != nil {
+		t.Fatal(err)
+	}
+	responses = append(responses, one)
+
+	key := []byte(cfg.ResultSigningKey)
+	for _, resp := range responses {
+		if !signature.Verify(key, resp.R, resp.C, resp.N, 0.5, resp.Result, resp.Signature) {
+			t.Errorf("signature of %+v does not verify", resp)
+		}
+		if signature.Verify(key, resp.R, resp.C, resp.N, 0.5, resp.Result+1e-12, resp.Signature) {
+			t.Errorf("signature of %+v verifies a different result", resp)
+		}
+	}
+}
+
+func TestPurgeCheckpoints(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	cfg.AdminToken = "secret"
+	eng := engine.NewComputeEngine(cfg)
+	t.Cleanup(eng.Close)
+	s := NewServer(cfg, eng)
+
+	// More keys than one SCAN page: 150 written just now, 150 written 50
+	// minutes ago (10 minutes of the 1h TTL left), and one with no expiry.
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("cp:%d", i)
+		mr.ZAdd(key, 1000, "member")
+		if i < 150 {
+			mr.SetTTL(key, time.Hour)
+		} else {
+			mr.SetTTL(key, 10*time.Minute)
+		}
+	}
+	mr.ZAdd("cp:legacy", 1000, "member")
+	mr.Set("job:keep", "{}")
+
+	purge := func(body string) (int, models.PurgeResponse) {
+		req := httptest.NewRequest(http.MethodPost, "/checkpoints/purge", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := serve(s, req)
+		var resp models.PurgeResponse
+		if rec.Code == http.StatusOK {
+			json.NewDecoder(rec.Body).Decode(&resp)
+		}
+		return rec.Code, resp
+	}
+
+	if code, _ := purge(`{}`); code != http.StatusBadRequest {
+		t.Errorf("empty purge: status %d, want %d", code, http.StatusBadRequest)
+	}
+
+	if code, resp := purge(`{"older_than": "30m"}`); code != http.StatusOK || resp.Deleted != 151 {
+		t.Errorf("older_than purge: status %d, deleted %d; want 151", code, resp.Deleted)
+	}
+	if !mr.Exists("cp:0") || mr.Exists("cp:150") || mr.Exists("cp:legacy") {
+		t.Error("older_than purge removed the wrong keys")
+	}
+
+	if code, resp := purge(`{"all": true}`); code != http.StatusOK || resp.Deleted != 150 {
+		t.Errorf("purge all: status %d, deleted %d; want 150", code, resp.Deleted)
+	}
+	if !mr.Exists("job:keep") {
+		t.Error("purge removed a non-checkpoint key")
+	}
+}
+
+func TestTenantResolutionAndRateLimit(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	cfg.Tenants = []string{"team-a"}
+	cfg.TenantRateLimit = 1
+	cfg.TenantRateBurst = 2
+	eng := engine.NewComputeEngine(cfg)
+	t.Cleanup(eng.Close)
+	s := NewServer(cfg, eng)
+
+	get := func(tenant string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/calculate?r=3.5&n=10", nil)
+		if tenant != "" {
+			req.Header.Set("X-Tenant", tenant)
+		}
+		return serve(s, req)
+	}
+
+	if rec := get("team-b"); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown tenant: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := get("team-a"); rec.Code != http.StatusOK {
+			t.Fatalf("team-a request %d: status %d, want %d", i, rec.Code, http.StatusOK)
+		}
+	}
+	rec := get("team-a")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("team-a over its burst: status %d, Retry-After %q; want 429 with Retry-After",
+			rec.Code, rec.Header().Get("Retry-After"))
+	}
+	// The public tenant has its own bucket.
+	if rec := get(""); rec.Code != http.StatusOK {
+		t.Errorf("public after team-a was limited: status %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestCalculateAdaptiveIsRateLimited(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	post := func() *httptest.ResponseRecorder {
+		return serve(s, httptest.NewRequest(http.MethodPost, "/calculate/adaptive",
+			strings.NewReader(`{"r": 3.2, "n": 100}`)))
+	}
+
+	rec := post()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want %d", rec.Code, http.StatusOK)
+	}
+	var resp models.AdaptiveResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.PrecisionBits == 0 || resp.Decimal == "" {
+		t.Errorf("response = %+v, want a precision and decimal value", resp)
+	}
+
+	if rec := post(); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("second request: status %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestApplyReloadRateLimits(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	cfg.TenantRateLimit = 1
+	cfg.TenantRateBurst = 1
+	eng := engine.NewComputeEngine(cfg)
+	t.Cleanup(eng.Close)
+	s := NewServer(cfg, eng)
+
+	get := func() int {
+		return serve(s, httptest.NewRequest(http.MethodGet, "/calculate?r=3.5&n=10", nil)).Code
+	}
+	adaptive := func() int {
+		return serve(s, httptest.NewRequest(http.MethodPost, "/calculate/adaptive",
+			strings.NewReader(`{"r": 3.2, "n": 100}`))).Code
+	}
+
+	if code := get(); code != http.StatusOK {
+		t.Fatalf("first request: status %d, want %d", code, http.StatusOK)
+	}
+	if code := get(); code != http.StatusTooManyRequests {
+		t.Fatalf("over the burst: status %d, want %d", code, http.StatusTooManyRequests)
+	}
+
+	// Unchanged limits keep the buckets they have.
+	s.ApplyReload(cfg)
+	if code := get(); code != http.StatusTooManyRequests {
+		t.Errorf("after reloading the same limit: status %d, want %d", code, http.StatusTooManyRequests)
+	}
+
+	next := *cfg
+	next.TenantRateLimit = 0
+	next.AdaptiveRateLimit = 0
+	s.ApplyReload(&next)
+	for i := 0; i < 3; i++ {
+		if code := get(); code != http.StatusOK {
+			t.Errorf("request %d with the limit lifted: status %d, want %d", i, code, http.StatusOK)
+		}
+		if code := adaptive(); code != http.StatusOK {
+			t.Errorf("adaptive request %d with its limit lifted: status %d, want %d", i, code, http.StatusOK)
+		}
+	}
+
+	next.CorrelationRateLimit = 0
+	next.AdaptiveRateLimit = 1
+	s.ApplyReload(&next)
+	if code := adaptive(); code != http.StatusOK {
+		t.Errorf("adaptive request under the new limit: status %d, want %d", code, http.StatusOK)
+	}
+	if code := adaptive(); code != http.StatusTooManyRequests {
+		t.Errorf("adaptive request over the new limit: status %d, want %d", code, http.StatusTooManyRequests)
+	}
+}
+
+func TestRouteTimeouts(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	cfg.WriteTimeout = 20 * time.Millisecond
+	cfg.RouteTimeouts = map[string]time.Duration{"/bifurcations": 10 * time.Second}
+	eng := engine.NewComputeEngine(cfg)
+	t.Cleanup(eng.Close)
+	s := NewServer(cfg, eng)
+
+	start := time.Now()
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/calculate?r=3.7&n=2000000000", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("slow /calculate: status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("slow /calculate took %v to time out", elapsed)
+	}
+
+	// Takes well past the 20ms default, within the route's own budget.
+	rec = serve(s, httptest.NewRequest(http.MethodPost, "/bifurcations",
+		strings.NewReader(`{"r_min": 2.9, "r_max": 3.56, "steps": 1000, "transient": 100000}`)))
+	if rec.Code != http.StatusOK {
+		t.Errorf("/bifurcations: status %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestCalculateEnvelope(t *testing.T) {
+	s, _ := newTestServer(t)
+	body, _ := json.Marshal([]models.Request{{R: 3.5, N: 100}, {R: 3.5, N: 100}})
+
+	rec := serve(s, httptest.NewRequest(http.MethodPost, "/calculate", bytes.NewReader(body)))
+	var bare []models.Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &bare); err != nil || len(bare) != 2 {
+		t.Fatalf("bare response: %v, %d items, body %s", err, len(bare), rec.Body)
+	}
+
+	// Both items are now cached.
+	rec = serve(s, httptest.NewRequest(http.MethodPost, "/calculate?envelope=true", bytes.NewReader(body)))
+	var env models.BatchEnvelope
+	if err := json.Unmarshal(rec.Body.Bytes(), &env); err != nil {
+		t.Fatalf("envelope response: %v, body %s", err, rec.Body)
+	}
+	if len(env.Results) != 2 || env.Results[0].Result != bare[0].Result {
+		t.Errorf("envelope results = %+v, want %+v", env.Results, bare)
+	}
+	if env.Meta.PodID != config.Default().PodID || env.Meta.CacheHits != 2 || env.Meta.TotalIterations != 0 {
+		t.Errorf("meta = %+v, want pod %q, 2 cache hits, no iterations", env.Meta, config.Default().PodID)
+	}
+
+	body, _ = json.Marshal([]models.Request{{R: 3.6, N: 100}, {R: 3.6, N: 150}})
+	rec = serve(s, httptest.NewRequest(http.MethodPost, "/calculate?envelope=true", bytes.NewReader(body)))
+	env = models.BatchEnvelope{}
+	json.Unmarshal(rec.Body.Bytes(), &env)
+	if env.Meta.CacheHits != 0 || env.Meta.TotalIterations != 150 {
+		t.Errorf("meta = %+v, want no cache hits and 150 iterations", env.Meta)
+	}
+}
+
+func TestCalculateBatchBodies(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	rec := serve(s, httptest.NewRequest(http.MethodPost, "/calculate", strings.NewReader("[]")))
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Errorf("empty array: status %d, body %q, want 200 []", rec.Code, rec.Body)
+	}
+
+	for _, tc := range []struct {
+		name, body, want string
+	}{
+		{"empty body", "", "Missing request body"},
+		{"object", `{"r": 3.5, "n": 10}`, "JSON object: expected an array"},
+		{"null", "null", "JSON null: expected an array"},
+		{"malformed", `[{"r": 3.5,`, "Invalid JSON"},
+		{"bad item", `[{"r": "x"}]`, "Invalid JSON"},
+		{"negative n", `[{"r": 3.5, "n": 10}, {"r": 3.5, "n": -1}]`, "record 2: n must not be negative"},
+		{"negative budget", `[{"r": 3.5, "n": 10, "budget_ms": -1}]`, "record 1: budget_ms must not be negative"},
+	} {
+		rec := serve(s, httptest.NewRequest(http.MethodPost, "/calculate", strings.NewReader(tc.body)))
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), tc.want) {
+			t.Errorf("%s: status %d, body %q, want 400 containing %q", tc.name, rec.Code, rec.Body, tc.want)
+		}
+	}
+}
+
+func TestSensitivity(t *testing.T) {
+	s, _ := newTestServer(t)
+	post := func(body string) (*httptest.ResponseRecorder, models.SensitivityResponse) {
+		rec := serve(s, httptest.NewRequest(http.MethodPost, "/sensitivity", strings.NewReader(body)))
+		var resp models.SensitivityResponse
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec, resp
+	}
+
+	rec, resp := post(`{"r": 3.7, "x0": 0.3, "n": 20}`)
+	if rec.Code != http.StatusOK || resp.LogAbs == nil || resp.Derivative == nil {
+		t.Fatalf("status %d, response %+v", rec.Code, resp)
+	}
+	if d := float64(resp.Sign) * math.Exp(*resp.LogAbs); math.Abs(d-*resp.Derivative) > 1e-9*math.Abs(d) {
+		t.Errorf("derivative %v does not match sign %d and log_abs %v", *resp.Derivative, resp.Sign, *resp.LogAbs)
+	}
+
+	// The product overflows float64, so only the log magnitude is returned.
+	if _, resp := post(`{"r": 4, "x0": 0.3, "n": 5000}`); resp.LogAbs == nil || resp.Derivative != nil {
+		t.Errorf("overflowing derivative: response %+v, want log_abs only", resp)
+	}
+	// The default x0 of 0.5 is a zero factor.
+	if _, resp := post(`{"r": 3.7, "n": 20}`); resp.Sign != 0 || resp.LogAbs != nil || resp.Derivative == nil || *resp.Derivative != 0 {
+		t.Errorf("x0=0.5: response %+v, want a zero derivative", resp)
+	}
+
+	for body, code := range map[string]int{
+		`{"r": 3.7, "x0": 1.5, "n": 20}`:      http.StatusBadRequest,
+		`{"r": 3.7, "x0": 0.3, "n": 2000000}`: http.StatusBadRequest,
+		`{"r": 4.5, "x0": 0.3, "n": 100}`:     http.StatusUnprocessableEntity,
+	} {
+		if rec, _ := post(body); rec.Code != code {
+			t.Errorf("%s: status %d, want %d", body, rec.Code, code)
+		}
+	}
+}
+
+func TestCalculateInterval(t *testing.T) {
+	s, _ := newTestServer(t)
+	rec := serve(s, httptest.NewRequest(http.MethodPost, "/calculate/interval", strings.NewReader(`{"r": 3.9, "x0": 0.3, "n": 30}`)))
+	var resp models.IntervalResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("status %d: %v", rec.Code, err)
+	}
+	x := 0.3
+	for i := 0; i < 30; i++ {
+		x = 3.9 * x * (1 - x)
+	}
+	if !(resp.Lo < resp.Hi) || math.Abs(x-resp.Mid) > resp.Width || resp.Width < resp.Hi-resp.Lo {
+		t.Errorf("response %+v, want a narrow interval around %v", resp, x)
+	}
+
+	for _, body := range []string{
+		`{"r": 4.5, "x0": 0.3, "n": 10}`,
+		`{"r": 3.9, "x0": -0.1, "n": 10}`,
+		`{"r": 3.9, "x0": 0.3, "n": 2000000}`,
+	} {
+		rec := serve(s, httptest.NewRequest(http.MethodPost, "/calculate/interval", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, rec.Code)
+		}
+	}
+}
+
+func TestLogTrajectory(t *testing.T) {
+	s, _ := newTestServer(t)
+	post := func(body string) *httptest.ResponseRecorder {
+		return serve(s, httptest.NewRequest(http.MethodPost, "/trajectory/log", strings.NewReader(body)))
+	}
+
+	rec := post(`{"r": 3.7, "max_n": 1000}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var resp models.LogTrajectoryResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Base != 2 || len(resp.Points) != 10 || resp.Points[0].N != 1 || resp.Points[9].N != 512 {
+		t.Errorf("response = %+v, want n = 1, 2, ..., 512 in base 2", resp)
+	}
+	for _, p := range resp.Points {
+		if want, _ := s.engine.Compute(context.Background(), 3.7, p.N); p.X != want {
+			t.Errorf("x at n=%d = %v, want %v", p.N, p.X, want)
+		}
+	}
+
+	for _, body := range []string{`{"r": 3.7, "max_n": 0}`, `{"r": 3.7, "max_n": 1000, "base": 1}`} {
+		if rec := post(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", body, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestCorrelationIsRateLimited(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	post := func() *httptest.ResponseRecorder {
+		return serve(s, httptest.NewRequest(http.MethodPost, "/correlation",
+			strings.NewReader(`{"r": 3.9, "n": 5000, "transient": 500}`)))
+	}
+
+	rec := post()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var resp models.CorrelationResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Points != 4500 || len(resp.Radii) != 20 || len(resp.Integrals) != 20 || resp.Slope <= 0 {
+		t.Errorf("response = %+v, want 4500 points over 20 radii and a positive slope", resp)
+	}
+
+	if rec := post(); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("second request: status %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestCalculateBinaryRoundTrip(t *testing.T) {
+	s, _ := newTestServer(t)
+	requests := []models.Request{{R: 3.5, N: 100}, {R: 3.7, N: 2000}, {R: 3.5, N: 50}, {R: 3.7, N: 2000}}
+
+	var body []byte
+	for _, req := range requests {
+		body = binary.LittleEndian.AppendUint64(body, math.Float64bits(req.R))
+		body = binary.LittleEndian.AppendUint64(body, uint64(req.N))
+	}
+	req := httptest.NewRequest(http.MethodPost, "/calculate", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	rec := serve(s, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/octet-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	data := rec.Body.Bytes()
+	if len(data) != 8*len(requests) {
+		t.Fatalf("response has %d bytes, want %d", len(data), 8*len(requests))
+	}
+	for i, req := range requests {
+		got := math.Float64frombits(binary.LittleEndian.Uint64(data[8*i:]))
+		want, err := s.engine.Compute(context.Background(), req.R, req.N)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("record %d (r=%v, n=%d) = %v, want %v", i, req.R, req.N, got, want)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/calculate", bytes.NewReader(body[:20]))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if rec := serve(s, req); rec.Code != http.StatusBadRequest {
+		t.Errorf("truncated record: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	for _, bad := range []models.Request{{R: math.NaN(), N: 10}, {R: math.Inf(1), N: 10}, {R: 3.7, N: -1}} {
+		body := binary.LittleEndian.AppendUint64(nil, math.Float64bits(3.5))
+		body = binary.LittleEndian.AppendUint64(body, 10)
+		body = binary.LittleEndian.AppendUint64(body, math.Float64bits(bad.R))
+		body = binary.LittleEndian.AppendUint64(body, uint64(bad.N))
+		req := httptest.NewRequest(http.MethodPost, "/calculate", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/octet-stream")
+		rec := serve(s, req)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "record 2") {
+			t.Errorf("r=%v n=%d: status %d, body %q; want 400 naming record 2", bad.R, bad.N, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestPointCeilingsWithoutSharedCap(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	cfg.MaxPointsPerRequest = 0
+	eng := engine.NewComputeEngine(cfg)
+	t.Cleanup(eng.Close)
+	s := NewServer(cfg, eng)
+
+	for _, tc := range []struct {
+		path          string
+		within, above string
+	}{
+		{"/trajectory/compare", `{"r1": 3.5, "r2": 3.6, "n": 9999, "stride": 1}`, `{"r1": 3.5, "r2": 3.6, "n": 10000, "stride": 1}`},
+		{"/density", `{"r": 3.9, "n": 1000, "bins": 1000}`, `{"r": 3.9, "n": 1000, "bins": 1001}`},
+	} {
+		if rec := serve(s, httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.within))); rec.Code != http.StatusOK {
+			t.Errorf("%s at the ceiling: status %d: %s", tc.path, rec.Code, rec.Body)
+		}
+		if rec := serve(s, httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.above))); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s above the ceiling: status %d, want 400", tc.path, rec.Code)
+		}
+	}
+}
+
+func TestMaxPointsPerRequest(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	cfg.MaxPointsPerRequest = 5
+	cfg.CorrelationRateLimit = 0
+	eng := engine.NewComputeEngine(cfg)
+	t.Cleanup(eng.Close)
+	s := NewServer(cfg, eng)
+
+	for _, tc := range []struct {
+		path          string
+		within, above string
+	}{
+		{"/calculate/rs", `{"rs": [3.1, 3.2, 3.3, 3.4, 3.5], "n": 10}`, `{"rs": [3.1, 3.2, 3.3, 3.4, 3.5, 3.6], "n": 10}`},
+		{"/trajectory/compare", `{"r1": 3.5, "r2": 3.6, "n": 40, "stride": 10}`, `{"r1": 3.5, "r2": 3.6, "n": 41, "stride": 10}`},
+		{"/density", `{"r": 3.9, "n": 1000, "bins": 5}`, `{"r": 3.9, "n": 1000, "bins": 6}`},
+		{"/bifurcations", `{"r_min": 2.9, "r_max": 3.3, "steps": 4, "transient": 100}`, `{"r_min": 2.9, "r_max": 3.3, "steps": 5, "transient": 100}`},
+		{"/sample", `{"a": 3, "b": 4, "count": 5, "n": 100}`, `{"a": 3, "b": 4, "count": 6, "n": 100}`},
+		{"/correlation", `{"r": 3.9, "n": 2000, "radii": 5}`, `{"r": 3.9, "n": 2000, "radii": 6}`},
+	} {
+		rec := serve(s, httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.within)))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s at the cap: status %d, want %d: %s", tc.path, rec.Code, http.StatusOK, rec.Body)
+		}
+		rec = serve(s, httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.above)))
+		if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "6 points, above the limit of 5") {
+			t.Errorf("%s above the cap: status %d, body %q", tc.path, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestShardMap(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 3
+	eng := engine.NewComputeEngine(cfg)
+	t.Cleanup(eng.Close)
+	s := NewServer(cfg, eng)
+
+	post := func(body string) (*httptest.ResponseRecorder, models.ShardMapResponse) {
+		rec := serve(s, httptest.NewRequest(http.MethodPost, "/shard-map", strings.NewReader(body)))
+		var resp models.ShardMapResponse
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec, resp
+	}
+
+	rec, resp := post(`{"rs": [3.7, 3.5, 3.2]}`)
+	if rec.Code != http.StatusOK || resp.TotalPods != 3 || len(resp.Assignments) != 3 {
+		t.Fatalf("status %d, response %+v", rec.Code, resp)
+	}
+	for i, r := range []float64{3.7, 3.5, 3.2} {
+		if a := resp.Assignments[i]; a.R != r || a.Pod != engine.GetPodForR(engine.HashFloat64(r), 3) {
+			t.Errorf("assignment %d = %+v, want r=%v on pod %d", i, a, r, engine.GetPodForR(engine.HashFloat64(r), 3))
+		}
+	}
+
+	_, resp = post(`{"r_min": 3, "r_max": 4, "steps": 999}`)
+	total := 0
+	for pod, count := range resp.Counts {
+		total += count
+		if count < 250 {
+			t.Errorf("pod %d owns %d of 1000 r values, want about a third", pod, count)
+		}
+	}
+	if total != 1000 || len(resp.Assignments) != 1000 || resp.Assignments[999].R != 4 {
+		t.Errorf("range mapped %d r values, last %+v, want 1000 ending at r=4", total, resp.Assignments[len(resp.Assignments)-1])
+	}
+
+	for _, body := range []string{`{}`, `{"rs": [3.5], "steps": 10}`, `{"r_min": 4, "r_max": 3, "steps": 10}`} {
+		if rec, _ := post(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, rec.Code)
+		}
+	}
+	if rec, _ := post(`{"r_min": 3, "r_max": 4, "steps": 20000}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("range above MAX_POINTS_PER_REQUEST: status %d, want 422", rec.Code)
+	}
+}
+
+func TestTimeAverage(t *testing.T) {
+	s, _ := newTestServer(t)
+	post := func(body string) (*httptest.ResponseRecorder, models.TimeAverageResponse) {
+		rec := serve(s, httptest.NewRequest(http.MethodPost, "/trajectory/mean", strings.NewReader(body)))
+		var resp models.TimeAverageResponse
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec, resp
+	}
+
+	// At r=3.2 the orbit alternates between two points, so the mean over an
+	// even n lies halfway between them.
+	rec, resp := post(`{"r": 3.2, "n": 100000, "variance": true}`)
+	lo, hi := (4.2-math.Sqrt(4.2*0.2))/6.4, (4.2+math.Sqrt(4.2*0.2))/6.4
+	if rec.Code != http.StatusOK || resp.Variance == nil || math.Abs(resp.Mean-(lo+hi)/2) > 1e-4 {
+		t.Fatalf("status %d, response %+v, want mean %v", rec.Code, resp, (lo+hi)/2)
+	}
+	if want := (hi - lo) * (hi - lo) / 4; math.Abs(*resp.Variance-want) > 1e-4 {
+		t.Errorf("variance %v, want %v", *resp.Variance, want)
+	}
+	if _, resp := post(`{"r": 3.2, "n": 100000}`); resp.Variance != nil {
+		t.Errorf("variance %v returned without being asked for", *resp.Variance)
+	}
+	if rec, _ := post(`{"r": 3.2, "n": 0}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("n=0: status %d, want 400", rec.Code)
+	}
+}
+
+func TestCalculateMemoryBudget(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	cfg.MemoryBudget = 10 << 20
+	eng := engine.NewComputeEngine(cfg)
+	t.Cleanup(eng.Close)
+	s := NewServer(cfg, eng)
+
+	post := func(n int64) *httptest.ResponseRecorder {
+		body, _ := json.Marshal([]models.Request{{R: 3.7, N: n}, {R: 3.8, N: n / 2}})
+		return serve(s, httptest.NewRequest(http.MethodPost, "/calculate", bytes.NewReader(body)))
+	}
+
+	if rec := post(1000000); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("batch above the budget: status = %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+
+	// Holding Redis keeps the first batch at its checkpoint lookup, and its
+	// 9MB or so reserved, while the second asks for as much again.
+	mr.Lock()
+	first := make(chan int, 1)
+	go func() { first <- post(150000).Code }()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.memory.mu.Lock()
+		inFlight := s.memory.inFlight
+		s.memory.mu.Unlock()
+		if inFlight > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			mr.Unlock()
+			t.Fatal("first batch never reserved its working set")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	rec := post(150000)
+	mr.Unlock()
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("batch beside one in flight: status = %d, Retry-After %q, want %d with Retry-After",
+			rec.Code, rec.Header().Get("Retry-After"), http.StatusTooManyRequests)
+	}
+	if code := <-first; code != http.StatusOK {
+		t.Errorf("first batch: status = %d, want %d", code, http.StatusOK)
+	}
+
+	if rec := post(150000); rec.Code != http.StatusOK {
+		t.Errorf("batch after the first finished: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestWorkingSetSaturates(t *testing.T) {
+	tests := []struct {
+		name     string
+		requests []models.Request
+		want     int64
+	}{
+		{"small", []models.Request{{R: 3.7, N: 99}, {R: 3.8, N: 9}}, 110 * seriesEntryBytes},
+		{"largest n", []models.Request{{R: 3.7, N: math.MaxInt64}}, math.MaxInt64},
+		{"just past the limit", []models.Request{{R: 3.7, N: maxSeriesSteps}}, math.MaxInt64},
+		{"sum overflows", []models.Request{{R: 3.7, N: maxSeriesSteps / 2}, {R: 3.8, N: maxSeriesSteps / 2}, {R: 3.9, N: maxSeriesSteps / 2}}, math.MaxInt64},
+	}
+	for _, tt := range tests {
+		if got := workingSet(tt.requests, 10); got != tt.want {
+			t.Errorf("%s: workingSet = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	// A batch whose estimate would wrap negative must still be refused.
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	cfg.MemoryBudget = 10 << 20
+	eng := engine.NewComputeEngine(cfg)
+	t.Cleanup(eng.Close)
+	s := NewServer(cfg, eng)
+	body, _ := json.Marshal([]models.Request{{R: 3.7, N: math.MaxInt64 / 20}})
+	rec := serve(s, httptest.NewRequest(http.MethodPost, "/calculate", bytes.NewReader(body)))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("batch overflowing the estimate: status = %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+
+	// Pinned series are held beside the L1_CACHE_SIZE ring, so they count
+	// too.
+	cfg.CacheSize = 3
+	cfg.PinnedRValues = []float64{3.5, 3.6}
+	pinned := engine.NewComputeEngine(cfg)
+	t.Cleanup(pinned.Close)
+	if got := NewServer(cfg, pinned).maxSeries; got != 5 {
+		t.Errorf("maxSeries = %d, want 3 ring series plus 2 pinned", got)
+	}
+}
+
+// newRemoteServer returns a server allowed to fetch datasets under /data/
+// of a test origin serving files, and that origin's URL.
+func newRemoteServer(t *testing.T, files map[string]string) (*Server, string) {
+	t.Helper()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/data/moved" {
+			http.Redirect(w, r, "/private/list", http.StatusFound)
+			return
+		}
+		body, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(origin.Close)
+
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	cfg.DatasetURLPrefixes = []string{origin.URL + "/data/"}
+	cfg.DatasetMaxBytes = 200
+	eng := engine.NewComputeEngine(cfg)
+	t.Cleanup(eng.Close)
+	return NewServer(cfg, eng), origin.URL
+}
+
+func TestCalculateRemote(t *testing.T) {
+	s, origin := newRemoteServer(t, map[string]string{
+		"/data/lines":   "{\"r\": 3.5, \"n\": 10}\n\n{\"r\": 3.7, \"n\": 20}\n",
+		"/data/array":   `[{"r": 3.5, "n": 10}, {"r": 3.7, "n": 20}]`,
+		"/data/bad":     "{\"r\": 3.5, \"n\": 10}\n{\"r\": 3.7, \"n\": -1}\n",
+		"/data/large":   strings.Repeat(`{"r": 3.5, "n": 10}`+"\n", 20),
+		"/private/list": `[{"r": 3.5, "n": 10}]`,
+	})
+	post := func(url string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(models.RemoteRequest{URL: url})
+		return serve(s, httptest.NewRequest(http.MethodPost, "/calculate/remote", bytes.NewReader(body)))
+	}
+
+	for _, path := range []string{"/data/lines", "/data/array"} {
+		rec := post(origin + path)
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-ndjson" {
+			t.Fatalf("%s: status = %d, content type %q", path, rec.Code, rec.Header().Get("Content-Type"))
+		}
+		dec := json.NewDecoder(rec.Body)
+		for _, want := range []struct {
+			r float64
+			n int64
+		}{{3.5, 10}, {3.7, 20}} {
+			var resp models.Response
+			if err := dec.Decode(&resp); err != nil {
+				t.Fatalf("%s: %v", path, err)
+			}
+			x := 0.5
+			for i := int64(0); i < want.n; i++ {
+				x = want.r * x * (1 - x)
+			}
+			if resp.R != want.r || resp.N != want.n || resp.Result != x {
+				t.Errorf("%s: got %+v, want x_%d(%v) = %v", path, resp, want.n, want.r, x)
+			}
+		}
+		if dec.More() {
+			t.Errorf("%s: more results than records", path)
+		}
+	}
+
+	for _, tc := range []struct {
+		url  string
+		want int
+	}{
+		{origin + "/data/bad", http.StatusBadRequest},
+		{origin + "/data/large", http.StatusUnprocessableEntity},
+		{origin + "/data/missing", http.StatusBadGateway},
+		{origin + "/data/moved", http.StatusBadGateway},
+		{origin + "/private/list", http.StatusForbidden},
+		{origin + ".evil.example/data/lines", http.StatusForbidden},
+	} {
+		if rec := post(tc.url); rec.Code != tc.want {
+			t.Errorf("%s: status = %d, want %d", tc.url, rec.Code, tc.want)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	s, mr := newTestServer(t)
+	mr.ZAdd(fmt.Sprintf("cp:%d", engine.HashFloat64(3.7)), 1000, "5.000000000000000e-01")
+	serve(s, httptest.NewRequest(http.MethodGet, "/calculate?r=3.7&n=1500", nil))
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+	}
+	var stats models.Stats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.PodID != "pod-0" || stats.CheckpointResumes != 1 || stats.IterationsSaved != 1000 {
+		t.Errorf("stats = %+v, want one resume on pod-0 saving 1000 iterations", stats)
+	}
+
+	if rec := serve(s, httptest.NewRequest(http.MethodPost, "/stats", nil)); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /stats = %d, want 405", rec.Code)
+	}
+}
+
+func TestAsyncCancel(t *testing.T) {
+	s, _ := newTestServer(t)
+	del := func(id string) *httptest.ResponseRecorder {
+		return serve(s, httptest.NewRequest(http.MethodDelete, "/compute/async/"+id, nil))
+	}
+
+	if rec := del("missing"); rec.Code != http.StatusNotFound {
+		t.Errorf("DELETE unknown job = %d, want 404", rec.Code)
+	}
+
+	// r = 3.7 is chaotic, so the job runs until it is cancelled.
+	rec := serve(s, httptest.NewRequest(http.MethodPost, "/compute/async",
+		strings.NewReader(`[{"r": 3.7, "n": 1099511627776}]`)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("submit = %d, body %q", rec.Code, rec.Body.String())
+	}
+	var job models.Job
+	json.Unmarshal(rec.Body.Bytes(), &job)
+
+	rec = del(job.ID)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("DELETE = %d, body %q", rec.Code, rec.Body.String())
+	}
+	json.Unmarshal(rec.Body.Bytes(), &job)
+	if job.Status != models.JobCancelled {
+		t.Errorf("DELETE returned status %q, want cancelled", job.Status)
+	}
+
+	rec = serve(s, httptest.NewRequest(http.MethodGet, "/compute/async/"+job.ID, nil))
+	json.Unmarshal(rec.Body.Bytes(), &job)
+	if job.Status != models.JobCancelled {
+		t.Errorf("GET after cancel = %q, want cancelled", job.Status)
+	}
+	if rec := del(job.ID); rec.Code != http.StatusConflict {
+		t.Errorf("second DELETE = %d, want 409", rec.Code)
+	}
+}
+
+func TestServerListensOnBindAddr(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	cfg.BindAddr = "127.0.0.1"
+	cfg.Port = "0"
+	eng := engine.NewComputeEngine(cfg)
+	t.Cleanup(eng.Close)
+	s := NewServer(cfg, eng)
+	if s.server.Addr != "127.0.0.1:0" {
+		t.Fatalf("server address = %q, want 127.0.0.1:0", s.server.Addr)
+	}
+
+	ln, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.server.Serve(ln)
+	defer s.server.Close()
+
+	resp, err := http.Get("http://" + ln.Addr().String() + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /health on %s = %d, want 200", ln.Addr(), resp.StatusCode)
+	}
+
+	cfg.BindAddr = "::1"
+	cfg.Port = "2586"
+	if addr := NewServer(cfg, eng).server.Addr; addr != "[::1]:2586" {
+		t.Errorf("IPv6 server address = %q, want [::1]:2586", addr)
+	}
+}
+
+func TestBasins(t *testing.T) {
+	s, _ := newTestServer(t)
+	body := `{"r_range": [2.8, 3.2], "x0_range": [0.1, 0.6], "steps": 4}`
+
+	rec := serve(s, httptest.NewRequest(http.MethodPost, "/basins", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp models.BasinResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Cells) != 25 {
+		t.Fatalf("got %d cells, want 25", len(resp.Cells))
+	}
+	if first, last := resp.Cells[0], resp.Cells[24]; first.R != 2.8 || first.X0 != 0.1 || first.Period != 1 ||
+		last.R != 3.2 || last.X0 != 0.6 || last.Period != 2 {
+		t.Errorf("first cell %+v, last cell %+v", first, last)
+	}
+
+	rec = serve(s, httptest.NewRequest(http.MethodPost, "/basins/stream", strings.NewReader(body)))
+	if ct := rec.Header().Get("Content-Type"); rec.Code != http.StatusOK || ct != "application/x-ndjson" {
+		t.Fatalf("stream: status %d, content type %q", rec.Code, ct)
+	}
+	dec := json.NewDecoder(rec.Body)
+	for i := 0; dec.More(); i++ {
+		var cell models.BasinCell
+		if err := dec.Decode(&cell); err != nil {
+			t.Fatal(err)
+		}
+		if i >= len(resp.Cells) || cell != resp.Cells[i] {
+			t.Fatalf("streamed cell %d = %+v, want the cells of the document", i, cell)
+		}
+	}
+
+	for _, body := range []string{
+		`{"r_range": [3.2, 2.8], "x0_range": [0, 1], "steps": 4}`,
+		`{"r_range": [2.8, 3.2], "x0_range": [0, 1], "steps": 0}`,
+		`{"r_range": [2.8, 3.2], "x0_range": [0, 1], "steps": 500}`,
+		`{"r_range": [2.8, 3.2], "x0_range": [0, 1], "steps": 4, "n": 1}`,
+		`{"r_range": [2.8, 3.2], "x0_range": [0, 1], "steps": 4, "transient": -1}`,
+	} {
+		if rec := serve(s, httptest.NewRequest(http.MethodPost, "/basins", strings.NewReader(body))); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, rec.Code)
+		}
+	}
+}
+
+func TestTrajectoryCompare(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	rec := serve(s, httptest.NewRequest(http.MethodPost, "/trajectory/compare",
+		strings.NewReader(`{"r1": 3.5, "r2": 3.6, "n": 25, "stride": 10}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp models.TrajectoryCompareResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	wantN := []int64{0, 10, 20, 25}
+	if len(resp.Points) != len(wantN) {
+		t.Fatalf("got %d points, want %d", len(resp.Points), len(wantN))
+	}
+	for i, p := range resp.Points {
+		x1, _ := s.engine.Compute(context.Background(), 3.5, p.N)
+		x2, _ := s.engine.Compute(context.Background(), 3.6, p.N)
+		if p.N != wantN[i] || p.X1 != x1 || p.X2 != x2 {
+			t.Errorf("point %d = %+v, want n=%d x1=%v x2=%v", i, p, wantN[i], x1, x2)
+		}
+	}
+
+	for _, body := range []string{
+		`{"r1": 3.5, "r2": 3.6, "n": 10, "stride": 0}`,
+		`{"r1": 3.5, "r2": 3.6, "n": -1, "stride": 1}`,
+		// Few points, but a walk that would fill L1.
+		`{"r1": 3.5, "r2": 3.6, "n": 1000001, "stride": 1000000}`,
+		`[]`,
+	} {
+		rec := serve(s, httptest.NewRequest(http.MethodPost, "/trajectory/compare", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, rec.Code)
+		}
+	}
+}
+
+// TestComputeErrorsAreAnswered checks that a compute the engine gives up on,
+// here because the client went away, gets an error status and body rather
+// than an empty 200.
+func TestComputeErrorsAreAnswered(t *testing.T) {
+	s, _ := newTestServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, tc := range []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+	}{
+		{"/trajectory/compare", s.handleTrajectoryCompare, `{"r1": 3.5, "r2": 3.6, "n": 100000, "stride": 1000}`},
+		{"/density", s.handleDensity, `{"r": 3.9, "n": 100000, "bins": 10}`},
+		{"/bifurcations", s.handleBifurcations, `{"r_min": 2.9, "r_max": 3.3, "steps": 4, "transient": 100000}`},
+		{"/sample", s.handleSample, `{"a": 3, "b": 4, "count": 5, "n": 100000}`},
+		{"/calculate/adaptive", s.handleCalculateAdaptive, `{"r": 3.9, "n": 10000}`},
+		{"/correlation", s.handleCorrelation, `{"r": 3.9, "n": 100000, "transient": 10000}`},
+		{"/transient", s.handleTransient, `{"r": 3.9, "max_n": 100000}`},
+		{"/sensitivity", s.handleSensitivity, `{"r": 3.9, "x0": 0.3, "n": 100000}`},
+		{"/trajectory/log", s.handleLogTrajectory, `{"r": 3.9, "max_n": 1000000}`},
+		{"/calculate/interval", s.handleCalculateInterval, `{"r": 3.2, "n": 100000}`},
+		{"/trajectory/mean", s.handleTimeAverage, `{"r": 3.9, "n": 100000}`},
+		{"/basins", s.handleBasins, `{"r_range": [2.8, 3.2], "x0_range": [0, 1], "steps": 4, "transient": 100000}`},
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, tc.name, strings.NewReader(tc.body)).WithContext(ctx)
+		tc.handler(rec, req)
+		if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "failed") {
+			t.Errorf("%s: status %d, body %q; want 500", tc.name, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestKeysReportsSeriesParams(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	cfg.AdminToken = "secret"
+	eng := engine.NewComputeEngine(cfg)
+	t.Cleanup(eng.Close)
+	s := NewServer(cfg, eng)
+
+	ctx := context.Background()
+	if _, err := eng.Compute(ctx, 3.5, 20); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := eng.ComputePerturbed(ctx, 3.5, 0.001, 20); err != nil {
+		t.Fatal(err)
+	}
+	// A checkpoint preheated from Redis carries only its key.
+	mr.ZAdd(fmt.Sprintf("cp:%d", engine.HashFloat64(3.6)), 10, "10:5.000000000000000e-01")
+	eng.PreheatCache(ctx)
+
+	req := httptest.NewRequest(http.MethodGet, "/keys", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := serve(s, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp models.KeysResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Total != 3 {
+		t.Fatalf("total = %d, want 3", resp.Total)
+	}
+	for _, key := range resp.Keys {
+		switch key.RHash {
+		case engine.HashFloat64(3.5):
+			if key.R == nil || *key.R != 3.5 {
+				t.Errorf("plain series: r = %v, want 3.5", key.R)
+			}
+		case engine.HashSeries(3.5, 0.001):
+			if key.R == nil || *key.R != 3.5 || key.C != 0.001 {
+				t.Errorf("perturbed series: r = %v, c = %v; want 3.5, 0.001", key.R, key.C)
+			}
+		case engine.HashSeries(3.6, 0):
+			if key.R != nil {
+				t.Errorf("preheated series: r = %v, want none", *key.R)
+			}
+		default:
+			t.Errorf("unexpected key %+v", key)
+		}
+	}
+}
+
+func TestKeysPagination(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	cfg.AdminToken = "secret"
+	eng := engine.NewComputeEngine(cfg)
+	t.Cleanup(eng.Close)
+	s := NewServer(cfg, eng)
+
+	rs := []float64{3.1, 3.2, 3.3, 3.4, 3.5}
+	for _, r := range rs {
+		if _, err := eng.Compute(context.Background(), r, 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func(query, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/keys"+query, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return serve(s, req)
+	}
+	page := func(query string) models.KeysResponse {
+		t.Helper()
+		rec := get(query, "secret")
+		var resp models.KeysResponse
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", query, rec.Code, rec.Body)
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	for _, token := range []string{"", "wrong"} {
+		if rec := get("", token); rec.Code != http.StatusUnauthorized {
+			t.Errorf("token %q: status %d, want %d", token, rec.Code, http.StatusUnauthorized)
+		}
+	}
+
+	all := page("")
+	if all.Total != len(rs) || len(all.Keys) != len(rs) {
+		t.Fatalf("all keys: total %d, %d listed; want %d", all.Total, len(all.Keys), len(rs))
+	}
+	for _, key := range all.Keys {
+		// x_1..x_10 are cached.
+		if key.Entries != 10 || key.MaxN != 10 {
+			t.Errorf("key %d: %d entries up to n=%d, want 10 up to 10", key.RHash, key.Entries, key.MaxN)
+		}
+	}
+
+	// Pages are slices of the same stable order.
+	second := page("?offset=2&limit=2")
+	if second.Total != len(rs) || second.Offset != 2 || len(second.Keys) != 2 ||
+		second.Keys[0].RHash != all.Keys[2].RHash || second.Keys[1].RHash != all.Keys[3].RHash {
+		t.Errorf("offset=2&limit=2 = %+v, want keys 2 and 3 of %+v", second, all.Keys)
+	}
+	if past := page("?offset=10"); past.Total != len(rs) || len(past.Keys) != 0 {
+		t.Errorf("offset past the end = %+v, want no keys", past)
+	}
+
+	for _, query := range []string{"?offset=-1", "?offset=x", "?limit=0", "?limit=x"} {
+		if rec := get(query, "secret"); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", query, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestDensity(t *testing.T) {
+	s, _ := newTestServer(t)
+	post := func(body string) *httptest.ResponseRecorder {
+		return serve(s, httptest.NewRequest(http.MethodPost, "/density", strings.NewReader(body)))
+	}
+
+	rec := post(`{"r": 3.9, "n": 10000, "bins": 4, "transient": 1000}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var resp models.DensityResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, c := range resp.Counts {
+		total += c
+	}
+	if len(resp.Counts) != 4 || resp.BinWidth != 0.25 || resp.Samples != 9000 || total+resp.Outside != 9000 {
+		t.Errorf("response = %+v, want 9000 samples in 4 bins of width 0.25", resp)
+	}
+
+	for _, body := range []string{
+		`{"r": 3.9, "n": 1000, "bins": 0}`,
+		`{"r": 3.9, "n": 0, "bins": 4}`,
+		`{"r": 3.9, "n": 1000001, "bins": 4}`,
+		`{"r": 3.9, "n": 1000, "bins": 4, "transient": 1000}`,
+		`{"r": 3.9, "n": 1000, "bins": 4, "transient": -1}`,
+		`not json`,
+	} {
+		if rec := post(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", body, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestCalculateRs(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.RedisAddr = mr.Addr()
+	cfg.TotalPods = 1
+	cfg.MaxBatchSize = 4
+	eng := engine.NewComputeEngine(cfg)
+	t.Cleanup(eng.Close)
+	s := NewServer(cfg, eng)
+	post := func(body string) *httptest.ResponseRecorder {
+		return serve(s, httptest.NewRequest(http.MethodPost, "/calculate/rs", strings.NewReader(body)))
+	}
+
+	rec := post(`{"rs": [3.7, 3.2, 3.7, 2.9], "n": 100}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var responses []models.Response
+	if err := json.NewDecoder(rec.Body).Decode(&responses); err != nil {
+		t.Fatal(err)
+	}
+	rs := []float64{3.7, 3.2, 3.7, 2.9}
+	if len(responses) != len(rs) {
+		t.Fatalf("%d responses, want %d", len(responses), len(rs))
+	}
+	for i, r := range rs {
+		want, _ := eng.Compute(context.Background(), r, 100)
+		if responses[i].R != r || responses[i].Result != want {
+			t.Errorf("response %d = r=%v %v, want r=%v %v", i, responses[i].R, responses[i].Result, r, want)
+		}
+	}
+
+	for _, body := range []string{`{"rs": [3.1, 3.2, 3.3, 3.4, 3.5], "n": 100}`, `{"rs": [3.1], "n": -1}`, `not json`} {
+		if rec := post(body); rec.Code < 400 || rec.Code >= 500 {
+			t.Errorf("%s: status %d, want a client error", body, rec.Code)
+		}
+	}
+}
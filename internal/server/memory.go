@@ -0,0 +1,100 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+
+	"resilientrecursion/internal/models"
+)
+
+// seriesEntryBytes is about what one cached step of a series costs in L1,
+// map overhead included: a million steps measure close to 38MB.
+const seriesEntryBytes = 40
+
+// maxSeriesSteps is the most steps whose working set an int64 still holds.
+const maxSeriesSteps = math.MaxInt64 / seriesEntryBytes
+
+// memoryBudget admits requests while the estimated working sets of those in
+// flight fit within limit bytes.
+type memoryBudget struct {
+	limit int64
+
+	mu       sync.Mutex
+	inFlight int64
+}
+
+// acquire reserves bytes and reports whether they fit.
+func (m *memoryBudget) acquire(bytes int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if bytes > m.limit-m.inFlight {
+		return false
+	}
+	m.inFlight += bytes
+	return true
+}
+
+func (m *memoryBudget) release(bytes int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight -= bytes
+}
+
+// workingSet estimates the bytes a batch adds to L1: every step up to the
+// largest n of each series is cached, and L1 keeps at most maxSeries series,
+// so only the largest maxSeries of them count. An estimate too large for an
+// int64 saturates at math.MaxInt64.
+func workingSet(requests []models.Request, maxSeries int) int64 {
+	maxN := make(map[[2]float64]int64, len(requests))
+	for _, req := range requests {
+		id := [2]float64{req.R, req.C}
+		if req.N > maxN[id] {
+			maxN[id] = req.N
+		}
+	}
+	ns := make([]int64, 0, len(maxN))
+	for _, n := range maxN {
+		ns = append(ns, n)
+	}
+	sort.Slice(ns, func(i, j int) bool { return ns[i] > ns[j] })
+	if len(ns) > maxSeries {
+		ns = ns[:maxSeries]
+	}
+	var bytes int64
+	for _, n := range ns {
+		if n >= maxSeriesSteps-1 {
+			return math.MaxInt64
+		}
+		entry := (n + 1) * seriesEntryBytes
+		if bytes > math.MaxInt64-entry {
+			return math.MaxInt64
+		}
+		bytes += entry
+	}
+	return bytes
+}
+
+// admitMemory reserves the working set of requests against the memory
+// budget. A batch that could never fit gets 422 and one that does not fit
+// beside those in flight gets 429; either way it reports false. Otherwise
+// the caller must call the returned release once the batch is computed.
+func (s *Server) admitMemory(w http.ResponseWriter, requests []models.Request) (release func(), ok bool) {
+	if s.memory == nil {
+		return func() {}, true
+	}
+	bytes := workingSet(requests, s.maxSeries)
+	if bytes > s.memory.limit {
+		http.Error(w, fmt.Sprintf("Batch needs an estimated %d bytes, above the memory budget of %d", bytes, s.memory.limit),
+			http.StatusUnprocessableEntity)
+		return nil, false
+	}
+	if !s.memory.acquire(bytes) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Memory budget exhausted, retry later", http.StatusTooManyRequests)
+		return nil, false
+	}
+	return func() { s.memory.release(bytes) }, true
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// newPprofServer serves the net/http/pprof handlers under /debug/pprof/ on
+// addr, every one of them behind admin auth. It is a listener of its own so
+// profiles are never reachable on the public port. There is no write
+// timeout, since a CPU profile or trace streams for as long as the client
+// asks.
+func (s *Server) newPprofServer(addr string, readTimeout time.Duration) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", s.requireAuth(pprof.Index))
+	mux.HandleFunc("/debug/pprof/cmdline", s.requireAuth(pprof.Cmdline))
+	mux.HandleFunc("/debug/pprof/profile", s.requireAuth(pprof.Profile))
+	mux.HandleFunc("/debug/pprof/symbol", s.requireAuth(pprof.Symbol))
+	mux.HandleFunc("/debug/pprof/trace", s.requireAuth(pprof.Trace))
+
+	return &http.Server{
+		Addr:        addr,
+		Handler:     mux,
+		ReadTimeout: readTimeout,
+	}
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"net/http"
+
+	"resilientrecursion/internal/engine"
+	"resilientrecursion/internal/worker"
+)
+
+// priorityHeader selects the worker pool priority of a request's queued
+// work: "high" or "low" (the default).
+const priorityHeader = "X-Priority"
+
+// withPriority passes the requested worker priority on to the engine through
+// the request context and rejects unknown values.
+func withPriority(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		priority := worker.Low
+		switch r.Header.Get(priorityHeader) {
+		case "", "low":
+		case "high":
+			priority = worker.High
+		default:
+			http.Error(w, "Invalid X-Priority", http.StatusBadRequest)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(engine.WithPriority(r.Context(), priority)))
+	})
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"resilientrecursion/internal/engine"
+	"resilientrecursion/internal/logging"
+	"resilientrecursion/internal/models"
+)
+
+// errDatasetTooLarge is returned by fetchDataset for a body above the limit.
+var errDatasetTooLarge = errors.New("dataset too large")
+
+// allowedDataset reports whether rawURL starts with one of the configured
+// prefixes.
+func (s *Server) allowedDataset(rawURL string) bool {
+	for _, prefix := range s.datasetPrefixes {
+		if strings.HasPrefix(rawURL, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// newDatasetClient fetches datasets within timeout, following redirects
+// only to URLs that are allowed themselves.
+func (s *Server) newDatasetClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("too many redirects")
+			}
+			if !s.allowedDataset(req.URL.String()) {
+				return fmt.Errorf("redirect to %s is not an allowed dataset URL", req.URL.Redacted())
+			}
+			return nil
+		},
+	}
+}
+
+// fetchDataset downloads the dataset at rawURL, at most datasetMaxBytes of
+// it.
+func (s *Server) fetchDataset(ctx context.Context, rawURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.datasetClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("dataset responded %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, s.datasetMaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > s.datasetMaxBytes {
+		return nil, errDatasetTooLarge
+	}
+	return data, nil
+}
+
+// parseDataset reads a JSON array of requests, or one JSON object per line
+// with blank lines skipped, and validates each record. Errors name the
+// record by its position, counted from 1.
+func parseDataset(data []byte) ([]models.Request, error) {
+	data = bytes.TrimSpace(data)
+	var requests []models.Request
+	if len(data) > 0 && data[0] == '[' {
+		if err := json.Unmarshal(data, &requests); err != nil {
+			return nil, fmt.Errorf("invalid JSON array: %v", err)
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(nil, len(data)+1)
+		for line := 1; scanner.Scan(); line++ {
+			text := bytes.TrimSpace(scanner.Bytes())
+			if len(text) == 0 {
+				continue
+			}
+			var req models.Request
+			if err := json.Unmarshal(text, &req); err != nil {
+				return nil, fmt.Errorf("line %d: invalid JSON: %v", line, err)
+			}
+			requests = append(requests, req)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, req := range requests {
+		if err := validateRecord(req); err != nil {
+			return nil, fmt.Errorf("record %d: %v", i+1, err)
+		}
+	}
+	return requests, nil
+}
+
+func validateRecord(req models.Request) error {
+	switch {
+	case math.IsNaN(req.R) || math.IsInf(req.R, 0):
+		return errors.New("r must be finite")
+	case math.IsNaN(req.C) || math.IsInf(req.C, 0):
+		return errors.New("c must be finite")
+	case req.N < 0:
+		return errors.New("n must not be negative")
+	case req.BudgetMs < 0:
+		return errors.New("budget_ms must not be negative")
+	}
+	return nil
+}
+
+// handleCalculateRemote serves POST /calculate/remote: it fetches the
+// dataset at the URL in the body, validates every record, and then streams
+// back one JSON response per line, in dataset order, as each is computed.
+// Nothing is computed if the fetch or any record fails. The stream runs on
+// the request context, so a client disconnecting stops it.
+func (s *Server) handleCalculateRemote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if len(s.datasetPrefixes) == 0 {
+		http.Error(w, "Remote datasets are disabled; set DATASET_URL_PREFIXES", http.StatusForbidden)
+		return
+	}
+
+	var req models.RemoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !s.allowedDataset(req.URL) {
+		http.Error(w, "URL is not under an allowed dataset prefix", http.StatusForbidden)
+		return
+	}
+
+	data, err := s.fetchDataset(r.Context(), req.URL)
+	if errors.Is(err, errDatasetTooLarge) {
+		http.Error(w, fmt.Sprintf("Dataset exceeds the limit of %d bytes", s.datasetMaxBytes), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		logging.Warnf("Dataset fetch error: %v", err)
+		status := http.StatusBadGateway
+		if errors.Is(err, context.DeadlineExceeded) || isTimeout(err) {
+			status = http.StatusGatewayTimeout
+		}
+		http.Error(w, "Could not fetch dataset", status)
+		return
+	}
+	requests, err := parseDataset(data)
+	if err != nil {
+		http.Error(w, "Invalid dataset: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	// Like /calculate/stream, the response outlives the write timeout.
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+	for _, item := range requests {
+		resp, err := s.engine.ComputeRequest(r.Context(), item)
+		if r.Context().Err() != nil {
+			return
+		}
+		var notOwner *engine.NotOwnerError
+		if errors.As(err, &notOwner) {
+			resp.OwnerPod = &notOwner.Owner
+		}
+		if err != nil {
+			resp.Error = err.Error()
+		} else {
+			s.engine.Sign(&resp)
+		}
+		enc.Encode(resp)
+		flusher.Flush()
+	}
+}
+
+// isTimeout reports whether err is a network timeout, as the dataset
+// client's own timeout is.
+func isTimeout(err error) bool {
+	var timeout interface{ Timeout() bool }
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
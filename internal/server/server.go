@@ -2,40 +2,133 @@
 
 import (
     "context"
-    "log"
     "net/http"
-    "time"
+    "sync/atomic"
 
     "resilientrecursion/internal/engine"
+    "resilientrecursion/internal/logging"
+    "resilientrecursion/pkg/config"
 )
 
 type Server struct {
-    engine *engine.ComputeEngine
-    server *http.Server
+    engine     *engine.ComputeEngine
+    server     *http.Server
+    adminToken string
+
+    // pprof is the profiling listener, nil unless PPROF_ADDR is set.
+    pprof *http.Server
+
+    maxBatchSize int
+    maxDistinctR int
+    maxPoints    int
+    maxMaps      int
+
+    // rejectRoundedR refuses an r with more digits than a float64 holds.
+    rejectRoundedR bool
+
+    // memory admits synchronous batches by estimated working set, nil
+    // unless MEMORY_BUDGET is set. maxSeries bounds the series it counts:
+    // the ring of CACHE_SIZE series plus the pinned ones held beside it.
+    memory    *memoryBudget
+    maxSeries int
+
+    // datasetPrefixes are the URL prefixes /calculate/remote may fetch.
+    datasetPrefixes []string
+    datasetMaxBytes int64
+    datasetClient   *http.Client
+
+    // queueBackend publishes POST /calculate batches to the job stream.
+    queueBackend bool
+
+    // The rate limiters are swapped by ApplyReload.
+    limiter            atomic.Pointer[rateLimiter]
+    adaptiveLimiter    atomic.Pointer[rateLimiter]
+    correlationLimiter atomic.Pointer[rateLimiter]
 }
 
-func NewServer(port string, eng *engine.ComputeEngine) *Server {
-    s := &Server{engine: eng}
+func NewServer(cfg *config.Config, eng *engine.ComputeEngine) *Server {
+    s := &Server{
+        engine:       eng,
+        adminToken:   cfg.AdminToken,
+        maxBatchSize: cfg.MaxBatchSize,
+        maxDistinctR: cfg.MaxDistinctR,
+        maxPoints:    cfg.MaxPointsPerRequest,
+        maxMaps:      cfg.MaxMapsPerRequest,
+        queueBackend: cfg.ComputeBackend == config.ComputeBackendQueue,
+
+        rejectRoundedR: cfg.RoundedR == config.RoundedRReject,
+
+        datasetPrefixes: cfg.DatasetURLPrefixes,
+        datasetMaxBytes: int64(cfg.DatasetMaxBytes),
+    }
+    s.datasetClient = s.newDatasetClient(cfg.DatasetTimeout)
+    s.ApplyReload(cfg)
+    if cfg.MemoryBudget > 0 {
+        s.memory = &memoryBudget{limit: int64(cfg.MemoryBudget)}
+        s.maxSeries = cfg.CacheSize + len(cfg.PinnedRValues)
+    }
     
     mux := http.NewServeMux()
     mux.HandleFunc("/calculate", s.handleCalculate)
+    mux.HandleFunc("/calculate/rs", s.handleCalculateRs)
+    mux.HandleFunc("/calculate/stream", s.handleCalculateStream)
+    mux.HandleFunc("/calculate/remote", s.handleCalculateRemote)
+    mux.HandleFunc("/calculate/adaptive", s.handleCalculateAdaptive)
+    mux.HandleFunc("/calculate/interval", s.handleCalculateInterval)
+    mux.HandleFunc("/calculate/maps", s.handleCalculateMaps)
+    mux.HandleFunc("/trajectory/compare", s.handleTrajectoryCompare)
+    mux.HandleFunc("/trajectory/log", s.handleLogTrajectory)
+    mux.HandleFunc("/trajectory/mean", s.handleTimeAverage)
+    mux.HandleFunc("/density", s.handleDensity)
+    mux.HandleFunc("/correlation", s.handleCorrelation)
+    mux.HandleFunc("/replay", s.handleReplay)
+    mux.HandleFunc("/bifurcations", s.handleBifurcations)
+    mux.HandleFunc("/transient", s.handleTransient)
+    mux.HandleFunc("/basins", s.handleBasins)
+    mux.HandleFunc("/basins/stream", s.handleBasins)
+    mux.HandleFunc("/sensitivity", s.handleSensitivity)
+    mux.HandleFunc("/shard-map", s.handleShardMap)
+    mux.HandleFunc("/sample", s.handleSample)
+    mux.HandleFunc("/compute/async", s.handleAsyncSubmit)
+    mux.HandleFunc("/compute/async/", s.handleAsyncStatus)
     mux.HandleFunc("/health", s.handleHealth)
+    mux.HandleFunc("/stats", s.handleStats)
+    mux.Handle("/metrics", eng.Metrics().Handler())
+    mux.HandleFunc("/keys", s.requireAuth(s.handleKeys))
+    mux.HandleFunc("/flush", s.requireAuth(s.handleFlush))
+    mux.HandleFunc("/checkpoints/purge", s.requireAuth(s.handlePurge))
     
     s.server = &http.Server{
-        Addr:         ":" + port,
-        Handler:      mux,
-        ReadTimeout:  5 * time.Second,
-        WriteTimeout: 10 * time.Second,
+        Addr:         cfg.ListenAddr(),
+        Handler:      s.withTenant(withPriority(withRouteTimeouts(cfg.WriteTimeout, cfg.RouteTimeouts, mux))),
+        ReadTimeout:  cfg.ReadTimeout,
+        WriteTimeout: cfg.WriteTimeout,
+    }
+    if cfg.PprofAddr != "" {
+        s.pprof = s.newPprofServer(cfg.PprofAddr, cfg.ReadTimeout)
     }
     
     return s
 }
 
 func (s *Server) Start() error {
-    log.Printf("Starting server on %s", s.server.Addr)
+    if s.pprof != nil {
+        go func() {
+            logging.Infof("Serving profiles on %s", s.pprof.Addr)
+            if err := s.pprof.ListenAndServe(); err != http.ErrServerClosed {
+                logging.Errorf("Profiling server error: %v", err)
+            }
+        }()
+    }
+    logging.Infof("Starting server on %s", s.server.Addr)
     return s.server.ListenAndServe()
 }
 
+// Shutdown drains the public server. The profiling listener is closed
+// outright rather than waiting on a profile being captured.
 func (s *Server) Shutdown(ctx context.Context) error {
+    if s.pprof != nil {
+        s.pprof.Close()
+    }
     return s.server.Shutdown(ctx)
 }
\ No newline at end of file
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"resilientrecursion/internal/logging"
+	"resilientrecursion/internal/models"
+)
+
+// How often /calculate/stream reports progress, and how often it sends a
+// heartbeat comment to keep idle proxies from closing the connection.
+const (
+	sseProgressInterval = 250 * time.Millisecond
+	sseHeartbeat        = 15 * time.Second
+)
+
+// handleCalculateStream serves GET /calculate/stream?r=<r>&n=<n> as
+// server-sent events: "progress" events while x_n is computed, then a single
+// "result" or "error" event. The compute runs on the request context, so a
+// client closing the stream stops it.
+func (s *Server) handleCalculateStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	rVal, err := strconv.ParseFloat(query.Get("r"), 64)
+	if err != nil {
+		http.Error(w, "Invalid r", http.StatusBadRequest)
+		return
+	}
+	n, err := strconv.ParseInt(query.Get("n"), 10, 64)
+	if err != nil || n < 0 {
+		http.Error(w, "Invalid n", http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	// The stream outlives the server's write timeout by design.
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	type outcome struct {
+		result float64
+		err    error
+	}
+	var current atomic.Int64
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := s.engine.ComputeProgress(r.Context(), rVal, n, func(i int64) {
+			current.Store(i)
+		})
+		done <- outcome{result, err}
+	}()
+
+	progress := time.NewTicker(sseProgressInterval)
+	defer progress.Stop()
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	reported := int64(-1)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case <-progress.C:
+			i := current.Load()
+			if i == reported {
+				continue
+			}
+			reported = i
+			writeEvent(w, "progress", models.ProgressEvent{N: i, Percent: 100 * float64(i) / float64(n)})
+			flusher.Flush()
+		case out := <-done:
+			if out.err != nil {
+				logging.Errorf("Stream compute error: %v", out.err)
+				writeEvent(w, "error", models.Response{R: rVal, N: n, Error: out.err.Error()})
+			} else {
+				response := models.Response{R: rVal, N: n, Result: out.result}
+				s.engine.Sign(&response)
+				writeEvent(w, "result", response)
+			}
+			flusher.Flush()
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event string, data interface{}) {
+	payload, _ := json.Marshal(data)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+}
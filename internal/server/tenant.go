@@ -0,0 +1,123 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"resilientrecursion/internal/engine"
+	"resilientrecursion/pkg/config"
+)
+
+// tenantHeader names the tenant of a request. The tenant query parameter is
+// used when the header is absent.
+const tenantHeader = "X-Tenant"
+
+// withTenant resolves the tenant of every request, rejects unknown tenants,
+// applies the per-tenant rate limit and passes the tenant on to the engine
+// through the request context. Health checks and metrics scrapes are neither
+// counted nor limited.
+func (s *Server) withTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant := r.Header.Get(tenantHeader)
+		if tenant == "" {
+			tenant = r.URL.Query().Get("tenant")
+		}
+		if tenant == "" {
+			tenant = config.DefaultTenant
+		}
+		if !s.engine.HasTenant(tenant) {
+			http.Error(w, "Unknown tenant", http.StatusBadRequest)
+			return
+		}
+
+		if r.URL.Path != "/health" && r.URL.Path != "/metrics" {
+			rec := s.engine.Recorder()
+			rec.TenantRequest(tenant)
+			if ok, wait := s.limiter.Load().allow(tenant, time.Now()); !ok {
+				rec.RateLimitedRequest(tenant)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(engine.WithTenant(r.Context(), tenant)))
+	})
+}
+
+// rateLimiter is a token bucket per tenant. A nil limiter allows everything.
+type rateLimiter struct {
+	rate  float64 // tokens added per second
+	burst float64 // bucket capacity
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns nil when rate is not positive. A burst of 0 allows
+// one second's worth of requests, and at least one.
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	capacity := float64(burst)
+	if capacity == 0 {
+		capacity = math.Max(1, math.Ceil(rate))
+	}
+	return &rateLimiter{rate: rate, burst: capacity, buckets: make(map[string]*bucket)}
+}
+
+// ApplyReload picks up the rate limits, which may change without a restart.
+// A limiter whose settings did not change keeps its buckets.
+func (s *Server) ApplyReload(cfg *config.Config) {
+	reloadLimiter(&s.limiter, cfg.TenantRateLimit, cfg.TenantRateBurst)
+	reloadLimiter(&s.adaptiveLimiter, cfg.AdaptiveRateLimit, 0)
+	reloadLimiter(&s.correlationLimiter, cfg.CorrelationRateLimit, 0)
+}
+
+// reloadLimiter stores a limiter for rate and burst in p unless the one there
+// already runs at them.
+func reloadLimiter(p *atomic.Pointer[rateLimiter], rate float64, burst int) {
+	next, current := newRateLimiter(rate, burst), p.Load()
+	if next == nil && current == nil {
+		return
+	}
+	if next != nil && current != nil && next.rate == current.rate && next.burst == current.burst {
+		return
+	}
+	p.Store(next)
+}
+
+// allow takes a token from key's bucket. When none is left it reports how
+// long until the next one.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
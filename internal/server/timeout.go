@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+	"time"
+)
+
+// timeoutGrace is how long past its deadline a request may still write, so
+// the 503 for a timed-out request reaches the client.
+const timeoutGrace = time.Second
+
+// streamRoutes stream their responses and outlive every timeout by design;
+// see handleCalculateStream, handleCalculateRemote and handleBasins. CSV
+// batches stream too; see streamsCSV.
+var streamRoutes = map[string]bool{
+	"/calculate/stream": true,
+	"/calculate/remote": true,
+	"/basins/stream":    true,
+}
+
+// withRouteTimeouts gives each request the budget configured for its path in
+// routes, or fallback. The handler's context is cancelled at the deadline
+// and the client gets 503, and the connection's write deadline is moved to
+// match, so a route may run longer than the server's WriteTimeout. A
+// non-positive budget leaves the request unbounded.
+func withRouteTimeouts(fallback time.Duration, routes map[string]time.Duration, next http.Handler) http.Handler {
+	timed := make(map[time.Duration]http.Handler)
+	for _, d := range routes {
+		timed[d] = http.TimeoutHandler(next, d, "Request timed out")
+	}
+	if fallback > 0 {
+		timed[fallback] = http.TimeoutHandler(next, fallback, "Request timed out")
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d, ok := routes[r.URL.Path]
+		if !ok {
+			d = fallback
+		}
+		if d <= 0 || streamRoutes[r.URL.Path] || streamsCSV(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		http.NewResponseController(w).SetWriteDeadline(time.Now().Add(d + timeoutGrace))
+		timed[d].ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,210 @@
+package worker
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned by Submit when every worker is busy and the queue
+// has no room left.
+var ErrQueueFull = errors.New("worker queue full")
+
+// ErrClosed is returned by Submit after Close.
+var ErrClosed = errors.New("worker pool closed")
+
+type Task func()
+
+// Priority orders queued tasks: workers take High tasks before Low ones.
+type Priority int
+
+const (
+	Low Priority = iota
+	High
+
+	numPriorities
+)
+
+func (p Priority) String() string {
+	if p == High {
+		return "high"
+	}
+	return "low"
+}
+
+// Priorities lists every priority, lowest first.
+var Priorities = []Priority{Low, High}
+
+// taskTimeSmoothing is the weight, as 1/taskTimeSmoothing, each finished
+// task gets in the moving average of task run times.
+const taskTimeSmoothing = 5
+
+// Pool runs tasks on a fixed number of goroutines fed by a bounded queue per
+// priority. The bound applies to all queued tasks together.
+type Pool struct {
+	// starvationLimit is how many High tasks may run in a row while Low
+	// tasks wait before one Low task is taken, so a steady stream of High
+	// tasks cannot starve Low ones.
+	starvationLimit int
+
+	mu      sync.Mutex
+	ready   *sync.Cond
+	queues  [numPriorities][]Task
+	queued  int
+	size    int
+	workers int
+	idle    int
+	streak  int
+	closed  bool
+	wg      sync.WaitGroup
+
+	// avgTask is the moving average run time of finished tasks, 0 until
+	// one finishes.
+	avgTask time.Duration
+}
+
+func NewPool(workers, queueSize, starvationLimit int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	if starvationLimit < 1 {
+		starvationLimit = 1
+	}
+
+	p := &Pool{size: queueSize, workers: workers, starvationLimit: starvationLimit}
+	p.ready = sync.NewCond(&p.mu)
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go p.run()
+	}
+	return p
+}
+
+func (p *Pool) run() {
+	defer p.wg.Done()
+	for {
+		p.mu.Lock()
+		for p.queued == 0 && !p.closed {
+			// Submit takes the worker off the idle count when it wakes it.
+			p.idle++
+			p.ready.Wait()
+		}
+		if p.queued == 0 {
+			p.mu.Unlock()
+			return
+		}
+		task := p.next()
+		p.mu.Unlock()
+
+		start := time.Now()
+		task()
+		p.observe(time.Since(start))
+	}
+}
+
+func (p *Pool) observe(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.avgTask == 0 {
+		p.avgTask = d
+		return
+	}
+	p.avgTask += (d - p.avgTask) / taskTimeSmoothing
+}
+
+// next pops the task to run. The caller holds mu and has checked that a task
+// is queued.
+func (p *Pool) next() Task {
+	high, low := &p.queues[High], &p.queues[Low]
+	q := high
+	switch {
+	case len(*high) == 0:
+		q = low
+	case len(*low) > 0 && p.streak >= p.starvationLimit:
+		q = low
+	}
+
+	if q == high && len(*low) > 0 {
+		p.streak++
+	} else {
+		p.streak = 0
+	}
+
+	task := (*q)[0]
+	(*q)[0] = nil
+	*q = (*q)[1:]
+	p.queued--
+	return task
+}
+
+// Submit queues task at priority without blocking. A task is accepted while
+// the queue has room or a worker is idle to take it.
+func (p *Pool) Submit(priority Priority, task Task) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrClosed
+	}
+	if p.queued >= p.size+p.idle {
+		return ErrQueueFull
+	}
+
+	p.queues[priority] = append(p.queues[priority], task)
+	p.queued++
+	if p.idle > 0 {
+		p.idle--
+		p.ready.Signal()
+	}
+	return nil
+}
+
+// QueueDepth reports how many tasks are waiting for a worker.
+func (p *Pool) QueueDepth() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.queued
+}
+
+// QueueDepthAt reports how many tasks of priority are waiting for a worker.
+func (p *Pool) QueueDepthAt(priority Priority) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.queues[priority])
+}
+
+// EstimatedWait estimates how long a task submitted now would wait for a
+// worker, from the current queue depth and the average task run time; see
+// EstimateWait.
+func (p *Pool) EstimatedWait() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return EstimateWait(p.queued, p.workers, p.avgTask)
+}
+
+// EstimateWait is the wait of a task queued behind queued others on workers
+// workers taking avgTask each: every worker first finishes the task it is
+// running, then the queue drains workers tasks at a time.
+func EstimateWait(queued, workers int, avgTask time.Duration) time.Duration {
+	if workers < 1 {
+		workers = 1
+	}
+	rounds := (queued + workers - 1) / workers
+	return time.Duration(rounds+1) * avgTask
+}
+
+// Close stops accepting tasks and waits for queued and running ones to finish.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	p.ready.Broadcast()
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
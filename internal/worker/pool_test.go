@@ -0,0 +1,120 @@
+package worker
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// queueBehindGate occupies the pool's only worker until the returned release
+// func is called, so tasks submitted meanwhile all wait in the queue.
+func queueBehindGate(t *testing.T, p *Pool) (release func()) {
+	t.Helper()
+	gate, started := make(chan struct{}), make(chan struct{})
+	if err := p.Submit(Low, func() { close(started); <-gate }); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	return func() { close(gate) }
+}
+
+func runOrder(t *testing.T, starvationLimit int, tasks []Priority) []Priority {
+	t.Helper()
+	p := NewPool(1, len(tasks), starvationLimit)
+	release := queueBehindGate(t, p)
+
+	var mu sync.Mutex
+	var order []Priority
+	for _, priority := range tasks {
+		priority := priority
+		if err := p.Submit(priority, func() {
+			mu.Lock()
+			order = append(order, priority)
+			mu.Unlock()
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := p.QueueDepthAt(High) + p.QueueDepthAt(Low); got != len(tasks) {
+		t.Errorf("queue depth = %d, want %d", got, len(tasks))
+	}
+
+	release()
+	p.Close()
+	return order
+}
+
+func TestPoolRunsHighPriorityFirst(t *testing.T) {
+	got := runOrder(t, 8, []Priority{Low, Low, High, Low, High})
+	want := []Priority{High, High, Low, Low, Low}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("order = %v, want %v", got, want)
+	}
+}
+
+func TestPoolDoesNotStarveLowPriority(t *testing.T) {
+	got := runOrder(t, 2, []Priority{Low, High, High, High, High, High})
+	want := []Priority{High, High, Low, High, High, High}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("order = %v, want %v", got, want)
+	}
+}
+
+func TestPoolQueueFull(t *testing.T) {
+	p := NewPool(1, 1, 8)
+	defer p.Close()
+	release := queueBehindGate(t, p)
+	defer release()
+
+	if err := p.Submit(High, func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Submit(High, func() {}); err != ErrQueueFull {
+		t.Errorf("Submit past the queue size: error = %v, want ErrQueueFull", err)
+	}
+}
+
+func TestEstimateWait(t *testing.T) {
+	for _, tc := range []struct {
+		queued, workers int
+		want            time.Duration
+	}{
+		{0, 4, time.Second},
+		{1, 4, 2 * time.Second},
+		{4, 4, 2 * time.Second},
+		{5, 4, 3 * time.Second},
+		{3, 0, 4 * time.Second},
+	} {
+		if got := EstimateWait(tc.queued, tc.workers, time.Second); got != tc.want {
+			t.Errorf("EstimateWait(%d, %d, 1s) = %v, want %v", tc.queued, tc.workers, got, tc.want)
+		}
+	}
+}
+
+func TestPoolEstimatedWaitTracksTaskTime(t *testing.T) {
+	p := NewPool(1, 4, 8)
+	defer p.Close()
+	if got := p.EstimatedWait(); got != 0 {
+		t.Errorf("EstimatedWait before any task = %v, want 0", got)
+	}
+
+	done := make(chan struct{})
+	if err := p.Submit(Low, func() { time.Sleep(20 * time.Millisecond); close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	release := queueBehindGate(t, p)
+	defer release()
+	for i := 0; i < 2; i++ {
+		if err := p.Submit(Low, func() {}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Two queued behind the running task, on one worker: three tasks of
+	// roughly 20ms each.
+	if got := p.EstimatedWait(); got < 60*time.Millisecond || got > time.Second {
+		t.Errorf("EstimatedWait = %v, want about 60ms", got)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+
+	"resilientrecursion/internal/engine"
+	"resilientrecursion/internal/logging"
+	"resilientrecursion/internal/server"
+	"resilientrecursion/pkg/config"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestSIGHUPReloadsLogLevel(t *testing.T) {
+	mr := miniredis.RunT(t)
+	t.Setenv("REDIS_ADDR", mr.Addr())
+	t.Setenv("LOG_LEVEL", "info")
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	eng := engine.NewComputeEngine(cfg)
+	defer eng.Close()
+	logging.SetLevel(logging.Info)
+	defer logging.SetLevel(logging.Info)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	defer signal.Stop(sigs)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watchReload(ctx, sigs, cfg, eng, server.NewServer(cfg, eng))
+
+	t.Setenv("LOG_LEVEL", "debug")
+	t.Setenv("PORT", "9999")
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for logging.GetLevel() != logging.Debug {
+		if time.Now().After(deadline) {
+			t.Fatalf("log level = %s after SIGHUP, want debug", logging.GetLevel())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if cfg.Port != "2586" {
+		t.Errorf("port changed to %q on reload, want it ignored", cfg.Port)
+	}
+}
+
+// shutdownRecorder stands in for the server and engine, logging each call
+// and the budget it was given.
+type shutdownRecorder struct {
+	calls     []string
+	budgets   map[string]time.Duration
+	flushTime time.Duration
+}
+
+func (s *shutdownRecorder) record(call string, ctx context.Context) {
+	s.calls = append(s.calls, call)
+	if deadline, ok := ctx.Deadline(); ok {
+		s.budgets[call] = time.Until(deadline)
+	}
+}
+
+func (s *shutdownRecorder) Shutdown(ctx context.Context) error {
+	s.record("drain", ctx)
+	return nil
+}
+
+func (s *shutdownRecorder) StopJobs(ctx context.Context) int {
+	s.record("jobs", ctx)
+	return 0
+}
+
+func (s *shutdownRecorder) FlushToRedis(ctx context.Context) (int, error) {
+	s.record("flush", ctx)
+	select {
+	case <-time.After(s.flushTime):
+		return 1, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+func (s *shutdownRecorder) Close() { s.calls = append(s.calls, "close") }
+
+func TestShutdownDrainsThenStopsJobsThenFlushesThenCloses(t *testing.T) {
+	cfg := config.Default()
+	cfg.DrainTimeout = time.Second
+	cfg.FlushTimeout = 50 * time.Millisecond
+
+	// The flush outlasts its own budget and is cut off there, not at the
+	// drain budget.
+	rec := &shutdownRecorder{budgets: make(map[string]time.Duration), flushTime: time.Minute}
+	start := time.Now()
+	shutdown(cfg, rec, rec)
+	elapsed := time.Since(start)
+
+	want := []string{"drain", "jobs", "flush", "close"}
+	if len(rec.calls) != len(want) {
+		t.Fatalf("calls = %v, want %v", rec.calls, want)
+	}
+	for i := range want {
+		if rec.calls[i] != want[i] {
+			t.Fatalf("calls = %v, want %v", rec.calls, want)
+		}
+	}
+	if b := rec.budgets["drain"]; b <= cfg.FlushTimeout || b > cfg.DrainTimeout {
+		t.Errorf("drain budget = %v, want DRAIN_TIMEOUT %v", b, cfg.DrainTimeout)
+	}
+	if b := rec.budgets["flush"]; b <= 0 || b > cfg.FlushTimeout {
+		t.Errorf("flush budget = %v, want FLUSH_TIMEOUT %v", b, cfg.FlushTimeout)
+	}
+	if elapsed < cfg.FlushTimeout || elapsed > cfg.DrainTimeout {
+		t.Errorf("shutdown took %v, want the slow flush stopped after %v", elapsed, cfg.FlushTimeout)
+	}
+}
@@ -1,24 +1,759 @@
 ﻿package config
 
 import (
+    "errors"
     "fmt"
+    "io"
+    "math"
+    "net"
+    "net/url"
     "os"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+
+    "resilientrecursion/internal/logging"
+
+    "gopkg.in/yaml.v3"
+)
+
+// Flush scopes select which L1 entries FlushToRedis persists on shutdown.
+const (
+    FlushScopeAll   = "all"
+    FlushScopeOwned = "owned"
+    FlushScopeNone  = "none"
+)
+
+// Checkpoint encodings for the sorted-set members stored in Redis.
+const (
+    CheckpointEncodingText   = "text"
+    CheckpointEncodingBinary = "binary"
+)
+
+// Checkpoint collision policies: what a checkpoint write does when the set
+// already holds a different value at the same n.
+const (
+    CheckpointCollisionsReplace = "replace"
+    CheckpointCollisionsKeep    = "keep"
+)
+
+// Series compressions for the full series blobs written on flush.
+const (
+    SeriesCompressionNone = "none"
+    SeriesCompressionGzip = "gzip"
+)
+
+// Batch duplicate policies for repeated (r, c, n) points in one batch.
+const (
+    BatchDuplicatesPreserve = "preserve"
+    BatchDuplicatesDedupe   = "dedupe"
+)
+
+// Rounded r policies for an r with more digits than a float64 holds.
+const (
+    RoundedRAllow  = "allow"
+    RoundedRReject = "reject"
 )
 
+// Compute backends select where POST /calculate batches are computed.
+const (
+    ComputeBackendInline = "inline"
+    ComputeBackendQueue  = "queue"
+)
+
+// DefaultX0 is the x_0 every series starts from unless X0 overrides it.
+const DefaultX0 = 0.5
+
+// DefaultTenant serves requests that name no tenant. It always exists.
+const DefaultTenant = "public"
+
+// tenantName restricts tenant names to characters safe in Redis keys and
+// metric labels.
+var tenantName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
 type Config struct {
-    Port      string
-    RedisAddr string
-    PodID     string
-    TotalPods int
+    Port      string `yaml:"port"`
+    RedisAddr string `yaml:"redis_addr"`
+    PodID     string `yaml:"pod_id"`
+    TotalPods int    `yaml:"total_pods"`
+
+    // RedisReplicaAddr, if set, is a read replica of RedisAddr that serves
+    // checkpoint and series reads.
+    RedisReplicaAddr string `yaml:"redis_replica_addr"`
+
+    // RedisMaxConcurrent bounds the Redis commands and pipelines that bulk
+    // work (flushes, preheats, purges and batches) has in flight at once;
+    // 0 leaves them unbounded.
+    RedisMaxConcurrent int `yaml:"redis_max_concurrent"`
+
+    // BindAddr is the IPv4 or IPv6 address of the interface the server
+    // listens on, on Port; empty listens on all interfaces.
+    BindAddr string `yaml:"bind_addr"`
+
+    // PodWeights, when set, gives each of the TotalPods pods a share of the r
+    // values proportional to its weight. It must be identical on every pod.
+    PodWeights []float64 `yaml:"pod_weights"`
+
+    // StrictSharding refuses to compute r values owned by another pod
+    // instead of computing them with a warning.
+    StrictSharding bool `yaml:"strict_sharding"`
+
+    // CoalesceComputes lets identical computes that overlap in time share
+    // one iteration instead of each running it.
+    CoalesceComputes bool `yaml:"coalesce_computes"`
+
+    // ClampComputes clamps x into [0, 1] after every step of every compute,
+    // as a request's clamp flag does for that request alone.
+    ClampComputes bool `yaml:"clamp_computes"`
+
+    // LogLevel is one of debug, info, warn or error.
+    LogLevel string `yaml:"log_level"`
+
+    // CacheSize is how many unpinned r series L1 holds; PinnedRValues are
+    // held on top, so L1 holds at most twice CacheSize. Checkpoints are
+    // stored every CheckpointMod iterations and expire after CheckpointTTL.
+    CacheSize     int           `yaml:"cache_size"`
+    CheckpointMod int           `yaml:"checkpoint_mod"`
+    CheckpointTTL time.Duration `yaml:"checkpoint_ttl"`
+
+    // X0 is the x_0 every series starts from. It must be identical on every
+    // pod; series from another X0 are cached and checkpointed apart.
+    X0 float64 `yaml:"x0"`
+
+    // MinRedisN is the smallest n that reads or writes Redis checkpoints;
+    // smaller queries use L1 only. 0 means CheckpointMod.
+    MinRedisN int `yaml:"min_redis_n"`
+
+    // CancelCheckStride is how many iterations the compute loops run between
+    // checks for cancellation and time budgets. It is independent of
+    // CheckpointMod.
+    CancelCheckStride int `yaml:"cancel_check_stride"`
+
+    // DisableL1 turns the L1 cache off, so every compute goes to Redis or
+    // iterates. It is meant for benchmarking the other layers.
+    DisableL1 bool `yaml:"disable_l1"`
+
+    // ConvergedTails stores a series that reached a fixed point as one
+    // converged entry standing for every later n, instead of one entry per
+    // n asked for.
+    ConvergedTails bool `yaml:"converged_tails"`
+
+    // CacheGeneration marks L1 series cached under a lower generation as
+    // stale. Bump it, with a SIGHUP, when cached values should be recomputed.
+    // StaleWhileRevalidate serves a stale value at once and recomputes its
+    // series in the background, instead of recomputing before answering.
+    CacheGeneration      int  `yaml:"cache_generation"`
+    StaleWhileRevalidate bool `yaml:"stale_while_revalidate"`
+
+    // PinnedRValues are never evicted from L1 and do not count towards
+    // CacheSize; at most CacheSize of them.
+    PinnedRValues []float64 `yaml:"pinned_r_values"`
+
+    // OwnedCheckpointsOnly skips checkpoint writes while computing r values
+    // another pod owns; those computes still fill L1.
+    OwnedCheckpointsOnly bool `yaml:"owned_checkpoints_only"`
+
+    // HTTP server timeouts.
+    ReadTimeout  time.Duration `yaml:"read_timeout"`
+    WriteTimeout time.Duration `yaml:"write_timeout"`
+
+    // Graceful shutdown drains in-flight requests within DrainTimeout, then
+    // flushes L1 to Redis within FlushTimeout, each with its own budget.
+    DrainTimeout time.Duration `yaml:"drain_timeout"`
+    FlushTimeout time.Duration `yaml:"flush_timeout"`
+
+    // Deprecated: ShutdownTimeout is read as DrainTimeout when that is not
+    // set in the same layer. Use DrainTimeout.
+    ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+    // RouteTimeouts overrides WriteTimeout for individual routes, keyed by
+    // path, so slow endpoints get a longer budget than single points.
+    RouteTimeouts map[string]time.Duration `yaml:"route_timeouts"`
+
+    // FlushFullSeries persists every cached n on shutdown instead of only
+    // checkpoint-aligned ones.
+    FlushFullSeries bool `yaml:"flush_full_series"`
+
+    // SeriesCompression is one of the SeriesCompression* values, applied to
+    // full series blobs when they are written; reads accept either.
+    SeriesCompression string `yaml:"series_compression"`
+
+    // FlushScope is one of the FlushScope* values. FlushJitter delays the
+    // shutdown flush by a random duration up to this bound.
+    FlushScope  string        `yaml:"flush_scope"`
+    FlushJitter time.Duration `yaml:"flush_jitter"`
+
+    // CheckpointEncoding selects how checkpoint values are written; reads
+    // accept either encoding.
+    CheckpointEncoding string `yaml:"checkpoint_encoding"`
+
+    // CheckpointCollisions is one of the CheckpointCollisions* values.
+    CheckpointCollisions string `yaml:"checkpoint_collisions"`
+
+    // CheckpointDedupeTolerance, when positive, skips writing a checkpoint
+    // whose value is within it of the last one the compute wrote; 0 writes
+    // every one.
+    CheckpointDedupeTolerance float64 `yaml:"checkpoint_dedupe_tolerance"`
+
+    // BigCheckpoints stores checkpoints of adaptive-precision computes as
+    // full big.Float values, one sorted set per precision, so repeated
+    // adaptive queries resume instead of iterating from x0.
+    BigCheckpoints bool `yaml:"big_checkpoints"`
+
+    // StatsdAddr, when set, is the host:port of a StatsD agent sent every
+    // metric alongside Prometheus. StatsdTags sends labels as DogStatsD tags;
+    // without it they are appended to metric names.
+    StatsdAddr string `yaml:"statsd_addr"`
+    StatsdTags bool   `yaml:"statsd_tags"`
+
+    // ComputeDurationBuckets (in seconds) and ComputeIterationBuckets are the
+    // upper bounds of the compute latency and iteration count histograms.
+    // Each must be strictly increasing.
+    ComputeDurationBuckets  []float64 `yaml:"compute_duration_buckets"`
+    ComputeIterationBuckets []float64 `yaml:"compute_iteration_buckets"`
+
+    // PreheatLimit caps the series each tenant's L1 is preheated with at
+    // startup; 0 disables preheating.
+    PreheatLimit int `yaml:"preheat_limit"`
+
+    // WarmRValues are precomputed up to WarmN at startup, for the ones this
+    // pod owns, within WarmTimeout.
+    WarmRValues []float64     `yaml:"warm_r_values"`
+    WarmN       int           `yaml:"warm_n"`
+    WarmTimeout time.Duration `yaml:"warm_timeout"`
+
+    // WarmSnapshot is a file of earlier results, NDJSON or CSV, loaded into
+    // L1 at startup before the Redis preheat. Empty disables it.
+    WarmSnapshot string `yaml:"warm_snapshot"`
+
+    // CheckpointSampleInterval is how often ZCARD is sampled over
+    // CheckpointSampleKeys checkpoint keys; 0 disables sampling.
+    CheckpointSampleInterval time.Duration `yaml:"checkpoint_sample_interval"`
+    CheckpointSampleKeys     int           `yaml:"checkpoint_sample_keys"`
+
+    // NonLocalLogEvery logs one in every N non-local computes; 0 silences
+    // the log and leaves only the counter metric.
+    NonLocalLogEvery int `yaml:"nonlocal_log_every"`
+
+    // Workers and QueueSize size the worker pool behind async jobs. JobTTL
+    // is how long job records are kept in Redis. StarvationLimit is how many
+    // high-priority tasks may run in a row while low-priority ones wait.
+    Workers         int           `yaml:"workers"`
+    QueueSize       int           `yaml:"queue_size"`
+    JobTTL          time.Duration `yaml:"job_ttl"`
+    StarvationLimit int           `yaml:"starvation_limit"`
+
+    // MaxBatchSize caps the items in one batch request; 0 means no cap.
+    // MaxDistinctR separately caps the distinct r values in one batch, so a
+    // single batch cannot evict the whole L1 cache; 0 means no cap.
+    MaxBatchSize int `yaml:"max_batch_size"`
+    MaxDistinctR int `yaml:"max_distinct_r"`
+
+    // MemoryBudget caps, in bytes, the L1 working set estimated for the
+    // synchronous batches in flight; 0 means no cap.
+    MemoryBudget int `yaml:"memory_budget"`
+
+    // BatchDuplicates is one of the BatchDuplicates* values: whether a point
+    // requested more than once in a batch gets one result per occurrence or
+    // just one.
+    BatchDuplicates string `yaml:"batch_duplicates"`
+
+    // RoundedR is one of the RoundedR* values: whether an r sent with more
+    // digits than a float64 holds is computed for the nearest float64 or
+    // refused.
+    RoundedR string `yaml:"rounded_r"`
+
+    // PerRBudget bounds the time a batch spends computing the points of any
+    // one series, so an expensive r cannot starve the rest; 0 means no
+    // bound.
+    PerRBudget time.Duration `yaml:"per_r_budget"`
+
+    // MaxPointsPerRequest caps the points one request to a series endpoint
+    // (/calculate/rs, /trajectory/compare, /density, /bifurcations, /sample,
+    // /correlation) may return; 0 means no cap beyond each endpoint's own
+    // ceiling.
+    MaxPointsPerRequest int `yaml:"max_points_per_request"`
+
+    // MaxMapsPerRequest caps the map kinds one /calculate/maps request may
+    // ask for; 0 means no cap.
+    MaxMapsPerRequest int `yaml:"max_maps_per_request"`
+
+    // DatasetURLPrefixes lists the URL prefixes, each ending in "/" so it
+    // cannot match a longer host name, that /calculate/remote may fetch from;
+    // the endpoint is disabled while it is empty. A fetch is cut off
+    // at DatasetMaxBytes or DatasetTimeout.
+    DatasetURLPrefixes []string      `yaml:"dataset_url_prefixes"`
+    DatasetMaxBytes    int           `yaml:"dataset_max_bytes"`
+    DatasetTimeout     time.Duration `yaml:"dataset_timeout"`
+
+    // ComputeBackend is one of the ComputeBackend* values. With the queue
+    // backend, batches are published to JobStream and StreamConsumer decides
+    // whether this pod also consumes them.
+    ComputeBackend string `yaml:"compute_backend"`
+    JobStream      string `yaml:"job_stream"`
+    StreamConsumer bool   `yaml:"stream_consumer"`
+
+    // CheckpointChannel, when set, is the Redis pub/sub channel every
+    // checkpoint write is announced on. Pods subscribe to it and fill their
+    // L1 caches from their peers' announcements. Empty disables it.
+    CheckpointChannel string `yaml:"checkpoint_channel"`
+
+    // AdminToken is the bearer token for admin endpoints; empty disables them.
+    AdminToken string `yaml:"admin_token"`
+
+    // PprofAddr, when set, is the host:port of a separate listener serving
+    // the net/http/pprof handlers under /debug/pprof, behind AdminToken.
+    // Empty disables profiling.
+    PprofAddr string `yaml:"pprof_addr"`
+
+    // Tenants are the tenants accepted besides DefaultTenant. Each has its
+    // own L1 cache of CacheSize series and its own Redis keys.
+    // TenantRateLimit is the per-tenant request rate in requests per second,
+    // with bursts of up to TenantRateBurst; 0 means no limit.
+    Tenants         []string `yaml:"tenants"`
+    TenantRateLimit float64  `yaml:"tenant_rate_limit"`
+    TenantRateBurst int      `yaml:"tenant_rate_burst"`
+
+    // AdaptiveRateLimit separately limits adaptive-precision requests per
+    // tenant, in requests per second; 0 means no limit.
+    AdaptiveRateLimit float64 `yaml:"adaptive_rate_limit"`
+
+    // CorrelationRateLimit does the same for correlation dimension
+    // estimates.
+    CorrelationRateLimit float64 `yaml:"correlation_rate_limit"`
+
+    // CompareEpsilon is the tolerance diagnostic endpoints compare results
+    // with when a request gives none; 0 picks the default for the active
+    // precision.
+    CompareEpsilon float64 `yaml:"compare_epsilon"`
+
+    // ResultSigningKey, when set, HMAC-signs every computed result.
+    ResultSigningKey string `yaml:"result_signing_key"`
 }
 
-func Load() *Config {
+// Default returns the configuration used when nothing is overridden.
+func Default() *Config {
     return &Config{
-        Port:      getEnv("PORT", "8080"),
-        RedisAddr: getEnv("REDIS_ADDR", "localhost:6379"),
-        PodID:     getEnv("POD_ID", "pod-0"),
-        TotalPods: getEnvInt("TOTAL_PODS", 3),
+        Port:      "2586",
+        RedisAddr: "localhost:6379",
+        PodID:     "pod-0",
+        TotalPods: 3,
+
+        CoalesceComputes: true,
+
+        LogLevel: "info",
+
+        CacheSize:     75,
+        CheckpointMod: 1000,
+        CheckpointTTL: time.Hour,
+        X0:            DefaultX0,
+
+        CancelCheckStride: 4096,
+
+        ReadTimeout:  5 * time.Second,
+        WriteTimeout: 10 * time.Second,
+        DrainTimeout: 10 * time.Second,
+        FlushTimeout: 10 * time.Second,
+        RouteTimeouts: map[string]time.Duration{
+            "/bifurcations":       time.Minute,
+            "/density":            time.Minute,
+            "/calculate/rs":       time.Minute,
+            "/sample":             time.Minute,
+            "/calculate/adaptive": time.Minute,
+            "/correlation":        time.Minute,
+            "/transient":          time.Minute,
+            "/trajectory/log":     time.Minute,
+            "/trajectory/mean":    time.Minute,
+            "/trajectory/compare": time.Minute,
+        },
+
+        SeriesCompression: SeriesCompressionNone,
+
+        FlushScope:  FlushScopeAll,
+        FlushJitter: time.Second,
+
+        CheckpointEncoding: CheckpointEncodingText,
+
+        CheckpointCollisions: CheckpointCollisionsReplace,
+
+        StatsdTags: true,
+
+        // 1µs to about 67s, and 1 to 1e9 steps.
+        ComputeDurationBuckets:  exponentialBuckets(1e-6, 4, 14),
+        ComputeIterationBuckets: exponentialBuckets(1, 10, 10),
+
+        PreheatLimit: 50,
+        WarmTimeout:  30 * time.Second,
+
+        CheckpointSampleInterval: time.Minute,
+        CheckpointSampleKeys:     20,
+
+        NonLocalLogEvery: 1000,
+
+        Workers:   4,
+        QueueSize: 100,
+        JobTTL:    time.Hour,
+
+        StarvationLimit: 8,
+
+        MaxBatchSize:        10000,
+        MaxPointsPerRequest: 10000,
+        MaxMapsPerRequest:   8,
+
+        DatasetMaxBytes: 16 << 20,
+        DatasetTimeout:  30 * time.Second,
+
+        BatchDuplicates: BatchDuplicatesPreserve,
+        RoundedR:        RoundedRAllow,
+
+        ComputeBackend: ComputeBackendInline,
+        JobStream:      "jobs:stream",
+        StreamConsumer: true,
+
+        AdaptiveRateLimit:    1,
+        CorrelationRateLimit: 1,
+    }
+}
+
+// Load builds the configuration from the defaults, then the file named by
+// CONFIG_FILE if set, then environment variables, each layer overriding the
+// previous one. The merged result is validated.
+func Load() (*Config, error) {
+    cfg := Default()
+
+    if path := os.Getenv("CONFIG_FILE"); path != "" {
+        if err := cfg.loadFile(path); err != nil {
+            return nil, fmt.Errorf("config file %s: %w", path, err)
+        }
+    }
+
+    cfg.applyEnv()
+
+    if err := cfg.Validate(); err != nil {
+        return nil, err
+    }
+    return cfg, nil
+}
+
+// loadFile merges a YAML or JSON file (JSON being a subset of YAML) into c.
+// Keys that don't match a setting are rejected so typos fail fast.
+func (c *Config) loadFile(path string) error {
+    f, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    drain := c.DrainTimeout
+    dec := yaml.NewDecoder(f)
+    dec.KnownFields(true)
+    if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+        return err
     }
+    if c.ShutdownTimeout > 0 && c.DrainTimeout == drain {
+        c.DrainTimeout = c.ShutdownTimeout
+    }
+    return nil
+}
+
+func (c *Config) applyEnv() {
+    c.Port = getEnv("PORT", c.Port)
+    c.RedisAddr = getEnv("REDIS_ADDR", c.RedisAddr)
+    c.RedisReplicaAddr = getEnv("REDIS_REPLICA_ADDR", c.RedisReplicaAddr)
+    c.RedisMaxConcurrent = getEnvInt("REDIS_MAX_CONCURRENT", c.RedisMaxConcurrent)
+    c.BindAddr = getEnv("BIND_ADDR", c.BindAddr)
+    c.PodID = getEnv("POD_ID", c.PodID)
+    c.TotalPods = getEnvInt("TOTAL_PODS", c.TotalPods)
+    c.PodWeights = getEnvFloatList("POD_WEIGHTS", c.PodWeights)
+    c.StrictSharding = getEnvBool("STRICT_SHARDING", c.StrictSharding)
+    c.CoalesceComputes = getEnvBool("COALESCE_COMPUTES", c.CoalesceComputes)
+    c.ClampComputes = getEnvBool("CLAMP_COMPUTES", c.ClampComputes)
+
+    c.LogLevel = getEnv("LOG_LEVEL", c.LogLevel)
+
+    c.CacheSize = getEnvInt("L1_CACHE_SIZE", c.CacheSize)
+    c.CheckpointMod = getEnvInt("CHECKPOINT_MOD", c.CheckpointMod)
+    c.CheckpointTTL = getEnvDuration("CHECKPOINT_TTL", c.CheckpointTTL)
+    c.X0 = getEnvFloat("X0", c.X0)
+    c.MinRedisN = getEnvInt("MIN_REDIS_N", c.MinRedisN)
+    c.CancelCheckStride = getEnvInt("CANCEL_CHECK_STRIDE", c.CancelCheckStride)
+    c.PinnedRValues = getEnvFloatList("PINNED_R_VALUES", c.PinnedRValues)
+    c.DisableL1 = getEnvBool("DISABLE_L1", c.DisableL1)
+    c.ConvergedTails = getEnvBool("CONVERGED_TAILS", c.ConvergedTails)
+    c.CacheGeneration = getEnvInt("CACHE_GENERATION", c.CacheGeneration)
+    c.StaleWhileRevalidate = getEnvBool("STALE_WHILE_REVALIDATE", c.StaleWhileRevalidate)
+    c.OwnedCheckpointsOnly = getEnvBool("OWNED_CHECKPOINTS_ONLY", c.OwnedCheckpointsOnly)
+
+    c.ReadTimeout = getEnvDuration("READ_TIMEOUT", c.ReadTimeout)
+    c.WriteTimeout = getEnvDuration("WRITE_TIMEOUT", c.WriteTimeout)
+    c.DrainTimeout = getEnvDuration("DRAIN_TIMEOUT", getEnvDuration("SHUTDOWN_TIMEOUT", c.DrainTimeout))
+    c.FlushTimeout = getEnvDuration("FLUSH_TIMEOUT", c.FlushTimeout)
+    c.RouteTimeouts = getEnvDurationMap("ROUTE_TIMEOUTS", c.RouteTimeouts)
+
+    c.FlushFullSeries = getEnvBool("FLUSH_FULL_SERIES", c.FlushFullSeries)
+    c.SeriesCompression = getEnv("SERIES_COMPRESSION", c.SeriesCompression)
+    c.FlushScope = getEnv("FLUSH_SCOPE", c.FlushScope)
+    c.FlushJitter = getEnvDuration("FLUSH_JITTER", c.FlushJitter)
+
+    c.CheckpointEncoding = getEnv("CHECKPOINT_ENCODING", c.CheckpointEncoding)
+    c.CheckpointCollisions = getEnv("CHECKPOINT_COLLISIONS", c.CheckpointCollisions)
+    c.CheckpointDedupeTolerance = getEnvFloat("CHECKPOINT_DEDUPE_TOLERANCE", c.CheckpointDedupeTolerance)
+    c.BigCheckpoints = getEnvBool("BIG_CHECKPOINTS", c.BigCheckpoints)
+
+    c.StatsdAddr = getEnv("STATSD_ADDR", c.StatsdAddr)
+    c.StatsdTags = getEnvBool("STATSD_TAGS", c.StatsdTags)
+    c.ComputeDurationBuckets = getEnvFloatList("COMPUTE_DURATION_BUCKETS", c.ComputeDurationBuckets)
+    c.ComputeIterationBuckets = getEnvFloatList("COMPUTE_ITERATION_BUCKETS", c.ComputeIterationBuckets)
+
+    c.PreheatLimit = getEnvInt("PREHEAT_LIMIT", c.PreheatLimit)
+    c.WarmRValues = getEnvFloatList("WARM_R_VALUES", c.WarmRValues)
+    c.WarmN = getEnvInt("WARM_N", c.WarmN)
+    c.WarmTimeout = getEnvDuration("WARM_TIMEOUT", c.WarmTimeout)
+    c.WarmSnapshot = getEnv("WARM_SNAPSHOT", c.WarmSnapshot)
+
+    c.CheckpointSampleInterval = getEnvDuration("CHECKPOINT_SAMPLE_INTERVAL", c.CheckpointSampleInterval)
+    c.CheckpointSampleKeys = getEnvInt("CHECKPOINT_SAMPLE_KEYS", c.CheckpointSampleKeys)
+
+    c.NonLocalLogEvery = getEnvInt("NONLOCAL_LOG_EVERY", c.NonLocalLogEvery)
+
+    c.Workers = getEnvInt("WORKERS", c.Workers)
+    c.QueueSize = getEnvInt("QUEUE_SIZE", c.QueueSize)
+    c.JobTTL = getEnvDuration("JOB_TTL", c.JobTTL)
+    c.StarvationLimit = getEnvInt("STARVATION_LIMIT", c.StarvationLimit)
+
+    c.MaxBatchSize = getEnvInt("MAX_BATCH_SIZE", c.MaxBatchSize)
+    c.MaxPointsPerRequest = getEnvInt("MAX_POINTS_PER_REQUEST", c.MaxPointsPerRequest)
+    c.MaxMapsPerRequest = getEnvInt("MAX_MAPS_PER_REQUEST", c.MaxMapsPerRequest)
+    c.DatasetURLPrefixes = getEnvList("DATASET_URL_PREFIXES", c.DatasetURLPrefixes)
+    c.DatasetMaxBytes = getEnvInt("DATASET_MAX_BYTES", c.DatasetMaxBytes)
+    c.DatasetTimeout = getEnvDuration("DATASET_TIMEOUT", c.DatasetTimeout)
+    c.MaxDistinctR = getEnvInt("MAX_DISTINCT_R", c.MaxDistinctR)
+    c.MemoryBudget = getEnvInt("MEMORY_BUDGET", c.MemoryBudget)
+    c.BatchDuplicates = getEnv("BATCH_DUPLICATES", c.BatchDuplicates)
+    c.RoundedR = getEnv("ROUNDED_R", c.RoundedR)
+    c.PerRBudget = getEnvDuration("PER_R_BUDGET", c.PerRBudget)
+
+    c.ComputeBackend = getEnv("COMPUTE_BACKEND", c.ComputeBackend)
+    c.JobStream = getEnv("JOB_STREAM", c.JobStream)
+    c.CheckpointChannel = getEnv("CHECKPOINT_CHANNEL", c.CheckpointChannel)
+    c.StreamConsumer = getEnvBool("STREAM_CONSUMER", c.StreamConsumer)
+
+    c.AdminToken = getEnv("ADMIN_TOKEN", c.AdminToken)
+    c.PprofAddr = getEnv("PPROF_ADDR", c.PprofAddr)
+    c.Tenants = getEnvList("TENANTS", c.Tenants)
+    c.TenantRateLimit = getEnvFloat("TENANT_RATE_LIMIT", c.TenantRateLimit)
+    c.TenantRateBurst = getEnvInt("TENANT_RATE_BURST", c.TenantRateBurst)
+    c.AdaptiveRateLimit = getEnvFloat("ADAPTIVE_RATE_LIMIT", c.AdaptiveRateLimit)
+    c.CorrelationRateLimit = getEnvFloat("CORRELATION_RATE_LIMIT", c.CorrelationRateLimit)
+    c.CompareEpsilon = getEnvFloat("COMPARE_EPSILON", c.CompareEpsilon)
+    c.ResultSigningKey = getEnv("RESULT_SIGNING_KEY", c.ResultSigningKey)
+}
+
+// ListenAddr is the host:port the server listens on, from BindAddr and
+// Port. An IPv6 address is bracketed, as in [::1]:2586.
+func (c *Config) ListenAddr() string {
+    return net.JoinHostPort(strings.Trim(c.BindAddr, "[]"), c.Port)
+}
+
+// Validate reports the first setting that cannot be used as configured.
+func (c *Config) Validate() error {
+    if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+        return fmt.Errorf("LOG_LEVEL: %w", err)
+    }
+    if port, err := strconv.ParseUint(c.Port, 10, 16); err != nil || port == 0 {
+        return fmt.Errorf("PORT must be a port number between 1 and 65535, got %q", c.Port)
+    }
+    if c.BindAddr != "" {
+        // A host name could resolve to any interface, so only addresses are
+        // accepted.
+        if net.ParseIP(strings.Trim(c.BindAddr, "[]")) == nil {
+            return fmt.Errorf("BIND_ADDR must be an IPv4 or IPv6 address, got %q", c.BindAddr)
+        }
+    }
+    if len(c.PodWeights) > 0 {
+        if len(c.PodWeights) != c.TotalPods {
+            return fmt.Errorf("POD_WEIGHTS has %d weights for %d pods", len(c.PodWeights), c.TotalPods)
+        }
+        for i, w := range c.PodWeights {
+            if w <= 0 {
+                return fmt.Errorf("POD_WEIGHTS: weight %d must be positive, got %v", i, w)
+            }
+        }
+    }
+    if c.CacheSize < 1 {
+        return fmt.Errorf("L1_CACHE_SIZE must be at least 1, got %d", c.CacheSize)
+    }
+    if c.CacheGeneration < 0 {
+        return fmt.Errorf("CACHE_GENERATION must not be negative, got %d", c.CacheGeneration)
+    }
+    if !(c.X0 >= 0 && c.X0 <= 1) {
+        return fmt.Errorf("X0 must be between 0 and 1, got %v", c.X0)
+    }
+    if len(c.PinnedRValues) > c.CacheSize {
+        return fmt.Errorf("PINNED_R_VALUES lists %d values, more than L1_CACHE_SIZE %d", len(c.PinnedRValues), c.CacheSize)
+    }
+    if c.CompareEpsilon < 0 {
+        return fmt.Errorf("COMPARE_EPSILON must not be negative, got %v", c.CompareEpsilon)
+    }
+    if c.CheckpointMod < 1 {
+        return fmt.Errorf("CHECKPOINT_MOD must be at least 1, got %d", c.CheckpointMod)
+    }
+    if c.CheckpointTTL <= 0 {
+        // Redis deletes a key given a zero or negative expiry, so every
+        // checkpoint written would be dropped.
+        return fmt.Errorf("CHECKPOINT_TTL must be positive, got %v", c.CheckpointTTL)
+    }
+    if c.DrainTimeout <= 0 {
+        return fmt.Errorf("DRAIN_TIMEOUT must be positive, got %v", c.DrainTimeout)
+    }
+    if c.FlushTimeout <= 0 {
+        return fmt.Errorf("FLUSH_TIMEOUT must be positive, got %v", c.FlushTimeout)
+    }
+    for route, d := range c.RouteTimeouts {
+        if d <= 0 {
+            return fmt.Errorf("ROUTE_TIMEOUTS: %s must be positive, got %v", route, d)
+        }
+    }
+    if c.Workers < 1 {
+        return fmt.Errorf("WORKERS must be at least 1, got %d", c.Workers)
+    }
+    if c.QueueSize < 0 {
+        return fmt.Errorf("QUEUE_SIZE must not be negative, got %d", c.QueueSize)
+    }
+    if c.JobTTL <= 0 {
+        return fmt.Errorf("JOB_TTL must be positive, got %v", c.JobTTL)
+    }
+    if c.StarvationLimit < 1 {
+        return fmt.Errorf("STARVATION_LIMIT must be at least 1, got %d", c.StarvationLimit)
+    }
+    for _, prefix := range c.DatasetURLPrefixes {
+        u, err := url.Parse(prefix)
+        if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || !strings.HasSuffix(prefix, "/") {
+            return fmt.Errorf("DATASET_URL_PREFIXES: %q is not an http or https URL ending in /", prefix)
+        }
+    }
+    if c.DatasetMaxBytes < 1 {
+        return fmt.Errorf("DATASET_MAX_BYTES must be at least 1, got %d", c.DatasetMaxBytes)
+    }
+    if c.DatasetTimeout <= 0 {
+        return fmt.Errorf("DATASET_TIMEOUT must be positive, got %v", c.DatasetTimeout)
+    }
+    if c.MaxBatchSize < 0 {
+        return fmt.Errorf("MAX_BATCH_SIZE must not be negative, got %d", c.MaxBatchSize)
+    }
+    if c.MaxDistinctR < 0 {
+        return fmt.Errorf("MAX_DISTINCT_R must not be negative, got %d", c.MaxDistinctR)
+    }
+    if c.MaxPointsPerRequest < 0 {
+        return fmt.Errorf("MAX_POINTS_PER_REQUEST must not be negative, got %d", c.MaxPointsPerRequest)
+    }
+    if c.MemoryBudget < 0 {
+        return fmt.Errorf("MEMORY_BUDGET must not be negative, got %d", c.MemoryBudget)
+    }
+    if c.RedisMaxConcurrent < 0 {
+        return fmt.Errorf("REDIS_MAX_CONCURRENT must not be negative, got %d", c.RedisMaxConcurrent)
+    }
+    if c.PerRBudget < 0 {
+        return fmt.Errorf("PER_R_BUDGET must not be negative, got %v", c.PerRBudget)
+    }
+    if c.MinRedisN < 0 {
+        return fmt.Errorf("MIN_REDIS_N must not be negative, got %d", c.MinRedisN)
+    }
+    if c.CancelCheckStride < 1 {
+        return fmt.Errorf("CANCEL_CHECK_STRIDE must be at least 1, got %d", c.CancelCheckStride)
+    }
+    if c.PreheatLimit < 0 {
+        return fmt.Errorf("PREHEAT_LIMIT must not be negative, got %d", c.PreheatLimit)
+    }
+    switch c.FlushScope {
+    case FlushScopeAll, FlushScopeOwned, FlushScopeNone:
+    default:
+        return fmt.Errorf("FLUSH_SCOPE must be %q, %q or %q, got %q",
+            FlushScopeAll, FlushScopeOwned, FlushScopeNone, c.FlushScope)
+    }
+    switch c.SeriesCompression {
+    case SeriesCompressionNone, SeriesCompressionGzip:
+    default:
+        return fmt.Errorf("SERIES_COMPRESSION must be %q or %q, got %q",
+            SeriesCompressionNone, SeriesCompressionGzip, c.SeriesCompression)
+    }
+    switch c.CheckpointEncoding {
+    case CheckpointEncodingText, CheckpointEncodingBinary:
+    default:
+        return fmt.Errorf("CHECKPOINT_ENCODING must be %q or %q, got %q",
+            CheckpointEncodingText, CheckpointEncodingBinary, c.CheckpointEncoding)
+    }
+    switch c.CheckpointCollisions {
+    case CheckpointCollisionsReplace, CheckpointCollisionsKeep:
+    default:
+        return fmt.Errorf("CHECKPOINT_COLLISIONS must be %q or %q, got %q",
+            CheckpointCollisionsReplace, CheckpointCollisionsKeep, c.CheckpointCollisions)
+    }
+    if !(c.CheckpointDedupeTolerance >= 0) {
+        return fmt.Errorf("CHECKPOINT_DEDUPE_TOLERANCE must not be negative, got %v", c.CheckpointDedupeTolerance)
+    }
+    switch c.BatchDuplicates {
+    case BatchDuplicatesPreserve, BatchDuplicatesDedupe:
+    default:
+        return fmt.Errorf("BATCH_DUPLICATES must be %q or %q, got %q",
+            BatchDuplicatesPreserve, BatchDuplicatesDedupe, c.BatchDuplicates)
+    }
+    switch c.RoundedR {
+    case RoundedRAllow, RoundedRReject:
+    default:
+        return fmt.Errorf("ROUNDED_R must be %q or %q, got %q", RoundedRAllow, RoundedRReject, c.RoundedR)
+    }
+    switch c.ComputeBackend {
+    case ComputeBackendInline, ComputeBackendQueue:
+    default:
+        return fmt.Errorf("COMPUTE_BACKEND must be %q or %q, got %q",
+            ComputeBackendInline, ComputeBackendQueue, c.ComputeBackend)
+    }
+    if c.ComputeBackend == ComputeBackendQueue && c.JobStream == "" {
+        return errors.New("JOB_STREAM must be set for the queue backend")
+    }
+    if c.PprofAddr != "" {
+        // An empty host would listen on every interface, public ones
+        // included, so the interface has to be named.
+        host, _, err := net.SplitHostPort(c.PprofAddr)
+        if err != nil {
+            return fmt.Errorf("PPROF_ADDR: %w", err)
+        }
+        if host == "" {
+            return fmt.Errorf("PPROF_ADDR must name an interface, such as 127.0.0.1:6060, got %q", c.PprofAddr)
+        }
+        if c.AdminToken == "" {
+            return errors.New("PPROF_ADDR requires ADMIN_TOKEN")
+        }
+    }
+    if c.StatsdAddr != "" {
+        if _, _, err := net.SplitHostPort(c.StatsdAddr); err != nil {
+            return fmt.Errorf("STATSD_ADDR: %w", err)
+        }
+    }
+    if err := checkBuckets(c.ComputeDurationBuckets); err != nil {
+        return fmt.Errorf("COMPUTE_DURATION_BUCKETS: %w", err)
+    }
+    if err := checkBuckets(c.ComputeIterationBuckets); err != nil {
+        return fmt.Errorf("COMPUTE_ITERATION_BUCKETS: %w", err)
+    }
+    for _, t := range c.Tenants {
+        if !tenantName.MatchString(t) {
+            return fmt.Errorf("TENANTS: invalid tenant name %q", t)
+        }
+    }
+    if c.AdaptiveRateLimit < 0 {
+        return fmt.Errorf("ADAPTIVE_RATE_LIMIT must not be negative, got %v", c.AdaptiveRateLimit)
+    }
+    if c.CorrelationRateLimit < 0 {
+        return fmt.Errorf("CORRELATION_RATE_LIMIT must not be negative, got %v", c.CorrelationRateLimit)
+    }
+    if c.TenantRateLimit < 0 || c.TenantRateBurst < 0 {
+        return fmt.Errorf("TENANT_RATE_LIMIT and TENANT_RATE_BURST must not be negative, got %v and %d",
+            c.TenantRateLimit, c.TenantRateBurst)
+    }
+    return nil
 }
 
 func getEnv(key, fallback string) string {
@@ -35,4 +770,121 @@ func getEnvInt(key string, fallback int) int {
         return i
     }
     return fallback
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+    if value := os.Getenv(key); value != "" {
+        if f, err := strconv.ParseFloat(value, 64); err == nil {
+            return f
+        }
+    }
+    return fallback
+}
+
+func getEnvBool(key string, fallback bool) bool {
+    if value := os.Getenv(key); value != "" {
+        if b, err := strconv.ParseBool(value); err == nil {
+            return b
+        }
+    }
+    return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+    if value := os.Getenv(key); value != "" {
+        if d, err := time.ParseDuration(value); err == nil {
+            return d
+        }
+    }
+    return fallback
+}
+
+// getEnvDurationMap parses comma-separated key=duration pairs into a copy of
+// fallback, so listed keys are overridden and the rest kept. Malformed pairs
+// are skipped.
+func getEnvDurationMap(key string, fallback map[string]time.Duration) map[string]time.Duration {
+    value := os.Getenv(key)
+    if value == "" {
+        return fallback
+    }
+
+    values := make(map[string]time.Duration, len(fallback))
+    for k, d := range fallback {
+        values[k] = d
+    }
+    for _, field := range strings.Split(value, ",") {
+        k, v, ok := strings.Cut(strings.TrimSpace(field), "=")
+        if !ok {
+            continue
+        }
+        if d, err := time.ParseDuration(strings.TrimSpace(v)); err == nil {
+            values[strings.TrimSpace(k)] = d
+        }
+    }
+    return values
+}
+
+// getEnvList parses a comma-separated list, skipping empty entries.
+func getEnvList(key string, fallback []string) []string {
+    value := os.Getenv(key)
+    if value == "" {
+        return fallback
+    }
+
+    var values []string
+    for _, field := range strings.Split(value, ",") {
+        if field = strings.TrimSpace(field); field != "" {
+            values = append(values, field)
+        }
+    }
+    return values
+}
+
+// exponentialBuckets returns count bucket bounds starting at start, each
+// factor times the one before.
+func exponentialBuckets(start, factor float64, count int) []float64 {
+    buckets := make([]float64, count)
+    for i := range buckets {
+        buckets[i] = start
+        start *= factor
+    }
+    return buckets
+}
+
+// checkBuckets reports whether buckets can bound a histogram: at least one
+// finite bound, each greater than the one before.
+func checkBuckets(buckets []float64) error {
+    if len(buckets) == 0 {
+        return errors.New("no buckets")
+    }
+    for i, b := range buckets {
+        if math.IsNaN(b) || math.IsInf(b, 0) {
+            return fmt.Errorf("bucket %v is not finite", b)
+        }
+        if i > 0 && b <= buckets[i-1] {
+            return fmt.Errorf("bucket %v does not exceed %v before it", b, buckets[i-1])
+        }
+    }
+    return nil
+}
+
+// getEnvFloatList parses a comma-separated list, skipping entries that are
+// not valid floats.
+func getEnvFloatList(key string, fallback []float64) []float64 {
+    value := os.Getenv(key)
+    if value == "" {
+        return fallback
+    }
+
+    var values []float64
+    for _, field := range strings.Split(value, ",") {
+        field = strings.TrimSpace(field)
+        if field == "" {
+            continue
+        }
+        if f, err := strconv.ParseFloat(field, 64); err == nil {
+            values = append(values, f)
+        }
+    }
+    return values
 }
\ No newline at end of file
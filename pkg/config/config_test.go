@@ -0,0 +1,214 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, name, content string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+}
+
+func TestLoadYAMLWithEnvOverride(t *testing.T) {
+	writeConfig(t, "config.yaml", "cache_size: 200\ncheckpoint_mod: 500\njob_ttl: 10m\n")
+	t.Setenv("CHECKPOINT_MOD", "250")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.CacheSize != 200 || cfg.JobTTL != 10*time.Minute {
+		t.Errorf("file values not applied: cache_size=%d job_ttl=%v", cfg.CacheSize, cfg.JobTTL)
+	}
+	if cfg.CheckpointMod != 250 {
+		t.Errorf("CheckpointMod = %d, want env override 250", cfg.CheckpointMod)
+	}
+	if cfg.Port != "2586" {
+		t.Errorf("Port = %q, want default", cfg.Port)
+	}
+}
+
+func TestLoadJSON(t *testing.T) {
+	writeConfig(t, "config.json", `{"total_pods": 5, "warm_r_values": [3.5, 3.9], "flush_jitter": "3s"}`)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.TotalPods != 5 || len(cfg.WarmRValues) != 2 || cfg.FlushJitter != 3*time.Second {
+		t.Errorf("JSON values not applied: %+v", cfg)
+	}
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	writeConfig(t, "config.yaml", "cache_sise: 200\n")
+
+	if _, err := Load(); err == nil {
+		t.Fatal("expected an error for an unknown key")
+	}
+}
+
+func TestCompareEpsilon(t *testing.T) {
+	t.Setenv("COMPARE_EPSILON", "1e-6")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.CompareEpsilon != 1e-6 {
+		t.Errorf("CompareEpsilon = %v, want 1e-6", cfg.CompareEpsilon)
+	}
+
+	t.Setenv("COMPARE_EPSILON", "-1")
+	if _, err := Load(); err == nil {
+		t.Error("negative COMPARE_EPSILON accepted")
+	}
+}
+
+func TestPprofAddr(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	t.Setenv("PPROF_ADDR", "127.0.0.1:6060")
+	if _, err := Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PPROF_ADDR", ":6060")
+	if _, err := Load(); err == nil {
+		t.Error("PPROF_ADDR without a host accepted")
+	}
+
+	t.Setenv("PPROF_ADDR", "127.0.0.1:6060")
+	t.Setenv("ADMIN_TOKEN", "")
+	if _, err := Load(); err == nil {
+		t.Error("PPROF_ADDR without ADMIN_TOKEN accepted")
+	}
+}
+
+func TestBindAddr(t *testing.T) {
+	for _, tc := range []struct {
+		bind, port string
+		want       string
+	}{
+		{"", "2586", ":2586"},
+		{"10.0.0.5", "8080", "10.0.0.5:8080"},
+		{"fd00::5", "2586", "[fd00::5]:2586"},
+		{"[::1]", "2586", "[::1]:2586"},
+	} {
+		t.Setenv("BIND_ADDR", tc.bind)
+		t.Setenv("PORT", tc.port)
+		cfg, err := Load()
+		if err != nil {
+			t.Errorf("BIND_ADDR=%q: %v", tc.bind, err)
+			continue
+		}
+		if got := cfg.ListenAddr(); got != tc.want {
+			t.Errorf("BIND_ADDR=%q PORT=%s: listen address %q, want %q", tc.bind, tc.port, got, tc.want)
+		}
+	}
+
+	for _, bad := range []struct{ bind, port string }{
+		{"internal.example.com", "2586"},
+		{"10.0.0.5:8080", "2586"},
+		{"10.0.0.5", "http"},
+		{"10.0.0.5", "70000"},
+	} {
+		t.Setenv("BIND_ADDR", bad.bind)
+		t.Setenv("PORT", bad.port)
+		if _, err := Load(); err == nil {
+			t.Errorf("BIND_ADDR=%q PORT=%q accepted", bad.bind, bad.port)
+		}
+	}
+}
+
+func TestTenants(t *testing.T) {
+	t.Setenv("TENANTS", "team-a, team_b")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Tenants) != 2 || cfg.Tenants[0] != "team-a" || cfg.Tenants[1] != "team_b" {
+		t.Errorf("Tenants = %q, want [team-a team_b]", cfg.Tenants)
+	}
+
+	t.Setenv("TENANTS", "team:a")
+	if _, err := Load(); err == nil {
+		t.Error("tenant name with a colon accepted")
+	}
+}
+
+func TestComputeHistogramBuckets(t *testing.T) {
+	cfg := Default()
+	if d := cfg.ComputeDurationBuckets; d[0] != 1e-6 || d[len(d)-1] < 10 {
+		t.Errorf("default duration buckets span %v to %v, want 1µs to tens of seconds", d[0], d[len(d)-1])
+	}
+
+	t.Setenv("COMPUTE_DURATION_BUCKETS", "0.001, 0.01, 1")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.ComputeDurationBuckets) != 3 || cfg.ComputeDurationBuckets[2] != 1 {
+		t.Errorf("ComputeDurationBuckets = %v, want [0.001 0.01 1]", cfg.ComputeDurationBuckets)
+	}
+
+	for _, buckets := range []string{"1,10,10", "1,100,10", "1,+Inf"} {
+		t.Setenv("COMPUTE_ITERATION_BUCKETS", buckets)
+		if _, err := Load(); err == nil {
+			t.Errorf("COMPUTE_ITERATION_BUCKETS=%s accepted", buckets)
+		}
+	}
+}
+
+func TestShutdownTimeoutSetsDrainTimeout(t *testing.T) {
+	writeConfig(t, "config.yaml", "shutdown_timeout: 20s\n")
+	t.Setenv("FLUSH_TIMEOUT", "3s")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DrainTimeout != 20*time.Second || cfg.FlushTimeout != 3*time.Second {
+		t.Errorf("DrainTimeout = %v, FlushTimeout = %v, want 20s and 3s", cfg.DrainTimeout, cfg.FlushTimeout)
+	}
+
+	t.Setenv("DRAIN_TIMEOUT", "5s")
+	if cfg, err = Load(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DrainTimeout != 5*time.Second {
+		t.Errorf("DrainTimeout = %v, want DRAIN_TIMEOUT 5s over shutdown_timeout", cfg.DrainTimeout)
+	}
+}
+
+func TestRejectsUnusableSizesAndTTLs(t *testing.T) {
+	for _, tc := range []struct{ env, value string }{
+		{"CHECKPOINT_TTL", "0s"},
+		{"CHECKPOINT_TTL", "-1m"},
+		{"WORKERS", "0"},
+		{"QUEUE_SIZE", "-1"},
+		{"JOB_TTL", "0s"},
+		{"MAX_BATCH_SIZE", "-1"},
+		{"MAX_DISTINCT_R", "-1"},
+		{"MAX_POINTS_PER_REQUEST", "-1"},
+	} {
+		t.Run(tc.env+"="+tc.value, func(t *testing.T) {
+			t.Setenv(tc.env, tc.value)
+			if _, err := Load(); err == nil {
+				t.Errorf("%s=%s accepted", tc.env, tc.value)
+			}
+		})
+	}
+
+	for _, env := range []string{"QUEUE_SIZE", "MAX_BATCH_SIZE", "MAX_DISTINCT_R", "MAX_POINTS_PER_REQUEST"} {
+		t.Setenv(env, "0")
+	}
+	if _, err := Load(); err != nil {
+		t.Errorf("zero sizes and caps: %v", err)
+	}
+}
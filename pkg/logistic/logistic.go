@@ -0,0 +1,30 @@
+// Package logistic is the logistic map x = r*x*(1-x) on its own, with no
+// cache, Redis or engine behind it. The engine iterates with Step, so a
+// result computed here has the same bits as one the server returns.
+package logistic
+
+// Step returns x_{i+1} = r*x*(1-x) from x_i. The operations are evaluated
+// as (r*x)*(1-x), rounding after each, which is the order results depend on.
+func Step(r, x float64) float64 {
+	return r * x * (1 - x)
+}
+
+// ComputeLogistic returns x_n of the logistic map at r started from x0, or
+// x0 itself for n <= 0. Once the orbit reaches an absorbing state, x = 0 or
+// the exact fixed point 1-1/r, the remaining steps are skipped, since every
+// later x is the same.
+//
+// Unlike the engine it never fails: at r = 4 an orbit whose step rounds to
+// 1 is absorbed at 0, where the engine reports it as numerically
+// degenerate instead.
+func ComputeLogistic(r float64, n int64, x0 float64) float64 {
+	x := x0
+	for i := int64(0); i < n; i++ {
+		next := Step(r, x)
+		if next == x {
+			break
+		}
+		x = next
+	}
+	return x
+}
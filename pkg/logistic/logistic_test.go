@@ -0,0 +1,31 @@
+package logistic
+
+import "testing"
+
+func TestComputeLogistic(t *testing.T) {
+	want := 0.5
+	for i := 0; i < 2500; i++ {
+		want = 3.9 * want * (1 - want)
+	}
+	if got := ComputeLogistic(3.9, 2500, 0.5); got != want {
+		t.Errorf("ComputeLogistic(3.9, 2500, 0.5) = %v, want %v", got, want)
+	}
+
+	cases := []struct {
+		name     string
+		r        float64
+		n        int64
+		x0, want float64
+	}{
+		{"n = 0 is the seed", 3.9, 0, 0.3, 0.3},
+		{"negative n is the seed", 3.9, -5, 0.3, 0.3},
+		{"fixed point 1-1/r", 2, 1 << 40, 0.5, 0.5},
+		{"absorbed at 0", 4, 1 << 40, 0.5, 0},
+		{"r = 0", 0, 10, 0.7, 0},
+	}
+	for _, tc := range cases {
+		if got := ComputeLogistic(tc.r, tc.n, tc.x0); got != tc.want {
+			t.Errorf("%s: ComputeLogistic(%v, %d, %v) = %v, want %v", tc.name, tc.r, tc.n, tc.x0, got, tc.want)
+		}
+	}
+}
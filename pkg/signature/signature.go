@@ -0,0 +1,42 @@
+// Package signature signs and verifies computed results so consumers can
+// check that a value came from a server holding the signing key and was not
+// altered on the way.
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+)
+
+// Sign returns the hex HMAC-SHA256 of a result: x_n of the map
+// x = r*x*(1-x) + c started from x0. Floats are signed by their exact bits,
+// so any change to a value invalidates the signature.
+func Sign(key []byte, r, c float64, n int64, x0, result float64) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(message(r, c, n, x0, result))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether sig is the signature of the given result under key.
+func Verify(key []byte, r, c float64, n int64, x0, result float64, sig string) bool {
+	want, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(message(r, c, n, x0, result))
+	return hmac.Equal(mac.Sum(nil), want)
+}
+
+func message(r, c float64, n int64, x0, result float64) []byte {
+	buf := make([]byte, 0, 40)
+	buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(r))
+	buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(c))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(x0))
+	buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(result))
+	return buf
+}
@@ -0,0 +1,40 @@
+package signature
+
+import "testing"
+
+func TestSignVerify(t *testing.T) {
+	key := []byte("test-key")
+	sig := Sign(key, 3.7, 0, 1000, 0.5, 0.42)
+
+	if !Verify(key, 3.7, 0, 1000, 0.5, 0.42, sig) {
+		t.Fatal("signature does not verify")
+	}
+
+	tampered := []struct {
+		name       string
+		r, c       float64
+		n          int64
+		x0, result float64
+		key, sig   string
+	}{
+		{name: "result", r: 3.7, n: 1000, x0: 0.5, result: 0.4200000000000001},
+		{name: "r", r: 3.7000001, n: 1000, x0: 0.5, result: 0.42},
+		{name: "c", r: 3.7, c: 0.01, n: 1000, x0: 0.5, result: 0.42},
+		{name: "n", r: 3.7, n: 1001, x0: 0.5, result: 0.42},
+		{name: "x0", r: 3.7, n: 1000, x0: 0.25, result: 0.42},
+		{name: "key", r: 3.7, n: 1000, x0: 0.5, result: 0.42, key: "other-key"},
+		{name: "malformed", r: 3.7, n: 1000, x0: 0.5, result: 0.42, sig: "zz"},
+	}
+	for _, tt := range tampered {
+		k, s := key, sig
+		if tt.key != "" {
+			k = []byte(tt.key)
+		}
+		if tt.sig != "" {
+			s = tt.sig
+		}
+		if Verify(k, tt.r, tt.c, tt.n, tt.x0, tt.result, s) {
+			t.Errorf("changed %s still verifies", tt.name)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"os"
+	"slices"
+
+	"resilientrecursion/internal/engine"
+	"resilientrecursion/internal/logging"
+	"resilientrecursion/internal/server"
+	"resilientrecursion/pkg/config"
+)
+
+// watchReload re-reads the configuration on every signal from sigs until ctx
+// is done. Only the log level, checkpoint interval, checkpoint TTL, minimum
+// Redis n, cancellation check stride, cache generation and rate limits are
+// applied; changes to anything else need a restart and are logged and
+// ignored. A configuration that fails to load or validate is ignored.
+func watchReload(ctx context.Context, sigs <-chan os.Signal, current *config.Config, eng *engine.ComputeEngine, srv *server.Server) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sigs:
+		}
+
+		next, err := config.Load()
+		if err != nil {
+			logging.Errorf("Config reload rejected: %v", err)
+			continue
+		}
+		warnRestartOnly(current, next)
+
+		level, _ := logging.ParseLevel(next.LogLevel)
+		logging.SetLevel(level)
+		eng.ApplyReload(next)
+		srv.ApplyReload(next)
+
+		current.LogLevel = next.LogLevel
+		current.CheckpointMod = next.CheckpointMod
+		current.CheckpointTTL = next.CheckpointTTL
+		current.MinRedisN = next.MinRedisN
+		current.CancelCheckStride = next.CancelCheckStride
+		current.CacheGeneration = next.CacheGeneration
+		current.TenantRateLimit = next.TenantRateLimit
+		current.TenantRateBurst = next.TenantRateBurst
+		current.AdaptiveRateLimit = next.AdaptiveRateLimit
+		current.CorrelationRateLimit = next.CorrelationRateLimit
+		logging.Infof("Config reloaded: log_level=%s checkpoint_mod=%d checkpoint_ttl=%s min_redis_n=%d cancel_check_stride=%d cache_generation=%d "+
+			"tenant_rate_limit=%v tenant_rate_burst=%d adaptive_rate_limit=%v correlation_rate_limit=%v",
+			current.LogLevel, current.CheckpointMod, current.CheckpointTTL, current.MinRedisN, current.CancelCheckStride,
+			current.CacheGeneration, current.TenantRateLimit, current.TenantRateBurst, current.AdaptiveRateLimit,
+			current.CorrelationRateLimit)
+	}
+}
+
+func warnRestartOnly(current, next *config.Config) {
+	changed := func(name string, differs bool) {
+		if differs {
+			logging.Warnf("Config reload: %s changed but needs a restart, ignoring", name)
+		}
+	}
+	changed("port", current.Port != next.Port)
+	changed("bind_addr", current.BindAddr != next.BindAddr)
+	changed("pprof_addr", current.PprofAddr != next.PprofAddr)
+	changed("statsd_addr", current.StatsdAddr != next.StatsdAddr)
+	changed("statsd_tags", current.StatsdTags != next.StatsdTags)
+	changed("compute_duration_buckets", !slices.Equal(current.ComputeDurationBuckets, next.ComputeDurationBuckets))
+	changed("compute_iteration_buckets", !slices.Equal(current.ComputeIterationBuckets, next.ComputeIterationBuckets))
+	changed("redis_addr", current.RedisAddr != next.RedisAddr)
+	changed("redis_replica_addr", current.RedisReplicaAddr != next.RedisReplicaAddr)
+	changed("redis_max_concurrent", current.RedisMaxConcurrent != next.RedisMaxConcurrent)
+	changed("checkpoint_collisions", current.CheckpointCollisions != next.CheckpointCollisions)
+	changed("checkpoint_dedupe_tolerance", current.CheckpointDedupeTolerance != next.CheckpointDedupeTolerance)
+	changed("pod_id", current.PodID != next.PodID)
+	changed("total_pods", current.TotalPods != next.TotalPods)
+	changed("pod_weights", !slices.Equal(current.PodWeights, next.PodWeights))
+	changed("cache_size", current.CacheSize != next.CacheSize)
+	changed("disable_l1", current.DisableL1 != next.DisableL1)
+	changed("stale_while_revalidate", current.StaleWhileRevalidate != next.StaleWhileRevalidate)
+	changed("x0", current.X0 != next.X0)
+	changed("coalesce_computes", current.CoalesceComputes != next.CoalesceComputes)
+	changed("clamp_computes", current.ClampComputes != next.ClampComputes)
+	changed("series_compression", current.SeriesCompression != next.SeriesCompression)
+	changed("big_checkpoints", current.BigCheckpoints != next.BigCheckpoints)
+	changed("pinned_r_values", !slices.Equal(current.PinnedRValues, next.PinnedRValues))
+	changed("workers", current.Workers != next.Workers)
+	changed("queue_size", current.QueueSize != next.QueueSize)
+	changed("starvation_limit", current.StarvationLimit != next.StarvationLimit)
+	changed("batch_duplicates", current.BatchDuplicates != next.BatchDuplicates)
+	changed("rounded_r", current.RoundedR != next.RoundedR)
+	changed("memory_budget", current.MemoryBudget != next.MemoryBudget)
+	changed("converged_tails", current.ConvergedTails != next.ConvergedTails)
+	changed("per_r_budget", current.PerRBudget != next.PerRBudget)
+	changed("dataset_url_prefixes", !slices.Equal(current.DatasetURLPrefixes, next.DatasetURLPrefixes))
+	changed("dataset_max_bytes", current.DatasetMaxBytes != next.DatasetMaxBytes)
+	changed("dataset_timeout", current.DatasetTimeout != next.DatasetTimeout)
+	changed("compute_backend", current.ComputeBackend != next.ComputeBackend)
+	changed("checkpoint_channel", current.CheckpointChannel != next.CheckpointChannel)
+	changed("tenants", !slices.Equal(current.Tenants, next.Tenants))
+	changed("route_timeouts", !maps.Equal(current.RouteTimeouts, next.RouteTimeouts))
+}